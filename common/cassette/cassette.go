@@ -0,0 +1,529 @@
+// Package cassette records the HTTP interactions of a sample run to a file and
+// replays them later, so that a run against a stamp can be repeated without
+// one. Secrets, keys and tokens are scrubbed before anything is written, and
+// the subscription, tenant and principal IDs are replaced with placeholders.
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/tags"
+)
+
+// Mode selects what a Recorder does with the requests it sees.
+type Mode string
+
+const (
+	// Record sends requests to the service and appends them to the cassette.
+	Record Mode = "record"
+	// Replay answers requests from the cassette and never reaches the service.
+	Replay Mode = "replay"
+	// Passthrough sends requests to the service without recording them.
+	Passthrough Mode = "passthrough"
+)
+
+func (m *Mode) String() string {
+	return string(*m)
+}
+
+// Set implements flag.Value.
+func (m *Mode) Set(value string) error {
+	switch Mode(value) {
+	case Record, Replay, Passthrough:
+		*m = Mode(value)
+		return nil
+	}
+	return fmt.Errorf("unknown cassette mode %q, expected %s, %s or %s", value, Record, Replay, Passthrough)
+}
+
+// The placeholders that replace the IDs of the recording principal.
+const (
+	SubscriptionID = "00000000-0000-0000-0000-00000000000a"
+	TenantID       = "00000000-0000-0000-0000-00000000000b"
+	ClientID       = "00000000-0000-0000-0000-00000000000c"
+	ObjectID       = "00000000-0000-0000-0000-00000000000d"
+
+	// Redacted replaces secrets, keys and tokens.
+	Redacted = "REDACTED"
+)
+
+// IDs are the identifiers of the principal a run authenticates as. They are
+// replaced with placeholders in the cassette, and in live requests before
+// they are matched against it, so a cassette replays with any configuration.
+type IDs struct {
+	SubscriptionID string
+	TenantID       string
+	ClientID       string
+	ObjectID       string
+}
+
+// Interaction is a request and the response it got.
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+type Request struct {
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Headers http.Header `json:"headers,omitempty"`
+	Body    string      `json:"body,omitempty"`
+}
+
+type Response struct {
+	StatusCode int         `json:"statusCode"`
+	Headers    http.Header `json:"headers,omitempty"`
+	Body       string      `json:"body,omitempty"`
+}
+
+type file struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// volatileHeaders differ between runs of the same request or carry secrets.
+// They are neither recorded nor matched.
+var volatileHeaders = map[string]bool{
+	"Authorization":               true,
+	"User-Agent":                  true,
+	"Date":                        true,
+	"Traceparent":                 true,
+	"Client-Request-Id":           true,
+	"Return-Client-Request-Id":    true,
+	"X-Client-Cpu":                true,
+	"X-Client-Os":                 true,
+	"X-Client-Sku":                true,
+	"X-Client-Ver":                true,
+	"X-Ms-Client-Request-Id":      true,
+	"X-Ms-Correlation-Request-Id": true,
+	"X-Ms-Request-Id":             true,
+	"X-Ms-Routing-Request-Id":     true,
+	"X-Ms-Ests-Server":            true,
+	"Set-Cookie":                  true,
+	"Strict-Transport-Security":   true,
+	"X-Content-Type-Options":      true,
+	"Content-Length":              true,
+}
+
+// Recorder is a policy.Transporter that records or replays a cassette.
+type Recorder struct {
+	mode  Mode
+	path  string
+	next  policy.Transporter
+	ids   []*regexp.Regexp
+	names []string
+	// live are the IDs that names replace, which replays put back so that
+	// the responses refer to the principal of the run.
+	live []string
+
+	mu           sync.Mutex
+	interactions []Interaction
+	used         []bool
+}
+
+// New creates a Recorder for the cassette at path. In Record mode the file is
+// replaced, in Replay mode it must exist. next sends the requests that are not
+// replayed; nil selects http.DefaultClient.
+func New(mode Mode, path string, next policy.Transporter, ids IDs) (*Recorder, error) {
+	if next == nil {
+		next = http.DefaultClient
+	}
+	r := &Recorder{mode: mode, path: path, next: next}
+	for _, id := range [][2]string{
+		{ids.SubscriptionID, SubscriptionID},
+		{ids.TenantID, TenantID},
+		{ids.ClientID, ClientID},
+		{ids.ObjectID, ObjectID},
+	} {
+		if id[0] == "" || id[0] == id[1] {
+			continue
+		}
+		r.ids = append(r.ids, regexp.MustCompile("(?i)"+regexp.QuoteMeta(id[0])))
+		r.names = append(r.names, id[1])
+		r.live = append(r.live, id[0])
+	}
+	if mode != Replay {
+		return r, nil
+	}
+	interactions, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	r.interactions = interactions
+	r.used = make([]bool, len(interactions))
+	return r, nil
+}
+
+// Load reads the interactions recorded in the cassette at path.
+func Load(path string) ([]Interaction, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("invalid cassette %s: %w", path, err)
+	}
+	return f.Interactions, nil
+}
+
+// ResourceManagerEndpoint returns the Azure Resource Manager endpoint the
+// cassette at path was recorded against, taken from its metadata request.
+func ResourceManagerEndpoint(path string) (string, error) {
+	interactions, err := Load(path)
+	if err != nil {
+		return "", err
+	}
+	for _, i := range interactions {
+		u, err := url.Parse(i.Request.URL)
+		if err == nil && u.Path == "/metadata/endpoints" {
+			return u.Scheme + "://" + u.Host, nil
+		}
+	}
+	return "", fmt.Errorf("cassette %s has no metadata request", path)
+}
+
+// Do implements policy.Transporter.
+func (r *Recorder) Do(req *http.Request) (*http.Response, error) {
+	switch r.mode {
+	case Record:
+		return r.record(req)
+	case Replay:
+		return r.replay(req)
+	}
+	return r.next.Do(req)
+}
+
+func (r *Recorder) record(req *http.Request) (*http.Response, error) {
+	recorded, err := r.request(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.next.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.interactions = append(r.interactions, Interaction{
+		Request: recorded,
+		Response: Response{
+			StatusCode: resp.StatusCode,
+			Headers:    r.headers(resp.Header),
+			Body:       r.scrubBody(resp.Header.Get("Content-Type"), body),
+		},
+	})
+	if err := r.save(); err != nil {
+		return nil, fmt.Errorf("failed to write cassette %s: %w", r.path, err)
+	}
+	return resp, nil
+}
+
+func (r *Recorder) replay(req *http.Request) (*http.Response, error) {
+	live, err := r.request(req)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, recorded := range r.interactions {
+		if r.used[i] || !matches(live, recorded.Request) {
+			continue
+		}
+		r.used[i] = true
+		body := r.restoreIDs(recorded.Response.Body)
+		header := http.Header{}
+		for name, values := range recorded.Response.Headers {
+			for _, v := range values {
+				header.Add(name, r.restoreIDs(v))
+			}
+		}
+		// replays never wait for the service
+		header.Del("Retry-After")
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", recorded.Response.StatusCode, http.StatusText(recorded.Response.StatusCode)),
+			StatusCode:    recorded.Response.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          io.NopCloser(strings.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       req,
+		}, nil
+	}
+	return nil, fmt.Errorf("cassette %s has no unused interaction for %s %s", r.path, live.Method, live.URL)
+}
+
+// request reads req into its scrubbed, recorded form and rewinds its body.
+func (r *Recorder) request(req *http.Request) (Request, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return Request{}, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	return Request{
+		Method:  req.Method,
+		URL:     r.replaceIDs(req.URL.String()),
+		Headers: r.headers(req.Header),
+		Body:    r.scrubBody(req.Header.Get("Content-Type"), body),
+	}, nil
+}
+
+func matches(live, recorded Request) bool {
+	if live.Method != recorded.Method || !sameURL(live.URL, recorded.URL) || !sameBody(live.Body, recorded.Body) {
+		return false
+	}
+	for name, values := range recorded.Headers {
+		if strings.Join(live.Headers[name], ",") != strings.Join(values, ",") {
+			return false
+		}
+	}
+	return len(live.Headers) == len(recorded.Headers)
+}
+
+// sameURL compares URLs with the query parameters in any order.
+func sameURL(a, b string) bool {
+	ua, errA := url.Parse(a)
+	ub, errB := url.Parse(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return ua.Scheme == ub.Scheme && strings.EqualFold(ua.Host, ub.Host) && ua.Path == ub.Path && ua.Query().Encode() == ub.Query().Encode()
+}
+
+// sameBody compares JSON bodies by value and anything else byte by byte.
+func sameBody(a, b string) bool {
+	if a == b {
+		return true
+	}
+	var va, vb interface{}
+	if json.Unmarshal([]byte(a), &va) != nil || json.Unmarshal([]byte(b), &vb) != nil {
+		return false
+	}
+	ja, _ := json.Marshal(va)
+	jb, _ := json.Marshal(vb)
+	return bytes.Equal(ja, jb)
+}
+
+func (r *Recorder) headers(h http.Header) http.Header {
+	kept := http.Header{}
+	for name, values := range h {
+		name = http.CanonicalHeaderKey(name)
+		if volatileHeaders[name] || strings.HasPrefix(name, "X-Ms-Ratelimit-") {
+			continue
+		}
+		for _, v := range values {
+			kept.Add(name, r.replaceIDs(v))
+		}
+	}
+	if len(kept) == 0 {
+		return nil
+	}
+	return kept
+}
+
+func (r *Recorder) replaceIDs(s string) string {
+	for i, id := range r.ids {
+		s = id.ReplaceAllLiteralString(s, r.names[i])
+	}
+	return s
+}
+
+// restoreIDs puts the IDs of the run back in place of their placeholders.
+func (r *Recorder) restoreIDs(s string) string {
+	for i, name := range r.names {
+		s = strings.ReplaceAll(s, name, r.live[i])
+	}
+	return s
+}
+
+// secretFields are redacted wherever they appear in a form or JSON body.
+var secretFields = map[string]bool{
+	"client_secret":    true,
+	"client_assertion": true,
+	"access_token":     true,
+	"refresh_token":    true,
+	"id_token":         true,
+	"adminPassword":    true,
+	"password":         true,
+}
+
+func (r *Recorder) scrubBody(contentType string, body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		if form, err := url.ParseQuery(string(body)); err == nil {
+			for name := range form {
+				if secretFields[name] {
+					form.Set(name, Redacted)
+				}
+			}
+			return r.replaceIDs(form.Encode())
+		}
+	}
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return r.replaceIDs(string(body))
+	}
+	ScrubJSON(v)
+	fixTimes(v)
+	scrubbed, err := json.Marshal(v)
+	if err != nil {
+		return r.replaceIDs(string(body))
+	}
+	return r.replaceIDs(string(scrubbed))
+}
+
+// ScrubJSON redacts the secret fields, the storage account keys, the Key
+// Vault secret values and the values of the parameters of template
+// deployments in v, a value decoded by encoding/json.
+func ScrubJSON(v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		_, isKey := v["keyName"]
+		for name, value := range v {
+			if _, ok := value.(string); ok && (secretFields[name] || (name == "value" && isKey)) {
+				v[name] = Redacted
+				continue
+			}
+			if props, ok := value.(map[string]interface{}); ok && name == "properties" {
+				if _, ok := props["value"].(string); ok {
+					props["value"] = Redacted
+				}
+				// The parameters of a deployment hold their values whatever
+				// their type, such as {"adminPassword": {"value": "..."}},
+				// unlike those of a template, which hold their definitions.
+				params, _ := props["parameters"].(map[string]interface{})
+				for _, param := range params {
+					if param, ok := param.(map[string]interface{}); ok {
+						if _, ok := param["value"]; ok {
+							param["value"] = Redacted
+						}
+					}
+				}
+			}
+			ScrubJSON(value)
+		}
+	case []interface{}:
+		for _, value := range v {
+			ScrubJSON(value)
+		}
+	}
+}
+
+// volatileTags are the standard tags whose values change from run to run.
+var volatileTags = []string{tags.CreatedAt, tags.ExpiresAt}
+
+// fixTimes replaces the times in the tags of v, a value decoded by
+// encoding/json, with the zero time, so that a replay matches the requests of
+// a recording made at another time.
+func fixTimes(v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for name, value := range v {
+			if t, ok := value.(map[string]interface{}); ok && name == "tags" {
+				for _, tag := range volatileTags {
+					if _, ok := t[tag].(string); ok {
+						t[tag] = time.Time{}.Format(time.RFC3339)
+					}
+				}
+			}
+			fixTimes(value)
+		}
+	case []interface{}:
+		for _, value := range v {
+			fixTimes(value)
+		}
+	}
+}
+
+// save replaces the cassette through a rename so a crash never leaves it half
+// written.
+func (r *Recorder) save() error {
+	data, err := json.MarshalIndent(file{Interactions: r.interactions}, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(r.path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(r.path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), r.path)
+}
+
+// Flags holds the command line flags that select a cassette.
+type Flags struct {
+	path string
+	mode Mode
+}
+
+// RegisterFlags defines the cassette flags on fs.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{mode: Passthrough}
+	fs.StringVar(&f.path, "cassette", "", "file the HTTP interactions are recorded to or replayed from")
+	fs.Var(&f.mode, "cassetteMode", "what to do with the cassette: record, replay or passthrough")
+	return f
+}
+
+// Transport wraps next in a Recorder as selected by the flags. It returns
+// next unchanged in Passthrough mode.
+func (f *Flags) Transport(next policy.Transporter, ids IDs) (policy.Transporter, error) {
+	if f.mode == Passthrough {
+		return next, nil
+	}
+	if f.path == "" {
+		return nil, errors.New("-cassette is required to record or replay")
+	}
+	if f.mode == Replay {
+		if _, err := os.Stat(f.path); errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("cassette %s does not exist, record it first", f.path)
+		}
+	}
+	r, err := New(f.mode, f.path, next, ids)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
@@ -0,0 +1,396 @@
+package checkpoint
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	armruntime "github.com/Azure/azure-sdk-for-go/sdk/azcore/arm/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+)
+
+const (
+	// DefaultContainer is the container of the state blobs unless the
+	// configuration names another.
+	DefaultContainer = "hybrid-state"
+	// blobVersion is the version of the blob service API, one Azure Stack
+	// Hub supports.
+	blobVersion = "2019-02-02"
+	// storageAPIVersion is the version of the storage resource provider API
+	// the account key is listed with.
+	storageAPIVersion = "2019-06-01"
+	// DefaultLeaseDuration is the duration of the lease on the state blob of
+	// a run unless the configuration sets another, which the run renews every
+	// third of it. The lease of a run that died expires after it.
+	DefaultLeaseDuration = 60 * time.Second
+)
+
+// BlobConfig is the "state" section of the configuration file, which selects
+// the storage account that keeps the state of the runs with -stateBackend
+// blob.
+type BlobConfig struct {
+	// StorageAccount is the name of the account and ResourceGroup its
+	// resource group, through which the account key is listed unless
+	// AccountKey is set.
+	StorageAccount string
+	ResourceGroup  string
+	AccountKey     string
+	// Container is the container of the state blobs, DefaultContainer when
+	// empty.
+	Container string
+	// BlobEndpoint is the blob endpoint of the account, such as
+	// http://127.0.0.1:10000/devstoreaccount1 for a local emulator. It is
+	// that of the account on the stamp when empty.
+	BlobEndpoint string
+	// LeaseDuration is the duration of the lease on the state blob in
+	// seconds, from 15 to 60, DefaultLeaseDuration when zero.
+	LeaseDuration int
+}
+
+// LockedError is returned for a state file that another run holds.
+type LockedError struct {
+	Where string
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("another run with the same names is in progress: it holds the lease of %s; wait for it to end or use another -runID", e.Where)
+}
+
+// Container is a Store that keeps the state files as block blobs in a
+// container of a storage account. A run holds a lease on its blob, which
+// keeps runs on other machines with the same names from overlapping.
+type Container struct {
+	// Out receives the warnings of the lease renewals.
+	Out io.Writer
+	// LeaseDuration is the duration of the leases the run acquires.
+	LeaseDuration time.Duration
+	// Lost is called, when set, once the run loses the lease of a state
+	// file, which another run may acquire from then on. The writes of the
+	// file fail after it.
+	Lost func(err error)
+
+	endpoint  string
+	account   string
+	container string
+	key       []byte
+	pl        runtime.Pipeline
+
+	mu sync.Mutex
+	// leases are the IDs of the leases the run holds, by file.
+	leases map[string]string
+	// lost are the reasons the run lost the leases of files it held.
+	lost map[string]error
+}
+
+// NewContainer returns the Container called container of the storage account
+// with the blob endpoint and the base64 account key. The requests are sent
+// with the transport and retry settings of options.
+func NewContainer(endpoint, account, key, container string, options *policy.ClientOptions) (*Container, error) {
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key of storage account %s: %w", account, err)
+	}
+	if container == "" {
+		container = DefaultContainer
+	}
+	c := &Container{
+		Out:           io.Discard,
+		LeaseDuration: DefaultLeaseDuration,
+		endpoint:      strings.TrimSuffix(endpoint, "/"),
+		account:       account,
+		container:     container,
+		key:           decoded,
+		leases:        map[string]string{},
+		lost:          map[string]error{},
+	}
+	o := &policy.ClientOptions{}
+	if options != nil {
+		o.Transport = options.Transport
+		o.Retry = options.Retry
+	}
+	c.pl = runtime.NewPipeline("checkpoint", "v1.0.0", runtime.PipelineOptions{PerRetry: []policy.Policy{policyFunc(c.sign)}}, o)
+	return c, nil
+}
+
+func (c *Container) Where(file string) string {
+	return c.endpoint + "/" + c.container + "/" + file
+}
+
+func (c *Container) Read(ctx context.Context, file string) ([]byte, error) {
+	resp, err := c.do(ctx, http.MethodGet, c.Where(file), nil, nil, nil, http.StatusOK, http.StatusNotFound)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func (c *Container) Write(ctx context.Context, file string, data []byte) error {
+	c.mu.Lock()
+	lease, lost := c.leases[file], c.lost[file]
+	c.mu.Unlock()
+	if lost != nil {
+		// The blob may be the state of another run by now.
+		return lost
+	}
+	header := http.Header{"X-Ms-Blob-Type": {"BlockBlob"}}
+	if lease != "" {
+		header.Set("x-ms-lease-id", lease)
+	}
+	resp, err := c.do(ctx, http.MethodPut, c.Where(file), nil, header, data, http.StatusCreated)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Lock creates the container and an empty blob for the state file when they
+// do not exist, and acquires a lease on the blob that it renews until unlock
+// releases it. The lease is lost when the service refuses a renewal, or when
+// the renewals have failed for so long that the lease would expire before the
+// next one; Lost is then called.
+func (c *Container) Lock(ctx context.Context, file string) (func(), error) {
+	where := c.Where(file)
+	resp, err := c.do(ctx, http.MethodPut, c.endpoint+"/"+c.container, url.Values{"restype": {"container"}}, nil, nil, http.StatusCreated, http.StatusConflict)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create container %s: %w", c.container, err)
+	}
+	resp.Body.Close()
+	header := http.Header{"X-Ms-Blob-Type": {"BlockBlob"}, "If-None-Match": {"*"}}
+	resp, err = c.do(ctx, http.MethodPut, where, nil, header, nil, http.StatusCreated, http.StatusConflict)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", where, err)
+	}
+	resp.Body.Close()
+
+	header = http.Header{"X-Ms-Lease-Action": {"acquire"}, "X-Ms-Lease-Duration": {fmt.Sprint(int(c.LeaseDuration.Seconds()))}}
+	resp, err = c.do(ctx, http.MethodPut, where, url.Values{"comp": {"lease"}}, header, nil, http.StatusCreated)
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) && respErr.ErrorCode == "LeaseAlreadyPresent" {
+		return nil, &LockedError{Where: where}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire the lease of %s: %w", where, err)
+	}
+	resp.Body.Close()
+	lease := resp.Header.Get("x-ms-lease-id")
+	c.mu.Lock()
+	c.leases[file] = lease
+	c.mu.Unlock()
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		every := c.LeaseDuration / 3
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+		renewed := time.Now()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				err := c.leaseAction(file, "renew", lease)
+				if err == nil {
+					renewed = time.Now()
+					continue
+				}
+				// A conflict means the lease is no longer that of the run:
+				// it expired and was broken or acquired by another run.
+				var respErr *azcore.ResponseError
+				conflict := errors.As(err, &respErr) && respErr.StatusCode == http.StatusConflict
+				if !conflict && time.Since(renewed)+every < c.LeaseDuration {
+					fmt.Fprintf(c.Out, "Warning: failed to renew the lease of %s: %s\n", where, err)
+					continue
+				}
+				err = fmt.Errorf("lost the lease of %s: %w", where, err)
+				c.mu.Lock()
+				c.lost[file] = err
+				c.mu.Unlock()
+				if c.Lost != nil {
+					c.Lost(err)
+				}
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-stopped
+			c.mu.Lock()
+			lost := c.lost[file]
+			delete(c.leases, file)
+			delete(c.lost, file)
+			c.mu.Unlock()
+			if lost != nil {
+				return
+			}
+			if err := c.leaseAction(file, "release", lease); err != nil {
+				fmt.Fprintf(c.Out, "Warning: failed to release the lease of %s, it expires in %s: %s\n", where, c.LeaseDuration, err)
+			}
+		})
+	}, nil
+}
+
+// leaseAction renews or releases the lease of the state file. Unlike the
+// other requests, it does not use the context of the run, which may have
+// been cancelled by then.
+func (c *Container) leaseAction(file, action, lease string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.LeaseDuration/3)
+	defer cancel()
+	header := http.Header{"X-Ms-Lease-Action": {action}, "X-Ms-Lease-Id": {lease}}
+	resp, err := c.do(ctx, http.MethodPut, c.Where(file), url.Values{"comp": {"lease"}}, header, nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// do sends a request to the blob service and returns its response when its
+// status is one of want. The caller closes the body of the response.
+func (c *Container) do(ctx context.Context, method, rawURL string, query url.Values, header http.Header, body []byte, want ...int) (*http.Response, error) {
+	req, err := runtime.NewRequest(ctx, method, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if query != nil {
+		req.Raw().URL.RawQuery = query.Encode()
+	}
+	for key, values := range header {
+		req.Raw().Header[http.CanonicalHeaderKey(key)] = values
+	}
+	if len(body) > 0 {
+		if err := req.SetBody(streaming.NopCloser(bytes.NewReader(body)), "application/json"); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := c.pl.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if !runtime.HasStatusCode(resp, want...) {
+		return nil, runtime.NewResponseError(resp)
+	}
+	return resp, nil
+}
+
+// sign signs the request with the account key, as the Shared Key scheme of
+// the storage services does.
+func (c *Container) sign(req *policy.Request) (*http.Response, error) {
+	raw := req.Raw()
+	raw.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	raw.Header.Set("x-ms-version", blobVersion)
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(StringToSign(raw, c.account)))
+	raw.Header.Set("Authorization", "SharedKey "+c.account+":"+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	return req.Next()
+}
+
+// StringToSign returns the string the Shared Key scheme signs for a request
+// to a storage account. It is exported for the fakes of the blob service.
+func StringToSign(req *http.Request, account string) string {
+	length := ""
+	if req.ContentLength > 0 {
+		length = fmt.Sprint(req.ContentLength)
+	}
+	h := req.Header
+	lines := []string{
+		req.Method,
+		h.Get("Content-Encoding"),
+		h.Get("Content-Language"),
+		length,
+		h.Get("Content-MD5"),
+		h.Get("Content-Type"),
+		"", // Date, x-ms-date is used instead
+		h.Get("If-Modified-Since"),
+		h.Get("If-Match"),
+		h.Get("If-None-Match"),
+		h.Get("If-Unmodified-Since"),
+		h.Get("Range"),
+	}
+	var names []string
+	for name := range h {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-ms-") {
+			names = append(names, lower)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		lines = append(lines, name+":"+strings.TrimSpace(h.Get(name)))
+	}
+
+	resource := "/" + account + req.URL.EscapedPath()
+	query := req.URL.Query()
+	var keys []string
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		resource += "\n" + strings.ToLower(key) + ":" + strings.Join(values, ",")
+	}
+	return strings.Join(append(lines, resource), "\n")
+}
+
+// AccountKey lists the keys of the storage account in the resource group of
+// the subscription through Azure Resource Manager and returns the first.
+func AccountKey(ctx context.Context, cred azcore.TokenCredential, options *arm.ClientOptions, subscriptionID, group, account string) (string, error) {
+	pl, err := armruntime.NewPipeline("checkpoint", "v1.0.0", cred, runtime.PipelineOptions{}, options)
+	if err != nil {
+		return "", err
+	}
+	endpoint := options.Cloud.Services[cloud.ResourceManager].Endpoint
+	u := fmt.Sprintf("%s/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Storage/storageAccounts/%s/listKeys", strings.TrimSuffix(endpoint, "/"), url.PathEscape(subscriptionID), url.PathEscape(group), url.PathEscape(account))
+	req, err := runtime.NewRequest(ctx, http.MethodPost, u)
+	if err != nil {
+		return "", err
+	}
+	req.Raw().URL.RawQuery = url.Values{"api-version": {storageAPIVersion}}.Encode()
+	resp, err := pl.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if !runtime.HasStatusCode(resp, http.StatusOK) {
+		return "", runtime.NewResponseError(resp)
+	}
+	var keys struct {
+		Keys []struct {
+			Value string `json:"value"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&keys); err != nil {
+		return "", err
+	}
+	if len(keys.Keys) == 0 {
+		return "", fmt.Errorf("storage account %s has no keys", account)
+	}
+	return keys.Keys[0].Value, nil
+}
@@ -0,0 +1,503 @@
+// Package checkpoint keeps the state of a run in a file, in a local directory
+// or in a blob of a storage account: the steps it completed, with their
+// results, and the resources it created. A run resumed with the ID of an
+// earlier run skips the steps that run completed, after checking that their
+// resources still exist, and carries on from the first step it did not
+// complete.
+package checkpoint
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	armruntime "github.com/Azure/azure-sdk-for-go/sdk/azcore/arm/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/cassette"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/cleanup"
+)
+
+// DefaultDir is the directory the state files are kept in unless overridden.
+const DefaultDir = ".hybrid-state"
+
+// writeTimeout is the time allowed for writing the state file.
+const writeTimeout = time.Minute
+
+// State is the content of a state file.
+type State struct {
+	Sample  string    `json:"sample"`
+	RunID   string    `json:"runId"`
+	Updated time.Time `json:"updated"`
+	// Steps are the steps the run completed, in order.
+	Steps []Step `json:"steps"`
+	// Resources are the resources the run created and has not deleted, in
+	// creation order.
+	Resources []cleanup.Resource `json:"resources"`
+	// Desired are the last bodies the run sent for its resources, by ID,
+	// without their secrets. They are what the resources are compared with
+	// to find the changes made since.
+	Desired map[string]json.RawMessage `json:"desired,omitempty"`
+}
+
+// Step is a completed step of a run.
+type Step struct {
+	Name      string    `json:"name"`
+	Completed time.Time `json:"completed"`
+	// Resources are the resources of the step that the run created or
+	// adopted and that existed when the step completed. A resumed run only
+	// skips the step while they still exist.
+	Resources []cleanup.Resource `json:"resources,omitempty"`
+	// Result is the value the step set for the steps that follow.
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+// Tracker records the steps of a run in its state file and, for a resumed
+// run, skips those the earlier run completed. It implements
+// report.Checkpoints.
+type Tracker struct {
+	// Out receives the steps that are skipped.
+	Out io.Writer
+	// Created reports whether the run created or adopted the resource with
+	// the ID and has not deleted it since, and Resources returns those it
+	// created. Both are set by the session to the record of the run.
+	Created   func(id string) bool
+	Resources func() []cleanup.Resource
+
+	store  Store
+	file   string
+	unlock func()
+	mu     sync.Mutex
+	// state is the state of the run, which is written after every step.
+	state State
+	// resumed are the steps of the earlier run that are still to be skipped.
+	resumed []Step
+	// seen are the resources the run sent requests for, with the API version
+	// of the last request, by lowercase ID.
+	seen map[string]cleanup.Resource
+	// bodies are the last bodies the run sent for resources, by lowercase
+	// ID.
+	bodies map[string]json.RawMessage
+}
+
+// FileName returns the name of the state file of the run ID of sample.
+func FileName(sample, runID string) string {
+	return sample + "-" + runID + ".json"
+}
+
+// New returns a Tracker for a new run that keeps its state in the file of
+// store, which is written when the first step completes.
+func New(store Store, file, sample, runID string) *Tracker {
+	return &Tracker{store: store, file: file, state: State{Sample: sample, RunID: runID}, seen: map[string]cleanup.Resource{}, bodies: map[string]json.RawMessage{}}
+}
+
+// Resume returns a Tracker for a run that resumes the run whose state is in
+// the file of store.
+func Resume(ctx context.Context, store Store, file, sample, runID string) (*Tracker, error) {
+	earlier, err := Read(ctx, store, file, sample, runID)
+	if err != nil {
+		return nil, err
+	}
+	t := New(store, file, sample, runID)
+	t.resumed = earlier.Steps
+	t.state.Resources = earlier.Resources
+	for id, body := range earlier.Desired {
+		t.bodies[strings.ToLower(id)] = body
+	}
+	return t, nil
+}
+
+// Read returns the state of the run ID of sample in the file of store.
+func Read(ctx context.Context, store Store, file, sample, runID string) (*State, error) {
+	where := store.Where(file)
+	data, err := store.Read(ctx, file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", where, err)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("no state of run %s of the %s sample in %s", runID, sample, where)
+	}
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", where, err)
+	}
+	if state.Sample != sample || state.RunID != runID {
+		return nil, fmt.Errorf("%s is the state of run %s of the %s sample, not of run %s of the %s sample", where, state.RunID, state.Sample, runID, sample)
+	}
+	return &state, nil
+}
+
+// Where returns the location of the state file of the run.
+func (t *Tracker) Where() string {
+	return t.store.Where(t.file)
+}
+
+// Close releases the lock the run holds on its state file, if any, so that
+// other runs with the same names may start.
+func (t *Tracker) Close() {
+	if t.unlock != nil {
+		t.unlock()
+		t.unlock = nil
+	}
+}
+
+// Restored returns the resources the resumed run created, which the run
+// counts as its own, and those of the steps it skips that the resumed run
+// adopted.
+func (t *Tracker) Restored() (created []cleanup.Resource, adopted []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	created = append(created, t.state.Resources...)
+	for _, step := range t.resumed {
+		for _, r := range step.Resources {
+			if !contains(created, r.ID) {
+				adopted = append(adopted, r.ID)
+			}
+		}
+	}
+	return created, adopted
+}
+
+// Verify checks that the resources of the steps the run skips still exist. It
+// stops skipping at the first step with a resource that no longer exists, so
+// that the run repeats that step and every one after it.
+func (t *Tracker) Verify(ctx context.Context, cred azcore.TokenCredential, options *arm.ClientOptions) error {
+	t.mu.Lock()
+	resumed := t.resumed
+	t.mu.Unlock()
+	if len(resumed) == 0 {
+		fmt.Fprintf(t.Out, "Run %s completed no steps, starting from the beginning\n", t.state.RunID)
+		return nil
+	}
+	pl, err := armruntime.NewPipeline("checkpoint", "v1.0.0", cred, runtime.PipelineOptions{}, options)
+	if err != nil {
+		return err
+	}
+	for i, step := range resumed {
+		for _, r := range step.Resources {
+			found, err := exists(ctx, pl, r)
+			if err != nil {
+				return fmt.Errorf("failed to check whether %s of step %q still exists: %w", r, step.Name, err)
+			}
+			if !found {
+				fmt.Fprintf(t.Out, "%s of step %q no longer exists, resuming run %s from that step\n", r, step.Name, t.state.RunID)
+				t.mu.Lock()
+				t.resumed = resumed[:i]
+				t.mu.Unlock()
+				return nil
+			}
+		}
+	}
+	fmt.Fprintf(t.Out, "Resuming run %s after its %d completed steps\n", t.state.RunID, len(resumed))
+	return nil
+}
+
+func exists(ctx context.Context, pl runtime.Pipeline, r cleanup.Resource) (bool, error) {
+	req, err := runtime.NewRequest(ctx, http.MethodGet, r.Endpoint+r.ID)
+	if err != nil {
+		return false, err
+	}
+	query := req.Raw().URL.Query()
+	query.Set("api-version", r.APIVersion)
+	req.Raw().URL.RawQuery = query.Encode()
+	resp, err := pl.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return false, nil
+	case !runtime.HasStatusCode(resp, http.StatusOK):
+		return false, runtime.NewResponseError(resp)
+	}
+	return true, nil
+}
+
+// Skip implements report.Checkpoints. A step is skipped when it is the next
+// step the resumed run completed; any other step ends the skipping, since the
+// steps after it may depend on it.
+func (t *Tracker) Skip(name string, result interface{}) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.resumed) == 0 || t.resumed[0].Name != name {
+		t.resumed = nil
+		return false
+	}
+	step := t.resumed[0]
+	if result != nil && len(step.Result) > 0 {
+		if err := json.Unmarshal(step.Result, result); err != nil {
+			fmt.Fprintf(t.Out, "Warning: failed to restore the result of step %q, repeating it: %s\n", name, err)
+			t.resumed = nil
+			return false
+		}
+	}
+	t.resumed = t.resumed[1:]
+	fmt.Fprintf(t.Out, "Skipping step %q, completed at %s\n", name, step.Completed.Format(time.RFC3339))
+	t.state.Steps = append(t.state.Steps, step)
+	t.save()
+	return true
+}
+
+// Complete implements report.Checkpoints.
+func (t *Tracker) Complete(name string, resourceIDs []string, result interface{}) {
+	step := Step{Name: name, Completed: time.Now().UTC()}
+	if result != nil {
+		data, err := json.Marshal(result)
+		if err != nil {
+			fmt.Fprintf(t.Out, "Warning: failed to record the result of step %q: %s\n", name, err)
+		}
+		step.Result = data
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, id := range resourceIDs {
+		r, ok := t.seen[strings.ToLower(id)]
+		if ok && t.created(id) {
+			step.Resources = append(step.Resources, r)
+		}
+	}
+	// A step may delete the resources of an earlier one on purpose, which a
+	// resumed run must not take for a reason to repeat that step.
+	for i := range t.state.Steps {
+		kept := t.state.Steps[i].Resources[:0]
+		for _, r := range t.state.Steps[i].Resources {
+			if t.created(r.ID) {
+				kept = append(kept, r)
+			}
+		}
+		t.state.Steps[i].Resources = kept
+	}
+	t.state.Steps = append(t.state.Steps, step)
+	t.save()
+}
+
+func (t *Tracker) created(id string) bool {
+	return t.Created != nil && t.Created(id)
+}
+
+// Save writes the state file with the resources the run created so far.
+func (t *Tracker) Save() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.save()
+}
+
+func (t *Tracker) save() {
+	if t.Resources != nil {
+		t.state.Resources = t.Resources()
+	}
+	t.state.Desired = nil
+	for _, r := range t.state.Resources {
+		if body, ok := t.bodies[strings.ToLower(r.ID)]; ok {
+			if t.state.Desired == nil {
+				t.state.Desired = map[string]json.RawMessage{}
+			}
+			t.state.Desired[r.ID] = body
+		}
+	}
+	t.state.Updated = time.Now().UTC()
+	data, err := json.MarshalIndent(t.state, "", "  ")
+	if err == nil {
+		// The state is written at the end of a run as well, after its context
+		// may have been cancelled.
+		ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+		err = t.store.Write(ctx, t.file, data)
+		cancel()
+	}
+	if err != nil {
+		fmt.Fprintf(t.Out, "Warning: failed to write the state of the run to %s: %s\n", t.Where(), err)
+	}
+}
+
+// Configure adds a policy to o that notes the API version of the requests
+// for every resource, which the check of a resumed run needs, and the body of
+// the last PUT of every resource.
+func (t *Tracker) Configure(o *policy.ClientOptions) {
+	o.PerCallPolicies = append(o.PerCallPolicies, policyFunc(t.note))
+}
+
+type policyFunc func(*policy.Request) (*http.Response, error)
+
+func (pf policyFunc) Do(req *policy.Request) (*http.Response, error) {
+	return pf(req)
+}
+
+func (t *Tracker) note(req *policy.Request) (*http.Response, error) {
+	u := req.Raw().URL
+	if version := u.Query().Get("api-version"); version != "" && isResourceID(u.Path) {
+		body, err := putBody(req)
+		if err != nil {
+			return nil, err
+		}
+		t.mu.Lock()
+		t.seen[strings.ToLower(u.Path)] = cleanup.Resource{ID: u.Path, APIVersion: version, Endpoint: u.Scheme + "://" + u.Host}
+		if body != nil {
+			t.bodies[strings.ToLower(u.Path)] = body
+		}
+		t.mu.Unlock()
+	}
+	return req.Next()
+}
+
+// secret matches the names of the properties that are left out of the bodies
+// in the state, such as the admin password of a virtual machine, next to
+// those cassette.ScrubJSON redacts.
+var secret = regexp.MustCompile(`(?i)password|secret|key$|keys$`)
+
+// putBody returns the JSON body of req without its secrets if req is a PUT,
+// nil otherwise. The state may be shared in a blob, so the secrets are left
+// out rather than masked, which diff skips as properties the service does
+// not return.
+func putBody(req *policy.Request) (json.RawMessage, error) {
+	if req.Raw().Method != http.MethodPut || req.Body() == nil {
+		return nil, nil
+	}
+	data, err := io.ReadAll(req.Body())
+	if err != nil {
+		return nil, err
+	}
+	if err := req.RewindBody(); err != nil {
+		return nil, err
+	}
+	var body interface{}
+	if json.Unmarshal(data, &body) != nil {
+		return nil, nil
+	}
+	cassette.ScrubJSON(body)
+	redact(body)
+	return json.Marshal(body)
+}
+
+// redact deletes the properties of v whose name is a secret and those
+// cassette.ScrubJSON redacted.
+func redact(v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if s, ok := item.(string); ok && (secret.MatchString(key) || s == cassette.Redacted) {
+				delete(v, key)
+				continue
+			}
+			redact(item)
+		}
+	case []interface{}:
+		for _, item := range v {
+			redact(item)
+		}
+	}
+}
+
+// isResourceID reports whether path addresses a resource group or a resource
+// below one, rather than a collection or an action.
+func isResourceID(path string) bool {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	return len(segments) >= 4 && len(segments)%2 == 0 && strings.EqualFold(segments[0], "subscriptions")
+}
+
+func contains(resources []cleanup.Resource, id string) bool {
+	for _, r := range resources {
+		if strings.EqualFold(r.ID, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// Backend is where the state files are kept: "file" for a local directory or
+// "blob" for a container of a storage account.
+type Backend string
+
+const (
+	FileBackend Backend = "file"
+	BlobBackend Backend = "blob"
+)
+
+func (b *Backend) String() string {
+	return string(*b)
+}
+
+func (b *Backend) Set(value string) error {
+	switch Backend(value) {
+	case FileBackend, BlobBackend:
+		*b = Backend(value)
+		return nil
+	}
+	return fmt.Errorf("unknown state backend %q, want file or blob", value)
+}
+
+// Flags holds the command line flags that select the state files.
+type Flags struct {
+	dir     string
+	backend Backend
+	resume  string
+}
+
+// RegisterFlags defines the state flags on fs.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{backend: FileBackend}
+	fs.StringVar(&f.dir, "stateDir", DefaultDir, "directory of the files that keep the completed steps and created resources of every run, empty to disable")
+	fs.Var(&f.backend, "stateBackend", "where the state of the runs is kept: file, in -stateDir, or blob, in the storage account of the state section of the configuration, leased by the run")
+	fs.StringVar(&f.resume, "resume", "", "resume the run with this ID, skipping the steps it completed whose resources still exist")
+	return f
+}
+
+// Resuming returns the ID of the run -resume selects, if any.
+func (f *Flags) Resuming() string {
+	return f.resume
+}
+
+// Backend returns the backend -stateBackend selects.
+func (f *Flags) Backend() Backend {
+	return f.backend
+}
+
+// Dir returns the directory of the state files -stateDir selects, empty when
+// they are disabled.
+func (f *Flags) Dir() string {
+	return f.dir
+}
+
+// Enabled reports whether the runs keep their state.
+func (f *Flags) Enabled() bool {
+	return f.backend == BlobBackend || f.dir != ""
+}
+
+// Tracker locks the state file of the run runID of sample in store and
+// returns its Tracker, nil when store is nil because the state is disabled.
+// name is the sample, with the suffix that tells apart the runs of the sample
+// with the same ID, such as those of a matrix.
+func (f *Flags) Tracker(ctx context.Context, store Store, name, sample, runID string, out io.Writer) (*Tracker, error) {
+	if store == nil {
+		if f.resume != "" {
+			return nil, errors.New("-resume needs the state files of -stateDir")
+		}
+		return nil, nil
+	}
+	file := FileName(name, runID)
+	unlock, err := store.Lock(ctx, file)
+	if err != nil {
+		return nil, err
+	}
+	t := New(store, file, sample, runID)
+	if f.resume != "" {
+		if t, err = Resume(ctx, store, file, sample, runID); err != nil {
+			unlock()
+			return nil, err
+		}
+	}
+	t.Out = out
+	t.unlock = unlock
+	return t, nil
+}
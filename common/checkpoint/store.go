@@ -0,0 +1,50 @@
+package checkpoint
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Store keeps the state files of runs by name.
+type Store interface {
+	// Read returns the content of the state file, nil when there is none.
+	Read(ctx context.Context, file string) ([]byte, error)
+	// Write replaces the content of the state file.
+	Write(ctx context.Context, file string, data []byte) error
+	// Lock keeps other runs from using the state file until unlock is
+	// called, or fails when another run holds it.
+	Lock(ctx context.Context, file string) (unlock func(), err error)
+	// Where describes the location of the state file for the messages.
+	Where(file string) string
+}
+
+// Dir is a Store that keeps the state files in a local directory. It locks
+// nothing: the runs that share a directory share a machine as well, and tell
+// their state apart by run ID.
+type Dir string
+
+func (d Dir) Read(ctx context.Context, file string) ([]byte, error) {
+	data, err := os.ReadFile(d.Where(file))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (d Dir) Write(ctx context.Context, file string, data []byte) error {
+	if err := os.MkdirAll(string(d), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(d.Where(file), data, 0o644)
+}
+
+func (d Dir) Lock(ctx context.Context, file string) (func(), error) {
+	return func() {}, nil
+}
+
+func (d Dir) Where(file string) string {
+	return filepath.Join(string(d), file)
+}
@@ -0,0 +1,388 @@
+// Package cleanup records the ARM resources a run creates so that they can be
+// rolled back when the run fails or is interrupted.
+package cleanup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	armruntime "github.com/Azure/azure-sdk-for-go/sdk/azcore/arm/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/lro"
+)
+
+// NotifyContext returns a context that is cancelled on SIGINT or SIGTERM. A
+// second signal terminates the process right away, skipping any cleanup.
+func NotifyContext(parent context.Context, out io.Writer) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			fmt.Fprintf(out, "\nReceived %s, cancelling the run. Send it again to exit without cleaning up.\n", sig)
+			cancel()
+		case <-ctx.Done():
+			return
+		}
+		<-signals
+		fmt.Fprintln(out, "Exiting without cleaning up")
+		os.Exit(130)
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
+
+// Resource is an ARM resource that did not exist before the run created it.
+type Resource struct {
+	ID         string `json:"id"`
+	APIVersion string `json:"apiVersion"`
+	Endpoint   string `json:"endpoint"`
+}
+
+func (r Resource) String() string {
+	id, err := arm.ParseResourceID(r.ID)
+	if err != nil {
+		return r.ID
+	}
+	return fmt.Sprintf("%s %s", id.ResourceType, id.Name)
+}
+
+// Stack records, in creation order, every resource created through the
+// clients it is configured on. A PUT counts as a creation when it answers
+// 201, or, for the resource types whose PUT answers alike either way, when a
+// GET of the same resource returned 404 right before it, so resources that
+// existed before the run are never recorded. Successful DELETEs remove
+// resources from the stack again.
+type Stack struct {
+	out       io.Writer
+	mu        sync.Mutex
+	resources []Resource
+	// adopted are the IDs of the resources of an earlier run with the same
+	// run ID that the run adopted. They count as created by the run, but are
+	// not rolled back.
+	adopted map[string]bool
+	// kept are the IDs of the resources that Rollback leaves alone, with
+	// the resources below them.
+	kept []string
+}
+
+// NewStack creates an empty Stack that reports to out.
+func NewStack(out io.Writer) *Stack {
+	return &Stack{out: out}
+}
+
+// Configure adds the policy that records created resources to o. Call it
+// before any other policy is added so the GETs of the resource types in
+// getBeforePut are reported on their own.
+func (s *Stack) Configure(o *policy.ClientOptions) {
+	o.PerCallPolicies = append(o.PerCallPolicies, policyFunc(s.record))
+}
+
+// Resources returns the recorded resources in creation order.
+func (s *Stack) Resources() []Resource {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Resource(nil), s.resources...)
+}
+
+// Restore records resources an earlier run with the same run ID created,
+// which the run resumes, as created by the run.
+func (s *Stack) Restore(resources []Resource) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range resources {
+		if !containsID(s.resources, r.ID) {
+			s.resources = append(s.resources, r)
+		}
+	}
+}
+
+func containsID(resources []Resource, id string) bool {
+	for _, r := range resources {
+		if strings.EqualFold(r.ID, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// Adopt records that the run adopted the existing resource with the ID.
+func (s *Stack) Adopt(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.adopted == nil {
+		s.adopted = map[string]bool{}
+	}
+	s.adopted[strings.ToLower(id)] = true
+}
+
+// Keep leaves the resource with the ID, and the resources below it, out of
+// the rollback. They are still recorded as created by the run.
+func (s *Stack) Keep(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.kept = append(s.kept, id)
+}
+
+// Created reports whether the run created or adopted the resource with the
+// ID and has not deleted it since.
+func (s *Stack) Created(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.adopted[strings.ToLower(id)] || containsID(s.resources, id)
+}
+
+type policyFunc func(*policy.Request) (*http.Response, error)
+
+func (pf policyFunc) Do(req *policy.Request) (*http.Response, error) {
+	return pf(req)
+}
+
+func (s *Stack) record(req *policy.Request) (*http.Response, error) {
+	raw := req.Raw()
+	if !isResourceID(raw.URL.Path) {
+		return req.Next()
+	}
+	switch raw.Method {
+	case http.MethodPut:
+		checked := getBeforePut[strings.ToLower(resourceType(raw.URL.Path))]
+		isNew := checked && notFound(req)
+		resp, err := req.Next()
+		if err == nil && !checked {
+			isNew = resp.StatusCode == http.StatusCreated
+		}
+		if err == nil && isNew && resp.StatusCode < 300 {
+			s.push(Resource{
+				ID:         raw.URL.Path,
+				APIVersion: raw.URL.Query().Get("api-version"),
+				Endpoint:   raw.URL.Scheme + "://" + raw.URL.Host,
+			})
+		}
+		return resp, err
+	case http.MethodDelete:
+		resp, err := req.Next()
+		if err == nil && resp.StatusCode < 300 {
+			s.forget(raw.URL.Path)
+		}
+		return resp, err
+	}
+	return req.Next()
+}
+
+// getBeforePut are the resource types whose PUT does not tell a creation
+// from an update: key vaults answer 200 and storage accounts and managed
+// disks 202 either way. Whether they are new takes a GET before the PUT. The
+// PUT of any other type answers 201 when it created the resource and 200
+// when it updated it.
+var getBeforePut = map[string]bool{
+	"microsoft.keyvault/vaults":         true,
+	"microsoft.storage/storageaccounts": true,
+	"microsoft.compute/disks":           true,
+}
+
+// resourceType returns the type of the resource at path, or "" when path is
+// not a resource ID.
+func resourceType(path string) string {
+	id, err := arm.ParseResourceID(path)
+	if err != nil {
+		return ""
+	}
+	return id.ResourceType.String()
+}
+
+// notFound reports whether a GET of the resource req is about to PUT returns
+// 404. Any other outcome counts as existing, so the resource is left alone.
+func notFound(req *policy.Request) bool {
+	get := req.Clone(req.Raw().Context())
+	get.Raw().Method = http.MethodGet
+	if err := get.SetBody(nil, ""); err != nil {
+		return false
+	}
+	resp, err := get.Next()
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusNotFound
+}
+
+// isResourceID reports whether path addresses a resource group or a resource
+// below one, rather than a collection or an action.
+func isResourceID(path string) bool {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	return len(segments) >= 4 && len(segments)%2 == 0 && strings.EqualFold(segments[0], "subscriptions")
+}
+
+func (s *Stack) push(r Resource) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resources = append(s.resources, r)
+}
+
+// forget drops id and everything below it.
+func (s *Stack) forget(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.resources[:0]
+	for _, r := range s.resources {
+		if !within(r.ID, id) {
+			kept = append(kept, r)
+		}
+	}
+	s.resources = kept
+}
+
+// within reports whether id is parent or one of its descendants.
+func within(id, parent string) bool {
+	return strings.EqualFold(id, parent) || strings.HasPrefix(strings.ToLower(id), strings.ToLower(parent)+"/")
+}
+
+// Rollback deletes the recorded resources that are not kept, newest first. A
+// resource is only created after the resources it refers to, so this is
+// reverse dependency order. Resources below a recorded parent are skipped
+// since deleting the parent removes them too. It prints every resource it
+// could not delete and returns them.
+func (s *Stack) Rollback(ctx context.Context, cred azcore.TokenCredential, options *arm.ClientOptions, w *lro.Waiter) []Resource {
+	resources := s.unkept()
+	if len(resources) == 0 {
+		if len(s.Resources()) > 0 {
+			fmt.Fprintln(s.out, "This run keeps the resources it created")
+		} else {
+			fmt.Fprintln(s.out, "This run did not create any resources")
+		}
+		return nil
+	}
+	pl, err := armruntime.NewPipeline("cleanup", "v1.0.0", cred, runtime.PipelineOptions{}, options)
+	if err != nil {
+		fmt.Fprintf(s.out, "Failed to create cleanup pipeline: %s\n", err)
+		s.printLeft(resources)
+		return resources
+	}
+
+	var left []Resource
+	for i := len(resources) - 1; i >= 0; i-- {
+		r := resources[i]
+		if hasParent(r, resources) {
+			continue
+		}
+		fmt.Fprintf(s.out, "Deleting %s\n", r)
+		if err := remove(ctx, pl, w, r); err != nil {
+			fmt.Fprintf(s.out, "Failed to delete %s: %s\n", r, err)
+			left = append(left, r)
+			continue
+		}
+		s.forget(r.ID)
+	}
+	s.printLeft(left)
+	return left
+}
+
+// unkept returns the recorded resources, except those the run keeps.
+func (s *Stack) unkept() []Resource {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var resources []Resource
+	for _, r := range s.resources {
+		kept := false
+		for _, id := range s.kept {
+			kept = kept || within(r.ID, id)
+		}
+		if !kept {
+			resources = append(resources, r)
+		}
+	}
+	return resources
+}
+
+func hasParent(r Resource, resources []Resource) bool {
+	for _, p := range resources {
+		if p.ID != r.ID && within(r.ID, p.ID) {
+			return true
+		}
+	}
+	return false
+}
+
+func remove(ctx context.Context, pl runtime.Pipeline, w *lro.Waiter, r Resource) error {
+	req, err := runtime.NewRequest(ctx, http.MethodDelete, r.Endpoint+r.ID)
+	if err != nil {
+		return err
+	}
+	query := req.Raw().URL.Query()
+	query.Set("api-version", r.APIVersion)
+	req.Raw().URL.RawQuery = query.Encode()
+	resp, err := pl.Do(req)
+	if err != nil {
+		return err
+	}
+	switch resp.StatusCode {
+	case http.StatusNotFound, http.StatusNoContent, http.StatusOK:
+		return nil
+	case http.StatusAccepted:
+		poller, err := runtime.NewPoller[lro.Result](resp, pl, nil)
+		if err != nil {
+			return err
+		}
+		kind, name := lro.Kind("resource"), r.ID
+		if id, err := arm.ParseResourceID(r.ID); err == nil {
+			kind, name = lro.KindOf(id.ResourceType.String()), id.Name
+		}
+		_, err = lro.Wait(ctx, w, lro.Delete(kind, name), poller)
+		return err
+	}
+	return runtime.NewResponseError(resp)
+}
+
+func (s *Stack) printLeft(left []Resource) {
+	if len(left) == 0 {
+		fmt.Fprintln(s.out, "All resources created by this run were deleted")
+		return
+	}
+	fmt.Fprintln(s.out, "The following resources created by this run were left behind:")
+	for _, r := range left {
+		fmt.Fprintf(s.out, "  %s\n", r.ID)
+	}
+}
+
+// Mode selects when a run deletes the resources it created.
+type Mode string
+
+const (
+	OnFailure Mode = "on-failure"
+	Always    Mode = "always"
+	Never     Mode = "never"
+)
+
+func (m *Mode) String() string {
+	return string(*m)
+}
+
+// Set implements flag.Value.
+func (m *Mode) Set(value string) error {
+	switch Mode(value) {
+	case OnFailure, Always, Never:
+		*m = Mode(value)
+		return nil
+	}
+	return fmt.Errorf("unknown cleanup mode %q, expected %s, %s or %s", value, OnFailure, Always, Never)
+}
+
+// Applies reports whether a run that ended in failure or success should roll
+// back. An interrupted run counts as failed.
+func (m Mode) Applies(failed bool) bool {
+	return m == Always || (m == OnFailure && failed)
+}
@@ -0,0 +1,358 @@
+// Package converge lets reruns of a sample converge on the resources an
+// earlier run with the same run ID left behind. Before a step creates a
+// resource it looks for an existing one and, comparing it with the resource it
+// would create, adopts it, updates it in place or reports how it drifted.
+package converge
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/tags"
+)
+
+// Action is what a step does about the resource it would create.
+type Action int
+
+const (
+	// Create creates the resource, which does not exist.
+	Create Action = iota
+	// Adopt uses the existing resource, which matches.
+	Adopt
+	// Update updates the existing resource in place.
+	Update
+)
+
+func (a Action) String() string {
+	switch a {
+	case Adopt:
+		return "adopt"
+	case Update:
+		return "update"
+	}
+	return "create"
+}
+
+// Difference is a property of an existing resource that differs from the
+// resource a step would create.
+type Difference struct {
+	// Path is the path of the property, such as location or
+	// properties.subnets[default].properties.addressPrefix.
+	Path string
+	// Have and Want are the JSON values of the property, Have is empty when
+	// the existing resource lacks it and Want when it is an item of a list
+	// that only the existing resource has.
+	Have, Want string
+	// Immutable is set for the properties that cannot be updated in place,
+	// and for the tags that tell which run the resource belongs to.
+	Immutable bool
+}
+
+func (d Difference) String() string {
+	if d.Want == "" {
+		return fmt.Sprintf("%s is %s, want none", d.Path, d.Have)
+	}
+	if d.Have == "" {
+		return fmt.Sprintf("%s is missing, want %s", d.Path, d.Want)
+	}
+	return fmt.Sprintf("%s is %s, want %s", d.Path, d.Have, d.Want)
+}
+
+// DriftError reports an existing resource that cannot be updated in place to
+// the resource a step would create.
+type DriftError struct {
+	Resource    string
+	Differences []Difference
+}
+
+func (e *DriftError) Error() string {
+	var diffs []string
+	for _, d := range e.Differences {
+		diffs = append(diffs, d.String())
+	}
+	return fmt.Sprintf("the existing %s has drifted: %s; delete it or use another -runID", e.Resource, strings.Join(diffs, "; "))
+}
+
+// ignored are the tags that differ between the runs of an ID without the
+// resource drifting.
+var ignored = map[string]bool{tags.CreatedAt: true, tags.ExpiresAt: true}
+
+// owner are the tags that tell which run a resource belongs to. A resource
+// with other values belongs to someone else and is never adopted.
+var owner = map[string]bool{tags.RunID: true, tags.Creator: true}
+
+// Checker decides what the steps of a run do about the resources that exist
+// already. A nil Checker reports nothing and records no adoptions.
+type Checker struct {
+	// Out receives the decisions about existing resources.
+	Out io.Writer
+	// Adopted is called with the ID of every existing resource the run
+	// adopts or updates, so that it counts as created by the run.
+	Adopted func(id string)
+}
+
+// Check compares the existing resource have with want, the resource a step
+// would create, both in the form of the clients. Only the properties set in
+// want are compared, and those the service does not return are skipped. It
+// returns Adopt when they match and Update when they differ in properties
+// other than the immutable ones, paths such as location or properties.
+// storageProfile. Any other difference is reported as a *DriftError.
+func (c *Checker) Check(what string, have, want interface{}, immutable ...string) (Action, error) {
+	diffs, err := Compare(have, want, immutable...)
+	if err != nil {
+		return Create, err
+	}
+	h, _ := toJSON(have)
+	var drift []Difference
+	for _, d := range diffs {
+		if d.Immutable {
+			drift = append(drift, d)
+		}
+	}
+	if len(drift) > 0 {
+		return Create, &DriftError{Resource: what, Differences: drift}
+	}
+
+	out := io.Discard
+	if c != nil && c.Out != nil {
+		out = c.Out
+	}
+	action := Adopt
+	switch {
+	case provisioningState(h) == "Failed":
+		action = Update
+		fmt.Fprintf(out, "Updating the existing %s, whose provisioning failed\n", what)
+	case len(diffs) > 0:
+		action = Update
+		var changes []string
+		for _, d := range diffs {
+			changes = append(changes, d.String())
+		}
+		fmt.Fprintf(out, "Updating the existing %s in place: %s\n", what, strings.Join(changes, "; "))
+	default:
+		fmt.Fprintf(out, "Adopting the existing %s\n", what)
+	}
+	if id, ok := field(h, "id").(string); ok && c != nil && c.Adopted != nil {
+		c.Adopted(id)
+	}
+	return action, nil
+}
+
+// Compare returns the differences between the existing resource have and
+// want, both in the form of the clients or of their JSON, as Check finds
+// them, with the immutable ones marked.
+func Compare(have, want interface{}, immutable ...string) ([]Difference, error) {
+	h, err := toJSON(have)
+	if err != nil {
+		return nil, err
+	}
+	w, err := toJSON(want)
+	if err != nil {
+		return nil, err
+	}
+	var diffs []Difference
+	compare("", h, w, &diffs)
+	for i := range diffs {
+		diffs[i].Immutable = isImmutable(diffs[i].Path, immutable)
+	}
+	return diffs, nil
+}
+
+// Ensure makes a step converge on the resource what: it gets the existing
+// resource with get and, unless Check adopts it, creates or updates it with
+// put, which are passed the context of the step by their closures. It
+// returns the resource the step ends with.
+func Ensure[T any](c *Checker, what string, get func() (T, error), want interface{}, put func() (T, error), immutable ...string) (T, error) {
+	have, err := get()
+	switch {
+	case NotFound(err):
+		return put()
+	case err != nil:
+		return have, fmt.Errorf("failed to get %s: %w", what, err)
+	}
+	action, err := c.Check(what, have, want, immutable...)
+	if err != nil || action == Adopt {
+		return have, err
+	}
+	return put()
+}
+
+// NotFound reports whether err is the response of the service to a resource
+// that does not exist.
+func NotFound(err error) bool {
+	var respErr *azcore.ResponseError
+	return errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound
+}
+
+func toJSON(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var decoded interface{}
+	err = json.Unmarshal(data, &decoded)
+	return decoded, err
+}
+
+func field(v interface{}, key string) interface{} {
+	m, _ := v.(map[string]interface{})
+	return m[key]
+}
+
+func provisioningState(v interface{}) string {
+	state, _ := field(field(v, "properties"), "provisioningState").(string)
+	return state
+}
+
+// compare appends the differences between the properties set in want and
+// those of have at path to diffs.
+func compare(path string, have, want interface{}, diffs *[]Difference) {
+	switch w := want.(type) {
+	case nil:
+	case map[string]interface{}:
+		h, _ := have.(map[string]interface{})
+		keys := make([]string, 0, len(w))
+		for key := range w {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			child := join(path, key)
+			hv, ok := lookup(h, key)
+			switch {
+			case path == "tags" && ignored[key]:
+			case !ok && child == "tags":
+				compare(child, map[string]interface{}{}, w[key], diffs)
+			case !ok && path == "tags":
+				// A missing tag is a difference, while a missing property
+				// is one the service does not return, such as a password.
+				*diffs = append(*diffs, Difference{Path: child, Want: encode(w[key])})
+			case ok:
+				compare(child, hv, w[key], diffs)
+			}
+		}
+	case []interface{}:
+		h, ok := have.([]interface{})
+		if !ok {
+			*diffs = append(*diffs, Difference{Path: path, Have: encode(have), Want: encode(want)})
+			return
+		}
+		if names := itemNames(w); names != nil {
+			for i, name := range names {
+				child := fmt.Sprintf("%s[%s]", path, name)
+				if hv := findNamed(h, name); hv != nil {
+					compare(child, hv, w[i], diffs)
+				} else {
+					*diffs = append(*diffs, Difference{Path: child, Want: encode(w[i])})
+				}
+			}
+			// Items added by hand, such as a security rule, are
+			// differences as well.
+			for _, item := range h {
+				if name, ok := field(item, "name").(string); ok && findNamed(w, name) == nil {
+					*diffs = append(*diffs, Difference{Path: fmt.Sprintf("%s[%s]", path, name), Have: encode(item)})
+				}
+			}
+			return
+		}
+		if len(h) != len(w) {
+			*diffs = append(*diffs, Difference{Path: path, Have: encode(have), Want: encode(want)})
+			return
+		}
+		for i := range w {
+			compare(fmt.Sprintf("%s[%d]", path, i), h[i], w[i], diffs)
+		}
+	default:
+		if !equal(have, want) {
+			*diffs = append(*diffs, Difference{Path: path, Have: encode(have), Want: encode(want)})
+		}
+	}
+}
+
+// equal compares scalars, strings without regard to case as ARM does for
+// names, locations and IDs.
+func equal(have, want interface{}) bool {
+	if h, ok := have.(string); ok {
+		w, ok := want.(string)
+		return ok && strings.EqualFold(h, w)
+	}
+	return reflect.DeepEqual(have, want)
+}
+
+func lookup(m map[string]interface{}, key string) (interface{}, bool) {
+	if v, ok := m[key]; ok {
+		return v, true
+	}
+	for k, v := range m {
+		if strings.EqualFold(k, key) {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// itemNames returns the names of the items of a list of named objects, such
+// as subnets or security rules, which are matched by name rather than index.
+func itemNames(items []interface{}) []string {
+	if len(items) == 0 {
+		return nil
+	}
+	names := make([]string, len(items))
+	for i, item := range items {
+		name, ok := field(item, "name").(string)
+		if !ok {
+			return nil
+		}
+		names[i] = name
+	}
+	return names
+}
+
+func findNamed(items []interface{}, name string) interface{} {
+	for _, item := range items {
+		if n, ok := field(item, "name").(string); ok && strings.EqualFold(n, name) {
+			return item
+		}
+	}
+	return nil
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func encode(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+// isImmutable reports whether the property at path is one of the immutable
+// ones or below one, or a tag that tells the owner of the resource.
+func isImmutable(path string, immutable []string) bool {
+	if strings.HasPrefix(path, "tags.") && owner[strings.TrimPrefix(path, "tags.")] {
+		return true
+	}
+	for _, p := range immutable {
+		if path == p || strings.HasPrefix(path, p+".") || strings.HasPrefix(path, p+"[") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,223 @@
+package converge
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/tags"
+)
+
+func decode(t *testing.T, s string) interface{} {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatalf("invalid JSON %s: %s", s, err)
+	}
+	return v
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name      string
+		have      string
+		want      string
+		immutable []string
+		diffs     []Difference
+	}{
+		{
+			name: "equal",
+			have: `{"location": "local", "properties": {"addressSpace": {"addressPrefixes": ["10.0.0.0/16"]}}}`,
+			want: `{"location": "local", "properties": {"addressSpace": {"addressPrefixes": ["10.0.0.0/16"]}}}`,
+		},
+		{
+			name: "case-folded strings and keys",
+			have: `{"Location": "LocalStack", "properties": {"sku": "Standard_LRS"}}`,
+			want: `{"location": "localstack", "properties": {"sku": "standard_lrs"}}`,
+		},
+		{
+			name:  "different scalar",
+			have:  `{"properties": {"diskSizeGB": 64}}`,
+			want:  `{"properties": {"diskSizeGB": 128}}`,
+			diffs: []Difference{{Path: "properties.diskSizeGB", Have: "64", Want: "128"}},
+		},
+		{
+			name:  "different list item",
+			have:  `{"properties": {"addressPrefixes": ["10.0.0.0/16"]}}`,
+			want:  `{"properties": {"addressPrefixes": ["10.1.0.0/16"]}}`,
+			diffs: []Difference{{Path: "properties.addressPrefixes[0]", Have: `"10.0.0.0/16"`, Want: `"10.1.0.0/16"`}},
+		},
+		{
+			name:  "different list length",
+			have:  `{"properties": {"addressPrefixes": ["10.0.0.0/16"]}}`,
+			want:  `{"properties": {"addressPrefixes": ["10.0.0.0/16", "10.1.0.0/16"]}}`,
+			diffs: []Difference{{Path: "properties.addressPrefixes", Have: `["10.0.0.0/16"]`, Want: `["10.0.0.0/16","10.1.0.0/16"]`}},
+		},
+		{
+			name:  "list for a scalar",
+			have:  `{"properties": {"dnsServers": "10.0.0.4"}}`,
+			want:  `{"properties": {"dnsServers": ["10.0.0.4"]}}`,
+			diffs: []Difference{{Path: "properties.dnsServers", Have: `"10.0.0.4"`, Want: `["10.0.0.4"]`}},
+		},
+		{
+			name: "named list in another order",
+			have: `{"properties": {"subnets": [{"name": "backend", "properties": {"addressPrefix": "10.0.1.0/24"}}, {"name": "default", "properties": {"addressPrefix": "10.0.0.0/24"}}]}}`,
+			want: `{"properties": {"subnets": [{"name": "Default", "properties": {"addressPrefix": "10.0.0.0/24"}}, {"name": "backend", "properties": {"addressPrefix": "10.0.1.0/24"}}]}}`,
+		},
+		{
+			name:  "named list item that differs",
+			have:  `{"properties": {"subnets": [{"name": "default", "properties": {"addressPrefix": "10.0.0.0/24"}}]}}`,
+			want:  `{"properties": {"subnets": [{"name": "default", "properties": {"addressPrefix": "10.0.2.0/24"}}]}}`,
+			diffs: []Difference{{Path: "properties.subnets[default].properties.addressPrefix", Have: `"10.0.0.0/24"`, Want: `"10.0.2.0/24"`}},
+		},
+		{
+			name:  "named list item that is missing",
+			have:  `{"properties": {"subnets": [{"name": "default"}]}}`,
+			want:  `{"properties": {"subnets": [{"name": "default"}, {"name": "backend"}]}}`,
+			diffs: []Difference{{Path: "properties.subnets[backend]", Want: `{"name":"backend"}`}},
+		},
+		{
+			name:  "named list item added by hand",
+			have:  `{"properties": {"securityRules": [{"name": "rdp", "properties": {"priority": 100}}, {"name": "ssh", "properties": {"priority": 110}}]}}`,
+			want:  `{"properties": {"securityRules": [{"name": "rdp", "properties": {"priority": 100}}]}}`,
+			diffs: []Difference{{Path: "properties.securityRules[ssh]", Have: `{"name":"ssh","properties":{"priority":110}}`}},
+		},
+		{
+			name: "missing property",
+			have: `{"properties": {"osProfile": {"computerName": "vm"}}}`,
+			want: `{"properties": {"osProfile": {"computerName": "vm", "adminPassword": "secret"}}}`,
+		},
+		{
+			name:  "missing tag",
+			have:  `{"tags": {"owner": "ci"}}`,
+			want:  `{"tags": {"owner": "ci", "env": "test"}}`,
+			diffs: []Difference{{Path: "tags.env", Want: `"test"`}},
+		},
+		{
+			name:  "missing tags",
+			have:  `{"location": "local"}`,
+			want:  `{"location": "local", "tags": {"env": "test"}}`,
+			diffs: []Difference{{Path: "tags.env", Want: `"test"`}},
+		},
+		{
+			name: "tags that differ between runs",
+			have: `{"tags": {"` + tags.CreatedAt + `": "2022-01-01T00:00:00Z"}}`,
+			want: `{"tags": {"` + tags.CreatedAt + `": "2022-01-02T00:00:00Z", "` + tags.ExpiresAt + `": "2022-01-03T00:00:00Z"}}`,
+		},
+		{
+			name:  "owner tag",
+			have:  `{"tags": {"` + tags.RunID + `": "abc123"}}`,
+			want:  `{"tags": {"` + tags.RunID + `": "def456"}}`,
+			diffs: []Difference{{Path: "tags." + tags.RunID, Have: `"abc123"`, Want: `"def456"`, Immutable: true}},
+		},
+		{
+			name:      "immutable property",
+			have:      `{"location": "local", "properties": {"storageProfile": {"osDisk": {"name": "os1"}}, "storageProfileVersion": 1}}`,
+			want:      `{"location": "remote", "properties": {"storageProfile": {"osDisk": {"name": "os2"}}, "storageProfileVersion": 2}}`,
+			immutable: []string{"location", "properties.storageProfile"},
+			diffs: []Difference{
+				{Path: "location", Have: `"local"`, Want: `"remote"`, Immutable: true},
+				{Path: "properties.storageProfile.osDisk.name", Have: `"os1"`, Want: `"os2"`, Immutable: true},
+				{Path: "properties.storageProfileVersion", Have: "1", Want: "2"},
+			},
+		},
+		{
+			name:      "immutable named list",
+			have:      `{"properties": {"subnets": [{"name": "default", "properties": {"addressPrefix": "10.0.0.0/24"}}]}}`,
+			want:      `{"properties": {"subnets": [{"name": "default", "properties": {"addressPrefix": "10.0.2.0/24"}}]}}`,
+			immutable: []string{"properties.subnets"},
+			diffs:     []Difference{{Path: "properties.subnets[default].properties.addressPrefix", Have: `"10.0.0.0/24"`, Want: `"10.0.2.0/24"`, Immutable: true}},
+		},
+	}
+	for _, tt := range tests {
+		diffs, err := Compare(decode(t, tt.have), decode(t, tt.want), tt.immutable...)
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(diffs, tt.diffs) {
+			t.Errorf("%s: differences %+v, want %+v", tt.name, diffs, tt.diffs)
+		}
+	}
+}
+
+func TestIsImmutable(t *testing.T) {
+	immutable := []string{"location", "properties.storageProfile", "properties.subnets"}
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"location", true},
+		{"locations", false},
+		{"properties.storageProfile", true},
+		{"properties.storageProfile.osDisk.name", true},
+		{"properties.storageProfileVersion", false},
+		{"properties.subnets[default]", true},
+		{"properties.subnets[default].properties.addressPrefix", true},
+		{"properties.subnetsCount", false},
+		{"properties", false},
+		{"tags." + tags.RunID, true},
+		{"tags." + tags.Creator, true},
+		{"tags." + tags.CreatedAt, false},
+		{"tags.env", false},
+	}
+	for _, tt := range tests {
+		if got := isImmutable(tt.path, immutable); got != tt.want {
+			t.Errorf("isImmutable(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	const have = `{"id": "/subscriptions/s/resourceGroups/g", "location": "local", "properties": {"provisioningState": "%s"}, "tags": {"env": "test"}}`
+	tests := []struct {
+		name      string
+		state     string
+		want      string
+		action    Action
+		out       string
+		drift     string
+		immutable []string
+	}{
+		{name: "match", state: "Succeeded", want: `{"location": "local"}`, action: Adopt, out: "Adopting the existing resource group g\n"},
+		{name: "mutable difference", state: "Succeeded", want: `{"location": "local", "tags": {"env": "prod"}}`, action: Update, out: `Updating the existing resource group g in place: tags.env is "test", want "prod"` + "\n"},
+		{name: "failed provisioning", state: "Failed", want: `{"location": "local"}`, action: Update, out: "Updating the existing resource group g, whose provisioning failed\n"},
+		{name: "immutable difference", state: "Succeeded", want: `{"location": "remote"}`, immutable: []string{"location"}, action: Create, drift: `the existing resource group g has drifted: location is "local", want "remote"; delete it or use another -runID`},
+	}
+	for _, tt := range tests {
+		var out strings.Builder
+		var adopted []string
+		c := &Checker{Out: &out, Adopted: func(id string) { adopted = append(adopted, id) }}
+		action, err := c.Check("resource group g", decode(t, strings.Replace(have, "%s", tt.state, 1)), decode(t, tt.want), tt.immutable...)
+		if action != tt.action {
+			t.Errorf("%s: action %s, want %s", tt.name, action, tt.action)
+		}
+		if tt.drift != "" {
+			var drift *DriftError
+			if !errors.As(err, &drift) || err.Error() != tt.drift {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.drift)
+			}
+			if len(adopted) != 0 {
+				t.Errorf("%s: adopted %v", tt.name, adopted)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+		}
+		if out.String() != tt.out {
+			t.Errorf("%s: output %q, want %q", tt.name, out.String(), tt.out)
+		}
+		if !reflect.DeepEqual(adopted, []string{"/subscriptions/s/resourceGroups/g"}) {
+			t.Errorf("%s: adopted %v", tt.name, adopted)
+		}
+	}
+
+	// A nil Checker decides the same, without output or adoptions.
+	var c *Checker
+	if action, err := c.Check("resource group g", decode(t, strings.Replace(have, "%s", "Succeeded", 1)), decode(t, `{"location": "local"}`)); action != Adopt || err != nil {
+		t.Errorf("nil Checker: %s, %v", action, err)
+	}
+}
@@ -0,0 +1,211 @@
+// Command fakegen generates fakes of the client interfaces declared by a
+// sample. Every method of a fake calls the function field of the same name
+// with the Func suffix, so a test only sets the methods it expects:
+//
+//	//go:generate go run github.com/Azure-Samples/Hybrid-Golang-Samples/common/fakes/fakegen -type resourceGroupsClient -out fakes_test.go
+//
+// The interfaces are read from the file go generate runs for, or from -source.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+func main() {
+	source := flag.String("source", os.Getenv("GOFILE"), "file declaring the interfaces")
+	types := flag.String("type", "", "comma separated names of the interfaces to fake")
+	out := flag.String("out", "fakes_test.go", "file to write the fakes to")
+	flag.Parse()
+	if *source == "" || *types == "" {
+		fmt.Fprintln(os.Stderr, "usage: fakegen -type name[,name...] [-source file] [-out file]")
+		os.Exit(2)
+	}
+	code, err := generate(*source, strings.Split(*types, ","))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fakegen: %s\n", err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(*out, code, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "fakegen: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// generator writes the fakes of the interfaces declared in one file.
+type generator struct {
+	fset    *token.FileSet
+	file    *ast.File
+	imports map[string]string
+	used    map[string]bool
+	body    bytes.Buffer
+}
+
+func generate(source string, names []string) ([]byte, error) {
+	g := &generator{fset: token.NewFileSet(), imports: map[string]string{}, used: map[string]bool{}}
+	file, err := parser.ParseFile(g.fset, source, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	g.file = file
+	for _, spec := range file.Imports {
+		path, _ := strconv.Unquote(spec.Path.Value)
+		name := path[strings.LastIndex(path, "/")+1:]
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		g.imports[name] = path
+	}
+	for _, name := range names {
+		iface, err := g.lookup(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		if err := g.fake(strings.TrimSpace(name), iface); err != nil {
+			return nil, err
+		}
+	}
+
+	var code bytes.Buffer
+	fmt.Fprintf(&code, "// Code generated by fakegen from %s; DO NOT EDIT.\n\npackage %s\n\n", source, file.Name.Name)
+	var std, paths []string
+	for name := range g.used {
+		path, ok := g.imports[name]
+		if !ok {
+			return nil, fmt.Errorf("package %s is not imported by %s", name, source)
+		}
+		spec := strconv.Quote(path)
+		if path[strings.LastIndex(path, "/")+1:] != name {
+			spec = name + " " + spec
+		}
+		if strings.Contains(strings.SplitN(path, "/", 2)[0], ".") {
+			paths = append(paths, spec)
+		} else {
+			std = append(std, spec)
+		}
+	}
+	sort.Strings(std)
+	sort.Strings(paths)
+	fmt.Fprintf(&code, "import (\n%s\n\n%s\n)\n", strings.Join(std, "\n"), strings.Join(paths, "\n"))
+	code.Write(g.body.Bytes())
+	return format.Source(code.Bytes())
+}
+
+func (g *generator) lookup(name string) (*ast.InterfaceType, error) {
+	for _, decl := range g.file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			spec := spec.(*ast.TypeSpec)
+			if spec.Name.Name != name {
+				continue
+			}
+			iface, ok := spec.Type.(*ast.InterfaceType)
+			if !ok {
+				return nil, fmt.Errorf("%s is not an interface", name)
+			}
+			return iface, nil
+		}
+	}
+	return nil, fmt.Errorf("interface %s not found", name)
+}
+
+func (g *generator) fake(name string, iface *ast.InterfaceType) error {
+	fake := "fake" + string(unicode.ToUpper(rune(name[0]))) + name[1:]
+	type method struct {
+		name, params, args, results string
+		returns                     bool
+	}
+	var methods []method
+	for _, field := range iface.Methods.List {
+		fn, ok := field.Type.(*ast.FuncType)
+		if !ok || len(field.Names) != 1 {
+			return fmt.Errorf("%s embeds %s, only methods are supported", name, g.expr(field.Type))
+		}
+		m := method{name: field.Names[0].Name}
+		var params, args []string
+		i := 0
+		for _, param := range fn.Params.List {
+			names := param.Names
+			if len(names) == 0 {
+				names = []*ast.Ident{{Name: "_"}}
+			}
+			for _, n := range names {
+				arg := n.Name
+				if arg == "_" {
+					arg = fmt.Sprintf("p%d", i)
+				}
+				i++
+				params = append(params, arg+" "+g.expr(param.Type))
+				if _, variadic := param.Type.(*ast.Ellipsis); variadic {
+					arg += "..."
+				}
+				args = append(args, arg)
+			}
+		}
+		m.params = strings.Join(params, ", ")
+		m.args = strings.Join(args, ", ")
+		if fn.Results != nil {
+			var results []string
+			for _, result := range fn.Results.List {
+				n := len(result.Names)
+				if n == 0 {
+					n = 1
+				}
+				for j := 0; j < n; j++ {
+					results = append(results, g.expr(result.Type))
+				}
+			}
+			m.returns = len(results) > 0
+			m.results = strings.Join(results, ", ")
+			if len(results) > 1 {
+				m.results = "(" + m.results + ")"
+			}
+		}
+		methods = append(methods, m)
+	}
+
+	fmt.Fprintf(&g.body, "\nvar _ %s = (*%s)(nil)\n", name, fake)
+	fmt.Fprintf(&g.body, "\n// %s is a fake %s. Its methods call the function\n// fields of the same name with the Func suffix, which panic when they are not\n// set.\n", fake, name)
+	fmt.Fprintf(&g.body, "type %s struct {\n", fake)
+	for _, m := range methods {
+		fmt.Fprintf(&g.body, "%sFunc func(%s) %s\n", m.name, m.params, m.results)
+	}
+	fmt.Fprintf(&g.body, "}\n")
+	for _, m := range methods {
+		fmt.Fprintf(&g.body, "\nfunc (f *%s) %s(%s) %s {\n", fake, m.name, m.params, m.results)
+		fmt.Fprintf(&g.body, "if f.%sFunc == nil {\npanic(%q)\n}\n", m.name, fake+"."+m.name+" called without "+m.name+"Func")
+		if m.returns {
+			fmt.Fprintf(&g.body, "return ")
+		}
+		fmt.Fprintf(&g.body, "f.%sFunc(%s)\n}\n", m.name, m.args)
+	}
+	return nil
+}
+
+// expr prints a type expression and records the packages it refers to.
+func (g *generator) expr(e ast.Expr) string {
+	ast.Inspect(e, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if pkg, ok := sel.X.(*ast.Ident); ok {
+				g.used[pkg.Name] = true
+			}
+		}
+		return true
+	})
+	var buf bytes.Buffer
+	format.Node(&buf, g.fset, e)
+	return buf.String()
+}
@@ -0,0 +1,111 @@
+// Package fakes builds the pagers, pollers and errors returned by the fake
+// clients that fakegen generates for the unit tests of the samples.
+package fakes
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/lro"
+)
+
+// Waiter returns a lro.Waiter that reports to out and polls the pollers
+// returned by Poller without delay.
+func Waiter(out io.Writer) *lro.Waiter {
+	w := lro.NewWaiter(out)
+	w.Frequency = time.Millisecond
+	return w
+}
+
+// Pager returns a pager that yields pages in order. When err is not nil, it
+// is returned instead of the page after the last one, as if fetching that
+// page failed.
+func Pager[T any](pages []T, err error) *runtime.Pager[T] {
+	next := 0
+	return runtime.NewPager(runtime.PagingHandler[T]{
+		More: func(T) bool {
+			return next < len(pages) || (err != nil && next == len(pages))
+		},
+		Fetcher: func(ctx context.Context, _ *T) (T, error) {
+			if next == len(pages) {
+				next++
+				var zero T
+				return zero, err
+			}
+			page := pages[next]
+			next++
+			return page, nil
+		},
+	})
+}
+
+// Poller returns a poller that reports the operation in progress for polls
+// polls and then completes with result, or fails with err when it is not nil.
+func Poller[T any](polls int, result T, err error) *runtime.Poller[T] {
+	handler := &pollingHandler[T]{polls: polls, result: result, err: err}
+	poller, newErr := runtime.NewPoller[T](nil, runtime.Pipeline{}, &runtime.NewPollerOptions[T]{Handler: handler})
+	if newErr != nil {
+		// NewPoller cannot fail when it is given a handler
+		panic(newErr)
+	}
+	return poller
+}
+
+type pollingHandler[T any] struct {
+	polls  int
+	polled int
+	result T
+	err    error
+}
+
+func (h *pollingHandler[T]) Done() bool {
+	return h.polled > h.polls
+}
+
+func (h *pollingHandler[T]) Poll(ctx context.Context) (*http.Response, error) {
+	h.polled++
+	status := "InProgress"
+	if h.Done() {
+		status = "Succeeded"
+		if h.err != nil {
+			status = "Failed"
+		}
+	}
+	return response(http.MethodGet, "https://fakes.invalid/operations", http.StatusOK, fmt.Sprintf(`{"status":%q}`, status)), nil
+}
+
+func (h *pollingHandler[T]) Result(ctx context.Context, out *T) error {
+	if h.err != nil {
+		return h.err
+	}
+	*out = h.result
+	return nil
+}
+
+// ResponseError returns the *azcore.ResponseError a client returns when ARM
+// answers a request with status and the error code.
+func ResponseError(method, rawURL string, status int, code string) error {
+	body := fmt.Sprintf(`{"error":{"code":%q,"message":"fake %s error"}}`, code, code)
+	return runtime.NewResponseError(response(method, rawURL, status, body))
+}
+
+func response(method, rawURL string, status int, body string) *http.Response {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		panic(err)
+	}
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    &http.Request{Method: method, URL: u},
+	}
+}
@@ -0,0 +1,717 @@
+package fakestack
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+)
+
+// DNSSuffix is the domain the data plane endpoints of the fake stamp live in.
+const DNSSuffix = "local.azurestack.external"
+
+const resourceGroupType = "microsoft.resources/resourcegroups"
+
+// style is the way an operation on a resource type reports completion.
+type style int
+
+const (
+	// synchronous operations complete within the initial response.
+	synchronous style = iota
+	// viaAsyncOperation operations return an Azure-AsyncOperation header to
+	// poll for the status.
+	viaAsyncOperation
+	// viaLocation operations return a Location header that answers 202 until
+	// the operation completes.
+	viaLocation
+	// viaBody operations complete once GETs of the resource report a terminal
+	// provisioning state.
+	viaBody
+)
+
+var createStyles = map[string]style{
+	"microsoft.storage/storageaccounts":       viaLocation,
+	"microsoft.keyvault/vaults":               viaBody,
+	"microsoft.network/virtualnetworks":       viaAsyncOperation,
+	"microsoft.network/networksecuritygroups": viaAsyncOperation,
+	"microsoft.network/publicipaddresses":     viaAsyncOperation,
+	"microsoft.network/networkinterfaces":     viaAsyncOperation,
+	"microsoft.compute/virtualmachines":       viaAsyncOperation,
+	"microsoft.compute/disks":                 viaAsyncOperation,
+}
+
+var deleteStyles = map[string]style{
+	resourceGroupType:                         viaLocation,
+	"microsoft.network/virtualnetworks":       viaAsyncOperation,
+	"microsoft.network/networksecuritygroups": viaAsyncOperation,
+	"microsoft.network/publicipaddresses":     viaAsyncOperation,
+	"microsoft.network/networkinterfaces":     viaAsyncOperation,
+	"microsoft.compute/virtualmachines":       viaAsyncOperation,
+	"microsoft.compute/disks":                 viaAsyncOperation,
+}
+
+// childCollections lists the properties whose entries ARM exposes as child
+// resources of their own, keyed by parent type.
+var childCollections = map[string]string{
+	"microsoft.network/virtualnetworks":       "subnets",
+	"microsoft.network/networksecuritygroups": "securityRules",
+	"microsoft.network/networkinterfaces":     "ipConfigurations",
+}
+
+type resource struct {
+	id   string
+	typ  string
+	body map[string]interface{}
+	// op is the long-running operation in progress on the resource, if any.
+	op string
+	// pendingGets counts the GETs that still report the transitional state
+	// of a creation that completes via the resource body.
+	pendingGets int
+	fail        *failure
+	keys        []map[string]interface{}
+}
+
+// Tags returns the tags of the resources on the stamp that have any, by
+// resource ID.
+func (s *Server) Tags() map[string]map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all := map[string]map[string]string{}
+	for _, res := range s.resources {
+		tags, _ := res.body["tags"].(map[string]interface{})
+		if len(tags) == 0 {
+			continue
+		}
+		all[res.id] = map[string]string{}
+		for key, value := range tags {
+			all[res.id][key], _ = value.(string)
+		}
+	}
+	return all
+}
+
+func (r *resource) properties() map[string]interface{} {
+	props, ok := r.body["properties"].(map[string]interface{})
+	if !ok {
+		props = map[string]interface{}{}
+		r.body["properties"] = props
+	}
+	return props
+}
+
+func (r *resource) setState(state string) {
+	r.properties()["provisioningState"] = state
+}
+
+func (r *resource) state() string {
+	state, _ := r.properties()["provisioningState"].(string)
+	return state
+}
+
+// complete moves the resource to the terminal state of its creation.
+func (r *resource) complete() {
+	if r.fail != nil {
+		r.setState("Failed")
+		return
+	}
+	r.setState("Succeeded")
+}
+
+type operation struct {
+	key       string
+	delete    bool
+	remaining int
+	done      bool
+	fail      *failure
+}
+
+// Resources returns the IDs of the resources on the stamp in creation order.
+func (s *Server) Resources() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]string, 0, len(s.order))
+	for _, key := range s.order {
+		ids = append(ids, s.resources[key].id)
+	}
+	return ids
+}
+
+func (s *Server) serveARM(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !s.tokens[token] {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer authorization_uri="%s", error="invalid_token"`, s.LoginEndpoint()))
+		writeError(w, http.StatusUnauthorized, "AuthenticationFailed", "Authentication failed. The 'Authorization' header is missing or invalid.")
+		return
+	}
+	if r.URL.Query().Get("api-version") == "" {
+		writeError(w, http.StatusBadRequest, "MissingApiVersionParameter", "The api-version query parameter (?api-version=) is required for all requests.")
+		return
+	}
+	if s.throttle > 0 {
+		s.throttle--
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusTooManyRequests, "TooManyRequests", "The request is being throttled.")
+		return
+	}
+
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if (len(segments) == 7 || len(segments) == 8) && strings.EqualFold(segments[4], "deployments") && strings.EqualFold(segments[6], "operations") {
+		s.serveDeploymentOperations(w, r, segments)
+		return
+	}
+	if len(segments) == 8 && strings.EqualFold(segments[2], "providers") && strings.EqualFold(segments[4], "locations") {
+		switch strings.ToLower(segments[6]) {
+		case "operations":
+			s.serveOperationStatus(w, segments[7])
+			return
+		case "operationresults":
+			s.serveOperationResult(w, r, segments[7])
+			return
+		}
+	}
+	if len(segments)%2 == 1 {
+		if r.Method == http.MethodPost {
+			s.serveAction(w, r, segments)
+			return
+		}
+		if r.Method == http.MethodGet {
+			s.serveList(w, r, segments)
+			return
+		}
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", fmt.Sprintf("%s is not supported on collections", r.Method))
+		return
+	}
+
+	id, err := arm.ParseResourceID(r.URL.Path)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidResourceId", err.Error())
+		return
+	}
+	key := strings.ToLower(r.URL.Path)
+	switch r.Method {
+	case http.MethodGet:
+		s.get(w, id, key)
+	case http.MethodHead:
+		if s.resources[key] == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodPut:
+		if strings.EqualFold(id.ResourceType.String(), deploymentType) {
+			s.putDeployment(w, r, id, key)
+			return
+		}
+		s.put(w, r, id, key)
+	case http.MethodDelete:
+		s.delete(w, r, id, key)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", fmt.Sprintf("%s is not supported", r.Method))
+	}
+}
+
+func notFound(w http.ResponseWriter, id *arm.ResourceID) {
+	if strings.EqualFold(id.ResourceType.String(), resourceGroupType) {
+		writeError(w, http.StatusNotFound, "ResourceGroupNotFound", fmt.Sprintf("Resource group '%s' could not be found.", id.Name))
+		return
+	}
+	writeError(w, http.StatusNotFound, "ResourceNotFound", fmt.Sprintf("The Resource '%s/%s' under resource group '%s' was not found.", id.ResourceType, id.Name, id.ResourceGroupName))
+}
+
+func (s *Server) get(w http.ResponseWriter, id *arm.ResourceID, key string) {
+	res := s.resources[key]
+	if res == nil {
+		notFound(w, id)
+		return
+	}
+	if res.pendingGets > 0 {
+		res.pendingGets--
+	} else if createStyles[res.typ] == viaBody && !terminal(res.state()) {
+		res.complete()
+	}
+	writeJSON(w, http.StatusOK, res.body)
+}
+
+func terminal(state string) bool {
+	return state == "Succeeded" || state == "Failed" || state == "Canceled"
+}
+
+func (s *Server) put(w http.ResponseWriter, r *http.Request, id *arm.ResourceID, key string) {
+	var body map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidRequestContent", fmt.Sprintf("The request content was invalid and could not be deserialized: %s", err))
+		return
+	}
+	typ := strings.ToLower(id.ResourceType.String())
+	parentType := strings.ToLower(id.Parent.ResourceType.String())
+	topLevel := typ == resourceGroupType || parentType == resourceGroupType
+	switch {
+	case id.ResourceGroupName != "" && typ != resourceGroupType && s.resources[groupKey(id)] == nil:
+		writeError(w, http.StatusNotFound, "ResourceGroupNotFound", fmt.Sprintf("Resource group '%s' could not be found.", id.ResourceGroupName))
+		return
+	case !topLevel && s.resources[strings.ToLower(id.Parent.String())] == nil:
+		writeError(w, http.StatusNotFound, "ParentResourceNotFound", fmt.Sprintf("Can not perform requested operation on nested resource. Parent resource '%s' not found.", id.Parent.Name))
+		return
+	case topLevel && body["location"] == nil:
+		writeError(w, http.StatusBadRequest, "LocationRequired", "The location property is required for this definition.")
+		return
+	}
+	if missing := s.missingReference(body, key); missing != "" {
+		writeError(w, http.StatusBadRequest, "InvalidResourceReference", fmt.Sprintf("Resource %s referenced by resource %s was not found.", missing, r.URL.Path))
+		return
+	}
+	existing := s.resources[key]
+	if existing != nil && existing.op != "" {
+		writeError(w, http.StatusConflict, "AnotherOperationInProgress", fmt.Sprintf("Another operation on resource %s is in progress.", id.Name))
+		return
+	}
+
+	name := strings.ToLower(id.Name)
+	fail, failing := s.failures[name]
+	delete(s.failures, name)
+	style := createStyles[typ]
+	if failing && style == synchronous {
+		writeError(w, http.StatusBadRequest, fail.code, fail.message)
+		return
+	}
+
+	res := s.store(id, r.URL.Path, body)
+	if failing {
+		res.fail = &fail
+	}
+
+	status := http.StatusCreated
+	if existing != nil {
+		status = http.StatusOK
+	}
+	switch style {
+	case synchronous:
+		res.setState("Succeeded")
+		writeJSON(w, status, res.body)
+	case viaBody:
+		res.setState("RegisteringDns")
+		res.pendingGets = s.polls
+		writeJSON(w, status, res.body)
+	case viaAsyncOperation:
+		res.setState(transitionalState(typ))
+		opID := s.startOperation(res, key, false)
+		w.Header().Set("Azure-AsyncOperation", operationURL(r, id, "operations", opID))
+		if typ == "microsoft.compute/disks" {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		writeJSON(w, status, res.body)
+	case viaLocation:
+		res.setState("Creating")
+		opID := s.startOperation(res, key, false)
+		w.Header().Set("Location", operationURL(r, id, "operationResults", opID))
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func transitionalState(typ string) string {
+	if strings.HasPrefix(typ, "microsoft.network/") {
+		return "Updating"
+	}
+	return "Creating"
+}
+
+func groupKey(id *arm.ResourceID) string {
+	return strings.ToLower(fmt.Sprintf("/subscriptions/%s/resourceGroups/%s", id.SubscriptionID, id.ResourceGroupName))
+}
+
+// missingReference returns the first resource ID referenced from body, outside
+// of the resource being written, that does not exist.
+func (s *Server) missingReference(body interface{}, key string) string {
+	switch v := body.(type) {
+	case map[string]interface{}:
+		for field, value := range v {
+			ref, ok := value.(string)
+			if field == "id" && ok && strings.HasPrefix(strings.ToLower(ref), "/subscriptions/") {
+				ref = strings.ToLower(ref)
+				if ref != key && !strings.HasPrefix(ref, key+"/") && s.resources[ref] == nil {
+					return value.(string)
+				}
+				continue
+			}
+			if missing := s.missingReference(value, key); missing != "" {
+				return missing
+			}
+		}
+	case []interface{}:
+		for _, value := range v {
+			if missing := s.missingReference(value, key); missing != "" {
+				return missing
+			}
+		}
+	}
+	return ""
+}
+
+// decorate adds the read-only properties the real resource providers return.
+func (s *Server) decorate(res *resource, id *arm.ResourceID) {
+	props := res.properties()
+	switch res.typ {
+	case "microsoft.storage/storageaccounts":
+		props["primaryEndpoints"] = map[string]interface{}{
+			"blob":  fmt.Sprintf("https://%s.blob.%s/", id.Name, DNSSuffix),
+			"queue": fmt.Sprintf("https://%s.queue.%s/", id.Name, DNSSuffix),
+			"table": fmt.Sprintf("https://%s.table.%s/", id.Name, DNSSuffix),
+		}
+		props["primaryLocation"] = res.body["location"]
+		props["statusOfPrimary"] = "available"
+		if res.keys == nil {
+			res.keys = []map[string]interface{}{newKey("key1"), newKey("key2")}
+		}
+	case "microsoft.keyvault/vaults":
+		props["vaultUri"] = fmt.Sprintf("https://%s.vault.%s/", id.Name, DNSSuffix)
+	case "microsoft.keyvault/vaults/secrets":
+		props["secretUri"] = fmt.Sprintf("https://%s.vault.%s/secrets/%s", id.Parent.Name, DNSSuffix, id.Name)
+		// the secret value is write-only
+		delete(props, "value")
+	case "microsoft.network/publicipaddresses":
+		s.nextID++
+		props["ipAddress"] = fmt.Sprintf("203.0.113.%d", s.nextID%250+1)
+	case "microsoft.network/networkinterfaces":
+		if configs, ok := props["ipConfigurations"].([]interface{}); ok {
+			for _, c := range configs {
+				if config, ok := c.(map[string]interface{}); ok {
+					s.nextID++
+					configProps, _ := config["properties"].(map[string]interface{})
+					if configProps == nil {
+						configProps = map[string]interface{}{}
+						config["properties"] = configProps
+					}
+					configProps["privateIPAddress"] = fmt.Sprintf("10.0.0.%d", s.nextID%250+4)
+				}
+			}
+		}
+	case "microsoft.compute/virtualmachines":
+		s.nextID++
+		props["vmId"] = fmt.Sprintf("%08d-0000-4000-8000-000000000000", s.nextID)
+	}
+}
+
+// addChildren registers the entries of the child collection of res, such as
+// the subnets of a virtual network, as resources of their own.
+func (s *Server) addChildren(res *resource, key string) {
+	collection, ok := childCollections[res.typ]
+	if !ok {
+		return
+	}
+	s.remove(key + "/" + strings.ToLower(collection) + "/")
+	children, _ := res.properties()[collection].([]interface{})
+	for _, c := range children {
+		child, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := child["name"].(string)
+		childID := res.id + "/" + collection + "/" + name
+		child["id"] = childID
+		child["type"] = res.body["type"].(string) + "/" + collection
+		props, _ := child["properties"].(map[string]interface{})
+		if props == nil {
+			props = map[string]interface{}{}
+			child["properties"] = props
+		}
+		props["provisioningState"] = "Succeeded"
+		childKey := strings.ToLower(childID)
+		s.resources[childKey] = &resource{id: childID, typ: res.typ + "/" + strings.ToLower(collection), body: child}
+		s.order = append(s.order, childKey)
+	}
+}
+
+func newKey(name string) map[string]interface{} {
+	value := make([]byte, 64)
+	rand.Read(value)
+	return map[string]interface{}{
+		"keyName":     name,
+		"value":       base64.StdEncoding.EncodeToString(value),
+		"permissions": "FULL",
+	}
+}
+
+func (s *Server) delete(w http.ResponseWriter, r *http.Request, id *arm.ResourceID, key string) {
+	res := s.resources[key]
+	if res == nil {
+		if strings.EqualFold(id.ResourceType.String(), resourceGroupType) {
+			notFound(w, id)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if res.op != "" {
+		writeError(w, http.StatusConflict, "AnotherOperationInProgress", fmt.Sprintf("Another operation on resource %s is in progress.", id.Name))
+		return
+	}
+	name := strings.ToLower(id.Name)
+	if f, failing := s.deleteFailures[name]; failing {
+		delete(s.deleteFailures, name)
+		writeError(w, http.StatusConflict, f.code, f.message)
+		return
+	}
+	switch deleteStyles[res.typ] {
+	case viaAsyncOperation:
+		res.setState("Deleting")
+		opID := s.startOperation(res, key, true)
+		w.Header().Set("Azure-AsyncOperation", operationURL(r, id, "operations", opID))
+		w.Header().Set("Location", operationURL(r, id, "operationResults", opID))
+		w.WriteHeader(http.StatusAccepted)
+	case viaLocation:
+		res.setState("Deleting")
+		opID := s.startOperation(res, key, true)
+		w.Header().Set("Location", operationURL(r, id, "operationResults", opID))
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		s.remove(key)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// remove deletes the resource at key together with everything below it. A
+// key ending in a slash removes only what is below it.
+func (s *Server) remove(key string) {
+	prefix := strings.TrimSuffix(key, "/") + "/"
+	kept := s.order[:0]
+	for _, k := range s.order {
+		if k == key || strings.HasPrefix(k, prefix) {
+			delete(s.resources, k)
+			continue
+		}
+		kept = append(kept, k)
+	}
+	s.order = kept
+}
+
+func (s *Server) startOperation(res *resource, key string, del bool) string {
+	s.nextID++
+	opID := fmt.Sprintf("%08d-0000-4000-8000-%012d", s.nextID, s.nextID)
+	op := &operation{key: key, delete: del, remaining: s.polls}
+	if !del {
+		op.fail = res.fail
+	}
+	s.ops[opID] = op
+	res.op = opID
+	return opID
+}
+
+func operationURL(r *http.Request, id *arm.ResourceID, kind, opID string) string {
+	namespace := id.ResourceType.Namespace
+	location := Location
+	path := fmt.Sprintf("/subscriptions/%s/providers/%s/locations/%s/%s/%s", id.SubscriptionID, namespace, location, kind, opID)
+	return absolute(r, path, url.Values{"api-version": {r.URL.Query().Get("api-version")}})
+}
+
+// advance counts a poll of op and completes it once it ran out of polls.
+func (s *Server) advance(op *operation) {
+	if op.done {
+		return
+	}
+	if d := s.deployments[op.key]; d != nil && !op.delete {
+		s.step(d, op)
+		return
+	}
+	if op.remaining > 0 {
+		op.remaining--
+		return
+	}
+	op.done = true
+	res := s.resources[op.key]
+	if res == nil {
+		return
+	}
+	res.op = ""
+	if op.delete {
+		s.remove(op.key)
+		return
+	}
+	res.complete()
+}
+
+func (s *Server) serveOperationStatus(w http.ResponseWriter, opID string) {
+	op := s.ops[opID]
+	if op == nil {
+		writeError(w, http.StatusNotFound, "OperationNotFound", fmt.Sprintf("Operation %s was not found.", opID))
+		return
+	}
+	s.advance(op)
+	switch {
+	case !op.done:
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": "InProgress"})
+	case op.fail != nil:
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status": "Failed",
+			"error":  op.fail.body(),
+		})
+	default:
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": "Succeeded"})
+	}
+}
+
+func (s *Server) serveOperationResult(w http.ResponseWriter, r *http.Request, opID string) {
+	op := s.ops[opID]
+	if op == nil {
+		writeError(w, http.StatusNotFound, "OperationNotFound", fmt.Sprintf("Operation %s was not found.", opID))
+		return
+	}
+	s.advance(op)
+	switch {
+	case !op.done:
+		w.Header().Set("Location", absolute(r, r.URL.Path, r.URL.Query()))
+		w.WriteHeader(http.StatusAccepted)
+	case op.fail != nil:
+		writeFailure(w, http.StatusBadRequest, op.fail)
+	case op.delete || s.resources[op.key] == nil:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSON(w, http.StatusOK, s.resources[op.key].body)
+	}
+}
+
+var filterType = regexp.MustCompile(`(?i)resourceType eq '([^']+)'`)
+
+// serveList answers a GET of a collection, one page at a time.
+func (s *Server) serveList(w http.ResponseWriter, r *http.Request, segments []string) {
+	collection := strings.ToLower("/" + strings.Join(segments, "/"))
+	var wantType string
+	if m := filterType.FindStringSubmatch(r.URL.Query().Get("$filter")); m != nil {
+		wantType = strings.ToLower(m[1])
+	}
+	var items []interface{}
+	for _, key := range s.order {
+		res := s.resources[key]
+		if !s.inCollection(collection, key, res) || (wantType != "" && res.typ != wantType) {
+			continue
+		}
+		items = append(items, res.body)
+	}
+	s.writePage(w, r, items)
+}
+
+// writePage writes the page of items r asks for, linking to the next one.
+func (s *Server) writePage(w http.ResponseWriter, r *http.Request, items []interface{}) {
+	page := map[string]interface{}{}
+	offset, _ := strconv.Atoi(r.URL.Query().Get("$skiptoken"))
+	if offset > len(items) {
+		offset = len(items)
+	}
+	end := len(items)
+	if s.pageSize > 0 && offset+s.pageSize < end {
+		end = offset + s.pageSize
+		query := r.URL.Query()
+		query.Set("$skiptoken", strconv.Itoa(end))
+		page["nextLink"] = absolute(r, r.URL.Path, query)
+	}
+	page["value"] = append([]interface{}{}, items[offset:end]...)
+	writeJSON(w, http.StatusOK, page)
+}
+
+func (s *Server) inCollection(collection, key string, res *resource) bool {
+	segments := strings.Split(strings.Trim(collection, "/"), "/")
+	scope := "/" + strings.Join(segments[:2], "/") + "/"
+	switch {
+	case strings.HasSuffix(collection, "/resources"):
+		// the generic resource list covers top-level resources only
+		scope = strings.TrimSuffix(collection, "resources")
+		return strings.HasPrefix(key, scope) && res.typ != resourceGroupType && res.typ != deploymentType && strings.Count(res.typ, "/") == 1
+	case len(segments) == 5 && segments[2] == "providers":
+		// a provider collection at subscription scope lists the whole subscription
+		return strings.HasPrefix(key, scope) && res.typ == segments[3]+"/"+segments[4]
+	}
+	return key[:strings.LastIndex(key, "/")] == collection
+}
+
+// serveAction answers the POST actions used by the samples.
+func (s *Server) serveAction(w http.ResponseWriter, r *http.Request, segments []string) {
+	action := strings.ToLower(segments[len(segments)-1])
+	if action == "checknameavailability" && len(segments) == 5 {
+		s.checkNameAvailability(w, r, strings.ToLower(segments[3]))
+		return
+	}
+	if action == "validate" && strings.EqualFold(segments[len(segments)-3], "deployments") {
+		s.validateDeployment(w, r, segments)
+		return
+	}
+	key := strings.ToLower("/" + strings.Join(segments[:len(segments)-1], "/"))
+	res := s.resources[key]
+	if res == nil {
+		id, err := arm.ParseResourceID(key)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "InvalidResourceId", err.Error())
+			return
+		}
+		notFound(w, id)
+		return
+	}
+	switch {
+	case res.typ == "microsoft.storage/storageaccounts" && action == "listkeys":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"keys": res.keys})
+	case res.typ == "microsoft.storage/storageaccounts" && action == "regeneratekey":
+		var params struct {
+			KeyName string `json:"keyName"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			writeError(w, http.StatusBadRequest, "InvalidRequestContent", err.Error())
+			return
+		}
+		for i, key := range res.keys {
+			if key["keyName"] == params.KeyName {
+				res.keys[i] = newKey(params.KeyName)
+				writeJSON(w, http.StatusOK, map[string]interface{}{"keys": res.keys})
+				return
+			}
+		}
+		writeError(w, http.StatusBadRequest, "InvalidKeyName", fmt.Sprintf("Key %q does not exist.", params.KeyName))
+	default:
+		writeError(w, http.StatusNotFound, "InvalidAction", fmt.Sprintf("The action '%s' is not supported on %s.", segments[len(segments)-1], res.body["type"]))
+	}
+}
+
+var storageAccountName = regexp.MustCompile(`^[a-z0-9]{3,24}$`)
+
+func (s *Server) checkNameAvailability(w http.ResponseWriter, r *http.Request, namespace string) {
+	var params struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidRequestContent", err.Error())
+		return
+	}
+	typ := namespace + "/storageaccounts"
+	if namespace == "microsoft.keyvault" {
+		typ = namespace + "/vaults"
+	}
+	if typ == "microsoft.storage/storageaccounts" && !storageAccountName.MatchString(params.Name) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"nameAvailable": false,
+			"reason":        "AccountNameInvalid",
+			"message":       fmt.Sprintf("%s is not a valid storage account name. Storage account name must be between 3 and 24 characters in length and use numbers and lower-case letters only.", params.Name),
+		})
+		return
+	}
+	// these names are global, so any resource group counts
+	for _, key := range s.order {
+		res := s.resources[key]
+		if res.typ == typ && strings.EqualFold(res.body["name"].(string), params.Name) {
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"nameAvailable": false,
+				"reason":        "AlreadyExists",
+				"message":       fmt.Sprintf("The storage account named %s is already taken.", params.Name),
+			})
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"nameAvailable": true})
+}
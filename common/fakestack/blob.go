@@ -0,0 +1,220 @@
+package fakestack
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/checkpoint"
+)
+
+// The account of the blob service a Server imitates, with the name and key of
+// the account of the local storage emulators. Its blob endpoint is
+// BlobEndpoint, in the path style of the emulators.
+const (
+	BlobAccount = "devstoreaccount1"
+	BlobKey     = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+)
+
+// blob is a block blob with its lease. A lease without duration is infinite.
+type blob struct {
+	data          []byte
+	lease         string
+	leaseDuration time.Duration
+	leaseExpiry   time.Time
+}
+
+func (b *blob) leased() bool {
+	return b.lease != "" && (b.leaseDuration == 0 || time.Now().Before(b.leaseExpiry))
+}
+
+// BlobEndpoint is the blob endpoint of BlobAccount.
+func (s *Server) BlobEndpoint() string {
+	return s.URL + "/" + BlobAccount
+}
+
+// StateConfig is the state section of a configuration that keeps the state
+// of the runs in the blob service of s.
+func (s *Server) StateConfig() map[string]string {
+	return map[string]string{"StorageAccount": BlobAccount, "AccountKey": BlobKey, "BlobEndpoint": s.BlobEndpoint()}
+}
+
+// Blob returns the content of the blob name in container, and whether it
+// exists and is leased.
+func (s *Server) Blob(container, name string) (data []byte, exists, leased bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := s.containers[container][name]
+	if b == nil {
+		return nil, false, false
+	}
+	return b.data, true, b.leased()
+}
+
+// LeaseBlob creates the blob name in container if needed and takes an
+// infinite lease on it, as a run on another machine would, until
+// ReleaseBlob.
+func (s *Server) LeaseBlob(container, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.containers[container] == nil {
+		s.containers[container] = map[string]*blob{}
+	}
+	b := s.containers[container][name]
+	if b == nil {
+		b = &blob{}
+		s.containers[container][name] = b
+	}
+	b.lease, b.leaseDuration = s.newLeaseID(), 0
+}
+
+// ReleaseBlob breaks the lease of the blob name in container.
+func (s *Server) ReleaseBlob(container, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if b := s.containers[container][name]; b != nil {
+		b.lease = ""
+	}
+}
+
+func (s *Server) newLeaseID() string {
+	s.nextID++
+	return fmt.Sprintf("00000000-0000-0000-0000-%012d", s.nextID)
+}
+
+// serveBlob answers the requests of the blob service the state backend sends:
+// creating containers, putting and getting block blobs, and acquiring,
+// renewing and releasing leases.
+func (s *Server) serveBlob(w http.ResponseWriter, r *http.Request) {
+	if !validSignature(r) {
+		writeBlobError(w, http.StatusForbidden, "AuthenticationFailed", "Server failed to authenticate the request.")
+		return
+	}
+	segments := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"+BlobAccount+"/"), "/", 2)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	query := r.URL.Query()
+	if len(segments) == 1 {
+		if r.Method != http.MethodPut || query.Get("restype") != "container" {
+			writeBlobError(w, http.StatusBadRequest, "UnsupportedHttpVerb", "The resource doesn't support the specified HTTP verb.")
+			return
+		}
+		if s.containers[segments[0]] != nil {
+			writeBlobError(w, http.StatusConflict, "ContainerAlreadyExists", "The specified container already exists.")
+			return
+		}
+		s.containers[segments[0]] = map[string]*blob{}
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	container := s.containers[segments[0]]
+	if container == nil {
+		writeBlobError(w, http.StatusNotFound, "ContainerNotFound", "The specified container does not exist.")
+		return
+	}
+	b := container[segments[1]]
+	switch {
+	case r.Method == http.MethodPut && query.Get("comp") == "lease":
+		if b == nil {
+			writeBlobError(w, http.StatusNotFound, "BlobNotFound", "The specified blob does not exist.")
+			return
+		}
+		s.lease(w, r, b)
+	case r.Method == http.MethodPut:
+		if b != nil && r.Header.Get("If-None-Match") == "*" {
+			writeBlobError(w, http.StatusConflict, "BlobAlreadyExists", "The specified blob already exists.")
+			return
+		}
+		if b != nil && b.leased() && r.Header.Get("x-ms-lease-id") != b.lease {
+			writeBlobError(w, http.StatusPreconditionFailed, "LeaseIdMissing", "There is currently a lease on the blob and no lease ID was specified in the request.")
+			return
+		}
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeBlobError(w, http.StatusBadRequest, "InvalidInput", err.Error())
+			return
+		}
+		if b == nil {
+			b = &blob{}
+			container[segments[1]] = b
+		}
+		b.data = data
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodGet:
+		if b == nil {
+			writeBlobError(w, http.StatusNotFound, "BlobNotFound", "The specified blob does not exist.")
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.Itoa(len(b.data)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(b.data)
+	default:
+		writeBlobError(w, http.StatusBadRequest, "UnsupportedHttpVerb", "The resource doesn't support the specified HTTP verb.")
+	}
+}
+
+func (s *Server) lease(w http.ResponseWriter, r *http.Request, b *blob) {
+	id := r.Header.Get("x-ms-lease-id")
+	switch r.Header.Get("x-ms-lease-action") {
+	case "acquire":
+		if b.leased() {
+			writeBlobError(w, http.StatusConflict, "LeaseAlreadyPresent", "There is already a lease present.")
+			return
+		}
+		duration, err := strconv.Atoi(r.Header.Get("x-ms-lease-duration"))
+		if err != nil || duration != -1 && (duration < 15 || duration > 60) {
+			writeBlobError(w, http.StatusBadRequest, "InvalidHeaderValue", "The value for the x-ms-lease-duration header is not valid.")
+			return
+		}
+		b.lease, b.leaseDuration = s.newLeaseID(), 0
+		if duration > 0 {
+			b.leaseDuration = time.Duration(duration) * time.Second
+			b.leaseExpiry = time.Now().Add(b.leaseDuration)
+		}
+		w.Header().Set("x-ms-lease-id", b.lease)
+		w.WriteHeader(http.StatusCreated)
+	case "renew":
+		if b.lease == "" || id != b.lease {
+			writeBlobError(w, http.StatusConflict, "LeaseIdMismatchWithLeaseOperation", "The lease ID specified did not match the lease ID for the blob.")
+			return
+		}
+		b.leaseExpiry = time.Now().Add(b.leaseDuration)
+		w.Header().Set("x-ms-lease-id", b.lease)
+		w.WriteHeader(http.StatusOK)
+	case "release":
+		if b.lease == "" || id != b.lease {
+			writeBlobError(w, http.StatusConflict, "LeaseIdMismatchWithLeaseOperation", "The lease ID specified did not match the lease ID for the blob.")
+			return
+		}
+		b.lease = ""
+		w.WriteHeader(http.StatusOK)
+	default:
+		writeBlobError(w, http.StatusBadRequest, "InvalidHeaderValue", "The value for the x-ms-lease-action header is not valid.")
+	}
+}
+
+// validSignature reports whether r is signed with the Shared Key of
+// BlobAccount.
+func validSignature(r *http.Request) bool {
+	key, _ := base64.StdEncoding.DecodeString(BlobKey)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(checkpoint.StringToSign(r, BlobAccount)))
+	want := "SharedKey " + BlobAccount + ":" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(r.Header.Get("Authorization")), []byte(want))
+}
+
+// writeBlobError writes an error of the storage services, whose code is in a
+// header as well as in the XML body.
+func writeBlobError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("x-ms-error-code", code)
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<?xml version=\"1.0\" encoding=\"utf-8\"?><Error><Code>%s</Code><Message>%s</Message></Error>", code, message)
+}
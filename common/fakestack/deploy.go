@@ -0,0 +1,1166 @@
+package fakestack
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+)
+
+const deploymentType = "microsoft.resources/deployments"
+
+// deploymentFailedMessage is the message of the error of a deployment with a
+// failed resource operation, which hides the cause in its details.
+const deploymentFailedMessage = "At least one resource deployment operation failed. Please list deployment operations for details. Please see https://aka.ms/DeployOperations for usage details."
+
+// deployment is a template deployment to a resource group. It deploys one
+// resource per poll of its long-running operation: the first poll starts the
+// operation of the next resource, the second completes it. Nested
+// deployments are deployed in full when their operation starts.
+type deployment struct {
+	res      *resource
+	groupKey string
+	mode     string
+	template map[string]interface{}
+	params   map[string]interface{}
+	// pending are the resources of the template still to deploy, running
+	// the one whose operation started.
+	pending []map[string]interface{}
+	running *deploymentOp
+	// ops are the operations of the deployment in the JSON form of ARM.
+	ops []map[string]interface{}
+	// failed are the keys of the resources whose operation failed, by the
+	// names dependsOn uses, and errors their errors.
+	failed map[string]bool
+	errors []interface{}
+	// created are the keys of the resources this deployment and the
+	// deployments nested in it deployed, which complete mode keeps.
+	created map[string]bool
+	started time.Time
+}
+
+type deploymentOp struct {
+	body   map[string]interface{}
+	id     *arm.ResourceID
+	path   string
+	names  []string
+	nested *deployment
+	op     map[string]interface{}
+}
+
+// templateError is an error of the deployment in the JSON form of ARM.
+type templateError map[string]interface{}
+
+func newTemplateError(code, format string, args ...interface{}) templateError {
+	return templateError{"code": code, "message": fmt.Sprintf(format, args...)}
+}
+
+func (e templateError) Error() string {
+	return fmt.Sprint(e["message"])
+}
+
+// invalidDeployment wraps the problems validation found in the error ARM
+// returns for them.
+func invalidDeployment(name string, problems []interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"code":    "InvalidTemplateDeployment",
+		"message": fmt.Sprintf("The template deployment '%s' is not valid according to the validation procedure. See inner errors for details.", name),
+		"details": problems,
+	}
+}
+
+// deploymentKey returns the key of the deployment name in the resource group
+// of the request path segments.
+func deploymentKey(segments []string, name string) string {
+	return strings.ToLower(fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Resources/deployments/%s", segments[1], segments[3], name))
+}
+
+// newDeployment checks the deployment request body for the deployment id and
+// prepares it. It returns the problems validation found instead when there
+// are any.
+func (s *Server) newDeployment(id *arm.ResourceID, body map[string]interface{}) (*deployment, []interface{}) {
+	props, _ := body["properties"].(map[string]interface{})
+	template, _ := props["template"].(map[string]interface{})
+	d := &deployment{
+		groupKey: groupKey(id),
+		template: template,
+		params:   map[string]interface{}{},
+		failed:   map[string]bool{},
+		created:  map[string]bool{},
+		started:  time.Now(),
+	}
+	var problems []interface{}
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, newTemplateError("InvalidTemplate", "Deployment template validation failed: '"+format+"'.", args...))
+	}
+	d.mode, _ = props["mode"].(string)
+	if d.mode != "Incremental" && d.mode != "Complete" {
+		problems = append(problems, newTemplateError("InvalidDeploymentMode", "The deployment mode '%s' is not supported, use 'Incremental' or 'Complete'.", d.mode))
+	}
+	if props["templateLink"] != nil || props["parametersLink"] != nil {
+		problems = append(problems, newTemplateError("InvalidContentLink", "Linked templates and parameters are not supported by this stamp, pass them inline."))
+	}
+	if template == nil {
+		problem("The template is missing or is not a JSON object")
+		return nil, problems
+	}
+	resources, ok := template["resources"].([]interface{})
+	if !ok {
+		problem("The template is missing the 'resources' array")
+	}
+
+	declared, _ := template["parameters"].(map[string]interface{})
+	provided, _ := props["parameters"].(map[string]interface{})
+	var unknown []string
+	for name := range provided {
+		if _, ok := declared[name]; !ok {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		problem("The template parameters '%s' are not valid; they are not present in the original template and can therefore not be provided at deployment time. The only supported parameters for this template are '%s'", strings.Join(unknown, ", "), strings.Join(sortedKeys(declared), ", "))
+	}
+	for _, name := range sortedKeys(declared) {
+		def, _ := declared[name].(map[string]interface{})
+		value, ok := lookupValue(provided, name)
+		if !ok {
+			dv, hasDefault := def["defaultValue"]
+			if !hasDefault {
+				problem("The value for the template parameter '%s' is not provided", name)
+				continue
+			}
+			v, err := s.evaluate(d, dv)
+			if err != nil {
+				problem("The default value of the template parameter '%s' is not valid: %s", name, err)
+				continue
+			}
+			value = v
+		}
+		typ, _ := def["type"].(string)
+		if !hasType(value, typ) {
+			problem("The provided value for the template parameter '%s' is not valid. Expected a value of type '%s', but received a value of type '%s'", name, typ, typeOf(value))
+			continue
+		}
+		if allowed, ok := def["allowedValues"].([]interface{}); ok && !containsValue(allowed, value) {
+			problem("The provided value '%v' for the template parameter '%s' is not valid. The parameter value is not part of the allowed value(s): '%s'", value, name, joinValues(allowed))
+			continue
+		}
+		d.params[name] = value
+	}
+	if len(problems) > 0 {
+		return nil, problems
+	}
+
+	// The expressions are checked without evaluating them, since the
+	// properties may refer to resources that the deployment creates.
+	for _, p := range s.checkExpressions(d, template, "") {
+		problem("%s", p)
+	}
+	for i, r := range resources {
+		res, ok := r.(map[string]interface{})
+		if !ok {
+			problem("The resource at index %d is not a JSON object", i)
+			continue
+		}
+		for _, field := range []string{"type", "apiVersion", "name"} {
+			if v, _ := res[field].(string); v == "" {
+				problem("The resource at index %d is missing the '%s' property", i, field)
+			}
+		}
+		d.pending = append(d.pending, res)
+	}
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	return d, nil
+}
+
+func lookupValue(params map[string]interface{}, name string) (interface{}, bool) {
+	p, ok := params[name].(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	v, ok := p["value"]
+	return v, ok
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func typeOf(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return "String"
+	case float64:
+		if v == float64(int64(v)) {
+			return "Integer"
+		}
+		return "Float"
+	case bool:
+		return "Boolean"
+	case []interface{}:
+		return "Array"
+	case map[string]interface{}:
+		return "Object"
+	}
+	return "Null"
+}
+
+func hasType(v interface{}, typ string) bool {
+	switch strings.ToLower(typ) {
+	case "string", "securestring":
+		return typeOf(v) == "String"
+	case "int":
+		return typeOf(v) == "Integer"
+	case "bool":
+		return typeOf(v) == "Boolean"
+	case "array":
+		return typeOf(v) == "Array"
+	case "object", "secureobject":
+		return typeOf(v) == "Object"
+	}
+	return false
+}
+
+func containsValue(values []interface{}, v interface{}) bool {
+	for _, value := range values {
+		if fmt.Sprint(value) == fmt.Sprint(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func joinValues(values []interface{}) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = fmt.Sprint(v)
+	}
+	return strings.Join(s, ", ")
+}
+
+// validateDeployment answers the validate action of a deployment.
+func (s *Server) validateDeployment(w http.ResponseWriter, r *http.Request, segments []string) {
+	name := segments[len(segments)-2]
+	id, err := arm.ParseResourceID("/" + strings.Join(segments[:len(segments)-1], "/"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidResourceId", err.Error())
+		return
+	}
+	var body map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidRequestContent", fmt.Sprintf("The request content was invalid and could not be deserialized: %s", err))
+		return
+	}
+	group := s.resources[groupKey(id)]
+	if group == nil {
+		writeError(w, http.StatusNotFound, "ResourceGroupNotFound", fmt.Sprintf("Resource group '%s' could not be found.", id.ResourceGroupName))
+		return
+	}
+	d, problems := s.newDeployment(id, body)
+	if problems != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": invalidDeployment(name, problems)})
+		return
+	}
+	var validated []interface{}
+	for _, res := range d.pending {
+		resID, _, err := s.resourceID(d, res)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": invalidDeployment(name, []interface{}{newTemplateError("InvalidTemplate", "Deployment template validation failed: '%s'.", err)})})
+			return
+		}
+		validated = append(validated, map[string]interface{}{"id": resID})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":   "/" + strings.Join(segments[:len(segments)-1], "/"),
+		"name": name,
+		"properties": map[string]interface{}{
+			"provisioningState":  "Succeeded",
+			"mode":               d.mode,
+			"parameters":         d.typedParams(),
+			"validatedResources": validated,
+		},
+	})
+}
+
+// typedParams returns the parameters of d as ARM reports them, with their
+// types and without the values of secure ones.
+func (d *deployment) typedParams() map[string]interface{} {
+	declared, _ := d.template["parameters"].(map[string]interface{})
+	params := map[string]interface{}{}
+	for name, value := range d.params {
+		def, _ := declared[name].(map[string]interface{})
+		typ, _ := def["type"].(string)
+		p := map[string]interface{}{"type": typ}
+		if !strings.HasPrefix(strings.ToLower(typ), "secure") {
+			p["value"] = value
+		}
+		params[name] = p
+	}
+	return params
+}
+
+// putDeployment starts a template deployment.
+func (s *Server) putDeployment(w http.ResponseWriter, r *http.Request, id *arm.ResourceID, key string) {
+	var body map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidRequestContent", fmt.Sprintf("The request content was invalid and could not be deserialized: %s", err))
+		return
+	}
+	if s.resources[groupKey(id)] == nil {
+		writeError(w, http.StatusNotFound, "ResourceGroupNotFound", fmt.Sprintf("Resource group '%s' could not be found.", id.ResourceGroupName))
+		return
+	}
+	existing := s.resources[key]
+	if existing != nil && existing.op != "" {
+		writeError(w, http.StatusConflict, "DeploymentActive", fmt.Sprintf("Unable to edit or replace deployment '%s': previous deployment from '%s' is still active (current provisioning state is 'Running').", id.Name, existing.properties()["timestamp"]))
+		return
+	}
+	d, problems := s.newDeployment(id, body)
+	if problems != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": invalidDeployment(id.Name, problems)})
+		return
+	}
+	d.res = s.storeDeployment(id, key, body, d)
+	status := http.StatusCreated
+	if existing != nil {
+		status = http.StatusOK
+	}
+	opID := s.startOperation(d.res, key, false)
+	s.deployments[key] = d
+	w.Header().Set("Azure-AsyncOperation", operationURL(r, id, "operations", opID))
+	writeJSON(w, status, d.res.body)
+}
+
+// storeDeployment records the deployment d as the resource at key, replacing
+// an earlier deployment of the same name.
+func (s *Server) storeDeployment(id *arm.ResourceID, key string, body map[string]interface{}, d *deployment) *resource {
+	s.nextID++
+	res := &resource{id: id.String(), typ: deploymentType, body: map[string]interface{}{
+		"id":   id.String(),
+		"name": id.Name,
+		"type": "Microsoft.Resources/deployments",
+		"properties": map[string]interface{}{
+			"provisioningState": "Running",
+			"mode":              d.mode,
+			"parameters":        d.typedParams(),
+			"correlationId":     fmt.Sprintf("%08d-0000-4000-8000-%012d", s.nextID, s.nextID),
+			"timestamp":         d.started.UTC().Format(time.RFC3339),
+			"duration":          "PT0S",
+		},
+	}}
+	if tags, ok := body["tags"]; ok {
+		res.body["tags"] = tags
+	}
+	if s.resources[key] == nil {
+		s.order = append(s.order, key)
+	}
+	s.resources[key] = res
+	delete(s.deployments, key)
+	return res
+}
+
+// step advances the deployment d of the long-running operation op by one
+// poll.
+func (s *Server) step(d *deployment, op *operation) {
+	switch {
+	case d.running != nil:
+		s.finish(d)
+	case len(d.pending) > 0:
+		s.start(d)
+	default:
+		s.conclude(d)
+		op.done = true
+		d.res.op = ""
+		if len(d.errors) > 0 {
+			op.fail = &failure{code: "DeploymentFailed", message: deploymentFailedMessage, details: d.errors}
+		}
+	}
+}
+
+// run deploys all of d at once, as nested deployments are.
+func (s *Server) run(d *deployment) {
+	for d.running != nil || len(d.pending) > 0 {
+		if d.running != nil {
+			s.finish(d)
+			continue
+		}
+		s.start(d)
+	}
+	s.conclude(d)
+}
+
+// start starts the operation of the next resource of d. Resources that
+// depend on a failed one are never deployed, as in ARM.
+func (s *Server) start(d *deployment) {
+	res := d.pending[0]
+	d.pending = d.pending[1:]
+	for _, dep := range stringList(res["dependsOn"]) {
+		if d.failed[s.dependencyKey(d, dep)] {
+			path, names, err := s.resourceID(d, res)
+			if err == nil {
+				for _, name := range names {
+					d.failed[strings.ToLower(name)] = true
+				}
+				d.failed[strings.ToLower(path)] = true
+			}
+			return
+		}
+	}
+	path, names, err := s.resourceID(d, res)
+	running := &deploymentOp{path: path, names: names}
+	s.nextID++
+	opID := fmt.Sprintf("%016X", s.nextID*7919)
+	running.op = map[string]interface{}{
+		"id":          d.res.id + "/operations/" + opID,
+		"operationId": opID,
+		"properties": map[string]interface{}{
+			"provisioningOperation": "Create",
+			"provisioningState":     "Running",
+			"timestamp":             time.Now().UTC().Format(time.RFC3339),
+			"duration":              "PT0S",
+			"serviceRequestId":      fmt.Sprintf("%08d-0000-4000-8000-%012d", s.nextID, s.nextID),
+		},
+	}
+	d.ops = append(d.ops, running.op)
+	d.running = running
+	if err != nil {
+		return
+	}
+	running.id, _ = arm.ParseResourceID(path)
+	resType, _ := res["type"].(string)
+	running.op["properties"].(map[string]interface{})["targetResource"] = map[string]interface{}{
+		"id":           path,
+		"resourceName": strings.Join(names, "/"),
+		"resourceType": resType,
+	}
+	body, err := s.evaluateResource(d, res)
+	if err != nil {
+		running.body = map[string]interface{}{"error": newTemplateError("InvalidTemplate", "Unable to process template language expressions for resource '%s': %s", path, err)}
+		return
+	}
+	running.body = body
+	if strings.EqualFold(resType, "Microsoft.Resources/deployments") {
+		running.nested = s.startNested(d, running, body)
+	}
+}
+
+// startNested deploys the nested deployment of op in full.
+func (s *Server) startNested(parent *deployment, op *deploymentOp, body map[string]interface{}) *deployment {
+	key := strings.ToLower(op.path)
+	nested, problems := s.newDeployment(op.id, body)
+	if problems != nil {
+		op.body = map[string]interface{}{"error": templateError(invalidDeployment(op.id.Name, problems))}
+		return nil
+	}
+	nested.created = parent.created
+	nested.res = s.storeDeployment(op.id, key, body, nested)
+	s.deployments[key] = nested
+	s.run(nested)
+	return nested
+}
+
+// finish completes the running operation of d.
+func (s *Server) finish(d *deployment) {
+	running := d.running
+	d.running = nil
+	props := running.op["properties"].(map[string]interface{})
+	props["duration"] = "PT1S"
+	fail := func(statusCode string, err map[string]interface{}) {
+		props["provisioningState"] = "Failed"
+		props["statusCode"] = statusCode
+		props["statusMessage"] = map[string]interface{}{"status": "Failed", "error": err}
+		d.failed[strings.ToLower(running.path)] = true
+		for _, name := range running.names {
+			d.failed[strings.ToLower(name)] = true
+		}
+		// ARM reports the errors of the operations as the details of the
+		// error of the deployment, with the status message as a JSON
+		// string in the message.
+		message, _ := json.Marshal(props["statusMessage"])
+		d.errors = append(d.errors, map[string]interface{}{"code": statusCode, "message": string(message)})
+	}
+	if running.id == nil {
+		fail("BadRequest", newTemplateError("InvalidTemplate", "Deployment template validation failed: the resource could not be identified."))
+		return
+	}
+	if err, ok := running.body["error"].(templateError); ok {
+		fail("BadRequest", err)
+		return
+	}
+	if nested := running.nested; nested != nil {
+		if len(nested.errors) > 0 {
+			fail("Conflict", map[string]interface{}{"code": "DeploymentFailed", "message": deploymentFailedMessage, "details": nested.errors})
+			return
+		}
+		props["provisioningState"] = "Succeeded"
+		props["statusCode"] = "OK"
+		props["statusMessage"] = nested.res.body
+		return
+	}
+	key := strings.ToLower(running.path)
+	typ := strings.ToLower(running.id.ResourceType.String())
+	if missing := s.missingReference(running.body, key); missing != "" {
+		fail("BadRequest", newTemplateError("InvalidResourceReference", "Resource %s referenced by resource %s was not found.", missing, running.path))
+		return
+	}
+	if typ != resourceGroupType && strings.ToLower(running.id.Parent.ResourceType.String()) == resourceGroupType && running.body["location"] == nil {
+		fail("BadRequest", newTemplateError("LocationRequired", "The location property is required for this definition."))
+		return
+	}
+	name := strings.ToLower(running.id.Name)
+	f, failing := s.failures[name]
+	delete(s.failures, name)
+	existed := s.resources[key] != nil
+	if failing && createStyles[typ] == synchronous {
+		fail("BadRequest", map[string]interface{}{"code": f.code, "message": f.message})
+		return
+	}
+	res := s.store(running.id, running.path, running.body)
+	if failing {
+		res.setState("Failed")
+		fail("Conflict", map[string]interface{}{"code": f.code, "message": f.message})
+		return
+	}
+	res.setState("Succeeded")
+	d.created[key] = true
+	props["provisioningState"] = "Succeeded"
+	props["statusCode"] = "Created"
+	if existed {
+		props["statusCode"] = "OK"
+	}
+	props["statusMessage"] = res.body
+}
+
+// conclude ends d once its resources are deployed: complete mode deletes the
+// other resources of the group, and the outputs are evaluated.
+func (s *Server) conclude(d *deployment) {
+	props := d.res.properties()
+	props["duration"] = fmt.Sprintf("PT%dS", len(d.ops))
+	var created []string
+	for key := range d.created {
+		if s.resources[key] != nil {
+			created = append(created, key)
+		}
+	}
+	sort.Strings(created)
+	var resources []interface{}
+	for _, key := range created {
+		resources = append(resources, map[string]interface{}{"id": s.resources[key].id})
+	}
+	props["outputResources"] = resources
+	if len(d.errors) > 0 {
+		props["provisioningState"] = "Failed"
+		props["error"] = map[string]interface{}{"code": "DeploymentFailed", "message": deploymentFailedMessage, "details": d.errors}
+		return
+	}
+	if d.mode == "Complete" {
+		prefix := d.groupKey + "/providers/"
+		for _, key := range append([]string{}, s.order...) {
+			res := s.resources[key]
+			if res == nil || !strings.HasPrefix(key, prefix) || strings.Count(res.typ, "/") != 1 || res.typ == deploymentType || d.created[key] {
+				continue
+			}
+			s.nextID++
+			opID := fmt.Sprintf("%016X", s.nextID*7919)
+			d.ops = append(d.ops, map[string]interface{}{
+				"id":          d.res.id + "/operations/" + opID,
+				"operationId": opID,
+				"properties": map[string]interface{}{
+					"provisioningOperation": "Delete",
+					"provisioningState":     "Succeeded",
+					"statusCode":            "OK",
+					"duration":              "PT1S",
+					"targetResource":        map[string]interface{}{"id": res.id, "resourceName": res.body["name"], "resourceType": res.body["type"]},
+				},
+			})
+			s.remove(key)
+		}
+	}
+	if outputs, ok := d.template["outputs"].(map[string]interface{}); ok {
+		values := map[string]interface{}{}
+		for name, o := range outputs {
+			output, _ := o.(map[string]interface{})
+			value, err := s.evaluate(d, output["value"])
+			if err != nil {
+				d.errors = append(d.errors, newTemplateError("DeploymentOutputEvaluationFailed", "The template output '%s' is not valid: %s.", name, err))
+				props["provisioningState"] = "Failed"
+				props["error"] = map[string]interface{}{"code": "DeploymentOutputEvaluationFailed", "message": "Unable to evaluate template outputs. Please see error details and deployment operations.", "details": d.errors}
+				return
+			}
+			values[name] = map[string]interface{}{"type": output["type"], "value": value}
+		}
+		props["outputs"] = values
+	}
+	props["provisioningState"] = "Succeeded"
+}
+
+// serveDeploymentOperations lists the operations of a deployment.
+func (s *Server) serveDeploymentOperations(w http.ResponseWriter, r *http.Request, segments []string) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", fmt.Sprintf("%s is not supported on deployment operations", r.Method))
+		return
+	}
+	key := deploymentKey(segments, segments[5])
+	d := s.deployments[key]
+	if d == nil {
+		writeError(w, http.StatusNotFound, "DeploymentNotFound", fmt.Sprintf("Deployment '%s' could not be found.", segments[5]))
+		return
+	}
+	items := make([]interface{}, 0, len(d.ops))
+	for _, op := range d.ops {
+		if len(segments) == 8 && strings.EqualFold(op["operationId"].(string), segments[7]) {
+			writeJSON(w, http.StatusOK, op)
+			return
+		}
+		items = append(items, op)
+	}
+	if len(segments) == 8 {
+		writeError(w, http.StatusNotFound, "DeploymentOperationNotFound", fmt.Sprintf("Deployment operation '%s' could not be found.", segments[7]))
+		return
+	}
+	s.writePage(w, r, items)
+}
+
+// store records the resource at path with body as the resource providers do
+// on a PUT and returns it.
+func (s *Server) store(id *arm.ResourceID, path string, body map[string]interface{}) *resource {
+	key := strings.ToLower(path)
+	existing := s.resources[key]
+	body["id"] = path
+	body["name"] = id.Name
+	body["type"] = id.ResourceType.String()
+	res := &resource{id: path, typ: strings.ToLower(id.ResourceType.String()), body: body}
+	if existing != nil {
+		res.keys = existing.keys
+	}
+	s.decorate(res, id)
+	if existing == nil {
+		s.order = append(s.order, key)
+	}
+	s.resources[key] = res
+	s.addChildren(res, key)
+	return res
+}
+
+// resourceID returns the ID of the template resource res of d and the names
+// of the resource and its parents.
+func (s *Server) resourceID(d *deployment, res map[string]interface{}) (string, []string, error) {
+	typ, err := s.evaluateString(d, res["type"])
+	if err != nil {
+		return "", nil, err
+	}
+	name, err := s.evaluateString(d, res["name"])
+	if err != nil {
+		return "", nil, err
+	}
+	names := strings.Split(name, "/")
+	id, err := s.buildID(d, append([]string{typ}, names...))
+	return id, names, err
+}
+
+// dependencyKey returns the key dependsOn entry dep stands for: the ID of a
+// resource, or its name.
+func (s *Server) dependencyKey(d *deployment, dep string) string {
+	if v, err := s.evaluateString(d, dep); err == nil {
+		dep = v
+	}
+	if !strings.HasPrefix(dep, "/") {
+		// A type and a name, such as Microsoft.Network/virtualNetworks/vnet,
+		// or a name alone.
+		dep = dep[strings.LastIndex(dep, "/")+1:]
+	}
+	return strings.ToLower(dep)
+}
+
+func stringList(v interface{}) []string {
+	list, _ := v.([]interface{})
+	var s []string
+	for _, item := range list {
+		if str, ok := item.(string); ok {
+			s = append(s, str)
+		}
+	}
+	return s
+}
+
+// evaluateResource returns the body of the PUT of template resource res.
+func (s *Server) evaluateResource(d *deployment, res map[string]interface{}) (map[string]interface{}, error) {
+	body := map[string]interface{}{}
+	for field, value := range res {
+		switch field {
+		case "type", "name", "apiVersion", "dependsOn", "comments", "condition":
+			continue
+		case "properties":
+			if strings.EqualFold(fmt.Sprint(res["type"]), "Microsoft.Resources/deployments") {
+				// The template of a nested deployment is evaluated
+				// in its own deployment.
+				props, _ := value.(map[string]interface{})
+				evaluated := map[string]interface{}{}
+				for key, v := range props {
+					if key == "template" {
+						evaluated[key] = v
+						continue
+					}
+					e, err := s.evaluate(d, v)
+					if err != nil {
+						return nil, err
+					}
+					evaluated[key] = e
+				}
+				body[field] = evaluated
+				continue
+			}
+		}
+		v, err := s.evaluate(d, value)
+		if err != nil {
+			return nil, err
+		}
+		body[field] = v
+	}
+	return body, nil
+}
+
+// templateFunctions are the template functions the stamp evaluates.
+var templateFunctions = map[string]bool{
+	"parameters": true, "variables": true, "resourcegroup": true, "subscription": true,
+	"concat": true, "format": true, "resourceid": true, "reference": true,
+	"tolower": true, "toupper": true, "string": true, "uniquestring": true,
+	"true": true, "false": true, "int": true,
+}
+
+// checkExpressions returns the problems of the expressions in v: syntax
+// errors, unknown functions and references to undeclared parameters and
+// variables.
+func (s *Server) checkExpressions(d *deployment, v interface{}, path string) []string {
+	var problems []string
+	switch v := v.(type) {
+	case string:
+		if !isExpression(v) {
+			return nil
+		}
+		expr, err := parseExpression(v[1 : len(v)-1])
+		if err != nil {
+			return []string{fmt.Sprintf("The template expression '%s' at %s is not valid: %s", v, path, err)}
+		}
+		expr.walk(func(e *expression) {
+			if e.call == "" {
+				return
+			}
+			name := strings.ToLower(e.call)
+			switch {
+			case !templateFunctions[name]:
+				problems = append(problems, fmt.Sprintf("The template function '%s' at %s is not valid. Please see https://aka.ms/arm-template-expressions for usage details", e.call, path))
+			case (name == "parameters" || name == "variables") && len(e.args) == 1 && e.args[0].literal != nil:
+				section, _ := d.template[name].(map[string]interface{})
+				if _, ok := section[fmt.Sprint(e.args[0].literal)]; !ok {
+					problems = append(problems, fmt.Sprintf("The template %s '%s' at %s is not found", strings.TrimSuffix(name, "s"), e.args[0].literal, path))
+				}
+			}
+		})
+	case map[string]interface{}:
+		for _, key := range sortedKeys(v) {
+			if key == "template" && strings.HasSuffix(path, ".properties") {
+				// A nested template is checked when it is deployed.
+				continue
+			}
+			problems = append(problems, s.checkExpressions(d, v[key], path+"."+key)...)
+		}
+	case []interface{}:
+		for i, item := range v {
+			problems = append(problems, s.checkExpressions(d, item, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	}
+	return problems
+}
+
+func isExpression(s string) bool {
+	return strings.HasPrefix(s, "[") && !strings.HasPrefix(s, "[[") && strings.HasSuffix(s, "]")
+}
+
+// evaluate returns v with its template expressions evaluated.
+func (s *Server) evaluate(d *deployment, v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		if strings.HasPrefix(v, "[[") {
+			return v[1:], nil
+		}
+		if !isExpression(v) {
+			return v, nil
+		}
+		expr, err := parseExpression(v[1 : len(v)-1])
+		if err != nil {
+			return nil, err
+		}
+		return s.eval(d, expr)
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			e, err := s.evaluate(d, value)
+			if err != nil {
+				return nil, err
+			}
+			out[key] = e
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, value := range v {
+			e, err := s.evaluate(d, value)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = e
+		}
+		return out, nil
+	}
+	return v, nil
+}
+
+func (s *Server) evaluateString(d *deployment, v interface{}) (string, error) {
+	e, err := s.evaluate(d, v)
+	if err != nil {
+		return "", err
+	}
+	str, ok := e.(string)
+	if !ok {
+		return "", fmt.Errorf("expected a string, got %s", typeOf(e))
+	}
+	return str, nil
+}
+
+func (s *Server) eval(d *deployment, e *expression) (interface{}, error) {
+	var v interface{}
+	if e.call == "" {
+		v = e.literal
+	} else {
+		args := make([]interface{}, len(e.args))
+		for i, arg := range e.args {
+			a, err := s.eval(d, arg)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = a
+		}
+		var err error
+		if v, err = s.call(d, e.call, args); err != nil {
+			return nil, err
+		}
+	}
+	for _, accessor := range e.accessors {
+		key, err := s.eval(d, accessor)
+		if err != nil {
+			return nil, err
+		}
+		switch value := v.(type) {
+		case map[string]interface{}:
+			found := false
+			for k, item := range value {
+				if strings.EqualFold(k, fmt.Sprint(key)) {
+					v, found = item, true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("the language expression property '%v' doesn't exist, available properties are '%s'", key, strings.Join(sortedKeys(value), ", "))
+			}
+		case []interface{}:
+			i, ok := key.(float64)
+			if !ok || int(i) < 0 || int(i) >= len(value) {
+				return nil, fmt.Errorf("the index '%v' is out of the bounds of an array of %d items", key, len(value))
+			}
+			v = value[int(i)]
+		default:
+			return nil, fmt.Errorf("the language expression property '%v' cannot be evaluated on a value of type '%s'", key, typeOf(v))
+		}
+	}
+	return v, nil
+}
+
+func (s *Server) call(d *deployment, name string, args []interface{}) (interface{}, error) {
+	arg := func(i int) string {
+		if i < len(args) {
+			return fmt.Sprint(args[i])
+		}
+		return ""
+	}
+	group := s.resources[d.groupKey]
+	switch strings.ToLower(name) {
+	case "parameters":
+		v, ok := d.params[arg(0)]
+		if !ok {
+			return nil, fmt.Errorf("the template parameter '%s' is not found", arg(0))
+		}
+		return v, nil
+	case "variables":
+		variables, _ := d.template["variables"].(map[string]interface{})
+		v, ok := variables[arg(0)]
+		if !ok {
+			return nil, fmt.Errorf("the template variable '%s' is not found", arg(0))
+		}
+		return s.evaluate(d, v)
+	case "resourcegroup":
+		if group == nil {
+			return nil, fmt.Errorf("the resource group of the deployment does not exist")
+		}
+		return group.body, nil
+	case "subscription":
+		return map[string]interface{}{"id": "/subscriptions/" + SubscriptionID, "subscriptionId": SubscriptionID, "tenantId": TenantID}, nil
+	case "concat":
+		if len(args) > 0 {
+			if _, ok := args[0].([]interface{}); ok {
+				var list []interface{}
+				for _, a := range args {
+					items, _ := a.([]interface{})
+					list = append(list, items...)
+				}
+				return list, nil
+			}
+		}
+		var b strings.Builder
+		for i := range args {
+			b.WriteString(arg(i))
+		}
+		return b.String(), nil
+	case "format":
+		out := arg(0)
+		for i := 1; i < len(args); i++ {
+			out = strings.ReplaceAll(out, "{"+strconv.Itoa(i-1)+"}", arg(i))
+		}
+		return out, nil
+	case "resourceid":
+		segments := make([]string, len(args))
+		for i := range args {
+			segments[i] = arg(i)
+		}
+		return s.buildID(d, segments)
+	case "reference":
+		ref := strings.ToLower(arg(0))
+		if !strings.HasPrefix(ref, "/subscriptions/") {
+			ref = ""
+			for key, res := range s.resources {
+				if strings.HasPrefix(key, d.groupKey+"/") && strings.EqualFold(fmt.Sprint(res.body["name"]), arg(0)) {
+					ref = key
+				}
+			}
+		}
+		res := s.resources[ref]
+		if res == nil {
+			return nil, fmt.Errorf("the resource '%s' is not defined in the template", arg(0))
+		}
+		// The properties of a nested deployment hold its outputs.
+		return res.properties(), nil
+	case "tolower":
+		return strings.ToLower(arg(0)), nil
+	case "toupper":
+		return strings.ToUpper(arg(0)), nil
+	case "string":
+		if len(args) == 1 {
+			if str, ok := args[0].(string); ok {
+				return str, nil
+			}
+		}
+		data, err := json.Marshal(args[0])
+		return string(data), err
+	case "int":
+		return strconv.ParseFloat(arg(0), 64)
+	case "uniquestring":
+		h := fnv.New64a()
+		for i := range args {
+			h.Write([]byte(arg(i) + "-"))
+		}
+		return strconv.FormatUint(h.Sum64(), 36), nil
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	return nil, fmt.Errorf("the template function '%s' is not valid", name)
+}
+
+// buildID returns the ID of the resource of type segments[0] with the names
+// of segments[1:] in the resource group of d, or of a resource group when
+// the type is Microsoft.Resources/resourceGroups.
+func (s *Server) buildID(d *deployment, segments []string) (string, error) {
+	if len(segments) < 2 {
+		return "", fmt.Errorf("resourceId needs a type and a name")
+	}
+	group := s.resources[d.groupKey]
+	groupID := d.groupKey
+	if group != nil {
+		groupID = group.id
+	}
+	types := strings.Split(segments[0], "/")
+	names := segments[1:]
+	if len(types) != len(names)+1 {
+		return "", fmt.Errorf("the type '%s' needs %d names, got %d", segments[0], len(types)-1, len(names))
+	}
+	id := groupID + "/providers/" + types[0]
+	for i, name := range names {
+		id += "/" + types[i+1] + "/" + name
+	}
+	return id, nil
+}
+
+// expression is a parsed template expression: a literal or a function call,
+// followed by property and index accessors.
+type expression struct {
+	literal   interface{}
+	call      string
+	args      []*expression
+	accessors []*expression
+}
+
+func (e *expression) walk(visit func(*expression)) {
+	visit(e)
+	for _, arg := range e.args {
+		arg.walk(visit)
+	}
+	for _, accessor := range e.accessors {
+		accessor.walk(visit)
+	}
+}
+
+// parseExpression parses the expression src, without its brackets.
+func parseExpression(src string) (*expression, error) {
+	p := &parser{src: src}
+	e, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	p.space()
+	if p.pos < len(p.src) {
+		return nil, fmt.Errorf("unexpected '%s' at position %d", p.src[p.pos:], p.pos)
+	}
+	return e, nil
+}
+
+func isWordChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_'
+}
+
+type parser struct {
+	src string
+	pos int
+}
+
+func (p *parser) space() {
+	for p.pos < len(p.src) && p.src[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *parser) peek() byte {
+	p.space()
+	if p.pos < len(p.src) {
+		return p.src[p.pos]
+	}
+	return 0
+}
+
+func (p *parser) expect(c byte) error {
+	if p.peek() != c {
+		return fmt.Errorf("expected '%c' at position %d", c, p.pos)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *parser) expression() (*expression, error) {
+	e := &expression{}
+	switch c := p.peek(); {
+	case c == '\'':
+		p.pos++
+		var b strings.Builder
+		for {
+			if p.pos >= len(p.src) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			if p.src[p.pos] == '\'' {
+				if p.pos+1 < len(p.src) && p.src[p.pos+1] == '\'' {
+					b.WriteByte('\'')
+					p.pos += 2
+					continue
+				}
+				p.pos++
+				break
+			}
+			b.WriteByte(p.src[p.pos])
+			p.pos++
+		}
+		e.literal = b.String()
+	case c >= '0' && c <= '9' || c == '-':
+		start := p.pos
+		p.pos++
+		for p.pos < len(p.src) && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+			p.pos++
+		}
+		n, err := strconv.ParseFloat(p.src[start:p.pos], 64)
+		if err != nil {
+			return nil, err
+		}
+		e.literal = n
+	case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		start := p.pos
+		for p.pos < len(p.src) && (isWordChar(p.src[p.pos])) {
+			p.pos++
+		}
+		e.call = p.src[start:p.pos]
+		if err := p.expect('('); err != nil {
+			return nil, err
+		}
+		for p.peek() != ')' {
+			arg, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			e.args = append(e.args, arg)
+			if p.peek() == ',' {
+				p.pos++
+			}
+		}
+		p.pos++
+	default:
+		return nil, fmt.Errorf("unexpected '%c' at position %d", c, p.pos)
+	}
+	for {
+		switch p.peek() {
+		case '.':
+			p.pos++
+			p.space()
+			start := p.pos
+			for p.pos < len(p.src) && (isWordChar(p.src[p.pos])) {
+				p.pos++
+			}
+			if start == p.pos {
+				return nil, fmt.Errorf("expected a property name at position %d", p.pos)
+			}
+			e.accessors = append(e.accessors, &expression{literal: p.src[start:p.pos]})
+		case '[':
+			p.pos++
+			index, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(']'); err != nil {
+				return nil, err
+			}
+			e.accessors = append(e.accessors, index)
+		default:
+			return e, nil
+		}
+	}
+}
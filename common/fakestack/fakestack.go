@@ -0,0 +1,342 @@
+// Package fakestack is an in-process fake of the Azure Stack Hub endpoints used
+// by the samples: the ARM metadata endpoint, an AAD or AD FS token endpoint and
+// the resource groups, storage, Key Vault, network and compute providers. It
+// keeps resources in memory and completes long-running operations after a
+// number of polls, so that the samples can run end-to-end in go test.
+package fakestack
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+)
+
+// Identity is the shape of the identity provider a Server imitates.
+type Identity string
+
+const (
+	AAD  Identity = "aad"
+	ADFS Identity = "adfs"
+)
+
+const (
+	SubscriptionID = "00000000-0000-0000-0000-000000000001"
+	TenantID       = "00000000-0000-0000-0000-000000000002"
+	ClientID       = "00000000-0000-0000-0000-000000000003"
+	ObjectID       = "00000000-0000-0000-0000-000000000004"
+	ClientSecret   = "fake-client-secret"
+	Location       = "local"
+	Audience       = "https://management.local.azurestack.external/fakestack"
+
+	// DefaultPolls is the number of polls a long-running operation reports
+	// as in progress before it completes.
+	DefaultPolls = 2
+)
+
+// Server is a fake Azure Stack Hub stamp served over TLS.
+type Server struct {
+	*httptest.Server
+	identity Identity
+
+	mu       sync.Mutex
+	polls    int
+	pageSize int
+	throttle int
+	failures map[string]failure
+	// deleteFailures are the failures of deletions, by resource name.
+	deleteFailures map[string]failure
+	tokens         map[string]bool
+	resources      map[string]*resource
+	order          []string
+	ops            map[string]*operation
+	// deployments are the template deployments, by the key of their
+	// resource.
+	deployments map[string]*deployment
+	nextID      int
+	// containers are the containers of BlobAccount, by name.
+	containers map[string]map[string]*blob
+}
+
+type failure struct {
+	code, message string
+	// details are the inner errors, in the JSON form of ARM.
+	details []interface{}
+}
+
+// body returns the error in the JSON form of ARM.
+func (f *failure) body() map[string]interface{} {
+	body := map[string]interface{}{"code": f.code, "message": f.message}
+	if len(f.details) > 0 {
+		body["details"] = f.details
+	}
+	return body
+}
+
+// Start starts a Server imitating identity and stops it when t finishes.
+func Start(t testing.TB, identity Identity) *Server {
+	s := &Server{
+		identity:       identity,
+		polls:          DefaultPolls,
+		failures:       map[string]failure{},
+		deleteFailures: map[string]failure{},
+		tokens:         map[string]bool{},
+		resources:      map[string]*resource{},
+		ops:            map[string]*operation{},
+		deployments:    map[string]*deployment{},
+		containers:     map[string]map[string]*blob{},
+	}
+	s.Server = httptest.NewTLSServer(s)
+	t.Cleanup(s.Close)
+	return s
+}
+
+// SetPolls sets how many polls long-running operations started from now on
+// stay in progress.
+func (s *Server) SetPolls(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.polls = n
+}
+
+// SetPageSize splits lists into pages of n items linked by nextLink. Zero
+// returns every item in one page.
+func (s *Server) SetPageSize(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pageSize = n
+}
+
+// Throttle answers the next n ARM requests with 429 Too Many Requests.
+func (s *Server) Throttle(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.throttle = n
+}
+
+// FailCreate makes the next creation of a resource called name fail with the
+// given error code once its long-running operation completes, or right away
+// for resources that are created synchronously.
+func (s *Server) FailCreate(name, code, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[strings.ToLower(name)] = failure{code: code, message: message}
+}
+
+// FailDelete makes the next deletion of a resource called name fail right
+// away with the given error code, leaving the resource in place.
+func (s *Server) FailDelete(name, code, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deleteFailures[strings.ToLower(name)] = failure{code: code, message: message}
+}
+
+// AddGroup creates the resource group name with tags on the stamp, as
+// someone other than the sample under test would.
+func (s *Server) AddGroup(name string, tags map[string]string) {
+	s.add(fmt.Sprintf("/subscriptions/%s/resourceGroups/%s", SubscriptionID, name), tags)
+}
+
+// AddResource creates the top-level resource with tags in the existing
+// resource group of the stamp, as someone other than the sample under test
+// would.
+func (s *Server) AddResource(group, typ, name string, tags map[string]string) {
+	s.add(fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/%s/%s", SubscriptionID, group, typ, name), tags)
+}
+
+// EditResource changes the body of the resource with the ID through edit, as
+// someone changing it by hand in the portal would. It reports whether the
+// resource exists.
+func (s *Server) EditResource(id string, edit func(body map[string]interface{})) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := strings.ToLower(id)
+	res := s.resources[key]
+	if res == nil {
+		return false
+	}
+	edit(res.body)
+	s.addChildren(res, key)
+	return true
+}
+
+// RemoveResource deletes the resource with the ID and the resources below it
+// at once, as someone deleting it by hand would.
+func (s *Server) RemoveResource(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(strings.ToLower(id))
+}
+
+func (s *Server) add(id string, tags map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	parsed, err := arm.ParseResourceID(id)
+	if err != nil {
+		panic(err)
+	}
+	body := map[string]interface{}{
+		"id":         id,
+		"name":       parsed.Name,
+		"type":       parsed.ResourceType.String(),
+		"location":   Location,
+		"properties": map[string]interface{}{"provisioningState": "Succeeded"},
+	}
+	if len(tags) > 0 {
+		t := map[string]interface{}{}
+		for key, value := range tags {
+			t[key] = value
+		}
+		body["tags"] = t
+	}
+	key := strings.ToLower(id)
+	if s.resources[key] == nil {
+		s.order = append(s.order, key)
+	}
+	s.resources[key] = &resource{id: id, typ: strings.ToLower(parsed.ResourceType.String()), body: body}
+}
+
+// LoginEndpoint is the login endpoint advertised by the metadata endpoint.
+func (s *Server) LoginEndpoint() string {
+	if s.identity == ADFS {
+		return s.URL + "/adfs/"
+	}
+	return s.URL + "/"
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	switch {
+	case path == "/metadata/endpoints":
+		s.serveMetadata(w)
+	case strings.HasSuffix(path, "/.well-known/openid-configuration"):
+		s.serveOpenIDConfiguration(w, r, path)
+	case strings.HasSuffix(path, "/oauth2/token") || strings.HasSuffix(path, "/oauth2/v2.0/token"):
+		s.serveToken(w, r, path)
+	case strings.HasPrefix(strings.ToLower(path), "/subscriptions/"):
+		s.serveARM(w, r)
+	case strings.HasPrefix(path, "/"+BlobAccount+"/"):
+		s.serveBlob(w, r)
+	default:
+		writeError(w, http.StatusNotFound, "NotFound", fmt.Sprintf("no endpoint at %s", r.URL.Path))
+	}
+}
+
+func (s *Server) serveMetadata(w http.ResponseWriter) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"galleryEndpoint": s.URL + "/gallery/",
+		"graphEndpoint":   s.URL + "/graph/",
+		"portalEndpoint":  s.URL + "/portal/",
+		"authentication": map[string]interface{}{
+			"loginEndpoint": s.LoginEndpoint(),
+			"audiences":     []string{Audience},
+		},
+	})
+}
+
+// tenantOf returns the tenant a token or discovery request was sent for.
+func (s *Server) tenantOf(path string) string {
+	tenant := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)[0]
+	return strings.ToLower(tenant)
+}
+
+func (s *Server) serveOpenIDConfiguration(w http.ResponseWriter, r *http.Request, path string) {
+	tenant := s.tenantOf(path)
+	if s.identity == ADFS {
+		if path != "/adfs/.well-known/openid-configuration" {
+			writeError(w, http.StatusNotFound, "NotFound", "AD FS only serves /adfs/.well-known/openid-configuration")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"issuer":                 s.URL + "/adfs",
+			"authorization_endpoint": s.URL + "/adfs/oauth2/authorize/",
+			"token_endpoint":         s.URL + "/adfs/oauth2/token/",
+		})
+		return
+	}
+	if tenant != TenantID {
+		writeError(w, http.StatusBadRequest, "invalid_tenant", fmt.Sprintf("tenant %s not found", tenant))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                 s.URL + "/" + tenant + "/v2.0",
+		"authorization_endpoint": s.URL + "/" + tenant + "/oauth2/v2.0/authorize",
+		"token_endpoint":         s.URL + "/" + tenant + "/oauth2/v2.0/token",
+	})
+}
+
+// serveToken issues an access token for the client credentials flow, either
+// with the client secret or with a certificate assertion.
+func (s *Server) serveToken(w http.ResponseWriter, r *http.Request, path string) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "invalid_request", "token requests must be POSTed")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeTokenError(w, "invalid_request", err.Error())
+		return
+	}
+	switch {
+	case s.identity == ADFS && s.tenantOf(path) != "adfs",
+		s.identity == AAD && s.tenantOf(path) != TenantID:
+		writeTokenError(w, "invalid_tenant", fmt.Sprintf("unknown tenant %s", s.tenantOf(path)))
+		return
+	case r.PostForm.Get("grant_type") != "client_credentials":
+		writeTokenError(w, "unsupported_grant_type", "only client_credentials is supported")
+		return
+	case r.PostForm.Get("client_id") != ClientID:
+		writeTokenError(w, "unauthorized_client", fmt.Sprintf("unknown client %s", r.PostForm.Get("client_id")))
+		return
+	case r.PostForm.Get("client_secret") == "" && r.PostForm.Get("client_assertion") == "":
+		writeTokenError(w, "invalid_client", "missing client_secret or client_assertion")
+		return
+	case r.PostForm.Get("client_secret") != "" && r.PostForm.Get("client_secret") != ClientSecret:
+		writeTokenError(w, "invalid_client", "invalid client secret")
+		return
+	}
+
+	s.mu.Lock()
+	s.nextID++
+	token := fmt.Sprintf("fake-token-%d", s.nextID)
+	s.tokens[token] = true
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"token_type":     "Bearer",
+		"expires_in":     3600,
+		"ext_expires_in": 3600,
+		"access_token":   token,
+	})
+}
+
+func writeTokenError(w http.ResponseWriter, code, description string) {
+	writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+		"error":             code,
+		"error_description": description,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeFailure(w, status, &failure{code: code, message: message})
+}
+
+func writeFailure(w http.ResponseWriter, status int, f *failure) {
+	writeJSON(w, status, map[string]interface{}{"error": f.body()})
+}
+
+// absolute returns the URL of path on the server that received r.
+func absolute(r *http.Request, path string, query url.Values) string {
+	u := url.URL{Scheme: "https", Host: r.Host, Path: path, RawQuery: query.Encode()}
+	return u.String()
+}
@@ -0,0 +1,231 @@
+package fakestack
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// The environment variables that turn a test binary into a sample run.
+const (
+	envChild = "FAKESTACK_CHILD"
+	envCert  = "FAKESTACK_CERT"
+	envArgs  = "FAKESTACK_ARGS"
+)
+
+// Main is called from the TestMain of a sample. The samples exit the process
+// when they are done, so Run starts the test binary again and Main calls run
+// with a transport that trusts the Server instead of running the tests. In
+// the test binary proper it runs the tests as usual.
+func Main(m *testing.M, run func(transport policy.Transporter)) {
+	if os.Getenv(envChild) == "" {
+		os.Exit(m.Run())
+	}
+	var args []string
+	if err := json.Unmarshal([]byte(os.Getenv(envArgs)), &args); err != nil {
+		os.Stderr.WriteString("fakestack: invalid " + envArgs + ": " + err.Error() + "\n")
+		os.Exit(2)
+	}
+	os.Args = append([]string{os.Args[0]}, args...)
+	// RunOffline passes no certificate, the sample then keeps its default transport
+	var transport policy.Transporter
+	if cert := os.Getenv(envCert); cert != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(cert)) {
+			os.Stderr.WriteString("fakestack: no server certificate in " + envCert + "\n")
+			os.Exit(2)
+		}
+		transport = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	}
+	run(transport)
+	os.Exit(0)
+}
+
+// Command returns a command that runs the sample again with args, against
+// the same stamp as the current run. It is meant for samples that start other
+// runs of themselves, and may only be called from the run function of Main.
+func Command(args ...string) (*exec.Cmd, error) {
+	encoded, err := json.Marshal(args)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	cmd.Env = append(os.Environ(), envArgs+"="+string(encoded))
+	return cmd, nil
+}
+
+// Result is the outcome of a sample run. Output has everything the sample
+// wrote, Stdout only what it wrote to stdout.
+type Result struct {
+	Output   string
+	Stdout   string
+	ExitCode int
+}
+
+// RunID is the run ID of the runs of Run and RunOffline.
+const RunID = "fake01"
+
+// Run runs the sample against s with args and returns its combined output and
+// exit code. The configuration files are written to a temporary directory and
+// passed with -configDir, the resume tokens and the state of the run go to the
+// same directory, the long-running operations are polled every 10ms and the
+// run ID is RunID, so that the names of the resources are the same in every
+// run. args are added after these flags, so they can override them.
+func (s *Server) Run(t testing.TB, args ...string) Result {
+	t.Helper()
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw})
+	return run(t, s.URL, string(cert), args)
+}
+
+// RunOffline runs the sample like Run, with a configuration pointing at
+// endpoint instead of a Server. It is meant for runs that never reach
+// endpoint, such as replays of a cassette.
+func RunOffline(t testing.TB, endpoint string, args ...string) Result {
+	t.Helper()
+	return run(t, endpoint, "", args)
+}
+
+func run(t testing.TB, endpoint, cert string, args []string) Result {
+	t.Helper()
+	dir := t.TempDir()
+	if err := WriteConfig(dir, endpoint); err != nil {
+		t.Fatalf("writing configuration: %s", err)
+	}
+	args = append([]string{
+		"-configDir", dir,
+		"-lroState", filepath.Join(dir, "lro-state.json"),
+		"-stateDir", filepath.Join(dir, "state"),
+		"-pollFrequency", "10ms",
+		"-runID", RunID,
+	}, args...)
+	encoded, err := json.Marshal(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	cmd.Env = append(os.Environ(), envChild+"=1", envCert+"="+cert, envArgs+"="+string(encoded))
+	var output, stdout strings.Builder
+	combined := &lockedWriter{w: &output}
+	cmd.Stdout = io.MultiWriter(combined, &stdout)
+	cmd.Stderr = combined
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return Result{Output: output.String(), Stdout: stdout.String()}
+	case errors.As(err, &exitErr):
+		return Result{Output: output.String(), Stdout: stdout.String(), ExitCode: exitErr.ExitCode()}
+	}
+	t.Fatalf("running sample: %s", err)
+	return Result{}
+}
+
+// lockedWriter serializes the writes of the stdout and stderr of a run into
+// their combined output.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+// WriteConfig writes the secret and certificate configuration files of the
+// service principal a Server knows into dir, for the stamp at endpoint.
+func WriteConfig(dir, endpoint string) error {
+	certPath := filepath.Join(dir, "client.pem")
+	if err := writeClientCertificate(certPath); err != nil {
+		return err
+	}
+	config := map[string]string{
+		"ClientId":                   ClientID,
+		"ObjectId":                   ObjectID,
+		"SubscriptionId":             SubscriptionID,
+		"TenantId":                   TenantID,
+		"ResourceManagerEndpointUrl": endpoint,
+		"Location":                   Location,
+		"ClientSecret":               ClientSecret,
+	}
+	if err := writeJSONFile(filepath.Join(dir, "azureSecretSpConfig.json"), config); err != nil {
+		return err
+	}
+	delete(config, "ClientSecret")
+	config["CertPath"] = certPath
+	config["CertPass"] = ""
+	return writeJSONFile(filepath.Join(dir, "azureCertSpConfig.json"), config)
+}
+
+// WriteConfigSection adds the section name with the value v to the
+// configuration files WriteConfig wrote into dir.
+func WriteConfigSection(dir, name string, v interface{}) error {
+	for _, file := range []string{"azureSecretSpConfig.json", "azureCertSpConfig.json"} {
+		path := filepath.Join(dir, file)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var config map[string]interface{}
+		if err := json.Unmarshal(data, &config); err != nil {
+			return err
+		}
+		config[name] = v
+		if err := writeJSONFile(path, config); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeJSONFile(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// writeClientCertificate writes a self-signed certificate and its key in the
+// PEM form azidentity.ParseCertificates reads.
+func writeClientCertificate(path string) error {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "fakestack client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	data = append(data, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})...)
+	return os.WriteFile(path, data, 0600)
+}
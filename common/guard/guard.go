@@ -0,0 +1,242 @@
+// Package guard keeps the samples from deleting resource groups they do not
+// own. Before a resource group is deleted, it checks the tags of the group, the
+// record of the resources the run created and the resources in the group.
+package guard
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/tags"
+)
+
+// maxListed is the number of foreign resources a refusal names.
+const maxListed = 5
+
+// Guard refuses the deletion of every resource group the run does not own.
+// A run owns a group when
+//   - the group has the run ID and creator tags of the run,
+//   - the run created the group, when the run keeps a record, and
+//   - every resource in the group has the run ID tag of the group, was
+//     created by the run or is managed by such a resource, as the OS disk of a
+//     virtual machine is.
+type Guard struct {
+	// RunID and Creator are the values of the tags of the groups of the run.
+	RunID   string
+	Creator string
+	// AnyRun accepts the groups of every run of Creator, for the commands
+	// that delete the groups of earlier runs.
+	AnyRun bool
+	// Created reports whether the run created the resource with the ID. It
+	// is nil when the run keeps no record of the groups it deletes.
+	Created func(id string) bool
+	// Force deletes the groups the run does not own after a warning and,
+	// when Confirm is set, a confirmation.
+	Force   bool
+	Confirm func(question string) bool
+	Out     io.Writer
+
+	// mu asks the questions of concurrent deletions one at a time.
+	mu sync.Mutex
+}
+
+// NotOwnedError is returned for the deletion of a resource group the run does
+// not own.
+type NotOwnedError struct {
+	Group   string
+	Reasons []string
+}
+
+func (e *NotOwnedError) Error() string {
+	return fmt.Sprintf("refusing to delete resource group %s: %s; pass -force to delete it anyway", e.Group, strings.Join(e.Reasons, "; "))
+}
+
+// Configure adds the policy that checks the deletions of resource groups to o.
+func (g *Guard) Configure(o *policy.ClientOptions) {
+	o.PerCallPolicies = append(o.PerCallPolicies, policyFunc(g.check))
+}
+
+type policyFunc func(*policy.Request) (*http.Response, error)
+
+func (pf policyFunc) Do(req *policy.Request) (*http.Response, error) {
+	return pf(req)
+}
+
+func (g *Guard) check(req *policy.Request) (*http.Response, error) {
+	raw := req.Raw()
+	name, ok := groupName(raw.URL.Path)
+	if raw.Method != http.MethodDelete || !ok {
+		return req.Next()
+	}
+	reasons, err := g.Reasons(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check whether the run owns resource group %s: %w", name, err)
+	}
+	if len(reasons) == 0 {
+		return req.Next()
+	}
+	refusal := &NotOwnedError{Group: name, Reasons: reasons}
+	if !g.Force {
+		return nil, refusal
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	fmt.Fprintf(g.Out, "Resource group %s is not owned by this run: %s\n", name, strings.Join(reasons, "; "))
+	if g.Confirm != nil && !g.Confirm(fmt.Sprintf("Delete resource group %s anyway (-force)?", name)) {
+		return nil, refusal
+	}
+	return req.Next()
+}
+
+// groupName returns the name of the resource group path addresses, if it
+// addresses one.
+func groupName(path string) (string, bool) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(segments) != 4 || !strings.EqualFold(segments[0], "subscriptions") || !strings.EqualFold(segments[2], "resourceGroups") {
+		return "", false
+	}
+	return segments[3], true
+}
+
+// resource is the part of a resource group or of a resource the guard reads.
+type resource struct {
+	ID        string             `json:"id"`
+	Type      string             `json:"type"`
+	Name      string             `json:"name"`
+	Tags      map[string]*string `json:"tags"`
+	ManagedBy *string            `json:"managedBy"`
+}
+
+// Reasons returns why the run does not own the resource group that req
+// deletes, none if it does. A group that does not exist has no reasons, its
+// deletion fails on its own.
+func (g *Guard) Reasons(req *policy.Request) ([]string, error) {
+	var group resource
+	found, err := get(req, req.Raw().URL, &group)
+	if err != nil || !found {
+		return nil, err
+	}
+	var reasons []string
+	runID := tagValue(group.Tags, tags.RunID)
+	switch {
+	case runID == "":
+		reasons = append(reasons, fmt.Sprintf("it has no %s tag", tags.RunID))
+	case !g.AnyRun && runID != g.RunID:
+		reasons = append(reasons, fmt.Sprintf("it belongs to run %s, not %s", runID, g.RunID))
+	}
+	if creator := tagValue(group.Tags, tags.Creator); runID != "" && !strings.EqualFold(creator, g.Creator) {
+		reasons = append(reasons, fmt.Sprintf("it was created by %q, not %q", creator, g.Creator))
+	}
+	if g.Created != nil && !g.Created(req.Raw().URL.Path) {
+		reasons = append(reasons, "it existed before the run started")
+	}
+
+	resources, err := list(req)
+	if err != nil {
+		return nil, err
+	}
+	owned := map[string]bool{}
+	for _, r := range resources {
+		owned[strings.ToLower(r.ID)] = runID != "" && tagValue(r.Tags, tags.RunID) == runID || g.Created != nil && g.Created(r.ID)
+	}
+	var foreign []string
+	for _, r := range resources {
+		if owned[strings.ToLower(r.ID)] || r.ManagedBy != nil && owned[strings.ToLower(*r.ManagedBy)] {
+			continue
+		}
+		foreign = append(foreign, r.Type+"/"+r.Name)
+	}
+	if len(foreign) > maxListed {
+		foreign = append(foreign[:maxListed], fmt.Sprintf("and %d more", len(foreign)-maxListed))
+	}
+	if len(foreign) > 0 {
+		reasons = append(reasons, "it contains resources the run did not create: "+strings.Join(foreign, ", "))
+	}
+	return reasons, nil
+}
+
+// list returns the resources in the resource group that req deletes, following
+// the next links of the list.
+func list(req *policy.Request) ([]resource, error) {
+	u := *req.Raw().URL
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/resources"
+	u.RawPath = ""
+	u.RawQuery = url.Values{"api-version": {u.Query().Get("api-version")}}.Encode()
+	var resources []resource
+	for next := &u; next != nil; {
+		var page struct {
+			Value    []resource `json:"value"`
+			NextLink string     `json:"nextLink"`
+		}
+		if _, err := get(req, next, &page); err != nil {
+			return nil, err
+		}
+		resources = append(resources, page.Value...)
+		next = nil
+		if page.NextLink != "" {
+			parsed, err := url.Parse(page.NextLink)
+			if err != nil {
+				return nil, fmt.Errorf("invalid next link %q: %w", page.NextLink, err)
+			}
+			next = parsed
+		}
+	}
+	return resources, nil
+}
+
+// get sends a GET of u down the rest of the pipeline of req and decodes the
+// response into v. It reports false when u does not exist.
+func get(req *policy.Request, u *url.URL, v interface{}) (bool, error) {
+	clone := req.Clone(req.Raw().Context())
+	clone.Raw().Method = http.MethodGet
+	clone.Raw().URL = u
+	if err := clone.SetBody(nil, ""); err != nil {
+		return false, err
+	}
+	resp, err := clone.Next()
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return false, nil
+	case !runtime.HasStatusCode(resp, http.StatusOK):
+		return false, runtime.NewResponseError(resp)
+	}
+	return true, json.NewDecoder(resp.Body).Decode(v)
+}
+
+func tagValue(t map[string]*string, key string) string {
+	if t[key] == nil {
+		return ""
+	}
+	return *t[key]
+}
+
+// Confirm asks question on out and reports whether the answer read from in is
+// yes. An input that ends before the answer counts as no. The questions of a
+// run share in, which may have buffered the answers to the next ones, as a
+// pipe of answers does.
+func Confirm(in *bufio.Reader, out io.Writer, question string) bool {
+	fmt.Fprintf(out, "%s [y/N] ", question)
+	answer, err := in.ReadString('\n')
+	if err != nil {
+		// The input ended without a newline to end the line of the question.
+		fmt.Fprintln(out)
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,29 @@
+package guard
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+// TestConfirm answers several questions from one pipe, whose answers the
+// reader of the first question buffers all at once.
+func TestConfirm(t *testing.T) {
+	in := bufio.NewReader(strings.NewReader("y\nno\n YES \nmaybe\nyes"))
+	var out strings.Builder
+	var got []bool
+	for i := 0; i < 6; i++ {
+		got = append(got, Confirm(in, &out, "Delete?"))
+	}
+	want := []bool{true, false, true, false, true, false}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("answers %v, want %v", got, want)
+		}
+	}
+	// The last two answers end without a newline, which Confirm writes
+	// instead.
+	if wantOut := strings.Repeat("Delete? [y/N] ", 5) + "\n" + "Delete? [y/N] \n"; out.String() != wantOut {
+		t.Errorf("output %q, want %q", out.String(), wantOut)
+	}
+}
@@ -0,0 +1,376 @@
+// Package lro waits for the long-running operations started by the Begin*
+// methods of the ARM clients, with per resource type timeouts and periodic
+// progress output.
+package lro
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/retry"
+)
+
+// Kind is the type of resource a long-running operation acts on.
+type Kind string
+
+const (
+	ResourceGroup    Kind = "resourceGroup"
+	StorageAccount   Kind = "storageAccount"
+	Vault            Kind = "vault"
+	VirtualNetwork   Kind = "virtualNetwork"
+	SecurityGroup    Kind = "networkSecurityGroup"
+	PublicIPAddress  Kind = "publicIPAddress"
+	NetworkInterface Kind = "networkInterface"
+	VirtualMachine   Kind = "virtualMachine"
+	Disk             Kind = "disk"
+	Deployment       Kind = "deployment"
+)
+
+// DefaultTimeouts are the timeouts used for each kind unless overridden.
+var DefaultTimeouts = map[Kind]time.Duration{
+	ResourceGroup:    15 * time.Minute,
+	StorageAccount:   5 * time.Minute,
+	Vault:            5 * time.Minute,
+	VirtualNetwork:   5 * time.Minute,
+	SecurityGroup:    5 * time.Minute,
+	PublicIPAddress:  5 * time.Minute,
+	NetworkInterface: 5 * time.Minute,
+	VirtualMachine:   30 * time.Minute,
+	Disk:             10 * time.Minute,
+	Deployment:       60 * time.Minute,
+}
+
+// KindOf returns the Kind of an ARM resource type such as
+// "Microsoft.Compute/virtualMachines". Types without a Kind of their own are
+// returned as is and use DefaultTimeout.
+func KindOf(resourceType string) Kind {
+	if kind, ok := kindsByType[strings.ToLower(resourceType)]; ok {
+		return kind
+	}
+	return Kind(resourceType)
+}
+
+var kindsByType = map[string]Kind{
+	"microsoft.resources/resourcegroups":      ResourceGroup,
+	"microsoft.storage/storageaccounts":       StorageAccount,
+	"microsoft.keyvault/vaults":               Vault,
+	"microsoft.network/virtualnetworks":       VirtualNetwork,
+	"microsoft.network/networksecuritygroups": SecurityGroup,
+	"microsoft.network/publicipaddresses":     PublicIPAddress,
+	"microsoft.network/networkinterfaces":     NetworkInterface,
+	"microsoft.compute/virtualmachines":       VirtualMachine,
+	"microsoft.compute/disks":                 Disk,
+	"microsoft.resources/deployments":         Deployment,
+}
+
+const (
+	// DefaultTimeout applies to kinds missing from DefaultTimeouts.
+	DefaultTimeout          = 10 * time.Minute
+	DefaultFrequency        = 10 * time.Second
+	DefaultProgressInterval = 30 * time.Second
+)
+
+var labels = map[Kind]string{
+	ResourceGroup:    "resource group",
+	StorageAccount:   "storage account",
+	Vault:            "key vault",
+	VirtualNetwork:   "virtual network",
+	SecurityGroup:    "network security group",
+	PublicIPAddress:  "public IP address",
+	NetworkInterface: "network interface",
+	VirtualMachine:   "virtual machine",
+	Disk:             "disk",
+	Deployment:       "deployment",
+}
+
+// Operation describes a long-running operation in progress and error messages.
+type Operation struct {
+	Action string
+	Kind   Kind
+	Name   string
+}
+
+// Create describes the creation of the resource name of type kind.
+func Create(kind Kind, name string) Operation {
+	return Operation{Action: "create", Kind: kind, Name: name}
+}
+
+// Delete describes the deletion of the resource name of type kind.
+func Delete(kind Kind, name string) Operation {
+	return Operation{Action: "delete", Kind: kind, Name: name}
+}
+
+func (op Operation) String() string {
+	return op.Action + " " + op.Kind.Label() + " " + op.Name
+}
+
+// Label returns the name of k in messages, such as "storage account".
+func (k Kind) Label() string {
+	if label, ok := labels[k]; ok {
+		return label
+	}
+	return string(k)
+}
+
+// Waiter polls long-running operations until they complete.
+type Waiter struct {
+	Timeouts         map[Kind]time.Duration
+	Frequency        time.Duration
+	ProgressInterval time.Duration
+	Out              io.Writer
+	// Store, when set, keeps the resume token of every operation until it
+	// reaches a terminal state.
+	Store *Store
+}
+
+// NewWaiter creates a Waiter with the default timeouts that reports to out.
+func NewWaiter(out io.Writer) *Waiter {
+	timeouts := make(map[Kind]time.Duration, len(DefaultTimeouts))
+	for kind, timeout := range DefaultTimeouts {
+		timeouts[kind] = timeout
+	}
+	return &Waiter{
+		Timeouts:         timeouts,
+		Frequency:        DefaultFrequency,
+		ProgressInterval: DefaultProgressInterval,
+		Out:              out,
+	}
+}
+
+// Timeout returns the timeout for operations on resources of type kind.
+func (w *Waiter) Timeout(kind Kind) time.Duration {
+	if timeout, ok := w.Timeouts[kind]; ok {
+		return timeout
+	}
+	if timeout, ok := DefaultTimeouts[kind]; ok {
+		return timeout
+	}
+	return DefaultTimeout
+}
+
+// WithTimeout returns a context bounded by the timeout of kind, for calls that
+// are not long-running operations but can still take a while.
+func (w *Waiter) WithTimeout(ctx context.Context, kind Kind) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, w.Timeout(kind))
+}
+
+// Wait polls poller until op completes or the timeout for op.Kind expires and
+// returns the final result. Progress is reported whenever the provisioning
+// state changes and at least every ProgressInterval.
+func Wait[T any](ctx context.Context, w *Waiter, op Operation, poller *runtime.Poller[T]) (T, error) {
+	return wait(ctx, w, op, poller, nil)
+}
+
+// Watch is Wait that also calls watch after every poll while op is in
+// progress and once more when it ended, however it ended, to report the
+// progress of its parts, such as the resource operations of a template
+// deployment.
+func Watch[T any](ctx context.Context, w *Waiter, op Operation, poller *runtime.Poller[T], watch func(context.Context)) (T, error) {
+	return wait(ctx, w, op, poller, watch)
+}
+
+func wait[T any](ctx context.Context, w *Waiter, op Operation, poller *runtime.Poller[T], watch func(context.Context)) (T, error) {
+	var zero T
+	if watch != nil {
+		// The last call outlives the timeout, so that a timed out
+		// operation still reports where it got to.
+		defer watch(ctx)
+	}
+	timeout := w.Timeout(op.Kind)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	lastReport := start
+	lastState := ""
+	fmt.Fprintf(w.Out, "Waiting to %s (timeout %s)\n", op, timeout)
+	w.save(op, poller)
+	for !poller.Done() {
+		resp, err := poller.Poll(ctx)
+		if err != nil {
+			return zero, w.fail(op, timeout, err)
+		}
+		if poller.Done() {
+			break
+		}
+		if watch != nil {
+			watch(ctx)
+		}
+		state := provisioningState(resp)
+		if state != lastState || time.Since(lastReport) >= w.ProgressInterval {
+			fmt.Fprintf(w.Out, "  %s: %s (%s elapsed)\n", op, state, time.Since(start).Round(time.Second))
+			lastState = state
+			lastReport = time.Now()
+		}
+		delay := w.Frequency
+		if after := retry.After(resp); after > 0 {
+			delay = after
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return zero, w.fail(op, timeout, ctx.Err())
+		}
+	}
+	w.forget(op)
+	result, err := poller.Result(ctx)
+	if err != nil {
+		return zero, w.fail(op, timeout, err)
+	}
+	fmt.Fprintf(w.Out, "Completed: %s in %s\n", op, time.Since(start).Round(time.Second))
+	return result, nil
+}
+
+// Error is returned by Wait when a long-running operation fails or times out.
+type Error struct {
+	Op       Operation
+	TimedOut bool
+	Err      error
+}
+
+func (e *Error) Error() string {
+	if e.TimedOut {
+		return fmt.Sprintf("timed out waiting to %s: %s", e.Op, e.Err)
+	}
+	var respErr *azcore.ResponseError
+	if errors.As(e.Err, &respErr) {
+		return fmt.Sprintf("failed to %s: %s (status %d)", e.Op, respErr.ErrorCode, respErr.StatusCode)
+	}
+	return fmt.Sprintf("failed to %s: %s", e.Op, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (w *Waiter) save(op Operation, poller interface{ ResumeToken() (string, error) }) {
+	if w.Store == nil {
+		return
+	}
+	token, err := poller.ResumeToken()
+	if err != nil {
+		// the operation already completed, there is nothing to resume
+		return
+	}
+	if err := w.Store.Save(op, token); err != nil {
+		fmt.Fprintf(w.Out, "Warning: failed to save resume token of %s to %s: %s\n", op, w.Store.Path(), err)
+	}
+}
+
+func (w *Waiter) forget(op Operation) {
+	if w.Store == nil {
+		return
+	}
+	if err := w.Store.Remove(op); err != nil {
+		fmt.Fprintf(w.Out, "Warning: failed to remove %s from %s: %s\n", op, w.Store.Path(), err)
+	}
+}
+
+func (w *Waiter) fail(op Operation, timeout time.Duration, err error) error {
+	timedOut := errors.Is(err, context.DeadlineExceeded)
+	if timedOut {
+		err = fmt.Errorf("no result after %s", timeout)
+	}
+	return &Error{Op: op, TimedOut: timedOut, Err: err}
+}
+
+// provisioningState reads the state from either a resource or an Azure
+// async operation status body.
+func provisioningState(resp *http.Response) string {
+	body, err := runtime.Payload(resp)
+	if err == nil && len(body) > 0 {
+		var status struct {
+			Status     string
+			Properties struct {
+				ProvisioningState string
+			}
+		}
+		if json.Unmarshal(body, &status) == nil {
+			if status.Properties.ProvisioningState != "" {
+				return status.Properties.ProvisioningState
+			}
+			if status.Status != "" {
+				return status.Status
+			}
+		}
+	}
+	if resp.StatusCode == http.StatusAccepted {
+		return "InProgress"
+	}
+	return resp.Status
+}
+
+// Flags holds the command line flags that adjust a Waiter.
+type Flags struct {
+	timeouts         string
+	frequency        time.Duration
+	progressInterval time.Duration
+	statePath        string
+}
+
+// RegisterFlags defines the long-running operation flags on fs.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{}
+	fs.StringVar(&f.timeouts, "lroTimeout", "", "timeout of long-running operations, either one duration for all resource types or a comma separated list such as virtualMachine=45m,disk=15m")
+	fs.DurationVar(&f.frequency, "pollFrequency", DefaultFrequency, "delay between polls of a long-running operation")
+	fs.DurationVar(&f.progressInterval, "progressInterval", DefaultProgressInterval, "interval of the progress output while waiting for a long-running operation")
+	fs.StringVar(&f.statePath, "lroState", DefaultStatePath, "file that keeps the resume tokens of in-flight long-running operations, empty to disable")
+	return f
+}
+
+// NewWaiter creates a Waiter reporting to out with the flags applied.
+func (f *Flags) NewWaiter(out io.Writer) (*Waiter, error) {
+	w := NewWaiter(out)
+	if f.frequency <= 0 {
+		return nil, errors.New("pollFrequency must be positive")
+	}
+	w.Frequency = f.frequency
+	w.ProgressInterval = f.progressInterval
+	if f.statePath != "" {
+		w.Store = OpenStore(f.statePath)
+	}
+	if f.timeouts == "" {
+		return w, nil
+	}
+	if timeout, err := time.ParseDuration(f.timeouts); err == nil {
+		for kind := range w.Timeouts {
+			w.Timeouts[kind] = timeout
+		}
+		return w, nil
+	}
+	for _, entry := range strings.Split(f.timeouts, ",") {
+		kind, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid lroTimeout entry %q, expected type=duration", entry)
+		}
+		if _, known := DefaultTimeouts[Kind(kind)]; !known {
+			return nil, fmt.Errorf("unknown resource type %q in lroTimeout, expected one of %s", kind, strings.Join(kinds(), ", "))
+		}
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid lroTimeout for %s: %s", kind, err)
+		}
+		w.Timeouts[Kind(kind)] = timeout
+	}
+	return w, nil
+}
+
+func kinds() []string {
+	names := make([]string, 0, len(DefaultTimeouts))
+	for kind := range DefaultTimeouts {
+		names = append(names, string(kind))
+	}
+	sort.Strings(names)
+	return names
+}
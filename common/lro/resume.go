@@ -0,0 +1,99 @@
+package lro
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	armruntime "github.com/Azure/azure-sdk-for-go/sdk/azcore/arm/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+)
+
+// Result is the final state of a resumed operation. Deletes leave it empty.
+type Result struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Properties struct {
+		ProvisioningState string `json:"provisioningState"`
+	} `json:"properties"`
+}
+
+// Resume reattaches to every operation pending in w.Store and waits for each
+// of them to finish, reporting its outcome. Operations that still have not
+// finished are left in the store for the next attempt.
+func Resume(ctx context.Context, w *Waiter, cred azcore.TokenCredential, options *arm.ClientOptions) error {
+	if w.Store == nil {
+		return errors.New("no long-running operation state file configured")
+	}
+	pending, err := w.Store.List()
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", w.Store.Path(), err)
+	}
+	if len(pending) == 0 {
+		fmt.Fprintf(w.Out, "No pending operations in %s\n", w.Store.Path())
+		return nil
+	}
+	pl, err := armruntime.NewPipeline("lro", "v1.0.0", cred, runtime.PipelineOptions{}, options)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w.Out, "Resuming %d operations from %s\n", len(pending), w.Store.Path())
+	outcomes := make([]string, 0, len(pending))
+	failed := 0
+	for _, p := range pending {
+		op := p.Operation()
+		err := resume(ctx, w, pl, p)
+		switch {
+		case err == nil:
+			outcomes = append(outcomes, fmt.Sprintf("  %s: succeeded", op))
+		case errors.As(err, new(*Error)):
+			outcomes = append(outcomes, fmt.Sprintf("  %s", err))
+			failed++
+		default:
+			outcomes = append(outcomes, fmt.Sprintf("  failed to resume %s: %s", op, err))
+			failed++
+		}
+	}
+	fmt.Fprintln(w.Out, "Resumed operations:")
+	for _, outcome := range outcomes {
+		fmt.Fprintln(w.Out, outcome)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d resumed operations did not succeed", failed, len(pending))
+	}
+	return nil
+}
+
+func resume(ctx context.Context, w *Waiter, pl runtime.Pipeline, p Pending) error {
+	token, err := retype(p.ResumeToken, "Result")
+	if err != nil {
+		return err
+	}
+	poller, err := runtime.NewPollerFromResumeToken[Result](token, pl, nil)
+	if err != nil {
+		return err
+	}
+	_, err = Wait(ctx, w, p.Operation(), poller)
+	return err
+}
+
+// retype rewrites the result type recorded in a resume token. The token was
+// created for the response type of a specific client method, but resuming
+// only needs the generic Result.
+func retype(token, typeName string) (string, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(token), &raw); err != nil {
+		return "", fmt.Errorf("invalid resume token: %w", err)
+	}
+	name, err := json.Marshal(typeName)
+	if err != nil {
+		return "", err
+	}
+	raw["type"] = name
+	data, err := json.Marshal(raw)
+	return string(data), err
+}
@@ -0,0 +1,140 @@
+package lro
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultStatePath is the file the resume tokens are kept in unless overridden.
+const DefaultStatePath = ".lro-state.json"
+
+// Pending is an operation that was started but not seen to completion.
+type Pending struct {
+	Action      string    `json:"action"`
+	Kind        Kind      `json:"kind"`
+	Name        string    `json:"name"`
+	ResumeToken string    `json:"resumeToken"`
+	Started     time.Time `json:"started"`
+}
+
+// Operation returns the operation p was started for.
+func (p Pending) Operation() Operation {
+	return Operation{Action: p.Action, Kind: p.Kind, Name: p.Name}
+}
+
+// Store keeps the resume tokens of in-flight operations in a JSON file, so that
+// a later process can reattach to them after a restart.
+type Store struct {
+	path string
+	mu   sync.Mutex
+}
+
+// OpenStore returns a Store backed by the file at path. The file is created
+// when the first operation is saved.
+func OpenStore(path string) *Store {
+	return &Store{path: path}
+}
+
+// Path returns the file the store is backed by.
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Save records the resume token of op, replacing any earlier token for it.
+func (s *Store) Save(op Operation, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pending, err := s.read()
+	if err != nil {
+		return err
+	}
+	pending = remove(pending, op)
+	pending = append(pending, Pending{
+		Action:      op.Action,
+		Kind:        op.Kind,
+		Name:        op.Name,
+		ResumeToken: token,
+		Started:     time.Now().UTC(),
+	})
+	return s.write(pending)
+}
+
+// Remove forgets op once it reached a terminal state.
+func (s *Store) Remove(op Operation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pending, err := s.read()
+	if err != nil {
+		return err
+	}
+	return s.write(remove(pending, op))
+}
+
+// List returns the operations that are still pending, oldest first.
+func (s *Store) List() ([]Pending, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.read()
+}
+
+func remove(pending []Pending, op Operation) []Pending {
+	kept := pending[:0]
+	for _, p := range pending {
+		if p.Operation() != op {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+func (s *Store) read() ([]Pending, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var state struct {
+		Operations []Pending `json:"operations"`
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	return state.Operations, nil
+}
+
+// write replaces the file through a rename so a crash never leaves it half written.
+func (s *Store) write(pending []Pending) error {
+	if len(pending) == 0 {
+		err := os.Remove(s.path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	data, err := json.MarshalIndent(struct {
+		Operations []Pending `json:"operations"`
+	}{pending}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
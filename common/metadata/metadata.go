@@ -0,0 +1,95 @@
+// Package metadata reads the endpoints of an Azure Stack Hub stamp from the
+// metadata endpoint of its Azure Resource Manager.
+package metadata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// Environment holds the endpoints the samples need to authenticate and to
+// address resources on a stamp.
+type Environment struct {
+	ResourceManagerEndpoint string
+	ActiveDirectoryEndpoint string
+	TokenAudience           string
+	StorageEndpointSuffix   string
+	KeyVaultDNSSuffix       string
+}
+
+// IsADFS reports whether the stamp authenticates against AD FS rather than
+// Azure Active Directory.
+func (e Environment) IsADFS() bool {
+	return strings.HasSuffix(strings.TrimSuffix(e.ActiveDirectoryEndpoint, "/"), "/adfs")
+}
+
+type endpoints struct {
+	Authentication struct {
+		LoginEndpoint string   `json:"loginEndpoint"`
+		Audiences     []string `json:"audiences"`
+	} `json:"authentication"`
+}
+
+// Load reads the environment of the stamp whose Azure Resource Manager is at
+// resourceManagerEndpoint. The request is sent through transport, or through
+// http.DefaultClient when transport is nil.
+func Load(ctx context.Context, resourceManagerEndpoint string, transport policy.Transporter) (Environment, error) {
+	if resourceManagerEndpoint == "" {
+		return Environment{}, fmt.Errorf("metadata resource manager endpoint is empty")
+	}
+	if transport == nil {
+		transport = http.DefaultClient
+	}
+	metadataURL := strings.TrimSuffix(resourceManagerEndpoint, "/") + "/metadata/endpoints?api-version=1.0"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadataURL, nil)
+	if err != nil {
+		return Environment{}, err
+	}
+	resp, err := transport.Do(req)
+	if err != nil {
+		return Environment{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Environment{}, fmt.Errorf("GET %s: %s", metadataURL, resp.Status)
+	}
+	var info endpoints
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return Environment{}, err
+	}
+	if len(info.Authentication.Audiences) == 0 {
+		return Environment{}, fmt.Errorf("no token audience in %s", metadataURL)
+	}
+
+	suffix, err := dnsSuffix(resourceManagerEndpoint)
+	if err != nil {
+		return Environment{}, err
+	}
+	return Environment{
+		ResourceManagerEndpoint: resourceManagerEndpoint,
+		ActiveDirectoryEndpoint: info.Authentication.LoginEndpoint,
+		TokenAudience:           info.Authentication.Audiences[0],
+		StorageEndpointSuffix:   suffix,
+		KeyVaultDNSSuffix:       "vault." + suffix,
+	}, nil
+}
+
+// dnsSuffix drops the first label of the host of endpoint, so that
+// management.region.example.com becomes region.example.com.
+func dnsSuffix(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	host := u.Host
+	if _, rest, ok := strings.Cut(host, "."); ok {
+		return rest, nil
+	}
+	return host, nil
+}
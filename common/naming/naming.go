@@ -0,0 +1,296 @@
+// Package naming names the resources of a run. Every run has an ID, which the
+// default templates add to the names of the resource groups and of the
+// resources whose names are global, such as storage accounts and key vaults,
+// so that runs of several people or CI jobs on the same stamp do not collide.
+package naming
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/lro"
+)
+
+// The placeholders of a template.
+const (
+	// Base is replaced with the name the sample gives the resource.
+	Base = "{base}"
+	// Run is replaced with the run ID, or with a new random suffix when a
+	// name is retried.
+	Run = "{run}"
+)
+
+// DefaultTemplates are the templates of the kinds that get the run ID by
+// default. The other kinds keep the names of the samples, since their names
+// only need to be unique in their resource group.
+var DefaultTemplates = map[lro.Kind]string{
+	lro.ResourceGroup:  Base + "-" + Run,
+	lro.StorageAccount: Base + Run,
+	lro.Vault:          Base + "-" + Run,
+	lro.Deployment:     Base + "-" + Run,
+}
+
+// runIDChars are the characters of run IDs, which every kind of resource
+// accepts in its names.
+const runIDChars = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+// RunIDLength is the length of generated run IDs and retry suffixes.
+const RunIDLength = 6
+
+// MaxAttempts is the number of names Retry tries.
+const MaxAttempts = 5
+
+// ErrUnavailable is returned, wrapped, when a name is taken.
+var ErrUnavailable = errors.New("name not available")
+
+// unavailableCodes are the ARM error codes of names taken by another resource.
+var unavailableCodes = map[string]bool{
+	"StorageAccountAlreadyTaken":  true,
+	"StorageAccountAlreadyExists": true,
+	"VaultAlreadyExists":          true,
+}
+
+// Unavailable reports whether err means that a name is taken.
+func Unavailable(err error) bool {
+	if errors.Is(err, ErrUnavailable) {
+		return true
+	}
+	var respErr *azcore.ResponseError
+	return errors.As(err, &respErr) && unavailableCodes[respErr.ErrorCode]
+}
+
+// rule is what ARM accepts as the name of a kind of resource.
+type rule struct {
+	min, max int
+	// chars are the characters allowed besides letters and digits.
+	chars string
+	lower bool
+	// first and last are the characters a name may start and end with,
+	// besides letters and digits; "-" means letters and digits only.
+	first, last string
+	letterFirst bool
+	description string
+}
+
+var rules = map[lro.Kind]rule{
+	lro.ResourceGroup:    {min: 1, max: 90, chars: "-_.()", first: "-_.()", last: "-_()", description: "1-90 letters, digits, '-', '_', '.', '(' and ')', not ending with '.'"},
+	lro.StorageAccount:   {min: 3, max: 24, lower: true, description: "3-24 lowercase letters and digits"},
+	lro.Vault:            {min: 3, max: 24, chars: "-", letterFirst: true, description: "3-24 letters, digits and single hyphens, starting with a letter and ending with a letter or digit"},
+	lro.VirtualNetwork:   {min: 2, max: 64, chars: "-_.", last: "_", description: "2-64 letters, digits, '-', '_' and '.', starting with a letter or digit and ending with a letter, digit or '_'"},
+	lro.SecurityGroup:    {min: 1, max: 80, chars: "-_.", last: "_", description: "1-80 letters, digits, '-', '_' and '.', starting with a letter or digit and ending with a letter, digit or '_'"},
+	lro.PublicIPAddress:  {min: 1, max: 80, chars: "-_.", last: "_", description: "1-80 letters, digits, '-', '_' and '.', starting with a letter or digit and ending with a letter, digit or '_'"},
+	lro.NetworkInterface: {min: 1, max: 80, chars: "-_.", last: "_", description: "1-80 letters, digits, '-', '_' and '.', starting with a letter or digit and ending with a letter, digit or '_'"},
+	lro.VirtualMachine:   {min: 1, max: 64, chars: "-.", description: "1-64 letters, digits, '-' and '.', starting and ending with a letter or digit"},
+	lro.Disk:             {min: 1, max: 80, chars: "-_.", last: "_", description: "1-80 letters, digits, '-', '_' and '.', starting with a letter or digit and ending with a letter, digit or '_'"},
+	lro.Deployment:       {min: 1, max: 64, chars: "-_.()", first: "-_.()", last: "-_()", description: "1-64 letters, digits, '-', '_', '.', '(' and ')', not ending with '.'"},
+}
+
+func isAlnum(c rune) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func isLetter(c rune) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// sanitize drops the characters r does not allow from s.
+func (r rule) sanitize(s string) string {
+	if r.lower {
+		s = strings.ToLower(s)
+	}
+	var b strings.Builder
+	for _, c := range s {
+		if isAlnum(c) || strings.ContainsRune(r.chars, c) {
+			b.WriteRune(c)
+		}
+	}
+	s = b.String()
+	if r.letterFirst {
+		// Key vault names have no consecutive hyphens.
+		for strings.Contains(s, "--") {
+			s = strings.ReplaceAll(s, "--", "-")
+		}
+	}
+	return s
+}
+
+func (r rule) check(kind lro.Kind, name string) error {
+	n := len(name)
+	ok := n >= r.min && n <= r.max
+	if ok {
+		first, last := rune(name[0]), rune(name[n-1])
+		ok = (isAlnum(first) || strings.ContainsRune(r.first, first)) && (isAlnum(last) || strings.ContainsRune(r.last, last))
+		if r.letterFirst && !isLetter(first) {
+			ok = false
+		}
+	}
+	if !ok {
+		return fmt.Errorf("%q is not a valid %s name: it must have %s", name, kind.Label(), r.description)
+	}
+	return nil
+}
+
+// Namer names the resources of a run. Its methods may be called on a nil
+// Namer, which keeps the names of the samples.
+type Namer struct {
+	runID     string
+	templates map[lro.Kind]string
+	// Out receives a message for every taken name Retry replaces.
+	Out io.Writer
+}
+
+// New creates a Namer for the run runID with templates in addition to
+// DefaultTemplates. An empty runID is replaced with a random one.
+func New(runID string, templates map[lro.Kind]string) (*Namer, error) {
+	if runID == "" {
+		runID = NewRunID()
+	}
+	if len(runID) > 8 || strings.Trim(runID, runIDChars) != "" {
+		return nil, fmt.Errorf("invalid run ID %q: it must have 1-8 lowercase letters and digits", runID)
+	}
+	n := &Namer{runID: runID, templates: map[lro.Kind]string{}, Out: io.Discard}
+	for kind, template := range DefaultTemplates {
+		n.templates[kind] = template
+	}
+	for kind, template := range templates {
+		if _, ok := rules[kind]; !ok {
+			return nil, fmt.Errorf("unknown resource type %q in a name template, expected one of %s", kind, strings.Join(Kinds(), ", "))
+		}
+		if !strings.Contains(template, Base) && !strings.Contains(template, Run) {
+			return nil, fmt.Errorf("the name template %q of %s has neither %s nor %s", template, kind, Base, Run)
+		}
+		n.templates[kind] = template
+	}
+	return n, nil
+}
+
+// Kinds returns the resource types that have name templates.
+func Kinds() []string {
+	var kinds []string
+	for kind := range rules {
+		kinds = append(kinds, string(kind))
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// NewRunID returns a random run ID.
+func NewRunID() string {
+	id := make([]byte, RunIDLength)
+	for i := range id {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(runIDChars))))
+		if err != nil {
+			panic(err)
+		}
+		id[i] = runIDChars[n.Int64()]
+	}
+	return string(id)
+}
+
+// RunID returns the ID of the run, or "" for a nil Namer.
+func (n *Namer) RunID() string {
+	if n == nil {
+		return ""
+	}
+	return n.runID
+}
+
+// Name returns the name of the resource of type kind that the sample calls
+// base.
+func (n *Namer) Name(kind lro.Kind, base string) (string, error) {
+	if n == nil {
+		return base, nil
+	}
+	return n.render(kind, base, n.runID)
+}
+
+// render fills the template of kind in with base and suffix. The characters
+// the kind does not allow are dropped, and base is shortened when the name
+// would be too long, so that the suffix is always kept.
+func (n *Namer) render(kind lro.Kind, base, suffix string) (string, error) {
+	template, ok := n.templates[kind]
+	if !ok {
+		template = Base
+	}
+	r, ok := rules[kind]
+	if !ok {
+		return strings.NewReplacer(Base, base, Run, suffix).Replace(template), nil
+	}
+	base = r.sanitize(base)
+	fixed := r.sanitize(strings.NewReplacer(Base, "", Run, suffix).Replace(template))
+	if count := strings.Count(template, Base); count > 0 && len(fixed)+count*len(base) > r.max {
+		keep := (r.max - len(fixed)) / count
+		if keep < 0 {
+			keep = 0
+		}
+		base = strings.TrimRight(base[:keep], "-_.")
+	}
+	name := r.sanitize(strings.NewReplacer(Base, base, Run, suffix).Replace(template))
+	return name, r.check(kind, name)
+}
+
+// Retry calls create with the name of the resource of type kind that the
+// sample calls base until create succeeds or fails for another reason than
+// the name being taken. Each retry replaces the run ID with a new random
+// suffix, so a template without Run is not retried. It returns the last name
+// tried.
+func (n *Namer) Retry(kind lro.Kind, base string, create func(name string) error) (string, error) {
+	name, err := n.Name(kind, base)
+	if err != nil {
+		return name, err
+	}
+	for attempt := 1; ; attempt++ {
+		err = create(name)
+		if n == nil || err == nil || !Unavailable(err) || attempt == MaxAttempts {
+			return name, err
+		}
+		next, renderErr := n.render(kind, base, NewRunID())
+		if renderErr != nil {
+			return name, renderErr
+		}
+		if next == name {
+			return name, fmt.Errorf("%w; the name template %q of %s has no %s to try another name with", err, n.templates[kind], kind, Run)
+		}
+		fmt.Fprintf(n.Out, "The name %s is taken, trying %s\n", name, next)
+		name = next
+	}
+}
+
+// Templates are name templates by resource type. It implements flag.Value
+// for a flag that takes kind=template and may be repeated.
+type Templates map[lro.Kind]string
+
+func (t Templates) String() string {
+	return strings.Join(t.Values(), ",")
+}
+
+// Values returns the templates as the kind=template arguments of the flags
+// that set them, sorted.
+func (t Templates) Values() []string {
+	var pairs []string
+	for kind, template := range t {
+		pairs = append(pairs, string(kind)+"="+template)
+	}
+	sort.Strings(pairs)
+	return pairs
+}
+
+// Set implements flag.Value.
+func (t Templates) Set(value string) error {
+	kind, template, ok := strings.Cut(value, "=")
+	if !ok || template == "" {
+		return fmt.Errorf("expected type=template, such as storageAccount=%s%s", Base, Run)
+	}
+	if _, ok := rules[lro.Kind(kind)]; !ok {
+		return fmt.Errorf("unknown resource type %q, expected one of %s", kind, strings.Join(Kinds(), ", "))
+	}
+	t[lro.Kind(kind)] = template
+	return nil
+}
@@ -0,0 +1,174 @@
+package naming
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/lro"
+)
+
+func TestName(t *testing.T) {
+	tests := []struct {
+		kind     lro.Kind
+		template string
+		base     string
+		want     string
+		wantErr  string
+	}{
+		// The default templates.
+		{kind: lro.ResourceGroup, base: "TestGoVMSampleResourceGroup", want: "TestGoVMSampleResourceGroup-abc123"},
+		{kind: lro.StorageAccount, base: "hybridstorage", want: "hybridstorageabc123"},
+		{kind: lro.Vault, base: "hybridvault", want: "hybridvault-abc123"},
+		{kind: lro.VirtualMachine, base: "TestGoVM", want: "TestGoVM"},
+		// The characters a kind does not allow are dropped.
+		{kind: lro.StorageAccount, base: "My-Storage_Acct", want: "mystorageacctabc123"},
+		{kind: lro.Vault, template: "{base}--{run}", base: "key--vault", want: "key-vault-abc123"},
+		{kind: lro.VirtualMachine, template: "{run}_{base}", base: "vm", want: "abc123vm"},
+		// The base is shortened to keep the suffix, without a separator at
+		// its end.
+		{kind: lro.StorageAccount, base: "averyveryverylongstorageaccount", want: "averyveryverylongsabc123"},
+		{kind: lro.Vault, base: "abcdefghijklmnop-qrstuvwxyz", want: "abcdefghijklmnop-abc123"},
+		{kind: lro.Vault, template: "{base}-{base}-{run}", base: "abcdefghijklm", want: "abcdefgh-abcdefgh-abc123"},
+		// Names the kind does not accept.
+		{kind: lro.Vault, template: "1{base}-{run}", base: "vault", wantErr: `"1vault-abc123" is not a valid`},
+		{kind: lro.StorageAccount, template: "{base}", base: "ab", wantErr: `"ab" is not a valid`},
+		{kind: lro.StorageAccount, template: "{base}averylongfixedpartofthename{run}", base: "storage", wantErr: "is not a valid"},
+		{kind: lro.ResourceGroup, template: "{base}.", base: "group", wantErr: `"group." is not a valid`},
+	}
+	for _, tt := range tests {
+		templates := map[lro.Kind]string{}
+		if tt.template != "" {
+			templates[tt.kind] = tt.template
+		}
+		n, err := New("abc123", templates)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := n.Name(tt.kind, tt.base)
+		switch {
+		case tt.wantErr != "":
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Name(%s, %q) with %q = %q, %v, want an error containing %q", tt.kind, tt.base, tt.template, got, err, tt.wantErr)
+			}
+		case err != nil || got != tt.want:
+			t.Errorf("Name(%s, %q) with %q = %q, %v, want %q", tt.kind, tt.base, tt.template, got, err, tt.want)
+		}
+	}
+}
+
+func TestNilNamer(t *testing.T) {
+	var n *Namer
+	if name, err := n.Name(lro.StorageAccount, "hybridstorage"); name != "hybridstorage" || err != nil {
+		t.Errorf("Name = %q, %v, want the name of the sample", name, err)
+	}
+	if id := n.RunID(); id != "" {
+		t.Errorf("RunID = %q", id)
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		runID     string
+		templates map[lro.Kind]string
+		wantErr   string
+	}{
+		{runID: "abc123"},
+		{runID: "ABC123", wantErr: "invalid run ID"},
+		{runID: "abcdefghi", wantErr: "invalid run ID"},
+		{runID: "abc-12", wantErr: "invalid run ID"},
+		{runID: "abc123", templates: map[lro.Kind]string{"loadBalancer": "{base}"}, wantErr: `unknown resource type "loadBalancer"`},
+		{runID: "abc123", templates: map[lro.Kind]string{lro.StorageAccount: "hybridstorage"}, wantErr: "has neither {base} nor {run}"},
+	}
+	for _, tt := range tests {
+		_, err := New(tt.runID, tt.templates)
+		if (err == nil) != (tt.wantErr == "") || err != nil && !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("New(%q, %v) = %v, want an error containing %q", tt.runID, tt.templates, err, tt.wantErr)
+		}
+	}
+	n, err := New("", nil)
+	if err != nil || len(n.RunID()) != RunIDLength || strings.Trim(n.RunID(), runIDChars) != "" {
+		t.Errorf("New without a run ID: %v, run ID %q", err, n.RunID())
+	}
+}
+
+func TestTemplatesSet(t *testing.T) {
+	templates := Templates{}
+	for _, value := range []string{"storageAccount={base}{run}x", "vault={run}v"} {
+		if err := templates.Set(value); err != nil {
+			t.Errorf("Set(%q): %v", value, err)
+		}
+	}
+	if got := templates.String(); got != "storageAccount={base}{run}x,vault={run}v" {
+		t.Errorf("String() = %q", got)
+	}
+	for _, value := range []string{"storageAccount", "storageAccount=", "loadBalancer={base}"} {
+		if err := templates.Set(value); err == nil {
+			t.Errorf("Set(%q) accepted an invalid template", value)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	taken := fmt.Errorf("storage account taken: %w", ErrUnavailable)
+	tests := []struct {
+		name      string
+		template  string
+		available int
+		wantTries int
+		wantErr   string
+	}{
+		{name: "available", available: 1, wantTries: 1},
+		{name: "taken twice", available: 3, wantTries: 3},
+		{name: "always taken", available: MaxAttempts + 1, wantTries: MaxAttempts, wantErr: "storage account taken"},
+		// Without {run}, every retry would try the same name.
+		{name: "fixed name", template: "{base}", available: 2, wantTries: 1, wantErr: `the name template "{base}" of storageAccount has no {run}`},
+	}
+	for _, tt := range tests {
+		templates := map[lro.Kind]string{}
+		if tt.template != "" {
+			templates[lro.StorageAccount] = tt.template
+		}
+		n, err := New("abc123", templates)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out strings.Builder
+		n.Out = &out
+		var tried []string
+		name, err := n.Retry(lro.StorageAccount, "hybridstorage", func(name string) error {
+			tried = append(tried, name)
+			if len(tried) < tt.available {
+				return taken
+			}
+			return nil
+		})
+		if len(tried) != tt.wantTries || name != tried[len(tried)-1] {
+			t.Errorf("%s: tried %v, returned %q", tt.name, tried, name)
+		}
+		for i := 1; i < len(tried); i++ {
+			if tried[i] == tried[i-1] || !strings.HasPrefix(tried[i], "hybridstorage") {
+				t.Errorf("%s: tried %v, want new names", tt.name, tried)
+			}
+			if want := fmt.Sprintf("The name %s is taken, trying %s\n", tried[i-1], tried[i]); !strings.Contains(out.String(), want) {
+				t.Errorf("%s: output %q, want %q", tt.name, out.String(), want)
+			}
+		}
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr) || !Unavailable(err)):
+			t.Errorf("%s: error %v, want an unavailable name containing %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestUnavailable(t *testing.T) {
+	if !Unavailable(fmt.Errorf("create: %w", ErrUnavailable)) {
+		t.Error("a wrapped ErrUnavailable is not unavailable")
+	}
+	if Unavailable(errors.New("StorageAccountAlreadyTaken")) {
+		t.Error("an error that is not a response of ARM is unavailable")
+	}
+}
@@ -0,0 +1,194 @@
+// Package output prints the listings of the samples as a table or in a
+// machine-readable format selected with -output.
+package output
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Format selects how listings are printed.
+type Format string
+
+const (
+	Table Format = "table"
+	JSON  Format = "json"
+	YAML  Format = "yaml"
+	CSV   Format = "csv"
+)
+
+func (f *Format) String() string {
+	return string(*f)
+}
+
+// Set implements flag.Value.
+func (f *Format) Set(value string) error {
+	switch Format(value) {
+	case Table, JSON, YAML, CSV:
+		*f = Format(value)
+		return nil
+	}
+	return fmt.Errorf("unknown output format %q, expected %s, %s, %s or %s", value, Table, JSON, YAML, CSV)
+}
+
+// MachineReadable reports whether listings in f are meant for scripts rather
+// than people. The samples then keep stdout for the listings alone.
+func (f Format) MachineReadable() bool {
+	return f != Table
+}
+
+// Resource is a row of a listing. Fields a resource type does not have stay
+// empty.
+type Resource struct {
+	Name              string            `json:"name"`
+	Location          string            `json:"location"`
+	ProvisioningState string            `json:"provisioningState"`
+	Tags              map[string]string `json:"tags"`
+	SKU               string            `json:"sku"`
+	ID                string            `json:"id"`
+}
+
+// String returns the value p points to, or "" if p is nil. It accepts the
+// string enums of the SDK, such as SKU names and provisioning states.
+func String[T ~string](p *T) string {
+	if p == nil {
+		return ""
+	}
+	return string(*p)
+}
+
+// Tags converts the tags of an SDK model, leaving out the nil values.
+func Tags(tags map[string]*string) map[string]string {
+	converted := make(map[string]string, len(tags))
+	for k, v := range tags {
+		if v != nil {
+			converted[k] = *v
+		}
+	}
+	return converted
+}
+
+// Printer writes listings to W in Format.
+type Printer struct {
+	W      io.Writer
+	Format Format
+}
+
+// Print writes resources sorted by name, then by ID, so that the same
+// resources always give the same output whatever order the pages came in.
+// Every call writes a complete document: a JSON array, a YAML document or a
+// CSV file with its header.
+func (p *Printer) Print(resources []Resource) error {
+	sorted := append([]Resource(nil), resources...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := strings.ToLower(sorted[i].Name), strings.ToLower(sorted[j].Name)
+		if a != b {
+			return a < b
+		}
+		return strings.ToLower(sorted[i].ID) < strings.ToLower(sorted[j].ID)
+	})
+	for i := range sorted {
+		if sorted[i].Tags == nil {
+			sorted[i].Tags = map[string]string{}
+		}
+	}
+
+	switch p.Format {
+	case JSON:
+		data, err := json.MarshalIndent(sorted, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(p.W, "%s\n", data)
+		return err
+	case YAML:
+		return writeYAML(p.W, sorted)
+	case CSV:
+		return writeCSV(p.W, sorted)
+	}
+	return writeTable(p.W, sorted)
+}
+
+var columns = []string{"name", "location", "provisioningState", "tags", "sku", "id"}
+
+func (r Resource) row() []string {
+	return []string{r.Name, r.Location, r.ProvisioningState, joinTags(r.Tags), r.SKU, r.ID}
+}
+
+// joinTags writes tags as key=value pairs sorted by key and separated by ";".
+func joinTags(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + tags[k]
+	}
+	return strings.Join(pairs, ";")
+}
+
+func writeTable(w io.Writer, resources []Resource) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tLOCATION\tSTATE\tTAGS\tSKU\tID")
+	for _, r := range resources {
+		row := r.row()
+		for i, cell := range row {
+			if cell == "" {
+				row[i] = "-"
+			}
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+func writeCSV(w io.Writer, resources []Resource) error {
+	cw := csv.NewWriter(w)
+	cw.Write(columns)
+	for _, r := range resources {
+		cw.Write(r.row())
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeYAML writes resources as a YAML sequence. The strings are written as
+// double-quoted scalars, whose escapes are the same as those of Go, so they
+// read back unchanged whatever they contain.
+func writeYAML(w io.Writer, resources []Resource) error {
+	var b strings.Builder
+	b.WriteString("---\n")
+	if len(resources) == 0 {
+		b.WriteString("[]\n")
+	}
+	for _, r := range resources {
+		fmt.Fprintf(&b, "- name: %s\n", strconv.Quote(r.Name))
+		fmt.Fprintf(&b, "  location: %s\n", strconv.Quote(r.Location))
+		fmt.Fprintf(&b, "  provisioningState: %s\n", strconv.Quote(r.ProvisioningState))
+		if len(r.Tags) == 0 {
+			b.WriteString("  tags: {}\n")
+		} else {
+			b.WriteString("  tags:\n")
+			keys := make([]string, 0, len(r.Tags))
+			for k := range r.Tags {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				fmt.Fprintf(&b, "    %s: %s\n", strconv.Quote(k), strconv.Quote(r.Tags[k]))
+			}
+		}
+		fmt.Fprintf(&b, "  sku: %s\n", strconv.Quote(r.SKU))
+		fmt.Fprintf(&b, "  id: %s\n", strconv.Quote(r.ID))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
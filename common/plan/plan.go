@@ -0,0 +1,347 @@
+// Package plan runs a sample without changing anything on the stamp. The
+// Planner answers the ARM requests of the sample as if they succeeded, without
+// sending them, and keeps them in order as the plan of the run.
+package plan
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/cassette"
+)
+
+// Operation is a request the sample would send.
+type Operation struct {
+	Method     string `json:"method"`
+	ResourceID string `json:"resourceId"`
+	APIVersion string `json:"apiVersion,omitempty"`
+	// Body is the request body with the secrets masked.
+	Body json.RawMessage `json:"body,omitempty"`
+}
+
+func (op Operation) String() string {
+	s := op.Method + " " + op.ResourceID
+	if op.APIVersion != "" {
+		s += " (api-version " + op.APIVersion + ")"
+	}
+	return s
+}
+
+// Planner is a policy.Transporter that records every request and answers it
+// from the resources it was asked to create so far: a PUT succeeds right
+// away, a GET returns what an earlier PUT stored, a DELETE removes it again
+// and the actions the samples call return plausible results.
+type Planner struct {
+	mu         sync.Mutex
+	operations []Operation
+	resources  map[string]map[string]interface{}
+	order      []string
+}
+
+// New creates a Planner with an empty plan.
+func New() *Planner {
+	return &Planner{resources: map[string]map[string]interface{}{}}
+}
+
+// Operations returns the planned requests in the order they were made.
+func (p *Planner) Operations() []Operation {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]Operation(nil), p.operations...)
+}
+
+func (p *Planner) Do(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	op := Operation{Method: req.Method, ResourceID: req.URL.Path, APIVersion: req.URL.Query().Get("api-version")}
+	if len(body) > 0 {
+		op.Body = mask(body)
+	}
+	p.operations = append(p.operations, op)
+
+	switch req.Method {
+	case http.MethodPut, http.MethodPatch:
+		return p.put(req, body)
+	case http.MethodDelete:
+		p.delete(req.URL.Path)
+		return respond(req, http.StatusOK, nil)
+	case http.MethodPost:
+		return action(req)
+	case http.MethodHead:
+		if _, ok := p.resources[resourceKey(req.URL.Path)]; ok {
+			return respond(req, http.StatusNoContent, nil)
+		}
+		return respond(req, http.StatusNotFound, nil)
+	}
+	return p.get(req)
+}
+
+// mask returns body with the secrets redacted. Bodies that are not JSON are
+// not shown, since they cannot be scrubbed.
+func mask(body []byte) json.RawMessage {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return json.RawMessage(`"(not shown)"`)
+	}
+	cassette.ScrubJSON(v)
+	masked, err := json.Marshal(v)
+	if err != nil {
+		return json.RawMessage(`"(not shown)"`)
+	}
+	return masked
+}
+
+// put stores the resource with the ID and the succeeded provisioning state
+// ARM would add, also to the named objects of its properties such as the
+// subnets of a virtual network.
+func (p *Planner) put(req *http.Request, body []byte) (*http.Response, error) {
+	id := req.URL.Path
+	resource := map[string]interface{}{}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &resource); err != nil {
+			return respondError(req, http.StatusBadRequest, "InvalidRequestContent", err.Error())
+		}
+	}
+	resource["id"] = id
+	resource["name"] = id[strings.LastIndex(id, "/")+1:]
+	properties, ok := resource["properties"].(map[string]interface{})
+	if !ok {
+		properties = map[string]interface{}{}
+		resource["properties"] = properties
+	}
+	properties["provisioningState"] = "Succeeded"
+	for collection, value := range properties {
+		items, _ := value.([]interface{})
+		for _, item := range items {
+			if child, ok := item.(map[string]interface{}); ok {
+				if name, ok := child["name"].(string); ok && child["id"] == nil {
+					child["id"] = id + "/" + collection + "/" + name
+				}
+			}
+		}
+	}
+	key := resourceKey(id)
+	if _, exists := p.resources[key]; !exists {
+		p.order = append(p.order, key)
+	}
+	p.resources[key] = resource
+	return respond(req, http.StatusOK, resource)
+}
+
+func (p *Planner) delete(id string) {
+	prefix := resourceKey(id)
+	kept := p.order[:0]
+	for _, key := range p.order {
+		if key == prefix || strings.HasPrefix(key, prefix+"/") {
+			delete(p.resources, key)
+			continue
+		}
+		kept = append(kept, key)
+	}
+	p.order = kept
+}
+
+// get returns a stored resource, a named object inside one, or the stored
+// resources of a collection.
+func (p *Planner) get(req *http.Request) (*http.Response, error) {
+	path := req.URL.Path
+	if resource, ok := p.resources[resourceKey(path)]; ok {
+		return respond(req, http.StatusOK, resource)
+	}
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(segments)%2 == 1 {
+		return respond(req, http.StatusOK, map[string]interface{}{"value": p.list(req, segments)})
+	}
+	if len(segments) > 4 {
+		parent := p.resources[resourceKey(strings.Join(segments[:len(segments)-2], "/"))]
+		if child := childOf(parent, segments[len(segments)-2], segments[len(segments)-1]); child != nil {
+			return respond(req, http.StatusOK, child)
+		}
+	}
+	if len(segments) == 4 {
+		return respondError(req, http.StatusNotFound, "ResourceGroupNotFound", fmt.Sprintf("Resource group '%s' could not be found.", segments[3]))
+	}
+	return respondError(req, http.StatusNotFound, "ResourceNotFound", fmt.Sprintf("The resource '%s' was not found.", path))
+}
+
+// resourceKey is the key of the resource at path in Planner.resources.
+func resourceKey(path string) string {
+	return strings.ToLower(strings.Trim(path, "/"))
+}
+
+func childOf(parent map[string]interface{}, collection, name string) map[string]interface{} {
+	properties, _ := parent["properties"].(map[string]interface{})
+	items, _ := properties[collection].([]interface{})
+	for _, item := range items {
+		if child, ok := item.(map[string]interface{}); ok && strings.EqualFold(fmt.Sprint(child["name"]), name) {
+			return child
+		}
+	}
+	return nil
+}
+
+// list returns the stored resources of the collection segments, in creation
+// order. Generic /resources lists honour a resourceType filter, and provider
+// lists at the subscription level cover every resource group.
+func (p *Planner) list(req *http.Request, segments []string) []interface{} {
+	path := strings.ToLower(strings.Join(segments, "/"))
+	// scope is the prefix of the keys listed; without a resource type only
+	// the direct children of path are listed.
+	scope := path
+	var resourceType string
+	switch {
+	case strings.EqualFold(segments[len(segments)-1], "resources"):
+		scope = strings.ToLower(strings.Join(segments[:len(segments)-1], "/"))
+		if _, t, ok := strings.Cut(req.URL.Query().Get("$filter"), "resourceType eq "); ok {
+			resourceType = strings.Trim(t, "' ")
+		} else {
+			resourceType = "*"
+		}
+	case len(segments) == 5 && strings.EqualFold(segments[2], "providers"):
+		scope = strings.ToLower(strings.Join(segments[:2], "/"))
+		resourceType = segments[3] + "/" + segments[4]
+	}
+
+	items := []interface{}{}
+	for _, key := range p.order {
+		if !strings.HasPrefix(key, scope+"/") {
+			continue
+		}
+		if resourceType == "" {
+			if key[:strings.LastIndex(key, "/")] != path {
+				continue
+			}
+		} else if id, err := arm.ParseResourceID("/" + key); err != nil || (resourceType != "*" && !strings.EqualFold(id.ResourceType.String(), resourceType)) {
+			continue
+		}
+		items = append(items, p.resources[key])
+	}
+	return items
+}
+
+// action answers the POST actions of the samples.
+func action(req *http.Request) (*http.Response, error) {
+	path := strings.ToLower(req.URL.Path)
+	switch path[strings.LastIndex(path, "/")+1:] {
+	case "checknameavailability":
+		return respond(req, http.StatusOK, map[string]interface{}{"nameAvailable": true})
+	case "listkeys", "regeneratekey":
+		keys := []interface{}{}
+		for _, name := range []string{"key1", "key2"} {
+			keys = append(keys, map[string]interface{}{"keyName": name, "value": cassette.Redacted, "permissions": "FULL"})
+		}
+		return respond(req, http.StatusOK, map[string]interface{}{"keys": keys})
+	}
+	return respond(req, http.StatusOK, map[string]interface{}{})
+}
+
+func respond(req *http.Request, status int, v interface{}) (*http.Response, error) {
+	resp := &http.Response{
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode: status,
+		Header:     http.Header{},
+		Body:       http.NoBody,
+		Request:    req,
+	}
+	if v != nil {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		resp.Header.Set("Content-Type", "application/json; charset=utf-8")
+		resp.Body = io.NopCloser(bytes.NewReader(data))
+		resp.ContentLength = int64(len(data))
+	}
+	return resp, nil
+}
+
+func respondError(req *http.Request, status int, code, message string) (*http.Response, error) {
+	return respond(req, status, map[string]interface{}{
+		"error": map[string]string{"code": code, "message": message},
+	})
+}
+
+// Credential stands in for the credential of the sample during a dry run. It
+// hands out a placeholder token without signing in, so a dry run needs no
+// access to the stamp beyond reading its metadata endpoint.
+type Credential struct{}
+
+func (Credential) GetToken(ctx context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: "dry-run", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+// Flags holds the command line flags that select a dry run.
+type Flags struct {
+	enabled bool
+	file    string
+}
+
+// RegisterFlags defines the dry run flags on fs.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{}
+	fs.BoolVar(&f.enabled, "dry-run", false, "print the ARM requests the sample would send instead of sending them")
+	fs.StringVar(&f.file, "planFile", "", "with -dry-run, also write the planned requests as JSON to this file")
+	return f
+}
+
+// Enabled reports whether -dry-run was given.
+func (f *Flags) Enabled() bool {
+	return f.enabled
+}
+
+// Take reports whether -dry-run was given and turns the dry run off, for
+// commands that read from the stamp even in a dry run and skip their writes
+// themselves.
+func (f *Flags) Take() bool {
+	enabled := f.enabled
+	f.enabled = false
+	return enabled
+}
+
+// Report prints the plan of p to out and writes it to -planFile if set.
+func (f *Flags) Report(p *Planner, out io.Writer) error {
+	operations := p.Operations()
+	fmt.Fprintf(out, "Dry run, nothing was sent to the stamp. The sample would send %d requests:\n", len(operations))
+	for i, op := range operations {
+		fmt.Fprintf(out, "%3d. %s\n", i+1, op)
+		if len(op.Body) > 0 {
+			fmt.Fprintf(out, "     %s\n", op.Body)
+		}
+	}
+	if f.file == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(struct {
+		Operations []Operation `json:"operations"`
+	}{operations}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(f.file, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write the plan to %s: %w", f.file, err)
+	}
+	fmt.Fprintf(out, "Plan written to %s\n", f.file)
+	return nil
+}
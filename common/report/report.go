@@ -0,0 +1,470 @@
+// Package report records the steps of a sample run, with their outcome,
+// duration and the resources they touched, and writes them as JSON and JUnit
+// XML for CI.
+package report
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/lro"
+)
+
+// Status is the outcome of a step or of a whole run.
+type Status string
+
+const (
+	Passed Status = "passed"
+	Failed Status = "failed"
+	// Skipped is the status of a step an earlier run with the same run ID
+	// completed, which a resumed run does not repeat.
+	Skipped Status = "skipped"
+)
+
+// Identity providers of a stamp.
+const (
+	AAD  = "AAD"
+	ADFS = "ADFS"
+)
+
+// Report is the outcome of a sample run on a stamp.
+type Report struct {
+	Sample           string    `json:"sample"`
+	Stamp            string    `json:"stamp"`
+	IdentityProvider string    `json:"identityProvider,omitempty"`
+	RunID            string    `json:"runId,omitempty"`
+	Status           Status    `json:"status"`
+	ExitCode         int       `json:"exitCode"`
+	Started          time.Time `json:"started"`
+	Duration         Duration  `json:"duration"`
+	Steps            []Step    `json:"steps"`
+}
+
+// Step is a step of a run, such as creating the resource group.
+type Step struct {
+	Name     string    `json:"name"`
+	Status   Status    `json:"status"`
+	Started  time.Time `json:"started"`
+	Duration Duration  `json:"duration"`
+	// ResourceIDs are the resources the step sent requests for, in the order
+	// of their first request.
+	ResourceIDs []string     `json:"resourceIds"`
+	Error       *ErrorDetail `json:"error,omitempty"`
+}
+
+// ErrorDetail describes why a step failed. Code and StatusCode are set when
+// the stamp answered with an error.
+type ErrorDetail struct {
+	Message    string `json:"message"`
+	Code       string `json:"code,omitempty"`
+	StatusCode int    `json:"statusCode,omitempty"`
+	TimedOut   bool   `json:"timedOut,omitempty"`
+}
+
+// Duration is a time.Duration written in seconds.
+type Duration time.Duration
+
+func (d Duration) Seconds() float64 {
+	return time.Duration(d).Seconds()
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.Seconds())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var seconds float64
+	if err := json.Unmarshal(data, &seconds); err != nil {
+		return err
+	}
+	*d = Duration(seconds * float64(time.Second))
+	return nil
+}
+
+// Checkpoints keep the steps a run completed, so that a resumed run can skip
+// them.
+type Checkpoints interface {
+	// Skip reports whether the step name was completed by the run that is
+	// resumed and, if so, restores the result of the step into result.
+	Skip(name string, result interface{}) bool
+	// Complete records the completion of the step name, which sent requests
+	// for resourceIDs and whose result is result.
+	Complete(name string, resourceIDs []string, result interface{})
+}
+
+// Recorder collects the steps of a run. Its methods may be called on a nil
+// Recorder, which runs the steps without recording them.
+type Recorder struct {
+	mu          sync.Mutex
+	report      Report
+	current     *Step
+	checkpoints Checkpoints
+}
+
+// New creates a Recorder for a run of sample against the stamp whose Azure
+// Resource Manager is at stamp.
+func New(sample, stamp string) *Recorder {
+	return &Recorder{report: Report{Sample: sample, Stamp: stamp, Started: time.Now()}}
+}
+
+// SetIdentityProvider records the identity provider of the stamp, AAD or
+// ADFS.
+func (r *Recorder) SetIdentityProvider(provider string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.report.IdentityProvider = provider
+}
+
+// SetRunID records the ID of the run, which is part of the names of the
+// resources it creates.
+func (r *Recorder) SetRunID(id string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.report.RunID = id
+}
+
+// SetCheckpoints makes the steps that follow skip those c reports completed
+// and record their completion in c. A nil c turns the checkpoints off.
+func (r *Recorder) SetCheckpoints(c Checkpoints) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checkpoints = c
+}
+
+// Step runs fn as the step name and records its outcome. It returns the error
+// of fn.
+func (r *Recorder) Step(name string, fn func() error) error {
+	return r.StepWith(name, nil, fn)
+}
+
+// StepWith runs fn as the step name like Step. result points to the value fn
+// sets for the steps that follow, which is checkpointed with the step and
+// restored when a resumed run skips it.
+func (r *Recorder) StepWith(name string, result interface{}, fn func() error) error {
+	if r == nil {
+		return fn()
+	}
+	r.mu.Lock()
+	checkpoints := r.checkpoints
+	r.mu.Unlock()
+	if checkpoints != nil && checkpoints.Skip(name, result) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.report.Steps = append(r.report.Steps, Step{Name: name, Status: Skipped, Started: time.Now(), ResourceIDs: []string{}})
+		return nil
+	}
+
+	r.mu.Lock()
+	r.current = &Step{Name: name, Started: time.Now(), ResourceIDs: []string{}}
+	r.mu.Unlock()
+
+	err := fn()
+
+	r.mu.Lock()
+	step := r.current
+	r.current = nil
+	step.Duration = Duration(time.Since(step.Started))
+	step.Status = Passed
+	if err != nil {
+		step.Status = Failed
+		step.Error = detail(err)
+	}
+	r.report.Steps = append(r.report.Steps, *step)
+	r.mu.Unlock()
+	if err == nil && checkpoints != nil {
+		checkpoints.Complete(name, step.ResourceIDs, result)
+	}
+	return err
+}
+
+// Record records a step that ran outside of Step, such as one of several
+// steps that ran at the same time, whose requests Step cannot tell apart.
+// resourceIDs are the resources the step sent requests for.
+func (r *Recorder) Record(name string, started time.Time, resourceIDs []string, err error) {
+	if r == nil {
+		return
+	}
+	step := Step{Name: name, Status: Passed, Started: started, Duration: Duration(time.Since(started)), ResourceIDs: append([]string{}, resourceIDs...)}
+	if err != nil {
+		step.Status = Failed
+		step.Error = detail(err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.report.Steps = append(r.report.Steps, step)
+}
+
+func detail(err error) *ErrorDetail {
+	d := &ErrorDetail{Message: err.Error()}
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) {
+		d.Code = respErr.ErrorCode
+		d.StatusCode = respErr.StatusCode
+	}
+	var lroErr *lro.Error
+	if errors.As(err, &lroErr) {
+		d.TimedOut = lroErr.TimedOut
+	}
+	return d
+}
+
+// Configure adds a policy to o that records the resources of the requests
+// sent during a step. A POST counts for the resource whose action it calls.
+func (r *Recorder) Configure(o *policy.ClientOptions) {
+	if r == nil {
+		return
+	}
+	o.PerCallPolicies = append(o.PerCallPolicies, policyFunc(r.record))
+}
+
+type policyFunc func(*policy.Request) (*http.Response, error)
+
+func (pf policyFunc) Do(req *policy.Request) (*http.Response, error) {
+	return pf(req)
+}
+
+func (r *Recorder) record(req *policy.Request) (*http.Response, error) {
+	raw := req.Raw()
+	path := strings.TrimSuffix(raw.URL.Path, "/")
+	if raw.Method == http.MethodPost {
+		path = path[:strings.LastIndex(path, "/")]
+	}
+	if id := resourceID(path); id != "" {
+		r.mu.Lock()
+		if r.current != nil && !contains(r.current.ResourceIDs, id) {
+			r.current.ResourceIDs = append(r.current.ResourceIDs, id)
+		}
+		r.mu.Unlock()
+	}
+	return req.Next()
+}
+
+// resourceID returns path if it is the ID of a resource group or of a
+// resource in one, and "" for collections, subscription-level paths and
+// operation status URLs.
+func resourceID(path string) string {
+	if strings.Count(path, "/")%2 == 1 {
+		return ""
+	}
+	id, err := arm.ParseResourceID(path)
+	if err != nil || id.ResourceGroupName == "" {
+		return ""
+	}
+	if types := id.ResourceType.Types; len(types) > 0 && strings.EqualFold(types[len(types)-1], "operations") {
+		return ""
+	}
+	return path
+}
+
+func contains(ids []string, id string) bool {
+	for _, existing := range ids {
+		if strings.EqualFold(existing, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// Report returns the report of the steps recorded so far for a run ending
+// with exitCode. The run failed if it exits with an error, which includes
+// failures outside of any step, or if any of its steps failed.
+func (r *Recorder) Report(exitCode int) Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	report := r.report
+	report.Steps = append([]Step(nil), r.report.Steps...)
+	report.ExitCode = exitCode
+	report.Duration = Duration(time.Since(report.Started))
+	report.Status = Passed
+	if exitCode != 0 {
+		report.Status = Failed
+	}
+	for _, step := range report.Steps {
+		if step.Status == Failed {
+			report.Status = Failed
+		}
+	}
+	return report
+}
+
+// WriteJSON writes report as indented JSON.
+func WriteJSON(w io.Writer, report Report) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Skipped    int             `xml:"skipped,attr,omitempty"`
+	Time       string          `xml:"time,attr"`
+	Timestamp  string          `xml:"timestamp,attr"`
+	Properties []junitProperty `xml:"properties>property"`
+	Cases      []junitCase     `xml:"testcase"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit writes report as a JUnit XML test suite with a test case per
+// step. The suite is named after the sample, the identity provider and the
+// stamp, so that dashboards collecting the reports of several stamps tell
+// them apart.
+func WriteJUnit(w io.Writer, report Report) error {
+	provider := report.IdentityProvider
+	if provider == "" {
+		provider = "unknown"
+	}
+	suite := junitSuite{
+		Name:      fmt.Sprintf("%s (%s, %s)", report.Sample, provider, report.Stamp),
+		Tests:     len(report.Steps),
+		Time:      seconds(report.Duration),
+		Timestamp: report.Started.UTC().Format("2006-01-02T15:04:05"),
+		Properties: []junitProperty{
+			{Name: "sample", Value: report.Sample},
+			{Name: "stamp", Value: report.Stamp},
+			{Name: "identityProvider", Value: provider},
+		},
+	}
+	for _, step := range report.Steps {
+		c := junitCase{
+			Name:      step.Name,
+			ClassName: report.Sample + "." + provider,
+			Time:      seconds(step.Duration),
+		}
+		if len(step.ResourceIDs) > 0 {
+			c.SystemOut = strings.Join(step.ResourceIDs, "\n")
+		}
+		if step.Status == Skipped {
+			suite.Skipped++
+			c.Skipped = &junitSkipped{Message: "completed by the resumed run"}
+		}
+		if step.Error != nil {
+			suite.Failures++
+			c.Failure = &junitFailure{Message: step.Error.Message, Type: step.Error.Code, Text: step.Error.Message}
+		}
+		suite.Cases = append(suite.Cases, c)
+	}
+	if report.Status == Failed && suite.Failures == 0 {
+		// The run failed outside of any step, such as while creating a client.
+		suite.Tests++
+		suite.Failures++
+		message := fmt.Sprintf("the sample exited with code %d", report.ExitCode)
+		suite.Cases = append(suite.Cases, junitCase{
+			Name:      "run",
+			ClassName: report.Sample + "." + provider,
+			Time:      seconds(report.Duration),
+			Failure:   &junitFailure{Message: message, Text: message},
+		})
+	}
+	data, err := xml.MarshalIndent(junitTestSuites{Suites: []junitSuite{suite}}, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s%s\n", xml.Header, data)
+	return err
+}
+
+func seconds(d Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// Flags holds the command line flags that select the report files.
+type Flags struct {
+	jsonPath  string
+	junitPath string
+}
+
+// RegisterFlags defines the report flags on fs.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{}
+	fs.StringVar(&f.jsonPath, "report", "", "write a JSON report of the steps of the run to this file")
+	fs.StringVar(&f.junitPath, "junit", "", "write a JUnit XML report of the steps of the run to this file")
+	return f
+}
+
+// Write writes the report of r, for a run ending with exitCode, to the files
+// selected by the flags. A report that cannot be written is reported to out,
+// without failing the run.
+func (f *Flags) Write(r *Recorder, exitCode int, out io.Writer) {
+	if r == nil || (f.jsonPath == "" && f.junitPath == "") {
+		return
+	}
+	report := r.Report(exitCode)
+	for _, file := range []struct {
+		path  string
+		write func(io.Writer, Report) error
+	}{{f.jsonPath, WriteJSON}, {f.junitPath, WriteJUnit}} {
+		if file.path == "" {
+			continue
+		}
+		if err := writeFile(file.path, report, file.write); err != nil {
+			fmt.Fprintf(out, "Warning: failed to write the report to %s: %s\n", file.path, err)
+		}
+	}
+}
+
+func writeFile(path string, report Report, write func(io.Writer, Report) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f, report); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
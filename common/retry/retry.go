@@ -0,0 +1,147 @@
+// Package retry makes the retry behavior of the sample clients configurable and
+// keeps track of how often Azure Stack Hub throttled or failed a request.
+package retry
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// Default values used by azcore when a setting is left at zero.
+const (
+	DefaultMaxRetries    = 3
+	DefaultRetryDelay    = 800 * time.Millisecond
+	DefaultMaxRetryDelay = 60 * time.Second
+)
+
+// DefaultStatusCodes are the HTTP status codes azcore retries by default.
+var DefaultStatusCodes = []int{
+	http.StatusRequestTimeout,
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// Duration is a time.Duration written as a string such as "30s" in JSON.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"30s\": %s", data)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Config is the "retry" section of the configuration file. Settings left at
+// zero keep the azcore defaults; a negative MaxRetries disables retries.
+type Config struct {
+	MaxRetries    int32
+	RetryDelay    Duration
+	MaxRetryDelay Duration
+	StatusCodes   []int
+	TryTimeout    Duration
+}
+
+// Options returns the azcore retry options for c with every default filled in,
+// so the values reported by a Tracker match what the pipeline does.
+func (c Config) Options() policy.RetryOptions {
+	o := policy.RetryOptions{
+		MaxRetries:    c.MaxRetries,
+		RetryDelay:    time.Duration(c.RetryDelay),
+		MaxRetryDelay: time.Duration(c.MaxRetryDelay),
+		StatusCodes:   c.StatusCodes,
+		TryTimeout:    time.Duration(c.TryTimeout),
+	}
+	if o.MaxRetries == 0 {
+		o.MaxRetries = DefaultMaxRetries
+	} else if o.MaxRetries < 0 {
+		o.MaxRetries = -1
+	}
+	if o.RetryDelay == 0 {
+		o.RetryDelay = DefaultRetryDelay
+	}
+	if o.MaxRetryDelay == 0 {
+		o.MaxRetryDelay = DefaultMaxRetryDelay
+	}
+	if o.StatusCodes == nil {
+		o.StatusCodes = append([]int(nil), DefaultStatusCodes...)
+	}
+	return o
+}
+
+// Flags holds the retry command line flags. Flags given on the command line
+// override the values read from the configuration file.
+type Flags struct {
+	fs            *flag.FlagSet
+	maxRetries    int
+	retryDelay    time.Duration
+	maxRetryDelay time.Duration
+	statusCodes   string
+	tryTimeout    time.Duration
+}
+
+// RegisterFlags defines the retry flags on fs.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{fs: fs}
+	fs.IntVar(&f.maxRetries, "retries", 0, "maximum number of retries per request, -1 disables retries (default 3)")
+	fs.DurationVar(&f.retryDelay, "retryDelay", 0, "initial delay between retries (default 800ms)")
+	fs.DurationVar(&f.maxRetryDelay, "maxRetryDelay", 0, "maximum delay between retries, longer Retry-After values are not retried (default 60s)")
+	fs.StringVar(&f.statusCodes, "retryStatusCodes", "", "comma separated HTTP status codes to retry (default 408,429,500,502,503,504)")
+	fs.DurationVar(&f.tryTimeout, "tryTimeout", 0, "timeout for a single try of a request (default none)")
+	return f
+}
+
+// Apply returns c with the flags that were set on the command line applied.
+func (f *Flags) Apply(c Config) (Config, error) {
+	var err error
+	f.fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "retries":
+			c.MaxRetries = int32(f.maxRetries)
+		case "retryDelay":
+			c.RetryDelay = Duration(f.retryDelay)
+		case "maxRetryDelay":
+			c.MaxRetryDelay = Duration(f.maxRetryDelay)
+		case "retryStatusCodes":
+			c.StatusCodes, err = parseStatusCodes(f.statusCodes)
+		case "tryTimeout":
+			c.TryTimeout = Duration(f.tryTimeout)
+		}
+	})
+	return c, err
+}
+
+func parseStatusCodes(s string) ([]int, error) {
+	codes := []int{}
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		code, err := strconv.Atoi(field)
+		if err != nil || code < 100 || code > 599 {
+			return nil, fmt.Errorf("invalid retry status code %q", field)
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
@@ -0,0 +1,68 @@
+package retry
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOptions(t *testing.T) {
+	o := Config{}.Options()
+	if o.MaxRetries != DefaultMaxRetries || o.RetryDelay != DefaultRetryDelay || o.MaxRetryDelay != DefaultMaxRetryDelay || o.TryTimeout != 0 {
+		t.Errorf("defaults %+v", o)
+	}
+	if !reflect.DeepEqual(o.StatusCodes, DefaultStatusCodes) {
+		t.Errorf("default status codes %v, want %v", o.StatusCodes, DefaultStatusCodes)
+	}
+	// The defaults are a copy, which the options of a client may change.
+	o.StatusCodes[0] = 0
+	if DefaultStatusCodes[0] == 0 {
+		t.Error("the options share the default status codes")
+	}
+
+	o = Config{MaxRetries: -5, RetryDelay: Duration(time.Second), MaxRetryDelay: Duration(time.Minute), StatusCodes: []int{}, TryTimeout: Duration(time.Second)}.Options()
+	if o.MaxRetries != -1 || o.RetryDelay != time.Second || o.MaxRetryDelay != time.Minute || o.TryTimeout != time.Second {
+		t.Errorf("options %+v", o)
+	}
+	if len(o.StatusCodes) != 0 {
+		t.Errorf("an empty list of status codes was replaced by %v", o.StatusCodes)
+	}
+}
+
+func TestParseStatusCodes(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []int
+		wantErr bool
+	}{
+		{"429", []int{429}, false},
+		{" 429, 503 ,", []int{429, 503}, false},
+		{"", []int{}, false},
+		{"429,abc", nil, true},
+		{"99", nil, true},
+		{"600", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseStatusCodes(tt.in)
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseStatusCodes(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestFlagsApply(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	f := RegisterFlags(fs)
+	if err := fs.Parse([]string{"-retries", "5", "-retryStatusCodes", "503"}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := f.Apply(Config{MaxRetries: 1, RetryDelay: Duration(time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Config{MaxRetries: 5, RetryDelay: Duration(time.Second), StatusCodes: []int{503}}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("applied %+v, want %+v", c, want)
+	}
+}
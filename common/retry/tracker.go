@@ -0,0 +1,217 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// Stats counts the requests, retries and throttled responses of one operation.
+type Stats struct {
+	Requests  int
+	Retries   int
+	Throttles int
+}
+
+// Tracker logs every retry made by the clients it is configured on and keeps
+// per operation statistics for the end of run summary. An operation is the
+// HTTP method and ARM resource type of a request, for example
+// "PUT Microsoft.Storage/storageAccounts".
+type Tracker struct {
+	out io.Writer
+	mu  sync.Mutex
+	ops map[string]*Stats
+}
+
+// NewTracker creates a Tracker that logs retries to out.
+func NewTracker(out io.Writer) *Tracker {
+	return &Tracker{out: out, ops: map[string]*Stats{}}
+}
+
+// Configure sets the retry options of o from c and adds the policies that
+// report each retry to t.
+func (t *Tracker) Configure(o *policy.ClientOptions, c Config) {
+	opts := c.Options()
+	o.Retry = opts
+	o.PerCallPolicies = append(o.PerCallPolicies, policyFunc(t.startCall))
+	o.PerRetryPolicies = append(o.PerRetryPolicies, &tryPolicy{t: t, opts: opts})
+}
+
+// Stats returns a copy of the statistics collected so far, keyed by operation.
+func (t *Tracker) Stats() map[string]Stats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	stats := make(map[string]Stats, len(t.ops))
+	for op, s := range t.ops {
+		stats[op] = *s
+	}
+	return stats
+}
+
+// PrintSummary writes the number of retries and throttled responses of every
+// operation that needed at least one retry.
+func (t *Tracker) PrintSummary() {
+	stats := t.Stats()
+	ops := make([]string, 0, len(stats))
+	for op, s := range stats {
+		if s.Retries > 0 || s.Throttles > 0 {
+			ops = append(ops, op)
+		}
+	}
+	if len(ops) == 0 {
+		fmt.Fprintln(t.out, "Retry summary: no requests were retried or throttled")
+		return
+	}
+	sort.Strings(ops)
+	fmt.Fprintln(t.out, "Retry summary:")
+	for _, op := range ops {
+		s := stats[op]
+		fmt.Fprintf(t.out, "  %s: %d requests, %d retries, %d throttled\n", op, s.Requests, s.Retries, s.Throttles)
+	}
+}
+
+type policyFunc func(*policy.Request) (*http.Response, error)
+
+func (pf policyFunc) Do(req *policy.Request) (*http.Response, error) {
+	return pf(req)
+}
+
+// call is shared by all tries of a single request.
+type call struct {
+	op    string
+	tries int
+	// ctx is the context of the request, on which azcore stops retrying,
+	// rather than the context of a try, which -tryTimeout ends.
+	ctx context.Context
+}
+
+func (t *Tracker) startCall(req *policy.Request) (*http.Response, error) {
+	req.SetOperationValue(&call{op: operationName(req.Raw()), ctx: req.Raw().Context()})
+	return req.Next()
+}
+
+// tryPolicy runs once per try, after the azcore retry policy.
+type tryPolicy struct {
+	t    *Tracker
+	opts policy.RetryOptions
+}
+
+func (p *tryPolicy) Do(req *policy.Request) (*http.Response, error) {
+	var c *call
+	if !req.OperationValue(&c) {
+		return req.Next()
+	}
+	c.tries++
+	resp, err := req.Next()
+	p.t.record(c, p.opts, resp, err)
+	return resp, err
+}
+
+func (t *Tracker) record(c *call, opts policy.RetryOptions, resp *http.Response, err error) {
+	t.mu.Lock()
+	s, ok := t.ops[c.op]
+	if !ok {
+		s = &Stats{}
+		t.ops[c.op] = s
+	}
+	if c.tries == 1 {
+		s.Requests++
+	}
+	throttled := resp != nil && resp.StatusCode == http.StatusTooManyRequests
+	if throttled {
+		s.Throttles++
+	}
+	t.mu.Unlock()
+
+	if !retriable(opts, resp, err) || c.ctx.Err() != nil {
+		return
+	}
+	maxRetries := int(opts.MaxRetries)
+	if maxRetries < 0 {
+		maxRetries = 0
+	}
+	reason := "error: " + fmt.Sprint(err)
+	if resp != nil {
+		reason = resp.Status
+	}
+	if c.tries > maxRetries {
+		fmt.Fprintf(t.out, "Giving up on %s after %d tries, last response %s\n", c.op, c.tries, reason)
+		return
+	}
+	wait := "exponential backoff"
+	if after := After(resp); after > opts.MaxRetryDelay {
+		fmt.Fprintf(t.out, "Giving up on %s: Retry-After of %s exceeds the maximum retry delay of %s\n", c.op, after, opts.MaxRetryDelay)
+		return
+	} else if after > 0 {
+		wait = "Retry-After " + after.String()
+	}
+
+	t.mu.Lock()
+	s.Retries++
+	t.mu.Unlock()
+	fmt.Fprintf(t.out, "Retrying %s (retry %d of %d) after %s, waiting for %s\n", c.op, c.tries, maxRetries, reason, wait)
+}
+
+func retriable(opts policy.RetryOptions, resp *http.Response, err error) bool {
+	if err != nil {
+		var nre interface{ NonRetriable() }
+		return !errors.As(err, &nre)
+	}
+	for _, code := range opts.StatusCodes {
+		if resp.StatusCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+// After returns the delay the Retry-After header of resp asks for, in
+// seconds or as a date, or 0 when it asks for none. It mirrors how azcore
+// reads the header.
+func After(resp *http.Response) time.Duration {
+	if resp == nil {
+		return 0
+	}
+	ra := resp.Header.Get("Retry-After")
+	if ra == "" {
+		return 0
+	}
+	if secs, _ := strconv.Atoi(ra); secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if at, err := http.ParseTime(ra); err == nil {
+		return time.Until(at)
+	}
+	return 0
+}
+
+// operationName describes a request by its method and resource type. ARM paths
+// alternate between collection names and resource names, so the collection
+// names are kept and a "providers" segment restarts the type at its namespace.
+func operationName(r *http.Request) string {
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(segments) == 0 || !strings.EqualFold(segments[0], "subscriptions") {
+		return r.Method + " " + r.URL.Host + r.URL.Path
+	}
+	var parts []string
+	for i := 0; i < len(segments); i += 2 {
+		if strings.EqualFold(segments[i], "providers") && i+1 < len(segments) {
+			parts = []string{segments[i+1]}
+			continue
+		}
+		parts = append(parts, segments[i])
+	}
+	if len(parts) > 1 && strings.EqualFold(parts[0], "subscriptions") {
+		parts = parts[1:]
+	}
+	return r.Method + " " + strings.Join(parts, "/")
+}
@@ -0,0 +1,132 @@
+package retry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+)
+
+func TestOperationName(t *testing.T) {
+	tests := []struct {
+		method, url, want string
+	}{
+		{"GET", "https://arm/subscriptions/s/resourcegroups/g", "GET resourcegroups"},
+		{"PUT", "https://arm/subscriptions/s/resourceGroups/g/providers/Microsoft.Storage/storageAccounts/a", "PUT Microsoft.Storage/storageAccounts"},
+		{"GET", "https://arm/subscriptions/s/resourceGroups/g/providers/Microsoft.Network/virtualNetworks/v/subnets/s", "GET Microsoft.Network/virtualNetworks/subnets"},
+		{"POST", "https://arm/subscriptions/s/providers/Microsoft.Storage/checkNameAvailability", "POST Microsoft.Storage/checkNameAvailability"},
+		{"GET", "https://arm/metadata/endpoints", "GET arm/metadata/endpoints"},
+	}
+	for _, tt := range tests {
+		r, err := http.NewRequest(tt.method, tt.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := operationName(r); got != tt.want {
+			t.Errorf("operationName(%s %s) = %q, want %q", tt.method, tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestAfter(t *testing.T) {
+	tests := []struct {
+		header string
+		min    time.Duration
+		max    time.Duration
+	}{
+		{"", 0, 0},
+		{"5", 5 * time.Second, 5 * time.Second},
+		{"0", 0, 0},
+		{"soon", 0, 0},
+		{time.Now().Add(time.Minute).UTC().Format(http.TimeFormat), 58 * time.Second, time.Minute},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{Header: http.Header{}}
+		if tt.header != "" {
+			resp.Header.Set("Retry-After", tt.header)
+		}
+		if got := After(resp); got < tt.min || got > tt.max {
+			t.Errorf("After(Retry-After: %q) = %s, want %s to %s", tt.header, got, tt.min, tt.max)
+		}
+	}
+	if got := After(nil); got != 0 {
+		t.Errorf("After(nil) = %s", got)
+	}
+}
+
+// send sends a GET to handler through a pipeline configured by a Tracker
+// with c and returns what the Tracker logged and counted.
+func send(t *testing.T, c Config, handler http.HandlerFunc) (string, Stats) {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	var out strings.Builder
+	tracker := NewTracker(&out)
+	var o policy.ClientOptions
+	tracker.Configure(&o, c)
+	pl := runtime.NewPipeline("retry", "v1.0.0", runtime.PipelineOptions{}, &o)
+	req, err := runtime.NewRequest(context.Background(), http.MethodGet, server.URL+"/subscriptions/s/resourcegroups/g")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := pl.Do(req); err == nil {
+		resp.Body.Close()
+	}
+	return out.String(), tracker.Stats()["GET resourcegroups"]
+}
+
+func TestTrackerRetries(t *testing.T) {
+	var n int32
+	out, stats := send(t, Config{RetryDelay: Duration(time.Millisecond)}, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&n, 1) < 3 {
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	})
+	if stats != (Stats{Requests: 1, Retries: 2, Throttles: 2}) {
+		t.Errorf("stats %+v", stats)
+	}
+	if !strings.Contains(out, "Retrying GET resourcegroups (retry 2 of 3) after 429 Too Many Requests, waiting for exponential backoff") {
+		t.Errorf("output:\n%s", out)
+	}
+}
+
+func TestTrackerRetryAfterTooLong(t *testing.T) {
+	var n int32
+	out, stats := send(t, Config{MaxRetryDelay: Duration(time.Second)}, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&n, 1)
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	if n != 1 || stats.Retries != 0 {
+		t.Errorf("%d tries, stats %+v, want no retry", n, stats)
+	}
+	if !strings.Contains(out, "Giving up on GET resourcegroups: Retry-After of 2m0s exceeds the maximum retry delay of 1s") {
+		t.Errorf("output:\n%s", out)
+	}
+}
+
+// TestTrackerTryTimeout checks that a try that -tryTimeout ends is counted
+// as the retry azcore makes of it.
+func TestTrackerTryTimeout(t *testing.T) {
+	var n int32
+	out, stats := send(t, Config{TryTimeout: Duration(50 * time.Millisecond), RetryDelay: Duration(time.Millisecond)}, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&n, 1) == 1 {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+		}
+	})
+	if n != 2 || stats.Retries != 1 {
+		t.Errorf("%d tries, stats %+v, want a retry", n, stats)
+	}
+	if !strings.Contains(out, "Retrying GET resourcegroups (retry 1 of 3) after error:") {
+		t.Errorf("output:\n%s", out)
+	}
+}
@@ -0,0 +1,541 @@
+// Package session connects a sample to a stamp. It reads the configuration
+// files, loads the metadata of the stamp, signs in, sets up the client options
+// shared by every client of the run and, when the run ends, rolls it back as
+// selected by -cleanup and writes its report.
+package session
+
+import (
+	"bufio"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/cassette"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/checkpoint"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/cleanup"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/converge"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/guard"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/lro"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/metadata"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/naming"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/output"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/plan"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/report"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/retry"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/tags"
+)
+
+// The configuration files of the service principal, in the configuration
+// directory or in the directory of a profile.
+const (
+	CertConfigFile   = "azureCertSpConfig.json"
+	SecretConfigFile = "azureSecretSpConfig.json"
+)
+
+// Config is the content of a configuration file.
+type Config struct {
+	ClientId                   string
+	CertPass                   string
+	CertPath                   string
+	ClientSecret               string
+	ObjectId                   string
+	SubscriptionId             string
+	TenantId                   string
+	ResourceManagerEndpointUrl string
+	Location                   string
+	Retry                      retry.Config
+	// Names are name templates by resource type, such as
+	// "storageAccount": "{base}{run}", which the -name flags override.
+	Names map[string]string
+	// Tags are added to the tags of every resource the run creates, before
+	// those of the -tag flags.
+	Tags map[string]string
+	// State is the storage account that keeps the state of the runs with
+	// -stateBackend blob.
+	State checkpoint.BlobConfig
+}
+
+// Flags holds the command line flags shared by the samples.
+type Flags struct {
+	Secret    bool
+	ConfigDir string
+	Profile   string
+	Clean     bool
+	DisableID bool
+	// GroupSuffix is appended to the names of the resource groups of the
+	// run, which keeps concurrent runs on the same stamp apart.
+	GroupSuffix string
+	// RunID is the ID of the run, which the names of its resources include.
+	// It is random unless -runID is given.
+	RunID string
+	// Force deletes resource groups the run does not own, and Yes answers
+	// the confirmations of destructive operations.
+	Force bool
+	Yes   bool
+
+	names          naming.Templates
+	tags           tags.Set
+	ttl            time.Duration
+	retry          *retry.Flags
+	lro            *lro.Flags
+	cassette       *cassette.Flags
+	plan           *plan.Flags
+	report         *report.Flags
+	checkpoint     *checkpoint.Flags
+	cleanupMode    cleanup.Mode
+	cleanupTimeout time.Duration
+	output         output.Format
+}
+
+// RegisterFlags defines the shared flags on fs.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{cleanupMode: cleanup.OnFailure, output: output.Table, names: naming.Templates{}, tags: tags.Set{}}
+	fs.BoolVar(&f.Secret, "secret", false, "use secret config file")
+	fs.StringVar(&f.ConfigDir, "configDir", "..", "directory containing the configuration files")
+	fs.StringVar(&f.Profile, "profile", "", "read the configuration files from this subdirectory of -configDir")
+	fs.BoolVar(&f.Clean, "clean", false, "clean resource groups")
+	fs.BoolVar(&f.DisableID, "disableID", false, "disables instance discovery")
+	fs.StringVar(&f.GroupSuffix, "groupSuffix", "", "append this suffix to the names of the resource groups the run creates")
+	fs.StringVar(&f.RunID, "runID", "", "ID of the run, 1-8 lowercase letters and digits, which the names of its resources include (default random)")
+	fs.BoolVar(&f.Force, "force", false, "delete resource groups the run does not own, after a confirmation")
+	fs.BoolVar(&f.Yes, "yes", false, "do not ask for the confirmation of destructive operations")
+	fs.Var(f.names, "name", "name template of a resource type as type=template, with the placeholders {base} and {run}; may be repeated")
+	fs.Var(f.tags, "tag", "tag every resource the run creates with name=value; may be repeated")
+	fs.DurationVar(&f.ttl, "ttl", 24*time.Hour, "time after which the resources of the run expire, recorded in their tags; 0 for never")
+	f.retry = retry.RegisterFlags(fs)
+	f.lro = lro.RegisterFlags(fs)
+	f.cassette = cassette.RegisterFlags(fs)
+	f.plan = plan.RegisterFlags(fs)
+	f.report = report.RegisterFlags(fs)
+	f.checkpoint = checkpoint.RegisterFlags(fs)
+	fs.Var(&f.cleanupMode, "cleanup", "when to delete the resources created by this run: on-failure, always or never")
+	fs.DurationVar(&f.cleanupTimeout, "cleanupTimeout", 10*time.Minute, "time allowed for deleting the resources created by this run")
+	fs.Var(&f.output, "output", "format of the listings: table, json, yaml or csv")
+	return f
+}
+
+// Dir returns the directory of the configuration files, that of -profile if
+// one is selected.
+func (f *Flags) Dir() string {
+	return filepath.Join(f.ConfigDir, f.Profile)
+}
+
+// Log returns where the messages of a run go that are not listings, such as
+// its progress and errors: stdout, or stderr with a machine-readable -output,
+// so that stdout carries the listings alone and can be piped into jq or a CSV
+// reader.
+func (f *Flags) Log() io.Writer {
+	if f.output.MachineReadable() {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// TakeDryRun reports whether -dry-run was given and turns the dry run off,
+// so that the session sends its requests. Commands that only read from the
+// stamp in a dry run call it before Open and skip their writes themselves.
+func (f *Flags) TakeDryRun() bool {
+	return f.plan.Take()
+}
+
+// KeepResources makes the run keep the resources it creates for good: they
+// get no expiry tag and are not deleted when the run ends. Commands that
+// maintain an environment rather than try it, such as reconcile, call it
+// before Open. It fails when -ttl or -cleanup on fs ask for anything else.
+func (f *Flags) KeepResources(fs *flag.FlagSet) error {
+	var err error
+	fs.Visit(func(fl *flag.Flag) {
+		switch {
+		case fl.Name == "ttl" && f.ttl != 0:
+			err = fmt.Errorf("-ttl must be 0, the resources are kept without expiry")
+		case fl.Name == "cleanup" && f.cleanupMode != cleanup.Never:
+			err = fmt.Errorf("-cleanup must be %s, the resources are kept", cleanup.Never)
+		}
+	})
+	f.ttl = 0
+	f.cleanupMode = cleanup.Never
+	return err
+}
+
+// identity is a configuration with the certificate it names, if any.
+type identity struct {
+	config     Config
+	path       string
+	certs      []*x509.Certificate
+	privateKey crypto.PrivateKey
+}
+
+// LoadConfig reads the configuration the flags select and returns it with the
+// path of its file. The certificate configuration is preferred unless -secret
+// is given; when it cannot be read or its certificate cannot be parsed, the
+// secret configuration is used instead.
+func (f *Flags) LoadConfig() (Config, string, error) {
+	id, err := f.load()
+	return id.config, id.path, err
+}
+
+func (f *Flags) load() (identity, error) {
+	certConfigFilePath := filepath.Join(f.Dir(), CertConfigFile)
+	secretConfigFilePath := filepath.Join(f.Dir(), SecretConfigFile)
+
+	if !f.Secret {
+		if id, ok := loadCert(certConfigFilePath, f.Log()); ok {
+			return id, nil
+		}
+		// The certificate configuration is unusable, the credential
+		// created for the secret one must use the secret as well.
+		f.Secret = true
+	}
+
+	id := identity{path: secretConfigFilePath}
+	if _, err := os.Stat(secretConfigFilePath); err != nil {
+		return id, fmt.Errorf("the configuration files, %s & %s, don't exist", secretConfigFilePath, certConfigFilePath)
+	}
+	data, err := os.ReadFile(secretConfigFilePath)
+	if err != nil {
+		return id, fmt.Errorf("failed to read configuration file %s: %w", secretConfigFilePath, err)
+	}
+	if err := json.Unmarshal(data, &id.config); err != nil {
+		return id, fmt.Errorf("failed to unmarshal data from %s: %w", secretConfigFilePath, err)
+	}
+	return id, nil
+}
+
+func loadCert(path string, log io.Writer) (identity, bool) {
+	id := identity{path: path}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return id, false
+	}
+	if err := json.Unmarshal(data, &id.config); err != nil {
+		return id, false
+	}
+	certData, _ := os.ReadFile(id.config.CertPath)
+	id.certs, id.privateKey, err = azidentity.ParseCertificates(certData, []byte(id.config.CertPass))
+	if err != nil {
+		fmt.Fprintln(log, "Unable to parse Certificate")
+		return id, false
+	}
+	return id, true
+}
+
+// Session is a signed-in run of a sample on a stamp.
+type Session struct {
+	Config      Config
+	Environment metadata.Environment
+	// AdminTenantID is the tenant of the configuration. On an ADFS stamp
+	// Config.TenantId is "adfs", which the credentials expect, while the
+	// access policies of key vaults still need the tenant.
+	AdminTenantID string
+	Credential    azcore.TokenCredential
+	Options       arm.ClientOptions
+	Waiter        *lro.Waiter
+	// Names names the resources of the run.
+	Names *naming.Namer
+	// Tags are the tags of every resource the run creates.
+	Tags map[string]*string
+	// Out receives the progress of the workflows, Lists their listings and
+	// Steps their steps. Log receives the other messages of the run, such
+	// as errors, as Flags.Log selects; Out is Log unless the run is dry.
+	Out   io.Writer
+	Log   io.Writer
+	Lists *output.Printer
+	Steps *report.Recorder
+	// Clean is set by -clean: the workflows delete their resource groups
+	// when they are done.
+	Clean bool
+	// Guard refuses to delete the resource groups the run does not own.
+	Guard *guard.Guard
+	// Converge decides what the workflows do about the resources an earlier
+	// run with the same run ID left behind.
+	Converge *converge.Checker
+
+	ctx  context.Context
+	stop context.CancelFunc
+	// cancel cancels ctx, and keeps handling the signals, when the run loses
+	// the lease of its state blob.
+	cancel context.CancelFunc
+	// in reads the answers to the questions of Confirm from stdin.
+	in      *bufio.Reader
+	flags   *Flags
+	planner *plan.Planner
+	created *cleanup.Stack
+	tracker *checkpoint.Tracker
+	retries *retry.Tracker
+}
+
+// Open starts a run of the sample name with the settings of the flags and
+// signs in. transport sends the requests; nil selects the default transport.
+// When the run has started its report, Open writes it before returning an
+// error.
+func Open(name string, f *Flags, transport policy.Transporter) (*Session, error) {
+	// With a machine-readable -output, stdout carries the listings alone;
+	// the other messages go to the log, stderr.
+	lists := &output.Printer{W: os.Stdout, Format: f.output}
+	log := f.Log()
+	id, err := f.load()
+	if err != nil {
+		return nil, err
+	}
+	s := &Session{Config: id.config, AdminTenantID: id.config.TenantId, Lists: lists, Log: log, Clean: f.Clean, flags: f, in: bufio.NewReader(os.Stdin)}
+	s.ctx, s.stop = cleanup.NotifyContext(context.Background(), log)
+	s.ctx, s.cancel = context.WithCancel(s.ctx)
+
+	config := id.config
+	templates := naming.Templates{}
+	for kind, template := range config.Names {
+		if err := templates.Set(kind + "=" + template); err != nil {
+			return nil, fmt.Errorf("invalid name template in %s: %w", id.path, err)
+		}
+	}
+	for kind, template := range f.names {
+		templates[kind] = template
+	}
+	if resume := f.checkpoint.Resuming(); resume != "" {
+		switch {
+		case f.plan.Enabled():
+			return nil, fmt.Errorf("-dry-run cannot be combined with -resume")
+		case f.RunID != "" && f.RunID != resume:
+			return nil, fmt.Errorf("-resume %s conflicts with -runID %s", resume, f.RunID)
+		}
+		f.RunID = resume
+	}
+	s.Names, err = naming.New(f.RunID, templates)
+	if err != nil {
+		return nil, err
+	}
+	s.Names.Out = log
+	fmt.Fprintf(log, "Run ID: %s\n", s.Names.RunID())
+	standard := tags.Standard(s.Names.RunID(), config.ObjectId, name, time.Now(), f.ttl)
+	s.Tags, err = tags.Build(standard, config.Tags, f.tags)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tags: %w", err)
+	}
+
+	transport, err = f.cassette.Transport(transport, cassette.IDs{SubscriptionID: config.SubscriptionId, TenantID: config.TenantId, ClientID: config.ClientId, ObjectID: config.ObjectId})
+	if err != nil {
+		return nil, fmt.Errorf("invalid cassette settings: %w", err)
+	}
+	s.Steps = report.New(name, config.ResourceManagerEndpointUrl)
+	s.Steps.SetRunID(s.Names.RunID())
+	err = s.Steps.Step("load stamp metadata", func() (err error) {
+		s.Environment, err = metadata.Load(s.ctx, config.ResourceManagerEndpointUrl, transport)
+		return err
+	})
+	if err != nil {
+		return nil, s.fail(fmt.Errorf("failed to get environment from url: %w", err))
+	}
+	disableInstanceDiscovery := f.DisableID
+	s.Steps.SetIdentityProvider(report.AAD)
+	if s.Environment.IsADFS() {
+		s.Steps.SetIdentityProvider(report.ADFS)
+		s.Config.TenantId = "adfs"
+		disableInstanceDiscovery = true
+	}
+
+	fmt.Fprintln(log, "Creating credential and getting token")
+
+	cloudConfig := cloud.Configuration{ActiveDirectoryAuthorityHost: s.Environment.ActiveDirectoryEndpoint, Services: map[cloud.ServiceName]cloud.ServiceConfiguration{cloud.ResourceManager: {Endpoint: s.Environment.ResourceManagerEndpoint, Audience: s.Environment.TokenAudience}}}
+
+	clientOptions := policy.ClientOptions{Cloud: cloudConfig, Transport: transport}
+	retryConfig, err := f.retry.Apply(config.Retry)
+	if err != nil {
+		return nil, fmt.Errorf("invalid retry settings: %w", err)
+	}
+	// A dry run answers the requests of the sample locally and never signs
+	// in, so it needs no write access to the stamp.
+	s.Out = log
+	if f.plan.Enabled() {
+		s.planner = plan.New()
+		clientOptions.Transport = s.planner
+		f.cleanupMode = cleanup.Never
+		s.Out = io.Discard
+		s.Lists.W = io.Discard
+	}
+	s.created = cleanup.NewStack(log)
+	if s.planner == nil {
+		s.created.Configure(&clientOptions)
+	}
+	s.Converge = &converge.Checker{Out: s.Out, Adopted: s.created.Adopt}
+	s.Guard = &guard.Guard{RunID: s.Names.RunID(), Creator: config.ObjectId, Created: s.created.Created, Force: f.Force, Confirm: s.Confirm, Out: log}
+	if s.planner == nil {
+		s.Guard.Configure(&clientOptions)
+	}
+	s.Steps.Configure(&clientOptions)
+	s.retries = retry.NewTracker(log)
+	s.retries.Configure(&clientOptions, retryConfig)
+	s.Waiter, err = f.lro.NewWaiter(log)
+	if err != nil {
+		return nil, fmt.Errorf("invalid long-running operation settings: %w", err)
+	}
+	if s.planner != nil {
+		s.Waiter.Store = nil
+		s.Waiter.Out = s.Out
+	}
+
+	switch {
+	case s.planner != nil:
+		s.Credential = plan.Credential{}
+	case f.Secret:
+		options := azidentity.ClientSecretCredentialOptions{ClientOptions: clientOptions, DisableInstanceDiscovery: disableInstanceDiscovery}
+		s.Credential, err = azidentity.NewClientSecretCredential(s.Config.TenantId, config.ClientId, config.ClientSecret, &options)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create the client secret credential: %w", err)
+		}
+	default:
+		options := azidentity.ClientCertificateCredentialOptions{ClientOptions: clientOptions, DisableInstanceDiscovery: disableInstanceDiscovery}
+		s.Credential, err = azidentity.NewClientCertificateCredential(s.Config.TenantId, config.ClientId, id.certs, id.privateKey, &options)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create the client certificate credential: %w", err)
+		}
+	}
+	if s.planner == nil {
+		err = s.Steps.Step("get token", func() error {
+			_, err := s.Credential.GetToken(s.ctx, policy.TokenRequestOptions{Scopes: []string{s.Environment.TokenAudience + "/.default"}})
+			return err
+		})
+		if err != nil {
+			return nil, s.fail(fmt.Errorf("failed to get a token: %w", err))
+		}
+	}
+	if s.planner == nil {
+		// The state of the run is read once the run has signed in, which the
+		// key of a storage account that keeps it may need.
+		var store checkpoint.Store
+		if f.checkpoint.Enabled() {
+			store, err = s.stateStore(clientOptions)
+			if err != nil {
+				return nil, s.fail(err)
+			}
+		}
+		s.tracker, err = f.checkpoint.Tracker(s.ctx, store, name+f.GroupSuffix, name, s.Names.RunID(), log)
+		if err != nil {
+			return nil, s.fail(err)
+		}
+	}
+	if s.tracker != nil {
+		s.tracker.Created = s.created.Created
+		s.tracker.Resources = s.created.Resources
+		s.tracker.Configure(&clientOptions)
+		created, adopted := s.tracker.Restored()
+		s.created.Restore(created)
+		for _, id := range adopted {
+			s.created.Adopt(id)
+		}
+	}
+	s.Options = arm.ClientOptions{ClientOptions: clientOptions}
+	if s.tracker != nil {
+		if f.checkpoint.Resuming() != "" {
+			err = s.Steps.Step("verify resumed run", func() error {
+				return s.tracker.Verify(s.ctx, s.Credential, &s.Options)
+			})
+			if err != nil {
+				return nil, s.fail(err)
+			}
+		}
+		s.Steps.SetCheckpoints(s.tracker)
+	}
+	return s, nil
+}
+
+// fail writes the report of a run that could not start and returns err.
+func (s *Session) fail(err error) error {
+	if s.tracker != nil {
+		s.tracker.Close()
+	}
+	s.flags.report.Write(s.Steps, 1, s.Log)
+	return err
+}
+
+// Context returns the context of the run, which is cancelled on SIGINT or
+// SIGTERM, or when the run loses the lease of its state blob.
+func (s *Session) Context() context.Context {
+	return s.ctx
+}
+
+// DryRun reports whether the requests of the run are planned rather than
+// sent.
+func (s *Session) DryRun() bool {
+	return s.planner != nil
+}
+
+// Confirm asks question on the terminal and reports whether it was answered
+// with yes. With -yes it asks nothing and reports true.
+func (s *Session) Confirm(question string) bool {
+	if s.flags.Yes {
+		return true
+	}
+	return guard.Confirm(s.in, s.Log, question)
+}
+
+// ResourceGroup returns the name of the resource group the sample calls name
+// in this run: name with the suffix of -groupSuffix, after the template of
+// resource groups.
+func (s *Session) ResourceGroup(name string) (string, error) {
+	return s.Names.Name(lro.ResourceGroup, name+s.flags.GroupSuffix)
+}
+
+// Keep leaves the resource with the ID, and everything in it, out of the
+// rollback of a failed run, such as the resource group of a failed
+// deployment, whose operations explain the failure.
+func (s *Session) Keep(id string) {
+	s.created.Keep(id)
+}
+
+// Resume reattaches to the long-running operations of an earlier,
+// interrupted run and waits for them. The run ends with Exit as any other.
+func (s *Session) Resume() error {
+	if s.planner != nil {
+		return fmt.Errorf("-dry-run cannot be combined with resume")
+	}
+	return lro.Resume(s.ctx, s.Waiter, s.Credential, &s.Options)
+}
+
+// Exit ends the run with code. It rolls back what the run created as selected
+// by -cleanup, prints the retry summary and, after a successful dry run, the
+// plan, writes the report and terminates the process.
+func (s *Session) Exit(code int) {
+	if code == 0 && s.planner != nil {
+		if err := s.flags.plan.Report(s.planner, s.Log); err != nil {
+			fmt.Fprintf(s.Log, "%s\n", err)
+			code = 1
+		}
+	}
+	s.Steps.SetCheckpoints(nil)
+	mode := s.flags.cleanupMode
+	if mode.Applies(code != 0) {
+		fmt.Fprintf(s.Log, "Deleting the resources created by this run (-cleanup=%s)\n", mode)
+		ctx, cancel := context.WithTimeout(context.Background(), s.flags.cleanupTimeout)
+		s.Steps.Step("roll back", func() error {
+			if left := s.created.Rollback(ctx, s.Credential, &s.Options, s.Waiter); len(left) > 0 {
+				return fmt.Errorf("%d resources created by this run were left behind", len(left))
+			}
+			return nil
+		})
+		cancel()
+	}
+	if s.tracker != nil {
+		s.tracker.Save()
+		s.tracker.Close()
+		if code != 0 && !mode.Applies(true) {
+			fmt.Fprintf(s.Log, "The state of the run is in %s, resume it with -resume %s\n", s.tracker.Where(), s.Names.RunID())
+		}
+	}
+	s.retries.PrintSummary()
+	s.flags.report.Write(s.Steps, code, s.Log)
+	s.stop()
+	os.Exit(code)
+}
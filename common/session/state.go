@@ -0,0 +1,73 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/checkpoint"
+)
+
+// stateStore returns the Store of the state files -stateBackend selects. The
+// blob backend uses the storage account of the state section of the
+// configuration, whose key is listed with the credential of the run unless
+// the section has it.
+func (s *Session) stateStore(clientOptions policy.ClientOptions) (checkpoint.Store, error) {
+	if s.flags.checkpoint.Backend() != checkpoint.BlobBackend {
+		return checkpoint.Dir(s.flags.checkpoint.Dir()), nil
+	}
+	c := s.Config.State
+	if c.StorageAccount == "" {
+		return nil, errors.New("-stateBackend blob needs the storage account of the state section of the configuration")
+	}
+	key := c.AccountKey
+	if key == "" {
+		if c.ResourceGroup == "" {
+			return nil, fmt.Errorf("the state section of the configuration needs the resource group of storage account %s or its key", c.StorageAccount)
+		}
+		var err error
+		key, err = checkpoint.AccountKey(s.ctx, s.Credential, &arm.ClientOptions{ClientOptions: clientOptions}, s.Config.SubscriptionId, c.ResourceGroup, c.StorageAccount)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the keys of storage account %s: %w", c.StorageAccount, err)
+		}
+	}
+	if c.LeaseDuration != 0 && (c.LeaseDuration < 15 || c.LeaseDuration > 60) {
+		return nil, fmt.Errorf("the lease duration of the state section of the configuration is %d seconds, want 15 to 60", c.LeaseDuration)
+	}
+	endpoint := c.BlobEndpoint
+	if endpoint == "" {
+		endpoint = "https://" + c.StorageAccount + ".blob." + s.Environment.StorageEndpointSuffix
+	}
+	store, err := checkpoint.NewContainer(endpoint, c.StorageAccount, key, c.Container, &clientOptions)
+	if err != nil {
+		return nil, err
+	}
+	store.Out = s.Log
+	if c.LeaseDuration != 0 {
+		store.LeaseDuration = time.Duration(c.LeaseDuration) * time.Second
+	}
+	// Another run may take over the state blob once the run lost its lease,
+	// so the run stops rather than carry on with resources it may share.
+	store.Lost = func(err error) {
+		fmt.Fprintf(s.Log, "Cancelling the run: %s\n", err)
+		s.cancel()
+	}
+	return store, nil
+}
+
+// LastState returns the state of the run -runID of sample, as kept by
+// -stateDir or -stateBackend.
+func (s *Session) LastState(sample string) (*checkpoint.State, error) {
+	if !s.flags.checkpoint.Enabled() {
+		return nil, errors.New("reading the state of a run needs -stateDir or -stateBackend blob")
+	}
+	store, err := s.stateStore(s.Options.ClientOptions)
+	if err != nil {
+		return nil, err
+	}
+	file := checkpoint.FileName(sample+s.flags.GroupSuffix, s.Names.RunID())
+	return checkpoint.Read(s.ctx, store, file, sample, s.Names.RunID())
+}
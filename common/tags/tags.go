@@ -0,0 +1,128 @@
+// Package tags builds the tags the samples put on every resource they create,
+// which tell the run, the service principal and the sample that created a
+// resource in a shared subscription and when it may be deleted.
+package tags
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The standard tags.
+const (
+	// RunID is the ID of the run that created the resource.
+	RunID = "hybrid-samples-run-id"
+	// Creator is the object ID of the service principal of the run.
+	Creator = "hybrid-samples-creator"
+	// Sample is the sample that created the resource.
+	Sample = "hybrid-samples-sample"
+	// CreatedAt is the time the run started, in RFC 3339 format and UTC.
+	CreatedAt = "hybrid-samples-created-at"
+	// ExpiresAt is the time after which the resource may be deleted, in RFC
+	// 3339 format and UTC. It is missing when the run has no time to live.
+	ExpiresAt = "hybrid-samples-expires-at"
+)
+
+// standard are the tags Standard sets, which Set refuses.
+var standard = map[string]bool{RunID: true, Creator: true, Sample: true, CreatedAt: true, ExpiresAt: true}
+
+// MaxTags is the number of tags a resource may have.
+const MaxTags = 50
+
+// Standard returns the standard tags of the resources of the run runID of
+// sample by creator, started at start. With a positive ttl the resources
+// expire ttl after start.
+func Standard(runID, creator, sample string, start time.Time, ttl time.Duration) map[string]string {
+	t := map[string]string{
+		RunID:     runID,
+		Creator:   creator,
+		Sample:    sample,
+		CreatedAt: start.UTC().Format(time.RFC3339),
+	}
+	if ttl > 0 {
+		t[ExpiresAt] = start.Add(ttl).UTC().Format(time.RFC3339)
+	}
+	return t
+}
+
+// Build returns the standard tags with the user tags of each set added, in
+// the form the clients take. Later sets override earlier ones.
+func Build(standard map[string]string, sets ...Set) (map[string]*string, error) {
+	t := map[string]*string{}
+	for _, set := range sets {
+		for key, value := range set {
+			if err := check(key, value); err != nil {
+				return nil, err
+			}
+			value := value
+			t[key] = &value
+		}
+	}
+	for key, value := range standard {
+		value := value
+		t[key] = &value
+	}
+	if len(t) > MaxTags {
+		return nil, fmt.Errorf("%d tags, but a resource may have at most %d", len(t), MaxTags)
+	}
+	return t, nil
+}
+
+func check(key, value string) error {
+	switch {
+	case standard[key]:
+		return fmt.Errorf("the tag %s is set by the samples", key)
+	case key == "" || len(key) > 512:
+		return fmt.Errorf("invalid tag name %q: it must have 1-512 characters", key)
+	case strings.ContainsAny(key, `<>%&\?/`):
+		return fmt.Errorf(`invalid tag name %q: it must not contain <, >, %%, &, \, ? or /`, key)
+	case len(value) > 256:
+		return fmt.Errorf("the value of the tag %s is longer than 256 characters", key)
+	}
+	return nil
+}
+
+// Time returns the time of the tag key of t, such as CreatedAt or ExpiresAt,
+// and whether t has a valid one.
+func Time(t map[string]*string, key string) (time.Time, bool) {
+	value := t[key]
+	if value == nil {
+		return time.Time{}, false
+	}
+	parsed, err := time.Parse(time.RFC3339, *value)
+	return parsed, err == nil
+}
+
+// Set are user tags. It implements flag.Value for a flag that takes
+// name=value and may be repeated.
+type Set map[string]string
+
+func (s Set) String() string {
+	return strings.Join(s.Values(), ",")
+}
+
+// Values returns the tags as the name=value arguments of the flags that set
+// them, sorted.
+func (s Set) Values() []string {
+	var pairs []string
+	for key, value := range s {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return pairs
+}
+
+// Set implements flag.Value.
+func (s Set) Set(value string) error {
+	key, value, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("expected name=value, such as owner=alice")
+	}
+	if err := check(key, value); err != nil {
+		return err
+	}
+	s[key] = value
+	return nil
+}
@@ -0,0 +1,685 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/fakestack"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/tags"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/hybrid/deployment"
+)
+
+func TestMain(m *testing.M) {
+	fakestack.Main(m, func(t policy.Transporter) {
+		transport = t
+		newCommand = fakestack.Command
+		main()
+	})
+}
+
+func checkOutput(t *testing.T, result fakestack.Result, wantCode int, want ...string) {
+	t.Helper()
+	if result.ExitCode != wantCode {
+		t.Fatalf("exit code %d, want %d, output:\n%s", result.ExitCode, wantCode, result.Output)
+	}
+	for _, w := range want {
+		if !strings.Contains(result.Output, w) {
+			t.Errorf("output is missing %q:\n%s", w, result.Output)
+		}
+	}
+}
+
+func TestDemo(t *testing.T) {
+	tests := []struct {
+		identity fakestack.Identity
+		args     []string
+		want     string
+	}{
+		{fakestack.AAD, []string{"-secret", "rg", "demo", "-disableID", "-clean"}, "Completed: delete resource group TestGoSampleResourceGroup-fake01"},
+		{fakestack.ADFS, []string{"storage", "demo", "-clean"}, "Completed: delete resource group TestGoStorageSampleResourceGroup-fake01"},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			stack := fakestack.Start(t, tt.identity)
+			checkOutput(t, stack.Run(t, tt.args...), 0, tt.want)
+			if left := stack.Resources(); len(left) != 0 {
+				t.Errorf("resources left behind: %v", left)
+			}
+		})
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	stack := fakestack.Start(t, fakestack.AAD)
+	checkOutput(t, stack.Run(t, "storage", "demo", "-secret", "-disableID", "-cleanup", "never"), 0)
+	if len(stack.Resources()) == 0 {
+		t.Fatal("the demo left no resource group to clean up")
+	}
+	checkOutput(t, stack.Run(t, "cleanup", "storage", "-secret", "-disableID", "-runID", ""), 2, "-runID is required")
+	checkOutput(t, stack.Run(t, "cleanup", "storage", "rg", "-secret", "-disableID"), 0,
+		"Delete the resource groups TestGoStorageSampleResourceGroup-fake01, TestGoSampleResourceGroup-fake01 with everything in them? [y/N]",
+		"Nothing was deleted",
+	)
+
+	// A resource someone else put in the group keeps it from being
+	// deleted, unless -force is given.
+	stack.AddResource("TestGoStorageSampleResourceGroup-fake01", "Microsoft.Storage/storageAccounts", "colleague", map[string]string{"owner": "bob"})
+	checkOutput(t, stack.Run(t, "cleanup", "storage", "-secret", "-disableID", "-yes"), 1,
+		"refusing to delete resource group TestGoStorageSampleResourceGroup-fake01: it contains resources the run did not create: Microsoft.Storage/storageAccounts/colleague; pass -force to delete it anyway",
+	)
+	if len(stack.Resources()) == 0 {
+		t.Fatal("cleanup deleted a resource group with a resource of someone else")
+	}
+	checkOutput(t, stack.Run(t, "cleanup", "storage", "rg", "-secret", "-disableID", "-force", "-yes"), 0,
+		"Resource group TestGoStorageSampleResourceGroup-fake01 is not owned by this run",
+		"Completed: delete resource group TestGoStorageSampleResourceGroup-fake01",
+		"Resource group TestGoSampleResourceGroup-fake01 does not exist",
+	)
+	if left := stack.Resources(); len(left) != 0 {
+		t.Errorf("resources left behind: %v", left)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	stack := fakestack.Start(t, fakestack.ADFS)
+	result := stack.Run(t, "-secret", "config")
+	checkOutput(t, result, 0, "Credential               client secret", "Identity provider        ADFS")
+	if strings.Contains(result.Output, fakestack.ClientSecret) {
+		t.Errorf("config shows the client secret:\n%s", result.Output)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	stack := fakestack.Start(t, fakestack.ADFS)
+	checkOutput(t, stack.Run(t, "auth"), 0, "Signed in to", "with a certificate")
+}
+
+// TestResume checks that a resumed run ends as any other, with its report.
+func TestResume(t *testing.T) {
+	stack := fakestack.Start(t, fakestack.ADFS)
+	path := filepath.Join(t.TempDir(), "report.json")
+	checkOutput(t, stack.Run(t, "resume", "-report", path), 0, "No pending operations in")
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("resume wrote no report: %s", err)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	stack := fakestack.Start(t, fakestack.AAD)
+	checkOutput(t, stack.Run(t, "help"), 0, "  rg demo ", "  vm demo ", "  cleanup [area...] ", "  -profile string")
+	checkOutput(t, stack.Run(t, "help", "cleanup"), 0, "Usage: hybrid cleanup [area...] [flags]")
+	checkOutput(t, stack.Run(t, "storage"), 2, "Usage: hybrid storage demo [flags]")
+	checkOutput(t, stack.Run(t, "unknown"), 2, `unknown command "unknown"`)
+}
+
+func TestMatrix(t *testing.T) {
+	stack := fakestack.Start(t, fakestack.AAD)
+	dir := t.TempDir()
+	for _, profile := range []string{"one", "two", "empty"} {
+		if err := os.Mkdir(filepath.Join(dir, profile), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if profile == "empty" {
+			continue
+		}
+		if err := fakestack.WriteConfig(filepath.Join(dir, profile), stack.URL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	result := stack.Run(t, "matrix", "-samples", "rg,keyvault", "-profiles", "one,two,empty", "-parallel", "2", "-configDir", dir, "-secret", "-disableID", "-clean")
+	checkOutput(t, result, 1,
+		"[rg/one] Completed: delete resource group TestGoSampleResourceGroup-one-fake01",
+		"[rg/two] Completed: delete resource group TestGoSampleResourceGroup-two-fake01",
+		"[keyvault/two] Completed: delete resource group TestGoKVSampleResourceGroup-two-fake01",
+		"4 of 6 runs passed",
+	)
+	var rows []string
+	for _, line := range strings.Split(result.Output, "\n") {
+		if fields := strings.Fields(line); len(fields) > 4 && (fields[0] == "rg" || fields[0] == "keyvault") {
+			rows = append(rows, strings.Join(fields[:5], " "))
+		}
+	}
+	want := []string{
+		"rg one AAD passed 0",
+		"rg two AAD passed 0",
+		"rg empty - failed 1",
+		"keyvault one AAD passed 0",
+		"keyvault two AAD passed 0",
+		"keyvault empty - failed 1",
+	}
+	if strings.Join(rows, "\n") != strings.Join(want, "\n") {
+		t.Errorf("result table rows:\n%s\nwant:\n%s", strings.Join(rows, "\n"), strings.Join(want, "\n"))
+	}
+	if !strings.Contains(result.Output, "don't exist") {
+		t.Errorf("result table does not explain the failed runs:\n%s", result.Output)
+	}
+	if left := stack.Resources(); len(left) != 0 {
+		t.Errorf("resources left behind: %v", left)
+	}
+}
+
+func TestJanitor(t *testing.T) {
+	stack := fakestack.Start(t, fakestack.AAD)
+	checkOutput(t, stack.Run(t, "rg", "demo", "-secret", "-disableID", "-ttl", "1ns"), 0)
+	checkOutput(t, stack.Run(t, "keyvault", "demo", "-secret", "-disableID", "-runID", "keep01"), 0)
+	stack.AddGroup("TestGoStorageSampleResourceGroup", nil)
+	stack.AddGroup("ColleagueGroup", map[string]string{"owner": "bob"})
+	stack.AddGroup("OtherRunGroup", map[string]string{
+		tags.RunID:     "other1",
+		tags.Creator:   "someone-else",
+		tags.CreatedAt: time.Now().Add(-72 * time.Hour).UTC().Format(time.RFC3339),
+	})
+	groups := func() []string {
+		var names []string
+		for _, id := range stack.Resources() {
+			if strings.Count(id, "/") == 4 {
+				names = append(names, id[strings.LastIndex(id, "/")+1:])
+			}
+		}
+		sort.Strings(names)
+		return names
+	}
+	all := strings.Join(groups(), " ")
+
+	args := []string{"janitor", "-secret", "-disableID"}
+	checkOutput(t, stack.Run(t, append(args, "-match", "TestGo*ResourceGroup*")...), 2, "-match requires -force")
+	// Without -force only the tagged groups of the service principal are
+	// selected.
+	checkOutput(t, stack.Run(t, append(args, "-dry-run")...), 0,
+		"Found 1 stale resource groups",
+		"TestGoSampleResourceGroup-fake01",
+		"Dry run, nothing was deleted",
+	)
+
+	args = append(args, "-force", "-match", "TestGo*ResourceGroup*")
+	checkOutput(t, stack.Run(t, append(args, "-dry-run")...), 0,
+		"Found 3 stale resource groups",
+		"name matches TestGo*ResourceGroup*",
+		"older than 24h0m0s",
+		"Dry run, nothing was deleted",
+	)
+	checkOutput(t, stack.Run(t, args...), 0, "Delete these 3 resource groups with everything in them? [y/N]", "Nothing was deleted")
+	if got := strings.Join(groups(), " "); got != all {
+		t.Fatalf("resource groups %s, want %s", got, all)
+	}
+
+	checkOutput(t, stack.Run(t, append(args, "-yes")...), 0,
+		"Completed: delete resource group TestGoSampleResourceGroup-fake01",
+		"Resource group TestGoStorageSampleResourceGroup is not owned by this run: it has no hybrid-samples-run-id tag",
+		"Completed: delete resource group TestGoStorageSampleResourceGroup",
+		"Completed: delete resource group OtherRunGroup",
+		"Deleted 3 of 3 resource groups",
+	)
+	want := "ColleagueGroup TestGoKVSampleResourceGroup-keep01"
+	if got := strings.Join(groups(), " "); got != want {
+		t.Errorf("resource groups %s, want %s", got, want)
+	}
+}
+
+func TestEnvironment(t *testing.T) {
+	stack := fakestack.Start(t, fakestack.AAD)
+	args := []string{"environments/vm.yaml", "-secret", "-disableID"}
+	index := func(name string) int {
+		for i, id := range stack.Resources() {
+			if strings.HasSuffix(id, "/"+name) {
+				return i
+			}
+		}
+		t.Fatalf("%s was not created", name)
+		return -1
+	}
+
+	// The resources of a failed resource are skipped, the others are
+	// created and kept for the rerun.
+	stack.FailCreate("TestGoNic-fake01", "InternalError", "The network interface could not be created.")
+	checkOutput(t, stack.Run(t, append([]string{"apply", "-cleanup", "never"}, args...)...), 1,
+		"Created virtual network TestGoVnet-fake01",
+		"Created disk TestGoDisk-fake01",
+		"failed to create network interface TestGoNic-fake01: InternalError",
+		"skipped, since nic failed",
+		"1 resources failed and 1 were skipped",
+	)
+	// The rerun updates the failed resource in place and creates the rest.
+	checkOutput(t, stack.Run(t, append([]string{"apply"}, args...)...), 0,
+		"Adopting the existing virtual network TestGoVnet-fake01",
+		"Updating the existing network interface TestGoNic-fake01, whose provisioning failed",
+		"Created virtual machine TestGoVM-fake01",
+	)
+	for _, dep := range []string{"TestGoVnet-fake01", "TestGoNsg-fake01", "TestGoIP-fake01"} {
+		if index(dep) > index("TestGoNic-fake01") {
+			t.Errorf("%s was created after the network interface that refers to it", dep)
+		}
+	}
+	if index("TestGoDisk-fake01") > index("TestGoVM-fake01") {
+		t.Error("the disk was created after the virtual machine it is attached to")
+	}
+	tags := stack.Tags()
+	for _, id := range stack.Resources() {
+		if strings.Count(id, "/") <= 8 && tags[id]["environment"] != "vmenv" {
+			t.Errorf("%s lacks the tags of the spec: %v", id, tags[id])
+		}
+	}
+
+	checkOutput(t, stack.Run(t, append([]string{"destroy"}, args...)...), 0, "Nothing was deleted")
+	checkOutput(t, stack.Run(t, append([]string{"destroy", "-yes", "-parallel", "2"}, args...)...), 0,
+		"Deleted virtual machine TestGoVM-fake01",
+		"Deleted resource group TestGoEnvResourceGroup-fake01",
+	)
+	if left := stack.Resources(); len(left) != 0 {
+		t.Errorf("resources left behind: %v", left)
+	}
+	checkOutput(t, stack.Run(t, append([]string{"destroy", "-yes"}, args...)...), 0, "The resource group TestGoEnvResourceGroup-fake01 does not exist")
+}
+
+func TestDiff(t *testing.T) {
+	stack := fakestack.Start(t, fakestack.AAD)
+	stateDir := t.TempDir()
+	args := []string{"-secret", "-disableID", "-stateDir", stateDir}
+	checkOutput(t, stack.Run(t, append([]string{"apply", "environments/vm.yaml"}, args...)...), 0)
+	checkOutput(t, stack.Run(t, append([]string{"diff", "environments/vm.yaml"}, args...)...), 0, "No drift: all 7 resources are in sync")
+	checkOutput(t, stack.Run(t, append([]string{"diff", "-state", "vmenv"}, args...)...), 0, "No drift")
+
+	// A scheduled check, or a reconciler, that runs as another service
+	// principal finds no drift in the tags that tell who created the
+	// resources.
+	other := t.TempDir()
+	if err := fakestack.WriteConfig(other, stack.URL); err != nil {
+		t.Fatal(err)
+	}
+	if err := fakestack.WriteConfigSection(other, "ObjectId", "00000000-0000-0000-0000-0000000000ff"); err != nil {
+		t.Fatal(err)
+	}
+	checkOutput(t, stack.Run(t, append([]string{"diff", "environments/vm.yaml", "-configDir", other}, args...)...), 0, "No drift: all 7 resources are in sync")
+	checkOutput(t, stack.Run(t, append([]string{"reconcile", "environments/vm.yaml", "-configDir", other, "-cycles", "1", "-listen", ""}, append(args, "-stateDir", t.TempDir())...)...), 0, "All 7 resources are in sync")
+	state, err := os.ReadFile(filepath.Join(stateDir, "vmenv-fake01.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(state), "Password!23") {
+		t.Errorf("the state of the run has the password of the virtual machine:\n%s", state)
+	}
+
+	// Someone opens RDP and resizes the virtual machine in the portal.
+	group := "/subscriptions/" + fakestack.SubscriptionID + "/resourceGroups/TestGoEnvResourceGroup-fake01/providers/"
+	stack.EditResource(group+"Microsoft.Network/networkSecurityGroups/TestGoNsg-fake01", func(body map[string]interface{}) {
+		props := body["properties"].(map[string]interface{})
+		props["securityRules"] = append(props["securityRules"].([]interface{}), map[string]interface{}{
+			"name":       "allow_rdp",
+			"properties": map[string]interface{}{"destinationPortRange": "3389", "access": "Allow"},
+		})
+	})
+	stack.EditResource(group+"Microsoft.Compute/virtualMachines/TestGoVM-fake01", func(body map[string]interface{}) {
+		body["properties"].(map[string]interface{})["hardwareProfile"] = map[string]interface{}{"vmSize": "Standard_A2"}
+	})
+	checkOutput(t, stack.Run(t, append([]string{"diff", "environments/vm.yaml"}, args...)...), 3,
+		"nsg            Microsoft.Network/networkSecurityGroups  TestGoNsg-fake01               drifted",
+		`"name":"allow_rdp","properties":{"access":"Allow","destinationPortRange":"3389"`,
+		"desired none (update in place)",
+		`properties.hardwareProfile.vmSize: live "Standard_A2", desired "Standard_A1" (update in place)`,
+		"2 of 7 resources are not in sync",
+	)
+	checkOutput(t, stack.Run(t, append([]string{"diff", "-state", "vmenv"}, args...)...), 3,
+		`properties.hardwareProfile.vmSize: live "Standard_A2", desired "Standard_A1" (update in place)`,
+	)
+	result := stack.Run(t, append([]string{"diff", "environments/vm.yaml", "-output", "json"}, args...)...)
+	checkOutput(t, result, 3)
+	var report struct {
+		InSync    bool `json:"inSync"`
+		Resources []struct {
+			Key         string `json:"key"`
+			Status      string `json:"status"`
+			Differences []struct {
+				Path    string      `json:"path"`
+				Live    interface{} `json:"live"`
+				Desired interface{} `json:"desired"`
+			} `json:"differences"`
+		} `json:"resources"`
+	}
+	if err := json.Unmarshal([]byte(result.Stdout), &report); err != nil {
+		t.Fatalf("invalid JSON report: %s\n%s", err, result.Stdout)
+	}
+	if report.InSync || len(report.Resources) != 7 {
+		t.Fatalf("report %+v, want 7 resources out of sync", report)
+	}
+	for _, r := range report.Resources {
+		if r.Key == "vm" && (r.Status != "drifted" || len(r.Differences) != 1 || r.Differences[0].Live != "Standard_A2") {
+			t.Errorf("virtual machine %+v, want it drifted in its size", r)
+		}
+	}
+
+	// A resource deleted by hand is missing, and those that refer to it
+	// cannot be compared.
+	stack.RemoveResource(group + "Microsoft.Network/publicIPAddresses/TestGoIP-fake01")
+	checkOutput(t, stack.Run(t, append([]string{"diff", "environments/vm.yaml"}, args...)...), 3,
+		"TestGoIP-fake01                missing",
+		"TestGoNic-fake01               unchecked  ip is missing",
+		"TestGoVM-fake01                unchecked  nic is unchecked",
+	)
+	checkOutput(t, stack.Run(t, "diff", "-secret", "-disableID"), 2, "Usage: hybrid diff <spec>")
+}
+
+func TestReconcile(t *testing.T) {
+	stack := fakestack.Start(t, fakestack.AAD)
+	args := []string{"environments/vm.yaml", "-secret", "-disableID", "-interval", "10ms", "-backoff", "10ms"}
+
+	// The first reconciliation fails on the network interface, the next
+	// one repairs it and creates the rest.
+	stack.FailCreate("TestGoNic-fake01", "InternalError", "The network interface could not be created.")
+	checkOutput(t, stack.Run(t, append([]string{"reconcile", "-cycles", "2", "-listen", "127.0.0.1:0"}, args...)...), 0,
+		"Serving the health and metrics endpoints on http://127.0.0.1:",
+		"Reconciliation 1 of environment vmenv",
+		"Repairing 7 resources: resourceGroup missing",
+		"Reconciliation 1 failed: 1 resources failed and 1 were skipped",
+		"Repairing 1 resources: vm missing",
+		"Updating the existing network interface TestGoNic-fake01, whose provisioning failed",
+		"Created virtual machine TestGoVM-fake01",
+	)
+
+	// Someone opens RDP and drops a tag of the resource group.
+	group := "/subscriptions/" + fakestack.SubscriptionID + "/resourceGroups/TestGoEnvResourceGroup-fake01"
+	stack.EditResource(group+"/providers/Microsoft.Network/networkSecurityGroups/TestGoNsg-fake01", func(body map[string]interface{}) {
+		props := body["properties"].(map[string]interface{})
+		props["securityRules"] = append(props["securityRules"].([]interface{}), map[string]interface{}{"name": "allow_rdp"})
+	})
+	stack.EditResource(group, func(body map[string]interface{}) {
+		delete(body["tags"].(map[string]interface{}), "environment")
+	})
+	checkOutput(t, stack.Run(t, append([]string{"reconcile", "-cycles", "2", "-listen", ""}, args...)...), 0,
+		`resourceGroup TestGoEnvResourceGroup-fake01: tags.environment is missing, want "vmenv"`,
+		"nsg TestGoNsg-fake01: properties.securityRules[allow_rdp] is",
+		"Repairing 2 resources: resourceGroup drifted, nsg drifted",
+		"Updating the existing network security group TestGoNsg-fake01 in place",
+		"All 7 resources are in sync",
+	)
+	checkOutput(t, stack.Run(t, "diff", "environments/vm.yaml", "-secret", "-disableID"), 0, "No drift")
+	checkOutput(t, stack.Run(t, "reconcile", "environments/vm.yaml", "-secret", "-disableID", "-listen", "", "-runID", ""), 2, "-runID is required")
+
+	// The environment never expires, and a reconciler that ends on a
+	// failure keeps what it repaired.
+	for id, tags := range stack.Tags() {
+		if _, ok := tags["hybrid-samples-expires-at"]; ok {
+			t.Errorf("%s expires: %v", id, tags)
+		}
+	}
+	checkOutput(t, stack.Run(t, append([]string{"reconcile", "-ttl", "1h", "-listen", ""}, args...)...), 2, "-ttl must be 0")
+	checkOutput(t, stack.Run(t, append([]string{"reconcile", "-cleanup", "on-failure", "-listen", ""}, args...)...), 2, "-cleanup must be never")
+	stack.RemoveResource(group + "/providers/Microsoft.Network/networkSecurityGroups/TestGoNsg-fake01")
+	stack.FailCreate("TestGoNsg-fake01", "InternalError", "The network security group could not be created.")
+	checkOutput(t, stack.Run(t, append([]string{"reconcile", "-cycles", "1", "-listen", ""}, args...)...), 1, "Reconciliation 1 failed")
+	if len(stack.Resources()) < 6 {
+		t.Errorf("the failed reconciliation rolled back the environment: %v", stack.Resources())
+	}
+}
+
+func TestReconcileStatus(t *testing.T) {
+	st := newReconcileStatus()
+	get := func(path string) (int, string) {
+		w := httptest.NewRecorder()
+		st.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code, w.Body.String()
+	}
+	if code, body := get("/healthz"); code != http.StatusOK {
+		t.Errorf("/healthz of a starting reconciler answered %d: %s", code, body)
+	}
+	st.record(time.Now(), errors.New("boom"))
+	st.record(time.Now(), errors.New("boom"))
+	if code, body := get("/healthz"); code != http.StatusServiceUnavailable || !strings.Contains(body, "2 reconciliations failed in a row, the last one with: boom") {
+		t.Errorf("/healthz after failures answered %d: %s", code, body)
+	}
+	st.setResources(map[string]int{"in sync": 6, "drifted": 1})
+	st.repaired("updated")
+	st.record(time.Now(), nil)
+	if code, body := get("/healthz"); code != http.StatusOK {
+		t.Errorf("/healthz after a success answered %d: %s", code, body)
+	}
+	_, metrics := get("/metrics")
+	for _, want := range []string{
+		"# TYPE hybrid_reconcile_runs_total counter",
+		`hybrid_reconcile_runs_total{result="failure"} 2`,
+		`hybrid_reconcile_runs_total{result="success"} 1`,
+		`hybrid_reconcile_repairs_total{action="created"} 0`,
+		`hybrid_reconcile_repairs_total{action="updated"} 1`,
+		`hybrid_reconcile_resources{status="drifted"} 1`,
+		`hybrid_reconcile_resources{status="missing"} 0`,
+		"hybrid_reconcile_consecutive_failures 0",
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("/metrics is missing %q:\n%s", want, metrics)
+		}
+	}
+	if code, _ := get("/other"); code != http.StatusNotFound {
+		t.Errorf("/other answered %d", code)
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for _, tt := range []struct {
+		failures int
+		max      time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{10, time.Minute},
+	} {
+		for i := 0; i < 20; i++ {
+			if d := backoffDelay(10*time.Second, time.Minute, tt.failures, random); d < tt.max/2 || d > tt.max {
+				t.Errorf("backoff after %d failures is %s, want between %s and %s", tt.failures, d, tt.max/2, tt.max)
+			}
+		}
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	stack := fakestack.Start(t, fakestack.AAD)
+	args := []string{"templates/network.json", "-parameters", "templates/network.parameters.json", "-secret", "-disableID"}
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	if _, err := deployment.Load("templates/network.json", write("bare.json", `{"prefix": "TestGo"}`)); err == nil {
+		t.Error("a parameter without a value was accepted")
+	}
+
+	// Only deploy creates the resource group of the deployment.
+	checkOutput(t, stack.Run(t, append([]string{"validate"}, args...)...), 1, "Resource group TestGoTemplateResourceGroup-fake01 does not exist")
+
+	// A failed operation of the nested deployment is reported with the
+	// errors it is nested in.
+	stack.FailCreate("TestGoTemplateNic", "InternalError", "The network interface could not be created.")
+	checkOutput(t, stack.Run(t, append([]string{"deploy", "-cleanup", "never"}, args...)...), 1,
+		"Creating resource group TestGoTemplateResourceGroup-fake01",
+		"Create Microsoft.Network/virtualNetworks TestGoTemplateVnet: Succeeded (Created)",
+		"Deployment network-fake01 failed:",
+		"DeploymentFailed:",
+		"InternalError: The network interface could not be created.",
+	)
+
+	invalid := write("invalid.parameters.json", `{"parameters": {"ipAllocation": {"value": "Sometimes"}, "size": {"value": 3}}}`)
+	checkOutput(t, stack.Run(t, "validate", "templates/network.json", "-parameters", invalid, "-secret", "-disableID"), 1,
+		"Validation of deployment network-fake01 failed:",
+		"InvalidTemplateDeployment:",
+		"Sometimes",
+		"size",
+	)
+	checkOutput(t, stack.Run(t, append([]string{"validate"}, args...)...), 0,
+		"Template templates/network.json is valid, it deploys:",
+		"  Microsoft.Network/virtualNetworks TestGoTemplateVnet",
+	)
+
+	// The rerun updates what exists, deploys the network interface that
+	// failed and prints the outputs of the template.
+	result := stack.Run(t, append([]string{"deploy", "-output", "json", "-cleanup", "never"}, args...)...)
+	checkOutput(t, result, 0)
+	var outputs map[string]string
+	if err := json.Unmarshal([]byte(result.Output[strings.Index(result.Output, "{"):strings.LastIndex(result.Output, "}")+1]), &outputs); err != nil {
+		t.Fatalf("the outputs are not JSON: %s\n%s", err, result.Output)
+	}
+	if !strings.HasSuffix(outputs["vnetId"], "/providers/Microsoft.Network/virtualNetworks/TestGoTemplateVnet") || outputs["publicIPAddress"] == "" {
+		t.Errorf("outputs %v", outputs)
+	}
+
+	// Complete mode deletes what the template does not have, once
+	// confirmed.
+	stack.AddResource("TestGoTemplateResourceGroup-fake01", "Microsoft.Network/publicIPAddresses", "Stray", nil)
+	checkOutput(t, stack.Run(t, append([]string{"deploy", "-mode", "complete"}, args...)...), 0, "Nothing was deployed")
+	checkOutput(t, stack.Run(t, append([]string{"deploy", "-mode", "Complete", "-yes", "-cleanup", "never"}, args...)...), 0,
+		"Delete Microsoft.Network/publicIPAddresses Stray",
+	)
+	for _, id := range stack.Resources() {
+		if strings.HasSuffix(id, "/Stray") {
+			t.Error("complete mode kept a resource the template does not have")
+		}
+	}
+	checkOutput(t, stack.Run(t, append([]string{"deploy", "-mode", "partial"}, args...)...), 2, "unknown deployment mode")
+}
+
+func TestFailures(t *testing.T) {
+	stack := fakestack.Start(t, fakestack.AAD)
+	args := []string{"-secret", "-disableID"}
+	checkOutput(t, stack.Run(t, append([]string{"failures", "network-fake01"}, args...)...), 1, "Deployment network-fake01 does not exist in resource group TestGoTemplateResourceGroup-fake01")
+
+	// The failed operation of the nested deployment is the root cause of
+	// the failed operations it is nested in. The rollback of the failed
+	// run keeps the resource group, so that the failures can be listed.
+	stack.FailCreate("TestGoTemplateNic", "InternalError", "The network interface could not be created.")
+	result := stack.Run(t, "deploy", "templates/network.json", "-secret", "-disableID")
+	checkOutput(t, result, 1,
+		"hybrid failures network-fake01 -group TestGoTemplateResourceGroup-fake01 lists the operations that failed",
+		"Deleting the resources created by this run (-cleanup=on-failure)",
+		"This run keeps the resources it created",
+	)
+	if strings.Contains(result.Output, "Deleting Microsoft.Resources/resourceGroups") {
+		t.Errorf("the rollback of the failed deployment deleted its resource group:\n%s", result.Output)
+	}
+	checkOutput(t, stack.Run(t, append([]string{"failures", "network-fake01"}, args...)...), 0,
+		"Deployment network-fake01 of resource group TestGoTemplateResourceGroup-fake01: Failed",
+		"2 of the 5 operations of deployment network-fake01 failed:",
+		"  Create Microsoft.Resources/deployments TestGoTemplateFrontend: Conflict DeploymentFailed\n"+
+			"    Create Microsoft.Network/networkInterfaces TestGoTemplateNic: Conflict InternalError\n",
+		"Root cause:\n"+
+			"  InternalError: The network interface could not be created.\n"+
+			"    at Microsoft.Network/networkInterfaces TestGoTemplateNic, deployment network-fake01 > TestGoTemplateFrontend\n",
+	)
+	result = stack.Run(t, append([]string{"failures", "network-fake01", "-group", "TestGoTemplateResourceGroup-fake01", "-output", "json"}, args...)...)
+	checkOutput(t, result, 0)
+	var doc struct {
+		ProvisioningState string
+		Operations        int
+		Failed            []struct {
+			ResourceName string
+			Nested       []struct{ ResourceName string }
+		}
+		RootCauses []struct {
+			Deployments  []string
+			ResourceName string
+			Code         string
+		}
+	}
+	if err := json.Unmarshal([]byte(result.Output[strings.Index(result.Output, "{"):strings.LastIndex(result.Output, "}")+1]), &doc); err != nil {
+		t.Fatalf("the failures are not JSON: %s\n%s", err, result.Output)
+	}
+	if doc.ProvisioningState != "Failed" || doc.Operations != 5 || len(doc.Failed) != 1 || len(doc.Failed[0].Nested) != 1 || doc.Failed[0].Nested[0].ResourceName != "TestGoTemplateNic" {
+		t.Errorf("failures %+v", doc)
+	}
+	if len(doc.RootCauses) != 1 || doc.RootCauses[0].Code != "InternalError" || strings.Join(doc.RootCauses[0].Deployments, ">") != "network-fake01>TestGoTemplateFrontend" {
+		t.Errorf("root causes %+v", doc.RootCauses)
+	}
+
+	checkOutput(t, stack.Run(t, "deploy", "templates/network.json", "-cleanup", "never", "-secret", "-disableID"), 0)
+	checkOutput(t, stack.Run(t, append([]string{"failures", "network-fake01"}, args...)...), 0,
+		"Deployment network-fake01 of resource group TestGoTemplateResourceGroup-fake01: Succeeded",
+		"None of the 3 operations of deployment network-fake01 failed",
+	)
+}
+
+func TestStateSecrets(t *testing.T) {
+	stack := fakestack.Start(t, fakestack.AAD)
+	dir := t.TempDir()
+	stateDir := filepath.Join(dir, "state")
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	template := write("secret.json", `{
+		"parameters": {"adminPassword": {"type": "securestring"}, "settings": {"type": "object"}},
+		"resources": [{
+			"type": "Microsoft.Network/networkSecurityGroups",
+			"apiVersion": "2018-11-01",
+			"name": "TestGoSecretNsg",
+			"location": "[resourceGroup().location]",
+			"properties": {"securityRules": []}
+		}]
+	}`)
+	parameters := write("secret.parameters.json", `{"adminPassword": {"value": "Templ@tePassw0rd"}, "settings": {"value": {"token": "0bject-s3cret"}}}`)
+
+	// The runs fail with their resources kept, so their state holds the
+	// bodies they sent.
+	stack.FailDelete("gotestkeyvault-fake01", "Conflict", "The vault cannot be deleted.")
+	checkOutput(t, stack.Run(t, "keyvault", "demo", "-cleanup", "never", "-secret", "-disableID", "-stateDir", stateDir), 1)
+	stack.FailCreate("TestGoSecretNsg", "InternalError", "The network security group could not be created.")
+	checkOutput(t, stack.Run(t, "deploy", template, "-parameters", parameters, "-cleanup", "never", "-secret", "-disableID", "-stateDir", stateDir), 1)
+
+	files, err := filepath.Glob(filepath.Join(stateDir, "*.json"))
+	if err != nil || len(files) != 2 {
+		t.Fatalf("state files %v, %v", files, err)
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, secret := range []string{"testvalue", "Templ@tePassw0rd", "0bject-s3cret"} {
+			if strings.Contains(string(data), secret) {
+				t.Errorf("%s holds the secret %q:\n%s", file, secret, data)
+			}
+		}
+		if !strings.Contains(string(data), `"desired"`) {
+			t.Errorf("%s holds no bodies:\n%s", file, data)
+		}
+	}
+}
+
+func TestMatrixFlags(t *testing.T) {
+	stack := fakestack.Start(t, fakestack.AAD)
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "one"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := fakestack.WriteConfig(filepath.Join(dir, "one"), stack.URL); err != nil {
+		t.Fatal(err)
+	}
+	// Repeated flags reach the runs as they were given, not merged into
+	// one value.
+	checkOutput(t, stack.Run(t, "matrix", "-samples", "rg", "-profiles", "one", "-configDir", dir, "-secret", "-disableID",
+		"-tag", "owner=alice", "-tag", "team=platform",
+		"-name", "resourceGroup=ci-{run}-{base}", "-name", "virtualNetwork={base}-{run}"), 0, "1 of 1 runs passed")
+	var got map[string]string
+	for id, tags := range stack.Tags() {
+		if strings.HasSuffix(id, "/ci-fake01-TestGoSampleResourceGroup-one") {
+			got = tags
+		}
+	}
+	if got["owner"] != "alice" || got["team"] != "platform" {
+		t.Errorf("tags of the resource group: %v", got)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/resources/armresources"
+
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/lro"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/metadata"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/report"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/session"
+)
+
+func runConfig(fs *flag.FlagSet, f *session.Flags, args []string) int {
+	if args = parseArgs(fs, args); len(args) != 0 {
+		fmt.Fprintf(os.Stderr, "Usage: hybrid config [flags]\n")
+		return 2
+	}
+	config, path, err := f.LoadConfig()
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return 1
+	}
+	credential := "certificate " + config.CertPath
+	if f.Secret {
+		credential = "client secret"
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Configuration file\t%s\n", path)
+	fmt.Fprintf(tw, "Credential\t%s\n", credential)
+	fmt.Fprintf(tw, "Client ID\t%s\n", config.ClientId)
+	fmt.Fprintf(tw, "Object ID\t%s\n", config.ObjectId)
+	fmt.Fprintf(tw, "Tenant ID\t%s\n", config.TenantId)
+	fmt.Fprintf(tw, "Subscription ID\t%s\n", config.SubscriptionId)
+	fmt.Fprintf(tw, "Location\t%s\n", config.Location)
+	fmt.Fprintf(tw, "Resource Manager\t%s\n", config.ResourceManagerEndpointUrl)
+
+	environment, err := metadata.Load(context.Background(), config.ResourceManagerEndpointUrl, transport)
+	if err != nil {
+		tw.Flush()
+		fmt.Printf("Failed to get environment from url: %s\n", err)
+		return 1
+	}
+	provider := report.AAD
+	if environment.IsADFS() {
+		provider = report.ADFS
+	}
+	fmt.Fprintf(tw, "Identity provider\t%s\n", provider)
+	fmt.Fprintf(tw, "Authority\t%s\n", environment.ActiveDirectoryEndpoint)
+	fmt.Fprintf(tw, "Token audience\t%s\n", environment.TokenAudience)
+	fmt.Fprintf(tw, "Storage endpoint suffix\t%s\n", environment.StorageEndpointSuffix)
+	tw.Flush()
+	return 0
+}
+
+func runAuth(fs *flag.FlagSet, f *session.Flags, args []string) int {
+	if args = parseArgs(fs, args); len(args) != 0 {
+		fmt.Fprintf(os.Stderr, "Usage: hybrid auth [flags]\n")
+		return 2
+	}
+	s, err := session.Open("auth", f, transport)
+	if err != nil {
+		fmt.Fprintf(f.Log(), "%s\n", err)
+		return 1
+	}
+	credential := "certificate"
+	if f.Secret {
+		credential = "client secret"
+	}
+	if s.DryRun() {
+		fmt.Fprintln(s.Log, "Dry run, not signed in")
+	} else {
+		fmt.Fprintf(s.Log, "Signed in to %s as client %s of tenant %s with a %s\n", s.Environment.ResourceManagerEndpoint, s.Config.ClientId, s.AdminTenantID, credential)
+	}
+	s.Exit(0)
+	return 0
+}
+
+func runCleanup(fs *flag.FlagSet, f *session.Flags, args []string) int {
+	selected := areas
+	if args = parseArgs(fs, args); len(args) > 0 {
+		selected = nil
+		for _, name := range args {
+			a := findArea(name)
+			if a == nil {
+				fmt.Fprintf(os.Stderr, "hybrid cleanup: unknown area %q\n", name)
+				return 2
+			}
+			selected = append(selected, *a)
+		}
+	}
+	if f.RunID == "" {
+		fmt.Fprintf(os.Stderr, "hybrid cleanup: -runID is required, since the names of the resource groups include the ID of the run\n")
+		return 2
+	}
+	s, err := session.Open("cleanup", f, transport)
+	if err != nil {
+		fmt.Fprintf(f.Log(), "%s\n", err)
+		return 1
+	}
+	groups, err := armresources.NewResourceGroupsClient(s.Config.SubscriptionId, s.Credential, &s.Options)
+	if err != nil {
+		fmt.Fprintf(s.Log, "failed to create the resource group client: %s\n", err)
+		s.Exit(1)
+	}
+	// The groups were created by an earlier run, which left no record of
+	// them, so the guard of the session goes by their tags alone.
+	s.Guard.Created = nil
+	code := 0
+	var names []string
+	for _, a := range selected {
+		name, err := s.ResourceGroup(a.resourceGroup)
+		if err != nil {
+			fmt.Fprintf(s.Log, "%s\n", err)
+			code = 1
+			continue
+		}
+		names = append(names, name)
+	}
+	if len(names) > 0 && !s.DryRun() && !s.Confirm(fmt.Sprintf("Delete the resource groups %s with everything in them?", strings.Join(names, ", "))) {
+		fmt.Fprintln(s.Log, "Nothing was deleted")
+		s.Exit(code)
+	}
+	for _, name := range names {
+		err = s.Steps.Step("delete resource group "+name, func() error {
+			return deleteGroup(s, groups, name)
+		})
+		if err != nil {
+			fmt.Fprintf(s.Log, "%s\n", err)
+			code = 1
+		}
+	}
+	s.Exit(code)
+	return code
+}
+
+// deleteGroup deletes the resource group name if it exists.
+func deleteGroup(s *session.Session, groups *armresources.ResourceGroupsClient, name string) error {
+	ctx := s.Context()
+	exists, err := groups.CheckExistence(ctx, name, nil)
+	if err != nil {
+		return fmt.Errorf("failed to check whether resource group %s exists: %w", name, err)
+	}
+	if !exists.Success {
+		fmt.Fprintf(s.Out, "Resource group %s does not exist\n", name)
+		return nil
+	}
+	fmt.Fprintf(s.Out, "Deleting resource group %s\n", name)
+	poller, err := groups.BeginDelete(ctx, name, nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete resource group %s: %w", name, err)
+	}
+	_, err = lro.Wait(ctx, s.Waiter, lro.Delete(lro.ResourceGroup, name), poller)
+	return err
+}
+
+func runResume(fs *flag.FlagSet, f *session.Flags, args []string) int {
+	if args = parseArgs(fs, args); len(args) != 0 {
+		fmt.Fprintf(os.Stderr, "Usage: hybrid resume [flags]\n")
+		return 2
+	}
+	s, err := session.Open("resume", f, transport)
+	if err != nil {
+		fmt.Fprintf(f.Log(), "%s\n", err)
+		return 1
+	}
+	if err := s.Resume(); err != nil {
+		fmt.Fprintf(s.Log, "%s\n", err)
+		s.Exit(1)
+	}
+	s.Exit(0)
+	return 0
+}
@@ -0,0 +1,472 @@
+// Package deployment validates ARM templates and deploys them to a resource
+// group with the deployments of Azure Resource Manager. It reports the
+// operations of a deployment while it runs and the nested errors of one that
+// failed.
+package deployment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/resources/armresources"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/lro"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/report"
+)
+
+// Client is the part of armresources.DeploymentsClient that validates and
+// deploys templates.
+type Client interface {
+	BeginValidate(ctx context.Context, resourceGroupName string, deploymentName string, parameters armresources.Deployment, options *armresources.DeploymentsClientBeginValidateOptions) (*runtime.Poller[armresources.DeploymentsClientValidateResponse], error)
+	BeginCreateOrUpdate(ctx context.Context, resourceGroupName string, deploymentName string, parameters armresources.Deployment, options *armresources.DeploymentsClientBeginCreateOrUpdateOptions) (*runtime.Poller[armresources.DeploymentsClientCreateOrUpdateResponse], error)
+	Get(ctx context.Context, resourceGroupName string, deploymentName string, options *armresources.DeploymentsClientGetOptions) (armresources.DeploymentsClientGetResponse, error)
+}
+
+// OperationsClient is the part of armresources.DeploymentOperationsClient
+// that lists the operations of a deployment.
+type OperationsClient interface {
+	NewListPager(resourceGroupName string, deploymentName string, options *armresources.DeploymentOperationsClientListOptions) *runtime.Pager[armresources.DeploymentOperationsClientListResponse]
+}
+
+// Template is an ARM template and the values of its parameters.
+type Template struct {
+	Path    string
+	Content map[string]interface{}
+	// Parameters are the parameters of the deployment in the form of ARM,
+	// such as {"name": {"value": "vnet"}}.
+	Parameters map[string]interface{}
+}
+
+// Load reads the JSON template at path and, unless parametersPath is empty,
+// the values of its parameters from a parameters file. The file is either a
+// deployment parameters document, with the values in its parameters, or the
+// parameters alone.
+func Load(path, parametersPath string) (*Template, error) {
+	t := &Template{Path: path, Parameters: map[string]interface{}{}}
+	if err := readJSON(path, &t.Content); err != nil {
+		return nil, err
+	}
+	if _, ok := t.Content["resources"].([]interface{}); !ok {
+		return nil, fmt.Errorf("%s: not an ARM template, it has no resources array", path)
+	}
+	if parametersPath == "" {
+		return t, nil
+	}
+	var params map[string]interface{}
+	if err := readJSON(parametersPath, &params); err != nil {
+		return nil, err
+	}
+	// A parameter of the template may itself be called parameters, which
+	// tells a parameters document by what else it holds.
+	if inner, ok := params["parameters"].(map[string]interface{}); ok {
+		_, schema := params["$schema"]
+		_, value := inner["value"]
+		if schema || !value && inner["reference"] == nil {
+			params = inner
+		}
+	}
+	for name, p := range params {
+		value, ok := p.(map[string]interface{})
+		if _, hasValue := value["value"]; !ok || !hasValue && value["reference"] == nil {
+			return nil, fmt.Errorf("%s: parameter %s has neither a value nor a reference, as in {\"value\": ...}", parametersPath, name)
+		}
+		t.Parameters[name] = value
+	}
+	return t, nil
+}
+
+func readJSON(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if v, ok := v.(*map[string]interface{}); ok && *v == nil {
+		return fmt.Errorf("%s: not a JSON object", path)
+	}
+	return nil
+}
+
+// Resources returns the number of top-level resources of t.
+func (t *Template) Resources() int {
+	resources, _ := t.Content["resources"].([]interface{})
+	return len(resources)
+}
+
+// Deployer validates and deploys templates to resource groups.
+type Deployer struct {
+	Deployments Client
+	// Operations, when set, lists the operations of a deployment while it
+	// runs.
+	Operations OperationsClient
+	// Tags are the tags of the deployments.
+	Tags   map[string]*string
+	Waiter *lro.Waiter
+	Steps  *report.Recorder
+	Out    io.Writer
+}
+
+// ParseMode returns the deployment mode called s, in any case.
+func ParseMode(s string) (armresources.DeploymentMode, error) {
+	for _, mode := range armresources.PossibleDeploymentModeValues() {
+		if strings.EqualFold(s, string(mode)) {
+			return mode, nil
+		}
+	}
+	return "", fmt.Errorf("unknown deployment mode %q, expected incremental or complete", s)
+}
+
+func (d *Deployer) deployment(t *Template, mode armresources.DeploymentMode) armresources.Deployment {
+	return armresources.Deployment{
+		Properties: &armresources.DeploymentProperties{
+			Mode:       to.Ptr(mode),
+			Template:   t.Content,
+			Parameters: t.Parameters,
+		},
+		Tags: d.Tags,
+	}
+}
+
+// Validate checks that ARM accepts t as the deployment name to the resource
+// group, without deploying anything. It returns the properties ARM reports
+// for the deployment, or an *Error with the reasons ARM rejected it.
+func (d *Deployer) Validate(ctx context.Context, group, name string, t *Template, mode armresources.DeploymentMode) (*armresources.DeploymentPropertiesExtended, error) {
+	var props *armresources.DeploymentPropertiesExtended
+	start := time.Now()
+	err := func() error {
+		// ARM rejects an invalid template with 400, which the poller
+		// turns into an error without the body, so the response is
+		// captured to read the reasons from.
+		var raw *http.Response
+		poller, err := d.Deployments.BeginValidate(runtime.WithCaptureResponse(ctx, &raw), group, name, d.deployment(t, mode), nil)
+		if err != nil {
+			if raw != nil && raw.StatusCode == http.StatusBadRequest {
+				if detail := responseError(raw); detail != nil {
+					return &Error{Action: "validate", Name: name, Detail: detail}
+				}
+			}
+			return asError("validate", name, err)
+		}
+		resp, err := lro.Wait(ctx, d.Waiter, lro.Operation{Action: "validate", Kind: lro.Deployment, Name: name}, poller)
+		if err != nil {
+			return asError("validate", name, err)
+		}
+		if resp.Error != nil {
+			return &Error{Action: "validate", Name: name, Detail: resp.Error}
+		}
+		props = resp.Properties
+		return nil
+	}()
+	d.Steps.Record("validate deployment "+name, start, nil, err)
+	return props, err
+}
+
+// Deploy deploys t as the deployment name to the resource group and waits
+// for it, reporting the operations of the deployment as their states change.
+// It returns the properties of the deployment, which hold its outputs, or an
+// *Error with the nested errors of a failed deployment.
+func (d *Deployer) Deploy(ctx context.Context, group, name string, t *Template, mode armresources.DeploymentMode) (*armresources.DeploymentPropertiesExtended, error) {
+	var props *armresources.DeploymentPropertiesExtended
+	start := time.Now()
+	err := func() error {
+		poller, err := d.Deployments.BeginCreateOrUpdate(ctx, group, name, d.deployment(t, mode), nil)
+		if err != nil {
+			return asError("deploy", name, err)
+		}
+		w := &watcher{client: d.Operations, group: group, name: name, out: d.Out, states: map[string]string{}}
+		_, waitErr := lro.Watch(ctx, d.Waiter, lro.Create(lro.Deployment, name), poller, w.watch)
+		// The deployment holds the outputs and, when it failed, the
+		// errors of its operations.
+		resp, err := d.Deployments.Get(ctx, group, name, nil)
+		if err == nil {
+			props = resp.Properties
+		}
+		switch {
+		case props != nil && props.Error != nil:
+			return &Error{Action: "deploy", Name: name, Detail: props.Error}
+		case waitErr != nil:
+			return asError("deploy", name, waitErr)
+		case err != nil:
+			return fmt.Errorf("failed to get deployment %s: %w", name, err)
+		}
+		return nil
+	}()
+	d.Steps.Record("deploy "+name, start, nil, err)
+	return props, err
+}
+
+// watcher prints the operations of a deployment whose state changed since
+// the last call of watch.
+type watcher struct {
+	client      OperationsClient
+	group, name string
+	out         io.Writer
+	states      map[string]string
+	warned      bool
+}
+
+func (w *watcher) watch(ctx context.Context) {
+	if w.client == nil {
+		return
+	}
+	ops, err := List(ctx, w.client, w.group, w.name)
+	if err != nil {
+		if !w.warned {
+			fmt.Fprintf(w.out, "Warning: failed to list the operations of deployment %s: %s\n", w.name, err)
+			w.warned = true
+		}
+		return
+	}
+	// ARM lists the latest operations first.
+	for i := len(ops) - 1; i >= 0; i-- {
+		op := ops[i]
+		if op.OperationID == nil || op.Properties == nil {
+			continue
+		}
+		state := describe(op.Properties)
+		if w.states[*op.OperationID] == state {
+			continue
+		}
+		w.states[*op.OperationID] = state
+		fmt.Fprintf(w.out, "  %s %s: %s\n", provisioningOperation(op.Properties), Target(op.Properties), state)
+	}
+}
+
+// List returns the operations of the deployment name in the resource group.
+func List(ctx context.Context, client OperationsClient, group, name string) ([]*armresources.DeploymentOperation, error) {
+	var ops []*armresources.DeploymentOperation
+	pager := client.NewListPager(group, name, nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the next page of the operations of deployment %s: %w", name, err)
+		}
+		ops = append(ops, page.Value...)
+	}
+	return ops, nil
+}
+
+func provisioningOperation(props *armresources.DeploymentOperationProperties) string {
+	if props.ProvisioningOperation == nil {
+		return string(armresources.ProvisioningOperationNotSpecified)
+	}
+	return string(*props.ProvisioningOperation)
+}
+
+// Target returns the type and name of the resource of an operation.
+func Target(props *armresources.DeploymentOperationProperties) string {
+	if props.TargetResource == nil {
+		return "(no resource)"
+	}
+	return fmt.Sprintf("%s %s", value(props.TargetResource.ResourceType), value(props.TargetResource.ResourceName))
+}
+
+// describe returns the state of an operation, with its status code once it
+// ended and the error of a failed one.
+func describe(props *armresources.DeploymentOperationProperties) string {
+	state := value(props.ProvisioningState)
+	if props.StatusCode != nil {
+		state += " (" + *props.StatusCode + ")"
+	}
+	if detail := StatusError(props.StatusMessage); detail != nil {
+		state += ": " + Summary(detail)
+	}
+	return state
+}
+
+func value(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// Outputs returns the values of the outputs of a deployment by name, without
+// their types.
+func Outputs(props *armresources.DeploymentPropertiesExtended) map[string]interface{} {
+	values := map[string]interface{}{}
+	if props == nil {
+		return values
+	}
+	outputs, _ := props.Outputs.(map[string]interface{})
+	for name, o := range outputs {
+		if output, ok := o.(map[string]interface{}); ok {
+			values[name] = output["value"]
+		}
+	}
+	return values
+}
+
+// WriteOutputs writes the outputs of a deployment as a JSON object.
+func WriteOutputs(w io.Writer, props *armresources.DeploymentPropertiesExtended) error {
+	data, err := json.MarshalIndent(Outputs(props), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+// Error is a validation or deployment that ARM rejected, with the error ARM
+// reported and its nested details.
+type Error struct {
+	// Action is validate or deploy.
+	Action string
+	Name   string
+	Detail *armresources.ErrorResponse
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("failed to %s deployment %s: %s", e.Action, e.Name, Summary(e.Detail))
+}
+
+// asError returns err as an *Error when it is an ARM error response, and
+// wrapped otherwise.
+func asError(action, name string, err error) error {
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) && respErr.RawResponse != nil {
+		if detail := responseError(respErr.RawResponse); detail != nil {
+			return &Error{Action: action, Name: name, Detail: detail}
+		}
+	}
+	return fmt.Errorf("failed to %s deployment %s: %w", action, name, err)
+}
+
+// responseError reads the error of an ARM error response or of a failed
+// operation status.
+func responseError(resp *http.Response) *armresources.ErrorResponse {
+	body, err := runtime.Payload(resp)
+	if err != nil {
+		return nil
+	}
+	var doc struct {
+		Error *armresources.ErrorResponse `json:"error"`
+	}
+	if json.Unmarshal(body, &doc) != nil || doc.Error == nil || doc.Error.Code == nil {
+		return nil
+	}
+	return doc.Error
+}
+
+// StatusError returns the error in the status message of a deployment
+// operation, or nil if it has none. The message is the response of the
+// resource provider, such as {"status": "Failed", "error": {...}}, either as
+// an object or as a JSON string.
+func StatusError(message interface{}) *armresources.ErrorResponse {
+	data, ok := message.(string)
+	if !ok {
+		raw, err := json.Marshal(message)
+		if err != nil {
+			return nil
+		}
+		data = string(raw)
+	}
+	var doc struct {
+		Error *armresources.ErrorResponse `json:"error"`
+	}
+	if json.Unmarshal([]byte(data), &doc) != nil || doc.Error == nil || doc.Error.Code == nil {
+		return nil
+	}
+	return doc.Error
+}
+
+// Summary returns the code and message of e on one line.
+func Summary(e *armresources.ErrorResponse) string {
+	if e == nil {
+		return "unknown error"
+	}
+	msg := strings.Join(strings.Fields(value(e.Message)), " ")
+	switch {
+	case msg == "":
+		return value(e.Code)
+	case e.Code == nil:
+		return msg
+	}
+	return *e.Code + ": " + msg
+}
+
+// Expand returns the details of e, with the errors that ARM nests as JSON in
+// the messages of details, such as the status messages of failed operations,
+// turned into details of their own.
+func Expand(e *armresources.ErrorResponse) []*armresources.ErrorResponse {
+	var details []*armresources.ErrorResponse
+	for _, detail := range e.Details {
+		if detail == nil {
+			continue
+		}
+		if inner := StatusError(value(detail.Message)); inner != nil {
+			detail = &armresources.ErrorResponse{Code: detail.Code, Target: detail.Target, Details: []*armresources.ErrorResponse{inner}}
+		}
+		details = append(details, detail)
+	}
+	return details
+}
+
+// WriteError writes e and its nested details as an indented tree.
+func WriteError(w io.Writer, e *armresources.ErrorResponse) {
+	writeError(w, e, "")
+}
+
+func writeError(w io.Writer, e *armresources.ErrorResponse, indent string) {
+	line := indent + Summary(e)
+	if e.Target != nil && *e.Target != "" {
+		line += " (target " + *e.Target + ")"
+	}
+	fmt.Fprintln(w, line)
+	for _, info := range e.AdditionalInfo {
+		if info == nil {
+			continue
+		}
+		data, _ := json.Marshal(info.Info)
+		fmt.Fprintf(w, "%s  %s: %s\n", indent, value(info.Type), data)
+	}
+	for _, detail := range Expand(e) {
+		writeError(w, detail, indent+"  ")
+	}
+}
+
+// ResourceNames returns the type and name of the resources of refs,
+// sorted, for the validated and output resources of a deployment.
+func ResourceNames(refs []*armresources.ResourceReference) []string {
+	var names []string
+	for _, ref := range refs {
+		if ref == nil || ref.ID == nil {
+			continue
+		}
+		names = append(names, resourceName(*ref.ID))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resourceName returns "type name" of a resource ID such as
+// /subscriptions/s/resourceGroups/g/providers/Microsoft.Network/virtualNetworks/vnet.
+func resourceName(id string) string {
+	i := strings.LastIndex(strings.ToLower(id), "/providers/")
+	if i < 0 {
+		return id
+	}
+	segments := strings.Split(id[i+len("/providers/"):], "/")
+	if len(segments) < 3 {
+		return id
+	}
+	types, names := []string{segments[0]}, []string{}
+	for j := 1; j+1 < len(segments); j += 2 {
+		types = append(types, segments[j])
+		names = append(names, segments[j+1])
+	}
+	return strings.Join(types, "/") + " " + strings.Join(names, "/")
+}
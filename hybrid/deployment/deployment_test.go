@@ -0,0 +1,46 @@
+package deployment
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/resources/armresources"
+)
+
+func TestInnermost(t *testing.T) {
+	// ARM nests the status messages of failed operations as JSON strings
+	// in the messages of the details.
+	doc := `{
+		"code": "DeploymentFailed",
+		"details": [
+			{"code": "Conflict", "message": "{\"status\": \"Failed\", \"error\": {\"code\": \"DeploymentFailed\", \"details\": [{\"code\": \"BadRequest\", \"message\": \"{\\\"error\\\": {\\\"code\\\": \\\"InvalidParameter\\\", \\\"message\\\": \\\"bad size\\\"}}\"}]}}"},
+			{"code": "NotFound", "message": "The subnet was not found."}
+		]
+	}`
+	var e armresources.ErrorResponse
+	if err := json.Unmarshal([]byte(doc), &e); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, inner := range Innermost(&e) {
+		got = append(got, Summary(inner))
+	}
+	want := []string{"InvalidParameter: bad size", "NotFound: The subnet was not found."}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Innermost = %q, want %q", got, want)
+	}
+}
+
+func TestResourceNames(t *testing.T) {
+	id := func(s string) *armresources.ResourceReference { return &armresources.ResourceReference{ID: &s} }
+	got := ResourceNames([]*armresources.ResourceReference{
+		id("/subscriptions/s/resourceGroups/g/providers/Microsoft.Network/virtualNetworks/vnet/subnets/default"),
+		id("/subscriptions/s/resourceGroups/g/providers/Microsoft.Network/networkSecurityGroups/nsg"),
+		nil,
+	})
+	want := []string{"Microsoft.Network/networkSecurityGroups nsg", "Microsoft.Network/virtualNetworks/subnets vnet/default"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ResourceNames = %q, want %q", got, want)
+	}
+}
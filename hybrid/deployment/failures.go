@@ -0,0 +1,207 @@
+package deployment
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/resources/armresources"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+)
+
+// nestedType is the resource type of the deployments a template nests.
+const nestedType = "Microsoft.Resources/deployments"
+
+// Failures are the failed operations of a deployment, with those of the
+// deployments nested in it.
+type Failures struct {
+	Deployment string `json:"deployment"`
+	Group      string `json:"resourceGroup"`
+	// Operations is the number of operations listed, those of the nested
+	// deployments that failed included.
+	Operations int        `json:"operations"`
+	Failed     []*Failure `json:"failed"`
+}
+
+// Failure is a failed operation of a deployment.
+type Failure struct {
+	// Deployments are the names of the deployment of the operation and of
+	// the deployments it is nested in, outermost first.
+	Deployments  []string `json:"deployments"`
+	OperationID  string   `json:"operationId"`
+	Operation    string   `json:"provisioningOperation"`
+	ResourceType string   `json:"resourceType,omitempty"`
+	ResourceName string   `json:"resourceName,omitempty"`
+	ResourceID   string   `json:"resourceId,omitempty"`
+	StatusCode   string   `json:"statusCode,omitempty"`
+	// Error is the error of the status message of the operation, nil when
+	// it has none.
+	Error *armresources.ErrorResponse `json:"error,omitempty"`
+	// Nested are the failed operations of the nested deployment the
+	// operation deployed, if it deployed one.
+	Nested []*Failure `json:"nested,omitempty"`
+}
+
+// Target returns the type and name of the resource of f.
+func (f *Failure) Target() string {
+	if f.ResourceType == "" && f.ResourceName == "" {
+		return "(no resource)"
+	}
+	return f.ResourceType + " " + f.ResourceName
+}
+
+// ListFailures lists the operations of the deployment name in the resource
+// group and returns those that failed. The failed operations of nested
+// deployments are listed as well, under the operation that deployed them.
+func ListFailures(ctx context.Context, client OperationsClient, group, name string) (*Failures, error) {
+	f := &Failures{Deployment: name, Group: group}
+	var err error
+	f.Failed, err = f.list(ctx, client, group, []string{name}, map[string]bool{})
+	return f, err
+}
+
+// list returns the failed operations of the last deployment of path. seen
+// are the deployments listed so far, which a template cannot nest in
+// themselves, but which are never listed twice all the same.
+func (f *Failures) list(ctx context.Context, client OperationsClient, group string, path []string, seen map[string]bool) ([]*Failure, error) {
+	name := path[len(path)-1]
+	seen[strings.ToLower(group+"/"+name)] = true
+	ops, err := List(ctx, client, group, name)
+	if err != nil {
+		return nil, err
+	}
+	f.Operations += len(ops)
+	sort.SliceStable(ops, func(i, j int) bool {
+		a, b := ops[i].Properties, ops[j].Properties
+		return a != nil && b != nil && a.Timestamp != nil && b.Timestamp != nil && a.Timestamp.Before(*b.Timestamp)
+	})
+	var failed []*Failure
+	for _, op := range ops {
+		props := op.Properties
+		if props == nil || props.ProvisioningState == nil || !strings.EqualFold(*props.ProvisioningState, "Failed") {
+			continue
+		}
+		failure := &Failure{
+			Deployments: path,
+			OperationID: value(op.OperationID),
+			Operation:   provisioningOperation(props),
+			StatusCode:  value(props.StatusCode),
+			Error:       StatusError(props.StatusMessage),
+		}
+		if target := props.TargetResource; target != nil {
+			failure.ResourceType = value(target.ResourceType)
+			failure.ResourceName = value(target.ResourceName)
+			failure.ResourceID = value(target.ID)
+		}
+		failed = append(failed, failure)
+		if !strings.EqualFold(failure.ResourceType, nestedType) {
+			continue
+		}
+		// A nested deployment may deploy to another resource group, which
+		// its ID tells.
+		nestedGroup := group
+		if id, err := arm.ParseResourceID(failure.ResourceID); err == nil && id.ResourceGroupName != "" {
+			nestedGroup = id.ResourceGroupName
+		}
+		if seen[strings.ToLower(nestedGroup+"/"+failure.ResourceName)] {
+			continue
+		}
+		nestedPath := append(append([]string{}, path...), failure.ResourceName)
+		// The error of the operation holds the errors of the nested
+		// deployment as well, so one that cannot be listed is still
+		// explained.
+		failure.Nested, _ = f.list(ctx, client, nestedGroup, nestedPath, seen)
+	}
+	return failed, nil
+}
+
+// Cause is an error that made a deployment fail, with the failed operation
+// it was reported by.
+type Cause struct {
+	Failure *Failure
+	Error   *armresources.ErrorResponse
+}
+
+// RootCauses returns the errors of the failed operations that explain the
+// failure of the deployment: the innermost errors of the operations that
+// failed themselves rather than because a nested deployment failed.
+func (f *Failures) RootCauses() []*Cause {
+	var causes []*Cause
+	var walk func(failed []*Failure)
+	walk = func(failed []*Failure) {
+		for _, failure := range failed {
+			if len(failure.Nested) > 0 {
+				walk(failure.Nested)
+				continue
+			}
+			if failure.Error == nil {
+				causes = append(causes, &Cause{Failure: failure, Error: &armresources.ErrorResponse{Code: &failure.StatusCode}})
+				continue
+			}
+			for _, e := range Innermost(failure.Error) {
+				causes = append(causes, &Cause{Failure: failure, Error: e})
+			}
+		}
+	}
+	walk(f.Failed)
+	return causes
+}
+
+// Innermost returns the errors nested in e that have no details of their
+// own, with the errors ARM nests as JSON expanded, or e when it has no
+// details.
+func Innermost(e *armresources.ErrorResponse) []*armresources.ErrorResponse {
+	details := Expand(e)
+	if len(details) == 0 {
+		return []*armresources.ErrorResponse{e}
+	}
+	var errs []*armresources.ErrorResponse
+	for _, detail := range details {
+		errs = append(errs, Innermost(detail)...)
+	}
+	return errs
+}
+
+// WriteFailures writes the failed operations of f as a tree, each with the
+// code of its error, followed by the root causes.
+func WriteFailures(w io.Writer, f *Failures) {
+	fmt.Fprintf(w, "%d of the %d operations of deployment %s failed:\n", count(f.Failed), f.Operations, f.Deployment)
+	writeFailures(w, f.Failed, "  ")
+	causes := f.RootCauses()
+	if len(causes) == 1 {
+		fmt.Fprintf(w, "\nRoot cause:\n")
+	} else {
+		fmt.Fprintf(w, "\n%d root causes:\n", len(causes))
+	}
+	for _, c := range causes {
+		fmt.Fprintf(w, "  %s\n", Summary(c.Error))
+		fmt.Fprintf(w, "    at %s, deployment %s", c.Failure.Target(), strings.Join(c.Failure.Deployments, " > "))
+		if c.Error.Target != nil && *c.Error.Target != "" {
+			fmt.Fprintf(w, ", target %s", *c.Error.Target)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+func writeFailures(w io.Writer, failed []*Failure, indent string) {
+	for _, failure := range failed {
+		status := failure.StatusCode
+		if failure.Error != nil && failure.Error.Code != nil {
+			status = strings.TrimSpace(status + " " + *failure.Error.Code)
+		}
+		fmt.Fprintf(w, "%s%s %s: %s\n", indent, failure.Operation, failure.Target(), status)
+		writeFailures(w, failure.Nested, indent+"  ")
+	}
+}
+
+// count returns the number of failed operations, those of nested
+// deployments included.
+func count(failed []*Failure) int {
+	n := len(failed)
+	for _, failure := range failed {
+		n += count(failure.Nested)
+	}
+	return n
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/resources/armresources"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/checkpoint"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/converge"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/lro"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/output"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/session"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/tags"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/hybrid/spec"
+)
+
+// driftExitCode is the exit code of diff when resources drifted or are
+// missing, which scheduled drift checks tell apart from the failures of 1.
+const driftExitCode = 3
+
+// ownerTags are the tags of a run that tell who created its resources and
+// when, which differ when someone else checks or reconciles them.
+var ownerTags = []string{tags.Creator, tags.CreatedAt, tags.ExpiresAt}
+
+func runDiff(fs *flag.FlagSet, f *session.Flags, args []string) int {
+	state := fs.String("state", "", "compare with the state of the run -runID of this sample or environment instead of a spec")
+	parallel := fs.Int("parallel", 4, "number of resources compared at a time")
+	args = parseArgs(fs, args)
+	if (len(args) == 1) == (*state != "") || len(args) > 1 || *parallel < 1 {
+		fmt.Fprintf(os.Stderr, "Usage: hybrid diff <spec> [-parallel n] [flags]\n       hybrid diff -state <sample> -runID <run ID> [flags]\n")
+		return 2
+	}
+	if f.RunID == "" {
+		fmt.Fprintf(os.Stderr, "hybrid diff: -runID is required, since the names of the resources include the ID of the run\n")
+		return 2
+	}
+	var env *spec.Spec
+	if len(args) == 1 {
+		var err error
+		if env, err = spec.Load(args[0]); err != nil {
+			fmt.Fprintf(os.Stderr, "hybrid diff: %s\n", err)
+			return 2
+		}
+	}
+	// The diff keeps a state of its own, so that it never overwrites the
+	// state of the run it compares with.
+	s, err := session.Open("diff", f, transport)
+	if err != nil {
+		fmt.Fprintf(f.Log(), "%s\n", err)
+		return 1
+	}
+	if s.Lists.Format != output.Table && s.Lists.Format != output.JSON {
+		fmt.Fprintf(s.Log, "-output %s is not supported by diff, use table or json\n", s.Lists.Format)
+		s.Exit(2)
+	}
+	client, err := armresources.NewClient(s.Config.SubscriptionId, s.Credential, &s.Options)
+	if err != nil {
+		fmt.Fprintf(s.Log, "failed to create the resource client: %s\n", err)
+		s.Exit(1)
+	}
+
+	var results []*spec.Result
+	source := ""
+	if env != nil {
+		source = "environment " + env.Name
+		// The resources carry the tags of the environment's own runs.
+		runTags := map[string]*string{}
+		for key, value := range s.Tags {
+			runTags[key] = value
+		}
+		runTags[tags.Sample] = &env.Name
+		r := &spec.Runner{
+			Client:         client,
+			SubscriptionID: s.Config.SubscriptionId,
+			Builtins:       spec.Builtins{RunID: s.Names.RunID(), Location: s.Config.Location},
+			Tags:           runTags,
+			Owners:         ownerTags,
+			Steps:          s.Steps,
+			Out:            s.Out,
+			Parallel:       *parallel,
+		}
+		results = r.Diff(s.Context(), env)
+	} else {
+		source = "the state of run " + s.Names.RunID() + " of " + *state
+		last, err := s.LastState(*state)
+		if err != nil {
+			fmt.Fprintf(s.Log, "%s\n", err)
+			s.Exit(1)
+		}
+		results = diffState(s, client, last)
+	}
+
+	code := 0
+	for _, r := range results {
+		switch r.Action {
+		case spec.Failed:
+			code = 1
+		case spec.Drifted, spec.Missing, spec.Unchecked:
+			if code == 0 {
+				code = driftExitCode
+			}
+		}
+	}
+	if s.Lists.Format == output.JSON {
+		if err := writeDiffJSON(s.Lists.W, source, results); err != nil {
+			fmt.Fprintf(s.Log, "%s\n", err)
+			s.Exit(1)
+		}
+	} else {
+		printDiff(s.Log, source, results)
+	}
+	s.Exit(code)
+	return code
+}
+
+// diffState compares the resources in the state of an earlier run with the
+// bodies the run last sent for them.
+func diffState(s *session.Session, client *armresources.Client, state *checkpoint.State) []*spec.Result {
+	var results []*spec.Result
+	for _, res := range state.Resources {
+		result := &spec.Result{ID: res.ID}
+		if id, err := arm.ParseResourceID(res.ID); err == nil {
+			result.Key, result.Type, result.Name = id.Name, id.ResourceType.String(), id.Name
+		}
+		results = append(results, result)
+		what := lro.KindOf(result.Type).Label() + " " + result.Name
+		start := time.Now()
+		err := func() error {
+			resp, err := client.GetByID(s.Context(), res.ID, res.APIVersion, nil)
+			switch {
+			case converge.NotFound(err):
+				result.Action = spec.Missing
+				return nil
+			case err != nil:
+				return fmt.Errorf("failed to get %s: %w", what, err)
+			}
+			result.Action = spec.InSync
+			desired, ok := state.Desired[res.ID]
+			if !ok {
+				return nil
+			}
+			var want interface{}
+			if err := json.Unmarshal(desired, &want); err != nil {
+				return fmt.Errorf("invalid state of %s: %w", what, err)
+			}
+			result.Differences, err = converge.Compare(resp.GenericResource, want, "location")
+			if len(result.Differences) > 0 {
+				result.Action = spec.Drifted
+			}
+			return err
+		}()
+		s.Steps.Record("diff "+what, start, []string{res.ID}, err)
+		if err != nil {
+			result.Action, result.Err = spec.Failed, err
+		}
+	}
+	return results
+}
+
+// printDiff writes the status of every resource and the differences of the
+// drifted ones.
+func printDiff(w io.Writer, source string, results []*spec.Result) {
+	fmt.Fprintf(w, "Comparing the resources with %s\n", source)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tTYPE\tNAME\tSTATUS\tERROR")
+	changed := 0
+	for _, r := range results {
+		msg := "-"
+		if r.Err != nil {
+			msg = r.Err.Error()
+		}
+		if r.Action != spec.InSync {
+			changed++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", r.Key, r.Type, r.Name, r.Action, msg)
+	}
+	tw.Flush()
+	for _, r := range results {
+		if len(r.Differences) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n%s %s (%s):\n", lro.KindOf(r.Type).Label(), r.Name, r.Key)
+		for _, d := range r.Differences {
+			change := "update in place"
+			if d.Immutable {
+				change = "replace"
+			}
+			fmt.Fprintf(w, "  %s: live %s, desired %s (%s)\n", d.Path, orNone(d.Have), orNone(d.Want), change)
+		}
+	}
+	fmt.Fprintln(w)
+	if changed == 0 {
+		fmt.Fprintf(w, "No drift: all %d resources are in sync\n", len(results))
+		return
+	}
+	fmt.Fprintf(w, "%d of %d resources are not in sync\n", changed, len(results))
+}
+
+func orNone(value string) string {
+	if value == "" {
+		return "none"
+	}
+	return value
+}
+
+// driftReport is the JSON document of diff with -output json.
+type driftReport struct {
+	Source    string          `json:"source"`
+	InSync    bool            `json:"inSync"`
+	Resources []driftResource `json:"resources"`
+}
+
+type driftResource struct {
+	Key         string            `json:"key"`
+	Type        string            `json:"type"`
+	Name        string            `json:"name"`
+	ID          string            `json:"id"`
+	Status      string            `json:"status"`
+	Error       string            `json:"error,omitempty"`
+	Differences []driftDifference `json:"differences"`
+}
+
+// driftDifference is a converge.Difference with its values as JSON rather
+// than as strings that hold JSON.
+type driftDifference struct {
+	Path      string          `json:"path"`
+	Live      json.RawMessage `json:"live"`
+	Desired   json.RawMessage `json:"desired"`
+	Immutable bool            `json:"immutable"`
+}
+
+func writeDiffJSON(w io.Writer, source string, results []*spec.Result) error {
+	report := driftReport{Source: source, InSync: true, Resources: []driftResource{}}
+	for _, r := range results {
+		res := driftResource{Key: r.Key, Type: r.Type, Name: r.Name, ID: r.ID, Status: r.Action, Differences: []driftDifference{}}
+		if r.Err != nil {
+			res.Error = r.Err.Error()
+		}
+		for _, d := range r.Differences {
+			res.Differences = append(res.Differences, driftDifference{Path: d.Path, Live: rawJSON(d.Have), Desired: rawJSON(d.Want), Immutable: d.Immutable})
+		}
+		if r.Action != spec.InSync {
+			report.InSync = false
+		}
+		report.Resources = append(report.Resources, res)
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", strings.TrimSpace(string(data)))
+	return err
+}
+
+func rawJSON(value string) json.RawMessage {
+	if value == "" {
+		return json.RawMessage("null")
+	}
+	return json.RawMessage(value)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/resources/armresources"
+
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/session"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/hybrid/spec"
+)
+
+func runApply(fs *flag.FlagSet, f *session.Flags, args []string) int {
+	return runEnvironment("apply", fs, f, args)
+}
+
+func runDestroy(fs *flag.FlagSet, f *session.Flags, args []string) int {
+	return runEnvironment("destroy", fs, f, args)
+}
+
+// runEnvironment applies or destroys the environment of a spec file.
+func runEnvironment(verb string, fs *flag.FlagSet, f *session.Flags, args []string) int {
+	parallel := fs.Int("parallel", 4, "number of resources created or deleted at a time")
+	if args = parseArgs(fs, args); len(args) != 1 || *parallel < 1 {
+		fmt.Fprintf(os.Stderr, "Usage: hybrid %s <spec> [-parallel n] [flags]\n", verb)
+		return 2
+	}
+	if verb == "destroy" && f.RunID == "" {
+		fmt.Fprintf(os.Stderr, "hybrid destroy: -runID is required, since the names of the resources may include the ID of the run\n")
+		return 2
+	}
+	env, err := spec.Load(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hybrid %s: %s\n", verb, err)
+		return 2
+	}
+	s, err := session.Open(env.Name, f, transport)
+	if err != nil {
+		fmt.Fprintf(f.Log(), "%s\n", err)
+		return 1
+	}
+	client, err := armresources.NewClient(s.Config.SubscriptionId, s.Credential, &s.Options)
+	if err != nil {
+		fmt.Fprintf(s.Log, "failed to create the resource client: %s\n", err)
+		s.Exit(1)
+	}
+	r := &spec.Runner{
+		Client:         client,
+		SubscriptionID: s.Config.SubscriptionId,
+		Builtins:       spec.Builtins{RunID: s.Names.RunID(), Location: s.Config.Location},
+		Tags:           s.Tags,
+		Waiter:         s.Waiter,
+		Converge:       s.Converge,
+		Steps:          s.Steps,
+		Out:            s.Out,
+		Parallel:       *parallel,
+	}
+
+	var results []*spec.Result
+	if verb == "apply" {
+		results, err = r.Apply(s.Context(), env)
+	} else {
+		// The resources were created by an earlier run, which left no
+		// record of them, so the guard of the session goes by their tags
+		// alone.
+		s.Guard.Created = nil
+		group := env.GroupName(r.Builtins)
+		if !s.DryRun() && !s.Confirm(fmt.Sprintf("Delete the %d resources of environment %s and resource group %s?", len(env.Resources), env.Name, group)) {
+			fmt.Fprintln(s.Log, "Nothing was deleted")
+			s.Exit(0)
+		}
+		results, err = r.Destroy(s.Context(), env)
+	}
+	fmt.Fprintln(s.Log)
+	printEnvironment(s.Log, results)
+	if err != nil {
+		fmt.Fprintf(s.Log, "%s\n", err)
+		s.Exit(1)
+	}
+	s.Exit(0)
+	return 0
+}
+
+// printEnvironment writes what apply or destroy did about every resource.
+func printEnvironment(w io.Writer, results []*spec.Result) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tTYPE\tNAME\tRESULT\tERROR")
+	for _, r := range results {
+		action, msg := r.Action, "-"
+		if action == "" {
+			action = "-"
+		}
+		if r.Err != nil {
+			msg = r.Err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", r.Key, r.Type, r.Name, action, msg)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/resources/armresources"
+
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/converge"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/output"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/session"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/hybrid/deployment"
+)
+
+func runFailures(fs *flag.FlagSet, f *session.Flags, args []string) int {
+	group := fs.String("group", "", "resource group of the deployment (default "+templateGroup+" of the run -runID)")
+	if args = parseArgs(fs, args); len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: hybrid failures <deployment> [-group name] [flags]\n")
+		return 2
+	}
+	if *group == "" && f.RunID == "" {
+		fmt.Fprintf(os.Stderr, "hybrid failures: -group or -runID is required, since the name of the resource group includes the ID of the run\n")
+		return 2
+	}
+	name := args[0]
+	s, err := session.Open("failures", f, transport)
+	if err != nil {
+		fmt.Fprintf(f.Log(), "%s\n", err)
+		return 1
+	}
+	if s.Lists.Format != output.Table && s.Lists.Format != output.JSON {
+		fmt.Fprintf(s.Log, "-output %s is not supported by failures, use table or json\n", s.Lists.Format)
+		s.Exit(2)
+	}
+	if *group == "" {
+		if *group, err = s.ResourceGroup(templateGroup); err != nil {
+			fmt.Fprintf(s.Log, "%s\n", err)
+			s.Exit(2)
+		}
+	}
+	deployments, err := armresources.NewDeploymentsClient(s.Config.SubscriptionId, s.Credential, &s.Options)
+	if err != nil {
+		fmt.Fprintf(s.Log, "failed to create the deployment client: %s\n", err)
+		s.Exit(1)
+	}
+	operations, err := armresources.NewDeploymentOperationsClient(s.Config.SubscriptionId, s.Credential, &s.Options)
+	if err != nil {
+		fmt.Fprintf(s.Log, "failed to create the deployment operation client: %s\n", err)
+		s.Exit(1)
+	}
+	ctx := s.Context()
+
+	resp, err := deployments.Get(ctx, *group, name, nil)
+	switch {
+	case converge.NotFound(err):
+		fmt.Fprintf(s.Log, "Deployment %s does not exist in resource group %s\n", name, *group)
+		s.Exit(1)
+	case err != nil:
+		fmt.Fprintf(s.Log, "failed to get deployment %s: %s\n", name, err)
+		s.Exit(1)
+	}
+	props := resp.Properties
+	if props == nil {
+		props = &armresources.DeploymentPropertiesExtended{}
+	}
+	var failures *deployment.Failures
+	err = s.Steps.Step("list the operations of deployment "+name, func() error {
+		failures, err = deployment.ListFailures(ctx, operations, *group, name)
+		return err
+	})
+	if err != nil {
+		fmt.Fprintf(s.Log, "%s\n", err)
+		s.Exit(1)
+	}
+	state := output.String(props.ProvisioningState)
+	if s.Lists.Format == output.JSON {
+		if err := writeFailuresJSON(s.Lists.W, state, props.Error, failures); err != nil {
+			fmt.Fprintf(s.Log, "%s\n", err)
+			s.Exit(1)
+		}
+		s.Exit(0)
+	}
+
+	w := s.Lists.W
+	fmt.Fprintf(w, "Deployment %s of resource group %s: %s\n", name, *group, state)
+	switch {
+	case len(failures.Failed) > 0:
+		deployment.WriteFailures(w, failures)
+	case props.Error != nil:
+		// The deployment failed before any of its operations did, such as
+		// on an invalid template.
+		fmt.Fprintf(w, "None of the %d operations of deployment %s failed, the deployment failed with:\n", failures.Operations, name)
+		deployment.WriteError(w, props.Error)
+	default:
+		fmt.Fprintf(w, "None of the %d operations of deployment %s failed\n", failures.Operations, name)
+	}
+	s.Exit(0)
+	return 0
+}
+
+// failuresDocument is the JSON form of the failures of a deployment.
+type failuresDocument struct {
+	*deployment.Failures
+	ProvisioningState string                      `json:"provisioningState"`
+	Error             *armresources.ErrorResponse `json:"error,omitempty"`
+	RootCauses        []failureCause              `json:"rootCauses"`
+}
+
+type failureCause struct {
+	Deployments  []string `json:"deployments"`
+	ResourceType string   `json:"resourceType,omitempty"`
+	ResourceName string   `json:"resourceName,omitempty"`
+	Code         string   `json:"code,omitempty"`
+	Message      string   `json:"message,omitempty"`
+}
+
+// writeFailuresJSON writes the failed operations of a deployment and their
+// root causes as a JSON document.
+func writeFailuresJSON(w io.Writer, state string, deploymentError *armresources.ErrorResponse, failures *deployment.Failures) error {
+	doc := failuresDocument{Failures: failures, ProvisioningState: state, Error: deploymentError, RootCauses: []failureCause{}}
+	for _, c := range failures.RootCauses() {
+		doc.RootCauses = append(doc.RootCauses, failureCause{
+			Deployments:  c.Failure.Deployments,
+			ResourceType: c.Failure.ResourceType,
+			ResourceName: c.Failure.ResourceName,
+			Code:         output.String(c.Error.Code),
+			Message:      output.String(c.Error.Message),
+		})
+	}
+	if doc.Failed == nil {
+		doc.Failed = []*deployment.Failure{}
+	}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
@@ -0,0 +1,36 @@
+module github.com/Azure-Samples/Hybrid-Golang-Samples/hybrid
+
+go 1.18
+
+require (
+	github.com/Azure-Samples/Hybrid-Golang-Samples/common v0.0.0
+	github.com/Azure-Samples/Hybrid-Golang-Samples/keyvault v0.0.0
+	github.com/Azure-Samples/Hybrid-Golang-Samples/resourcemanager v0.0.0
+	github.com/Azure-Samples/Hybrid-Golang-Samples/storage v0.0.0
+	github.com/Azure-Samples/Hybrid-Golang-Samples/vm v0.0.0
+	github.com/Azure/azure-sdk-for-go/profile/p20200901 v0.1.0
+	github.com/Azure/azure-sdk-for-go/sdk/azcore v1.5.0-beta.1
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/Azure/azure-sdk-for-go/sdk/azidentity v1.3.0-beta.4 // indirect
+	github.com/Azure/azure-sdk-for-go/sdk/internal v1.2.0 // indirect
+	github.com/AzureAD/microsoft-authentication-library-for-go v0.9.0 // indirect
+	github.com/golang-jwt/jwt/v4 v4.4.3 // indirect
+	github.com/google/uuid v1.3.0 // indirect
+	github.com/kylelemons/godebug v1.1.0 // indirect
+	github.com/pkg/browser v0.0.0-20210911075715-681adbf594b8 // indirect
+	golang.org/x/crypto v0.0.0-20220722155217-630584e8d5aa // indirect
+	golang.org/x/net v0.7.0 // indirect
+	golang.org/x/sys v0.5.0 // indirect
+	golang.org/x/text v0.7.0 // indirect
+)
+
+replace (
+	github.com/Azure-Samples/Hybrid-Golang-Samples/common => ../common
+	github.com/Azure-Samples/Hybrid-Golang-Samples/keyvault => ../keyvault
+	github.com/Azure-Samples/Hybrid-Golang-Samples/resourcemanager => ../resourcemanager
+	github.com/Azure-Samples/Hybrid-Golang-Samples/storage => ../storage
+	github.com/Azure-Samples/Hybrid-Golang-Samples/vm => ../vm
+)
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/resources/armresources"
+
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/output"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/session"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/tags"
+)
+
+// staleGroup is a resource group the janitor deletes.
+type staleGroup struct {
+	id     string
+	name   string
+	runID  string
+	sample string
+	// created and expires are zero when the group has no such tag.
+	created time.Time
+	expires time.Time
+	reason  string
+
+	deleted  bool
+	duration time.Duration
+	err      error
+}
+
+// janitorRules select the stale resource groups.
+type janitorRules struct {
+	now    time.Time
+	maxAge time.Duration
+	// patterns select the groups without the tags of the samples by name.
+	patterns []string
+	// creator, when set, limits the groups to the tagged groups of this
+	// object.
+	creator string
+}
+
+func runJanitor(fs *flag.FlagSet, f *session.Flags, args []string) int {
+	maxAge := fs.Duration("maxAge", 24*time.Hour, "delete the groups of runs without an expiry that started longer ago than this; 0 for never")
+	match := fs.String("match", "", "comma-separated name patterns, such as TestGo*ResourceGroup*, that select groups without the tags of the samples as well")
+	parallel := fs.Int("parallel", 4, "number of groups deleted at a time")
+	if args = parseArgs(fs, args); len(args) != 0 || *parallel < 1 {
+		fmt.Fprintf(os.Stderr, "Usage: hybrid janitor [-maxAge d] [-match patterns] [-parallel n] [-force] [-yes] [-dry-run] [flags]\n")
+		return 2
+	}
+	if *match != "" && !f.Force {
+		fmt.Fprintf(os.Stderr, "hybrid janitor: -match requires -force, since the groups it selects have no tags that tell who owns them\n")
+		return 2
+	}
+	rules := janitorRules{now: time.Now(), maxAge: *maxAge}
+	if *match != "" {
+		for _, pattern := range strings.Split(*match, ",") {
+			pattern = strings.TrimSpace(pattern)
+			if _, err := path.Match(pattern, ""); err != nil {
+				fmt.Fprintf(os.Stderr, "hybrid janitor: invalid pattern %q: %s\n", pattern, err)
+				return 2
+			}
+			rules.patterns = append(rules.patterns, pattern)
+		}
+	}
+	// The janitor lists the groups even in a dry run, it only skips the
+	// deletions.
+	dryRun := f.TakeDryRun()
+
+	s, err := session.Open("janitor", f, transport)
+	if err != nil {
+		fmt.Fprintf(f.Log(), "%s\n", err)
+		return 1
+	}
+	// The groups of other service principals are only selected with -force,
+	// the guard of the session refuses to delete them otherwise. It accepts
+	// the groups of every earlier run, of which the janitor has no record.
+	if !f.Force {
+		rules.creator = s.Config.ObjectId
+	}
+	s.Guard.AnyRun = true
+	s.Guard.Created = nil
+	groups, err := armresources.NewResourceGroupsClient(s.Config.SubscriptionId, s.Credential, &s.Options)
+	if err != nil {
+		fmt.Fprintf(s.Log, "failed to create the resource group client: %s\n", err)
+		s.Exit(1)
+	}
+
+	var stale []*staleGroup
+	err = s.Steps.Step("list resource groups", func() (err error) {
+		stale, err = findStale(s.Context(), groups, rules)
+		return err
+	})
+	if err != nil {
+		fmt.Fprintf(s.Log, "%s\n", err)
+		s.Exit(1)
+	}
+	if len(stale) == 0 {
+		fmt.Fprintln(s.Log, "No stale resource groups found")
+		s.Exit(0)
+	}
+	fmt.Fprintf(s.Log, "Found %d stale resource groups:\n", len(stale))
+	printStale(s.Log, stale)
+	switch {
+	case dryRun:
+		fmt.Fprintln(s.Log, "Dry run, nothing was deleted")
+		s.Exit(0)
+	case !s.Confirm(fmt.Sprintf("Delete these %d resource groups with everything in them?", len(stale))):
+		fmt.Fprintln(s.Log, "Nothing was deleted")
+		s.Exit(0)
+	}
+	// The deletions were confirmed as a whole, including those -force
+	// allows.
+	s.Guard.Confirm = nil
+
+	slots := make(chan struct{}, *parallel)
+	var wg sync.WaitGroup
+	for _, g := range stale {
+		wg.Add(1)
+		go func(g *staleGroup) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			// The deletions run at the same time, so they are recorded
+			// once done rather than as steps of their own.
+			start := time.Now()
+			g.err = deleteGroup(s, groups, g.name)
+			g.deleted = g.err == nil
+			g.duration = time.Since(start)
+			s.Steps.Record("delete resource group "+g.name, start, []string{g.id}, g.err)
+		}(g)
+	}
+	wg.Wait()
+
+	fmt.Fprintln(s.Log)
+	code := printJanitorSummary(s.Log, stale)
+	s.Exit(code)
+	return code
+}
+
+// findStale lists the resource groups of the subscription and returns those
+// rules select, in the order of the list.
+func findStale(ctx context.Context, groups *armresources.ResourceGroupsClient, rules janitorRules) ([]*staleGroup, error) {
+	var stale []*staleGroup
+	pager := groups.NewListPager(nil)
+	for pager.More() {
+		resp, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the next page of the resource group list: %w", err)
+		}
+		for _, rg := range resp.Value {
+			if g := rules.check(rg); g != nil {
+				stale = append(stale, g)
+			}
+		}
+	}
+	return stale, nil
+}
+
+// check returns the stale group of rg, or nil if rg is to be kept.
+func (r janitorRules) check(rg *armresources.ResourceGroup) *staleGroup {
+	if rg.Name == nil {
+		return nil
+	}
+	if rg.Properties != nil && rg.Properties.ProvisioningState != nil && *rg.Properties.ProvisioningState == "Deleting" {
+		return nil
+	}
+	g := &staleGroup{id: output.String(rg.ID), name: *rg.Name}
+	if runID := rg.Tags[tags.RunID]; runID != nil {
+		if creator := rg.Tags[tags.Creator]; r.creator != "" && (creator == nil || !strings.EqualFold(*creator, r.creator)) {
+			return nil
+		}
+		g.runID = *runID
+		if sample := rg.Tags[tags.Sample]; sample != nil {
+			g.sample = *sample
+		}
+		g.created, _ = tags.Time(rg.Tags, tags.CreatedAt)
+		expires, hasExpiry := tags.Time(rg.Tags, tags.ExpiresAt)
+		g.expires = expires
+		switch {
+		case hasExpiry && expires.Before(r.now):
+			g.reason = "expired"
+		case hasExpiry:
+			return nil
+		case r.maxAge > 0 && !g.created.IsZero() && r.now.Sub(g.created) > r.maxAge:
+			g.reason = fmt.Sprintf("older than %s", r.maxAge)
+		default:
+			return nil
+		}
+		return g
+	}
+	for _, pattern := range r.patterns {
+		if ok, _ := path.Match(pattern, g.name); ok {
+			g.reason = "name matches " + pattern
+			return g
+		}
+	}
+	return nil
+}
+
+func printStale(w io.Writer, stale []*staleGroup) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tRUN\tSAMPLE\tCREATED\tEXPIRES\tREASON")
+	for _, g := range stale {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", g.name, orDash(g.runID), orDash(g.sample), formatTime(g.created), formatTime(g.expires), g.reason)
+	}
+	tw.Flush()
+}
+
+// printJanitorSummary writes the result of every deletion and returns the
+// exit code of the janitor, 1 if any deletion failed.
+func printJanitorSummary(w io.Writer, stale []*staleGroup) int {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tRESULT\tDURATION\tERROR")
+	deleted := 0
+	for _, g := range stale {
+		result, msg := "failed", "-"
+		if g.deleted {
+			result = "deleted"
+			deleted++
+		} else if g.err != nil {
+			msg = g.err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", g.name, result, g.duration.Round(time.Second), msg)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "Deleted %d of %d resource groups\n", deleted, len(stale))
+	if deleted != len(stale) {
+		return 1
+	}
+	return 0
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
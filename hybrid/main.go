@@ -0,0 +1,372 @@
+// Command hybrid runs the samples of this repository from a single binary.
+// Each sample is an area with a demo subcommand, next to commands shared by
+// all of them that inspect the configuration, sign in and clean up.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+
+	kvdemo "github.com/Azure-Samples/Hybrid-Golang-Samples/keyvault/demo"
+	rgdemo "github.com/Azure-Samples/Hybrid-Golang-Samples/resourcemanager/demo"
+	storagedemo "github.com/Azure-Samples/Hybrid-Golang-Samples/storage/demo"
+	vmdemo "github.com/Azure-Samples/Hybrid-Golang-Samples/vm/demo"
+
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/session"
+)
+
+// transport sends the requests of the commands. It is nil, which selects the
+// default transport, except in the tests where it points to a fake stamp.
+var transport policy.Transporter
+
+// area is a sample. Its demo subcommand runs the sample as its own program
+// does.
+type area struct {
+	name    string
+	sample  string
+	summary string
+	// resourceGroup is the resource group the demo creates.
+	resourceGroup string
+	demo          func(*session.Session) error
+}
+
+var areas = []area{
+	{"rg", "resourcemanager", "create, list and delete a resource group", rgdemo.ResourceGroup, rgdemo.Run},
+	{"storage", "storage", "create a storage account, rotate its keys and delete it", storagedemo.ResourceGroup, storagedemo.Run},
+	{"keyvault", "keyvault", "create a key vault, store a secret in it and delete it", kvdemo.ResourceGroup, kvdemo.Run},
+	{"vm", "vm", "create a network and two virtual machines and delete them", vmdemo.ResourceGroup, vmdemo.Run},
+}
+
+func findArea(name string) *area {
+	for i := range areas {
+		if areas[i].name == name {
+			return &areas[i]
+		}
+	}
+	return nil
+}
+
+// command is a command shared by the areas.
+type command struct {
+	name    string
+	args    string
+	summary string
+	// help describes the command in full, after its usage line.
+	help string
+	run  func(fs *flag.FlagSet, f *session.Flags, args []string) int
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{
+			name:    "config",
+			summary: "print the configuration and the endpoints of the stamp",
+			help: "Reads the configuration file selected by -configDir, -profile and -secret\n" +
+				"and the metadata of the stamp it points to, and prints them. Secrets are\n" +
+				"never printed. Nothing is signed in to.",
+			run: runConfig,
+		},
+		{
+			name:    "auth",
+			summary: "sign in to the stamp and print the identity used",
+			help: "Signs in with the configured service principal, as every other command\n" +
+				"does before its first request, and prints the identity it signed in with.",
+			run: runAuth,
+		},
+		{
+			name:    "cleanup",
+			args:    "[area...]",
+			summary: "delete the resource groups of the demos",
+			help: "Deletes the resource groups the demos of the given areas created in the\n" +
+				"run -runID, or of all areas, with everything in them, once confirmed.\n" +
+				"Groups that do not exist are skipped, and groups that do not belong to the\n" +
+				"run are kept unless -force is given.",
+			run: runCleanup,
+		},
+		{
+			name:    "janitor",
+			summary: "delete the stale resource groups of earlier runs",
+			help: "Lists the resource groups of the subscription and deletes those the samples\n" +
+				"tagged for the service principal of the configuration that are past their\n" +
+				"expiry, or that started longer ago than -maxAge when they have no expiry.\n" +
+				"With -force the tagged groups of other service principals are deleted as\n" +
+				"well, and the groups without the tags of the samples whose name matches a\n" +
+				"pattern of -match. The groups are listed first, and deleted -parallel at a\n" +
+				"time once confirmed, followed by a summary of the deletions.\n\n" +
+				"  -maxAge d         age of the runs without expiry to delete (default 24h)\n" +
+				"  -match patterns   comma-separated name patterns, such as TestGo*ResourceGroup*\n" +
+				"  -parallel n       number of deletions at a time (default 4)\n" +
+				"  -force            delete the groups of others as well\n" +
+				"  -yes              delete without asking for confirmation\n\n" +
+				"With -dry-run the groups are listed but not deleted. The janitor exits with\n" +
+				"1 if any deletion failed.",
+			run: runJanitor,
+		},
+		{
+			name:    "matrix",
+			summary: "run the demos of several areas with several profiles at once",
+			help: "Runs the demo of every area of -samples with every configuration profile of\n" +
+				"-profiles, -parallel runs at a time, each in a process of its own. The\n" +
+				"resource groups of a run get the profile as suffix so that runs on the\n" +
+				"same stamp do not collide. The output of every run is prefixed with its\n" +
+				"area and profile, and a table of the results follows once all runs ended.\n" +
+				"The reports of the runs are kept in -outDir.\n\n" +
+				"  -samples list   comma-separated areas (default rg,storage,keyvault,vm)\n" +
+				"  -profiles list  comma-separated profiles (default -profile)\n" +
+				"  -parallel n     number of runs at a time (default 4)\n" +
+				"  -outDir dir     directory for the reports (default a temporary directory)\n\n" +
+				"The other flags are passed on to every run. The matrix exits with 1 if any\n" +
+				"run failed.",
+			run: runMatrix,
+		},
+		{
+			name:    "apply",
+			args:    "<spec>",
+			summary: "create the environment of a spec file",
+			help: "Reads the environment a YAML or JSON spec file describes, a resource group\n" +
+				"and the resources in it, and creates them in the order of their\n" +
+				"dependencies, -parallel at a time. A resource depends on the resources it\n" +
+				"refers to, as in ${vnet.id}, and on those of its dependsOn, and receives\n" +
+				"their properties once they exist. Resources that exist already are adopted\n" +
+				"or updated in place, and reported when they drifted in a property that\n" +
+				"cannot change. The resources of a failed resource are skipped. A summary\n" +
+				"of the resources follows.\n\n" +
+				"  -parallel n   number of resources created at a time (default 4)\n\n" +
+				"The names in the spec may include the run ID as ${run}. Apply exits with 1\n" +
+				"if any resource failed or was skipped.",
+			run: runApply,
+		},
+		{
+			name:    "destroy",
+			args:    "<spec>",
+			summary: "delete the environment of a spec file",
+			help: "Deletes the resources of the environment a spec file describes, as created\n" +
+				"by apply in the run -runID, in the reverse order of their dependencies,\n" +
+				"-parallel at a time once confirmed, and then its resource group. Resources\n" +
+				"that do not exist are skipped.\n\n" +
+				"  -parallel n   number of resources deleted at a time (default 4)\n" +
+				"  -yes          delete without asking for confirmation\n\n" +
+				"Destroy exits with 1 if any deletion failed.",
+			run: runDestroy,
+		},
+		{
+			name:    "diff",
+			args:    "<spec>",
+			summary: "report how the resources drifted from a spec or the last run",
+			help: "Compares the resources of the environment a spec file describes, as created\n" +
+				"by apply in the run -runID, with the resources that exist, without changing\n" +
+				"anything. With -state the resources the run -runID of a sample or an\n" +
+				"environment created are compared with the bodies the run last sent for\n" +
+				"them, as kept by -stateDir or -stateBackend, instead of a spec. Every\n" +
+				"resource is reported as in sync, drifted, missing, or unchecked when a\n" +
+				"resource it refers to is missing, followed by the properties that differ\n" +
+				"and whether they can be updated in place.\n\n" +
+				"  -state sample   compare with the state of the run of this sample\n" +
+				"  -parallel n     number of resources compared at a time (default 4)\n" +
+				"  -output json    print the differences as a JSON document\n\n" +
+				"Diff exits with 0 when everything is in sync, with 3 when resources drifted\n" +
+				"or are missing, and with 1 when resources could not be compared.",
+			run: runDiff,
+		},
+		{
+			name:    "reconcile",
+			args:    "<spec>",
+			summary: "keep the environment of a spec file at its desired state",
+			help: "Reconciles the environment a spec file describes in the run -runID every\n" +
+				"-interval until stopped: re-reads the spec, compares the environment with\n" +
+				"the resources that exist as diff does and, when resources are missing or\n" +
+				"drifted, applies the spec as apply does. After a failed reconciliation it\n" +
+				"waits -backoff instead, doubled with every further failure up to -interval,\n" +
+				"with a random part. It serves /healthz, which answers 503 while the last\n" +
+				"reconciliation failed, and /metrics in the text format of Prometheus.\n\n" +
+				"  -interval d    time between the reconciliations (default 5m)\n" +
+				"  -backoff d     wait after a failed reconciliation (default 30s)\n" +
+				"  -listen addr   address of the endpoints, empty for none (default localhost:8080)\n" +
+				"  -cycles n      number of reconciliations before exiting (default no limit)\n" +
+				"  -parallel n    number of resources created at a time (default 4)\n\n" +
+				"The resources get no expiry and are never rolled back, so -ttl can only be\n" +
+				"0 and -cleanup only never. Reconcile exits with 0 when stopped by a signal,\n" +
+				"and after -cycles with 1 if the last reconciliation failed.",
+			run: runReconcile,
+		},
+		{
+			name:    "validate",
+			args:    "<template>",
+			summary: "check that ARM accepts a template and its parameters",
+			help: "Sends an ARM template and the values of its parameters to the resource\n" +
+				"group as a deployment to validate, without deploying anything, and prints\n" +
+				"the resources it would deploy or the errors ARM rejected it with. The\n" +
+				"resource group must exist.\n\n" +
+				"  -parameters file   JSON file with the values of the parameters\n" +
+				"  -group name        resource group (default TestGoTemplateResourceGroup of the run)\n" +
+				"  -deployment name   name of the deployment (default the template file name)\n" +
+				"  -mode mode         incremental or complete (default incremental)\n\n" +
+				"Validate exits with 1 if the template is invalid.",
+			run: runValidate,
+		},
+		{
+			name:    "deploy",
+			args:    "<template>",
+			summary: "deploy a template and its parameters to a resource group",
+			help: "Creates the resource group unless it exists and deploys an ARM template and\n" +
+				"the values of its parameters to it, printing the operations of the\n" +
+				"deployment as they start and end, then its outputs as a JSON object. A\n" +
+				"failed deployment is followed by the errors of its operations, nested as\n" +
+				"ARM reports them. In complete mode, which deletes the resources of the group\n" +
+				"the template does not have, the deployment is confirmed first.\n\n" +
+				"  -parameters file   JSON file with the values of the parameters\n" +
+				"  -group name        resource group (default TestGoTemplateResourceGroup of the run)\n" +
+				"  -deployment name   name of the deployment (default the template file name)\n" +
+				"  -mode mode         incremental or complete (default incremental)\n" +
+				"  -yes               deploy in complete mode without asking for confirmation\n\n" +
+				"Deploy exits with 1 if the deployment failed.",
+			run: runDeploy,
+		},
+		{
+			name:    "failures",
+			args:    "<deployment>",
+			summary: "explain why a template deployment failed",
+			help: "Lists the operations of a deployment of the resource group, and of the\n" +
+				"deployments nested in it, and prints the tree of those that failed with\n" +
+				"the codes of their errors, followed by the root causes: the innermost\n" +
+				"errors of the operations that failed themselves rather than because a\n" +
+				"nested deployment failed, with the resource and deployment of each.\n\n" +
+				"  -group name     resource group (default TestGoTemplateResourceGroup of the run -runID)\n" +
+				"  -output json    print the failed operations and root causes as a JSON document\n\n" +
+				"Failures exits with 0 once the failures are printed, whether or not the\n" +
+				"deployment failed, and with 1 when the deployment could not be read.",
+			run: runFailures,
+		},
+		{
+			name:    "resume",
+			summary: "wait for the operations of an interrupted run",
+			help: "Reattaches to the long-running operations that an interrupted run left in\n" +
+				"the -lroState file and waits for them to complete. To carry on from the\n" +
+				"failed step of a run instead, pass -resume with its run ID to its demo.",
+			run: runResume,
+		},
+		{
+			name:    "help",
+			args:    "[command]",
+			summary: "print the help of a command",
+			run:     runHelp,
+		},
+	}
+}
+
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+func main() {
+	fs := flag.NewFlagSet("hybrid", flag.ExitOnError)
+	f := session.RegisterFlags(fs)
+	fs.Usage = func() { usage(fs, os.Stderr) }
+	fs.Parse(os.Args[1:])
+	args := fs.Args()
+	if len(args) == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if a := findArea(args[0]); a != nil {
+		os.Exit(runArea(fs, f, a, args[1:]))
+	}
+	if c := findCommand(args[0]); c != nil {
+		os.Exit(c.run(fs, f, args[1:]))
+	}
+	fmt.Fprintf(os.Stderr, "hybrid: unknown command %q\n\n", args[0])
+	fs.Usage()
+	os.Exit(2)
+}
+
+// parseArgs parses the flags among args, which may follow the command and
+// its arguments as well as precede them, and returns the other arguments.
+func parseArgs(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		if fs.NArg() == 0 {
+			return positional
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+func runArea(fs *flag.FlagSet, f *session.Flags, a *area, args []string) int {
+	args = parseArgs(fs, args)
+	if len(args) != 1 || args[0] != "demo" {
+		fmt.Fprintf(os.Stderr, "Usage: hybrid %s demo [flags]\n\n", a.name)
+		areaHelp(os.Stderr, a)
+		return 2
+	}
+	s, err := session.Open(a.sample, f, transport)
+	if err != nil {
+		fmt.Fprintf(f.Log(), "%s\n", err)
+		return 1
+	}
+	if err := a.demo(s); err != nil {
+		fmt.Fprintf(s.Log, "%s\n", err)
+		s.Exit(1)
+	}
+	s.Exit(0)
+	return 0
+}
+
+func usage(fs *flag.FlagSet, w io.Writer) {
+	fmt.Fprintf(w, "Usage: hybrid <command> [arguments] [flags]\n\nCommands:\n")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, a := range areas {
+		fmt.Fprintf(tw, "  %s demo\t%s\n", a.name, a.summary)
+	}
+	for _, c := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", strings.TrimSpace(c.name+" "+c.args), c.summary)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\nThe flags are shared by all commands and may precede or follow the\ncommand. Run 'hybrid help <command>' for the help of a command.\n\nFlags:\n")
+	fs.SetOutput(w)
+	fs.PrintDefaults()
+}
+
+func areaHelp(w io.Writer, a *area) {
+	fmt.Fprintf(w, "Runs the %s sample: %s.\nThe demo works in the resource group %s-<run ID>, which -clean deletes at the end.\n", a.sample, a.summary, a.resourceGroup)
+}
+
+func runHelp(fs *flag.FlagSet, f *session.Flags, args []string) int {
+	args = parseArgs(fs, args)
+	if len(args) == 0 {
+		usage(fs, os.Stdout)
+		return 0
+	}
+	if a := findArea(args[0]); a != nil {
+		fmt.Printf("Usage: hybrid %s demo [flags]\n\n", a.name)
+		areaHelp(os.Stdout, a)
+		return 0
+	}
+	c := findCommand(args[0])
+	if c == nil {
+		fmt.Fprintf(os.Stderr, "hybrid: unknown command %q\n", args[0])
+		return 2
+	}
+	fmt.Printf("Usage: %s\n\n", strings.TrimSpace("hybrid "+c.name+" "+c.args+" [flags]"))
+	if c.help != "" {
+		fmt.Println(c.help)
+	} else {
+		fmt.Println(strings.ToUpper(c.summary[:1]) + c.summary[1:] + ".")
+	}
+	return 0
+}
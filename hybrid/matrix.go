@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/report"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/session"
+)
+
+// newCommand returns the command that runs hybrid with args. The tests
+// replace it to run the test binary against the fake stamp instead.
+var newCommand = func(args ...string) (*exec.Cmd, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	return exec.Command(exe, args...), nil
+}
+
+// perRunFlags are the flags the matrix sets for each run itself rather than
+// passing on those it was given.
+var perRunFlags = map[string]bool{
+	"samples": true, "profiles": true, "parallel": true, "outDir": true,
+	"profile": true, "groupSuffix": true, "report": true, "junit": true, "lroState": true,
+	"cassette": true, "cassetteMode": true, "planFile": true, "output": true,
+}
+
+// repeatedFlag is the value of a flag that may be repeated, such as -tag or
+// -name.
+type repeatedFlag interface {
+	Values() []string
+}
+
+// matrixRun is the run of the demo of an area with a profile.
+type matrixRun struct {
+	area    *area
+	profile string
+	// label tells the run apart in the output and in the names of its files.
+	label    string
+	exitCode int
+	duration time.Duration
+	report   *report.Report
+	// lastLine is the last line the run printed, which explains the failures
+	// that happen before the report is started.
+	lastLine string
+}
+
+func runMatrix(fs *flag.FlagSet, f *session.Flags, args []string) int {
+	samples := fs.String("samples", "rg,storage,keyvault,vm", "comma-separated areas whose demos to run")
+	profiles := fs.String("profiles", f.Profile, "comma-separated configuration profiles to run the demos with")
+	parallel := fs.Int("parallel", 4, "number of runs at a time")
+	outDir := fs.String("outDir", "", "directory for the reports and operation state of the runs (default a temporary directory)")
+	if args = parseArgs(fs, args); len(args) != 0 || *parallel < 1 {
+		fmt.Fprintf(os.Stderr, "Usage: hybrid matrix [-samples list] [-profiles list] [-parallel n] [-outDir dir] [flags]\n")
+		return 2
+	}
+
+	var runs []*matrixRun
+	for _, name := range strings.Split(*samples, ",") {
+		a := findArea(strings.TrimSpace(name))
+		if a == nil {
+			fmt.Fprintf(os.Stderr, "hybrid matrix: unknown area %q\n", name)
+			return 2
+		}
+		for _, profile := range strings.Split(*profiles, ",") {
+			profile = strings.TrimSpace(profile)
+			label := a.name + "/" + profile
+			if profile == "" {
+				label = a.name
+			}
+			runs = append(runs, &matrixRun{area: a, profile: profile, label: label})
+		}
+	}
+
+	dir := *outDir
+	if dir == "" {
+		var err error
+		if dir, err = os.MkdirTemp("", "hybrid-matrix-"); err != nil {
+			fmt.Printf("%s\n", err)
+			return 1
+		}
+	} else if err := os.MkdirAll(dir, 0755); err != nil {
+		fmt.Printf("%s\n", err)
+		return 1
+	}
+
+	var shared []string
+	fs.Visit(func(fl *flag.Flag) {
+		if perRunFlags[fl.Name] {
+			return
+		}
+		// The values of a repeated flag may hold its separator, so they
+		// are passed on as one flag each.
+		if r, ok := fl.Value.(repeatedFlag); ok {
+			for _, value := range r.Values() {
+				shared = append(shared, "-"+fl.Name+"="+value)
+			}
+			return
+		}
+		shared = append(shared, "-"+fl.Name+"="+fl.Value.String())
+	})
+
+	fmt.Printf("Running %d demos, %d at a time, reports in %s\n", len(runs), *parallel, dir)
+	out := &syncWriter{w: os.Stdout}
+	slots := make(chan struct{}, *parallel)
+	var wg sync.WaitGroup
+	for _, r := range runs {
+		wg.Add(1)
+		go func(r *matrixRun) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			r.run(out, dir, shared)
+		}(r)
+	}
+	wg.Wait()
+
+	fmt.Println()
+	printMatrix(os.Stdout, runs)
+	for _, r := range runs {
+		if r.failed() {
+			return 1
+		}
+	}
+	return 0
+}
+
+// run runs the demo in a process of its own, with the output of the process
+// prefixed with the label of the run.
+func (r *matrixRun) run(out *syncWriter, dir string, shared []string) {
+	file := strings.NewReplacer("/", "-", `\`, "-").Replace(r.label)
+	reportPath := filepath.Join(dir, file+".json")
+	args := append([]string{r.area.name, "demo"}, shared...)
+	args = append(args,
+		"-profile", r.profile,
+		"-groupSuffix", groupSuffix(r.profile),
+		"-report", reportPath,
+		"-lroState", filepath.Join(dir, file+".lro-state.json"),
+	)
+	lines := &prefixWriter{out: out, prefix: "[" + r.label + "] "}
+	start := time.Now()
+	cmd, err := newCommand(args...)
+	if err == nil {
+		cmd.Stdout = lines
+		cmd.Stderr = lines
+		err = cmd.Run()
+	}
+	lines.Flush()
+	r.duration = time.Since(start)
+	r.lastLine = lines.last
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		r.exitCode = exitErr.ExitCode()
+	case err != nil:
+		r.exitCode = -1
+		r.lastLine = err.Error()
+	}
+
+	if data, err := os.ReadFile(reportPath); err == nil {
+		var rep report.Report
+		if json.Unmarshal(data, &rep) == nil {
+			r.report = &rep
+		}
+	}
+}
+
+func (r *matrixRun) failed() bool {
+	return r.exitCode != 0 || r.report == nil || r.report.Status != report.Passed
+}
+
+// groupSuffix returns the suffix of the resource groups of the runs with
+// profile, made of the characters resource group names allow.
+func groupSuffix(profile string) string {
+	if profile == "" {
+		return ""
+	}
+	suffix := []rune("-")
+	for _, c := range profile {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+			suffix = append(suffix, c)
+		default:
+			suffix = append(suffix, '-')
+		}
+	}
+	return string(suffix)
+}
+
+// printMatrix writes the result table of runs.
+func printMatrix(w io.Writer, runs []*matrixRun) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SAMPLE\tPROFILE\tPROVIDER\tSTATUS\tEXIT\tDURATION\tSTEPS\tFAILURE")
+	passed := 0
+	for _, r := range runs {
+		profile, provider, status, steps, failure := r.profile, "-", report.Failed, "-", "-"
+		if profile == "" {
+			profile = "-"
+		}
+		if r.report != nil {
+			if r.report.IdentityProvider != "" {
+				provider = r.report.IdentityProvider
+			}
+			status = r.report.Status
+			steps = fmt.Sprint(len(r.report.Steps))
+			for _, step := range r.report.Steps {
+				if step.Status == report.Failed && failure == "-" {
+					failure = step.Name
+					if step.Error != nil {
+						failure += ": " + step.Error.Message
+					}
+				}
+			}
+		}
+		if r.exitCode != 0 {
+			status = report.Failed
+		}
+		if status == report.Failed && failure == "-" && r.lastLine != "" {
+			failure = r.lastLine
+		}
+		if status == report.Passed {
+			passed++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\n", r.area.name, profile, provider, status, r.exitCode, r.duration.Round(time.Second), steps, failure)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "%d of %d runs passed\n", passed, len(runs))
+}
+
+// syncWriter serializes the writes of the runs.
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
+
+// prefixWriter writes complete lines to out, each with prefix, so that the
+// lines of concurrent runs do not mix.
+type prefixWriter struct {
+	out     io.Writer
+	prefix  string
+	partial []byte
+	last    string
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.partial = append(p.partial, b...)
+	for {
+		i := bytes.IndexByte(p.partial, '\n')
+		if i < 0 {
+			return len(b), nil
+		}
+		p.line(string(p.partial[:i]))
+		p.partial = p.partial[i+1:]
+	}
+}
+
+// Flush writes the last line if it has no newline.
+func (p *prefixWriter) Flush() {
+	if len(p.partial) > 0 {
+		p.line(string(p.partial))
+		p.partial = nil
+	}
+}
+
+func (p *prefixWriter) line(s string) {
+	s = strings.TrimRight(s, "\r")
+	if strings.TrimSpace(s) != "" {
+		p.last = strings.TrimSpace(s)
+	}
+	io.WriteString(p.out, p.prefix+s+"\n")
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/resources/armresources"
+
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/session"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/hybrid/spec"
+)
+
+func runReconcile(fs *flag.FlagSet, f *session.Flags, args []string) int {
+	interval := fs.Duration("interval", 5*time.Minute, "time between the reconciliations")
+	backoff := fs.Duration("backoff", 30*time.Second, "wait after a failed reconciliation, doubled with every further failure up to -interval")
+	listen := fs.String("listen", "localhost:8080", "address of the health and metrics endpoints; empty for none")
+	cycles := fs.Int("cycles", 0, "number of reconciliations before exiting; 0 for no limit")
+	parallel := fs.Int("parallel", 4, "number of resources created or updated at a time")
+	if args = parseArgs(fs, args); len(args) != 1 || *interval <= 0 || *backoff <= 0 || *cycles < 0 || *parallel < 1 {
+		fmt.Fprintf(os.Stderr, "Usage: hybrid reconcile <spec> [-interval d] [-backoff d] [-listen addr] [-cycles n] [-parallel n] [flags]\n")
+		return 2
+	}
+	if f.RunID == "" {
+		fmt.Fprintf(os.Stderr, "hybrid reconcile: -runID is required, so that every reconciliation keeps the same resources\n")
+		return 2
+	}
+	// The environment is kept in its desired state until stopped, so the
+	// reconciler neither lets it expire, which would have the janitor
+	// delete it, nor rolls back what it repaired.
+	if err := f.KeepResources(fs); err != nil {
+		fmt.Fprintf(os.Stderr, "hybrid reconcile: %s\n", err)
+		return 2
+	}
+	path := args[0]
+	env, err := spec.Load(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hybrid reconcile: %s\n", err)
+		return 2
+	}
+	s, err := session.Open(env.Name, f, transport)
+	if err != nil {
+		fmt.Fprintf(f.Log(), "%s\n", err)
+		return 1
+	}
+	client, err := armresources.NewClient(s.Config.SubscriptionId, s.Credential, &s.Options)
+	if err != nil {
+		fmt.Fprintf(s.Log, "failed to create the resource client: %s\n", err)
+		s.Exit(1)
+	}
+	// The reconciliations run until stopped, so their steps are not
+	// recorded in the report, which would grow without end.
+	r := &spec.Runner{
+		Client:         client,
+		SubscriptionID: s.Config.SubscriptionId,
+		Builtins:       spec.Builtins{RunID: s.Names.RunID(), Location: s.Config.Location},
+		Tags:           s.Tags,
+		Owners:         ownerTags,
+		Waiter:         s.Waiter,
+		Converge:       s.Converge,
+		Out:            s.Out,
+		Parallel:       *parallel,
+	}
+	status := newReconcileStatus()
+	if *listen != "" {
+		l, err := net.Listen("tcp", *listen)
+		if err != nil {
+			fmt.Fprintf(s.Log, "failed to listen for the health and metrics endpoints: %s\n", err)
+			s.Exit(1)
+		}
+		server := &http.Server{Handler: status, ReadHeaderTimeout: 10 * time.Second}
+		go server.Serve(l)
+		fmt.Fprintf(s.Log, "Serving the health and metrics endpoints on http://%s/healthz and http://%s/metrics\n", l.Addr(), l.Addr())
+	}
+
+	ctx := s.Context()
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	failures := 0
+	var lastErr error
+	for n := 1; ; n++ {
+		start := time.Now()
+		fmt.Fprintf(s.Log, "Reconciliation %d of environment %s\n", n, env.Name)
+		lastErr = reconcile(ctx, r, path, status)
+		status.record(start, lastErr)
+		if ctx.Err() != nil {
+			break
+		}
+		wait := *interval
+		if lastErr != nil {
+			failures++
+			wait = backoffDelay(*backoff, *interval, failures, random)
+			fmt.Fprintf(s.Log, "Reconciliation %d failed: %s\n", n, lastErr)
+		} else {
+			failures = 0
+		}
+		if *cycles > 0 && n >= *cycles {
+			break
+		}
+		fmt.Fprintf(s.Log, "Next reconciliation in %s\n", wait.Round(time.Millisecond))
+		select {
+		case <-ctx.Done():
+		case <-time.After(wait):
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if ctx.Err() != nil {
+		// Stopping the reconciler is how it ends, not a failure.
+		fmt.Fprintln(s.Log, "Stopped reconciling")
+		s.Exit(0)
+	}
+	code := 0
+	if lastErr != nil {
+		code = 1
+	}
+	s.Exit(code)
+	return code
+}
+
+// reconcile re-reads the spec at path, compares the environment with the
+// resources that exist and applies it when anything is missing or drifted.
+func reconcile(ctx context.Context, r *spec.Runner, path string, status *reconcileStatus) error {
+	env, err := spec.Load(path)
+	if err != nil {
+		return err
+	}
+	results := r.Diff(ctx, env)
+	counts := map[string]int{}
+	var changed []string
+	for _, result := range results {
+		counts[result.Action]++
+		switch result.Action {
+		case spec.Failed:
+			return result.Err
+		case spec.InSync:
+		default:
+			changed = append(changed, result.Key+" "+result.Action)
+			for _, d := range result.Differences {
+				fmt.Fprintf(r.Out, "  %s %s: %s\n", result.Key, result.Name, d)
+			}
+		}
+	}
+	status.setResources(counts)
+	if len(changed) == 0 {
+		fmt.Fprintf(r.Out, "All %d resources are in sync\n", len(results))
+		return nil
+	}
+	fmt.Fprintf(r.Out, "Repairing %d resources: %s\n", len(changed), strings.Join(changed, ", "))
+	results, err = r.Apply(ctx, env)
+	for _, result := range results {
+		if result.Action == spec.Created || result.Action == spec.Updated {
+			status.repaired(result.Action)
+		}
+	}
+	return err
+}
+
+// backoffDelay returns the wait after the failures-th failed reconciliation
+// in a row: base doubled with every failure up to max, of which a random
+// half is left out so that reconcilers that failed together do not retry
+// together.
+func backoffDelay(base, max time.Duration, failures int, random *rand.Rand) time.Duration {
+	d := base
+	for i := 1; i < failures && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d/2 + time.Duration(random.Int63n(int64(d/2)+1))
+}
+
+// reconcileStatus is the state of the reconciler that its health and metrics
+// endpoints serve.
+type reconcileStatus struct {
+	mu      sync.Mutex
+	started time.Time
+	// runs counts the reconciliations by result, repairs the resources
+	// they created or updated by action.
+	runs    map[string]int
+	repairs map[string]int
+	// resources counts the resources of the last comparison by status.
+	resources           map[string]int
+	consecutiveFailures int
+	lastRun             time.Time
+	lastSuccess         time.Time
+	lastDuration        time.Duration
+	lastErr             error
+}
+
+func newReconcileStatus() *reconcileStatus {
+	return &reconcileStatus{started: time.Now(), runs: map[string]int{}, repairs: map[string]int{}, resources: map[string]int{}}
+}
+
+func (st *reconcileStatus) record(start time.Time, err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.lastRun, st.lastDuration, st.lastErr = start, time.Since(start), err
+	if err != nil {
+		st.runs["failure"]++
+		st.consecutiveFailures++
+		return
+	}
+	st.runs["success"]++
+	st.consecutiveFailures = 0
+	st.lastSuccess = start
+}
+
+func (st *reconcileStatus) setResources(counts map[string]int) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.resources = counts
+}
+
+func (st *reconcileStatus) repaired(action string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.repairs[action]++
+}
+
+// ServeHTTP serves /healthz, which answers 503 while the last reconciliation
+// failed, and /metrics in the text format of Prometheus.
+func (st *reconcileStatus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	switch r.URL.Path {
+	case "/healthz":
+		if st.lastErr != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, "unhealthy: %d reconciliations failed in a row, the last one with: %s\n", st.consecutiveFailures, st.lastErr)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	case "/metrics":
+		st.writeMetrics(w)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (st *reconcileStatus) writeMetrics(w http.ResponseWriter) {
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	labelled := func(name, label string, values map[string]int, all ...string) {
+		keys := append([]string{}, all...)
+		for key := range values {
+			if !contains(keys, key) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(w, "%s{%s=%q} %d\n", name, label, key, values[key])
+		}
+	}
+	timestamp := func(t time.Time) float64 {
+		if t.IsZero() {
+			return 0
+		}
+		return float64(t.UnixNano()) / 1e9
+	}
+	metric("hybrid_reconcile_runs_total", "counter", "Reconciliations by result.")
+	labelled("hybrid_reconcile_runs_total", "result", st.runs, "success", "failure")
+	metric("hybrid_reconcile_repairs_total", "counter", "Resources the reconciliations created or updated, by action.")
+	labelled("hybrid_reconcile_repairs_total", "action", st.repairs, spec.Created, spec.Updated)
+	metric("hybrid_reconcile_resources", "gauge", "Resources of the last comparison by status.")
+	labelled("hybrid_reconcile_resources", "status", st.resources, spec.InSync, spec.Drifted, spec.Missing)
+	metric("hybrid_reconcile_consecutive_failures", "gauge", "Reconciliations that failed in a row.")
+	fmt.Fprintf(w, "hybrid_reconcile_consecutive_failures %d\n", st.consecutiveFailures)
+	metric("hybrid_reconcile_last_run_timestamp_seconds", "gauge", "Start of the last reconciliation.")
+	fmt.Fprintf(w, "hybrid_reconcile_last_run_timestamp_seconds %.3f\n", timestamp(st.lastRun))
+	metric("hybrid_reconcile_last_success_timestamp_seconds", "gauge", "Start of the last successful reconciliation.")
+	fmt.Fprintf(w, "hybrid_reconcile_last_success_timestamp_seconds %.3f\n", timestamp(st.lastSuccess))
+	metric("hybrid_reconcile_last_duration_seconds", "gauge", "Duration of the last reconciliation.")
+	fmt.Fprintf(w, "hybrid_reconcile_last_duration_seconds %.3f\n", st.lastDuration.Seconds())
+	metric("hybrid_reconcile_uptime_seconds", "gauge", "Time since the reconciler started.")
+	fmt.Fprintf(w, "hybrid_reconcile_uptime_seconds %.3f\n", time.Since(st.started).Seconds())
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,381 @@
+package spec
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/resources/armresources"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/converge"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/lro"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/report"
+)
+
+// groupType is the type of the resource group of an environment.
+const groupType = "Microsoft.Resources/resourceGroups"
+
+// Client is the part of armresources.Client that applies and destroys specs.
+type Client interface {
+	GetByID(ctx context.Context, resourceID string, apiVersion string, options *armresources.ClientGetByIDOptions) (armresources.ClientGetByIDResponse, error)
+	BeginCreateOrUpdateByID(ctx context.Context, resourceID string, apiVersion string, parameters armresources.GenericResource, options *armresources.ClientBeginCreateOrUpdateByIDOptions) (*runtime.Poller[armresources.ClientCreateOrUpdateByIDResponse], error)
+	BeginDeleteByID(ctx context.Context, resourceID string, apiVersion string, options *armresources.ClientBeginDeleteByIDOptions) (*runtime.Poller[armresources.ClientDeleteByIDResponse], error)
+}
+
+// Runner applies and destroys specs in a subscription.
+type Runner struct {
+	Client         Client
+	SubscriptionID string
+	Builtins       Builtins
+	// Tags are the tags of the run, which every resource gets before the
+	// tags of the spec and of the resource.
+	Tags map[string]*string
+	// Owners are the tags of Tags that tell who created a resource and
+	// when. The runner takes them from the existing resources, so that they
+	// are neither compared nor replaced when someone else runs it, while
+	// the resources it creates get those of Tags. Apply leaves Owners
+	// empty, so that it never adopts the resources of someone else.
+	Owners   []string
+	Waiter   *lro.Waiter
+	Converge *converge.Checker
+	Steps    *report.Recorder
+	Out      io.Writer
+	// Parallel is the number of resources created or deleted at a time.
+	Parallel int
+}
+
+// The actions of a Result.
+const (
+	Created = "created"
+	Adopted = "adopted"
+	Updated = "updated"
+	Deleted = "deleted"
+	Absent  = "absent"
+	Failed  = "failed"
+	Skipped = "skipped"
+)
+
+// Result is what Apply or Destroy did about a resource.
+type Result struct {
+	Key  string
+	Type string
+	Name string
+	ID   string
+	// Action is one of Created, Adopted, Updated, Deleted, Absent, Failed
+	// and Skipped, or for Diff one of InSync, Drifted, Missing, Unchecked
+	// and Failed.
+	Action string
+	Err    error
+	// Differences are those of a drifted resource.
+	Differences []converge.Difference
+}
+
+// SkippedError is the error of a resource that was skipped because a
+// resource it waited for failed.
+type SkippedError struct {
+	Key string
+}
+
+func (e *SkippedError) Error() string {
+	return fmt.Sprintf("skipped, since %s failed", e.Key)
+}
+
+// GroupID returns the ID of the resource group of s.
+func (r *Runner) GroupID(s *Spec) string {
+	return "/subscriptions/" + r.SubscriptionID + "/resourceGroups/" + s.GroupName(r.Builtins)
+}
+
+// Apply creates the resource group of s and the resources in it, or adopts
+// or updates those that exist, in the order of their dependencies. It returns
+// the result of the group followed by those of the resources in the order of
+// the spec, and an error if any of them failed.
+func (r *Runner) Apply(ctx context.Context, s *Spec) ([]*Result, error) {
+	b := Builtins{RunID: r.Builtins.RunID, Location: s.location(r.Builtins)}
+	groupID := r.GroupID(s)
+	group := &Result{Key: Group, Type: groupType, Name: s.GroupName(r.Builtins), ID: groupID}
+	results := []*Result{group}
+	outputs := map[string]interface{}{}
+	var mu sync.Mutex
+
+	tags, err := resolve(s.Tags, b, nil)
+	if err != nil {
+		return nil, err
+	}
+	groupParam := armresources.GenericResource{Location: to.Ptr(b.Location), Tags: r.tags(tags, nil)}
+	output, err := r.ensure(ctx, group, DefaultAPIVersion(groupType), groupParam, nil)
+	if err != nil {
+		return results, err
+	}
+	outputs[Group] = output
+
+	order, _ := s.Order()
+	byKey := map[string]*Result{}
+	for _, key := range s.Keys() {
+		res := s.Resources[key]
+		result := &Result{Key: key, Type: res.Type, Name: b.expand(res.Name), ID: s.ResourceID(groupID, key, b)}
+		byKey[key] = result
+		results = append(results, result)
+	}
+	r.schedule(order, func(key string) []string {
+		return s.Resources[key].deps
+	}, byKey, func(key string) error {
+		res := s.Resources[key]
+		mu.Lock()
+		fields, err := resolve(res.fields(), b, outputs)
+		mu.Unlock()
+		if err != nil {
+			return err
+		}
+		param, err := genericResource(fields.(map[string]interface{}), b.Location)
+		if err != nil {
+			return err
+		}
+		param.Tags = r.tags(tags, param.Tags)
+		output, err := r.ensure(ctx, byKey[key], res.APIVersion, param, res.Immutable)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		outputs[key] = output
+		mu.Unlock()
+		return nil
+	})
+	return results, failure(results)
+}
+
+// genericResource returns the resource with the resolved fields of a
+// Resource, in location unless they have one.
+func genericResource(fields map[string]interface{}, location string) (armresources.GenericResource, error) {
+	if fields["location"] == "" {
+		fields["location"] = location
+	}
+	for key, v := range fields {
+		if m, ok := v.(map[string]interface{}); v == nil || v == "" || ok && len(m) == 0 {
+			delete(fields, key)
+		}
+	}
+	var param armresources.GenericResource
+	data, err := json.Marshal(fields)
+	if err == nil {
+		err = json.Unmarshal(data, &param)
+	}
+	return param, err
+}
+
+// tags returns the tags of the run, then those of the spec and then own.
+func (r *Runner) tags(spec interface{}, own map[string]*string) map[string]*string {
+	tags := map[string]*string{}
+	for key, value := range r.Tags {
+		tags[key] = value
+	}
+	if m, ok := spec.(map[string]interface{}); ok {
+		for key, value := range m {
+			if s, ok := value.(string); ok {
+				tags[key] = to.Ptr(s)
+			}
+		}
+	}
+	for key, value := range own {
+		tags[key] = value
+	}
+	return tags
+}
+
+// withOwners returns param with the owner tags of the existing resource
+// instead of those of the run, and without those it does not have.
+func (r *Runner) withOwners(param armresources.GenericResource, existing map[string]*string) armresources.GenericResource {
+	if len(r.Owners) == 0 {
+		return param
+	}
+	tags := map[string]*string{}
+	for key, value := range param.Tags {
+		tags[key] = value
+	}
+	for _, key := range r.Owners {
+		if value, ok := existing[key]; ok {
+			tags[key] = value
+		} else {
+			delete(tags, key)
+		}
+	}
+	param.Tags = tags
+	return param
+}
+
+// ensure creates the resource of result with param, or adopts or updates the
+// existing one, and returns it in its JSON form for the resources that refer
+// to it.
+func (r *Runner) ensure(ctx context.Context, result *Result, apiVersion string, param armresources.GenericResource, immutable []string) (interface{}, error) {
+	kind := lro.KindOf(result.Type)
+	what := kind.Label() + " " + result.Name
+	start := time.Now()
+	resource, err := func() (armresources.GenericResource, error) {
+		resp, err := r.Client.GetByID(ctx, result.ID, apiVersion, nil)
+		switch {
+		case converge.NotFound(err):
+			result.Action = Created
+		case err != nil:
+			return resp.GenericResource, fmt.Errorf("failed to get %s: %w", what, err)
+		default:
+			param = r.withOwners(param, resp.GenericResource.Tags)
+			action, err := r.Converge.Check(what, resp.GenericResource, param, append([]string{"location"}, immutable...)...)
+			if err != nil || action == converge.Adopt {
+				result.Action = Adopted
+				return resp.GenericResource, err
+			}
+			result.Action = Updated
+		}
+		if result.Action == Created {
+			fmt.Fprintf(r.Out, "Creating %s\n", what)
+		}
+		poller, err := r.Client.BeginCreateOrUpdateByID(ctx, result.ID, apiVersion, param, nil)
+		if err != nil {
+			return armresources.GenericResource{}, fmt.Errorf("failed to create %s: %w", what, err)
+		}
+		resp2, err := lro.Wait(ctx, r.Waiter, lro.Create(kind, result.Name), poller)
+		return resp2.GenericResource, err
+	}()
+	r.Steps.Record("apply "+what, start, []string{result.ID}, err)
+	if err != nil {
+		result.Action, result.Err = Failed, err
+		return nil, err
+	}
+	if result.Action == Created {
+		fmt.Fprintf(r.Out, "Created %s\n", what)
+	}
+	var output interface{}
+	data, err := json.Marshal(resource)
+	if err == nil {
+		err = json.Unmarshal(data, &output)
+	}
+	return output, err
+}
+
+// Destroy deletes the resources of s in the reverse order of their
+// dependencies, and then its resource group if every resource is gone. It
+// returns the results in the order of Apply and an error if any deletion
+// failed.
+func (r *Runner) Destroy(ctx context.Context, s *Spec) ([]*Result, error) {
+	b := Builtins{RunID: r.Builtins.RunID, Location: s.location(r.Builtins)}
+	groupID := r.GroupID(s)
+	group := &Result{Key: Group, Type: groupType, Name: s.GroupName(r.Builtins), ID: groupID}
+	results := []*Result{group}
+	byKey := map[string]*Result{}
+	for _, key := range s.Keys() {
+		res := s.Resources[key]
+		result := &Result{Key: key, Type: res.Type, Name: b.expand(res.Name), ID: s.ResourceID(groupID, key, b)}
+		byKey[key] = result
+		results = append(results, result)
+	}
+	order, _ := s.Order()
+	reverse := make([]string, len(order))
+	for i, key := range order {
+		reverse[len(order)-1-i] = key
+	}
+	r.schedule(reverse, s.Dependents, byKey, func(key string) error {
+		return r.delete(ctx, byKey[key], s.Resources[key].APIVersion)
+	})
+	if err := failure(results); err != nil {
+		group.Action, group.Err = Skipped, &SkippedError{Key: "the deletion of its resources"}
+		return results, err
+	}
+	r.delete(ctx, group, DefaultAPIVersion(groupType))
+	return results, failure(results)
+}
+
+// delete deletes the resource of result if it exists.
+func (r *Runner) delete(ctx context.Context, result *Result, apiVersion string) error {
+	kind := lro.KindOf(result.Type)
+	what := kind.Label() + " " + result.Name
+	start := time.Now()
+	err := func() error {
+		_, err := r.Client.GetByID(ctx, result.ID, apiVersion, nil)
+		switch {
+		case converge.NotFound(err):
+			result.Action = Absent
+			fmt.Fprintf(r.Out, "The %s does not exist\n", what)
+			return nil
+		case err != nil:
+			return fmt.Errorf("failed to get %s: %w", what, err)
+		}
+		fmt.Fprintf(r.Out, "Deleting %s\n", what)
+		poller, err := r.Client.BeginDeleteByID(ctx, result.ID, apiVersion, nil)
+		if err != nil {
+			return fmt.Errorf("failed to delete %s: %w", what, err)
+		}
+		if _, err := lro.Wait(ctx, r.Waiter, lro.Delete(kind, result.Name), poller); err != nil {
+			return err
+		}
+		result.Action = Deleted
+		fmt.Fprintf(r.Out, "Deleted %s\n", what)
+		return nil
+	}()
+	r.Steps.Record("destroy "+what, start, []string{result.ID}, err)
+	if err != nil {
+		result.Action, result.Err = Failed, err
+	}
+	return err
+}
+
+// schedule runs fn for every key of keys, Parallel at a time, as soon as fn
+// has succeeded for every key that wait returns for it. A key that waits for
+// a key that failed or was skipped is skipped. The outcome of every key is
+// recorded in its result.
+func (r *Runner) schedule(keys []string, wait func(key string) []string, results map[string]*Result, fn func(key string) error) {
+	parallel := r.Parallel
+	if parallel < 1 {
+		parallel = 1
+	}
+	slots := make(chan struct{}, parallel)
+	done := map[string]chan struct{}{}
+	for _, key := range keys {
+		done[key] = make(chan struct{})
+	}
+	var wg sync.WaitGroup
+	for _, key := range keys {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			defer close(done[key])
+			result := results[key]
+			for _, other := range wait(key) {
+				<-done[other]
+				if results[other].Err != nil {
+					result.Action, result.Err = Skipped, &SkippedError{Key: other}
+					return
+				}
+			}
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			if err := fn(key); err != nil && result.Err == nil {
+				result.Action, result.Err = Failed, err
+			}
+		}(key)
+	}
+	wg.Wait()
+}
+
+// failure returns an error that counts the failed and skipped results, nil
+// when there are none.
+func failure(results []*Result) error {
+	failed, skipped := 0, 0
+	for _, result := range results {
+		var skip *SkippedError
+		switch {
+		case errors.As(result.Err, &skip):
+			skipped++
+		case result.Err != nil:
+			failed++
+		}
+	}
+	if failed == 0 && skipped == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d resources failed and %d were skipped", failed, skipped)
+}
@@ -0,0 +1,156 @@
+package spec
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/resources/armresources"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/converge"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/lro"
+)
+
+// The actions of the results of Diff.
+const (
+	InSync  = "in sync"
+	Drifted = "drifted"
+	Missing = "missing"
+	// Unchecked resources exist but could not be compared, since a
+	// resource they refer to is missing.
+	Unchecked = "unchecked"
+)
+
+// Diff compares the resource group of s and the resources in it with those
+// that exist, without changing anything. The references of a resource are
+// resolved with the existing resources it refers to. It returns the results
+// in the order of Apply, with the differences of the drifted resources.
+func (r *Runner) Diff(ctx context.Context, s *Spec) []*Result {
+	b := Builtins{RunID: r.Builtins.RunID, Location: s.location(r.Builtins)}
+	groupID := r.GroupID(s)
+	group := &Result{Key: Group, Type: groupType, Name: s.GroupName(r.Builtins), ID: groupID}
+	results := []*Result{group}
+	outputs := map[string]interface{}{}
+	var mu sync.Mutex
+
+	tags, err := resolve(s.Tags, b, nil)
+	if err != nil {
+		group.Action, group.Err = Failed, err
+		return results
+	}
+	groupParam := armresources.GenericResource{Location: to.Ptr(b.Location), Tags: r.tags(tags, nil)}
+	outputs[Group] = r.compare(ctx, group, DefaultAPIVersion(groupType), func() (interface{}, error) {
+		return groupParam, nil
+	}, nil)
+
+	order, _ := s.Order()
+	byKey := map[string]*Result{}
+	for _, key := range s.Keys() {
+		res := s.Resources[key]
+		result := &Result{Key: key, Type: res.Type, Name: b.expand(res.Name), ID: s.ResourceID(groupID, key, b)}
+		byKey[key] = result
+		results = append(results, result)
+	}
+	if group.Action != InSync && group.Action != Drifted {
+		// Nothing can exist in a group that does not.
+		for _, key := range order {
+			byKey[key].Action = Missing
+			if group.Action == Failed {
+				byKey[key].Action, byKey[key].Err = Unchecked, fmt.Errorf("%s is %s", Group, Failed)
+			}
+		}
+		return results
+	}
+	r.schedule(order, func(key string) []string {
+		return s.Resources[key].deps
+	}, byKey, func(key string) error {
+		res := s.Resources[key]
+		output := r.compare(ctx, byKey[key], res.APIVersion, func() (interface{}, error) {
+			for _, dep := range res.deps {
+				if byKey[dep].Action == Missing || byKey[dep].Action == Unchecked {
+					byKey[key].Action = Unchecked
+					return nil, fmt.Errorf("%s is %s", dep, byKey[dep].Action)
+				}
+			}
+			mu.Lock()
+			fields, err := resolve(res.fields(), b, outputs)
+			mu.Unlock()
+			if err != nil {
+				return nil, err
+			}
+			param, err := genericResource(fields.(map[string]interface{}), b.Location)
+			param.Tags = r.tags(tags, param.Tags)
+			return param, err
+		}, res.Immutable)
+		if output != nil {
+			mu.Lock()
+			outputs[key] = output
+			mu.Unlock()
+		}
+		return byKey[key].Err
+	})
+	// The resources that refer to one that could not be compared cannot be
+	// compared either.
+	for _, result := range results {
+		if skip, ok := result.Err.(*SkippedError); ok {
+			result.Action, result.Err = Unchecked, fmt.Errorf("%s is %s", skip.Key, byKey[skip.Key].Action)
+		}
+	}
+	return results
+}
+
+// compare gets the resource of result and compares it with the resource want
+// returns for it, setting the action of result to InSync, Drifted or Missing,
+// or to Failed or Unchecked with the error of want. It returns the existing
+// resource in its JSON form, nil if there is none.
+func (r *Runner) compare(ctx context.Context, result *Result, apiVersion string, want func() (interface{}, error), immutable []string) interface{} {
+	what := lro.KindOf(result.Type).Label() + " " + result.Name
+	start := time.Now()
+	var have interface{}
+	err := func() error {
+		resp, err := r.Client.GetByID(ctx, result.ID, apiVersion, nil)
+		switch {
+		case converge.NotFound(err):
+			result.Action = Missing
+			return nil
+		case err != nil:
+			return fmt.Errorf("failed to get %s: %w", what, err)
+		}
+		data, err := json.Marshal(resp.GenericResource)
+		if err == nil {
+			err = json.Unmarshal(data, &have)
+		}
+		if err != nil {
+			return err
+		}
+		param, err := want()
+		if err != nil {
+			return err
+		}
+		if p, ok := param.(armresources.GenericResource); ok {
+			param = r.withOwners(p, resp.GenericResource.Tags)
+		}
+		diffs, err := converge.Compare(have, param, append([]string{"location"}, immutable...)...)
+		if err != nil {
+			return err
+		}
+		result.Differences = diffs
+		result.Action = InSync
+		if len(diffs) > 0 {
+			result.Action = Drifted
+		}
+		return nil
+	}()
+	if result.Action == Unchecked {
+		result.Err = err
+		return have
+	}
+	r.Steps.Record("diff "+what, start, []string{result.ID}, err)
+	if err != nil {
+		result.Action, result.Err = Failed, err
+	}
+	return have
+}
@@ -0,0 +1,520 @@
+// Package spec describes the environments of the samples declaratively: a
+// resource group and the resources in it, with their dependencies, in a YAML
+// or JSON file. Apply creates the resources in the order of their
+// dependencies, those that do not depend on each other in parallel, passing
+// the properties of the resources it created to those that refer to them.
+// Destroy deletes them in the reverse order.
+package spec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Group is the key by which the resources refer to the resource group of
+// the environment, as in ${resourceGroup.id}.
+const Group = "resourceGroup"
+
+// Spec is an environment: a resource group and the resources in it.
+type Spec struct {
+	// Name names the environment in the tags and the state of its runs. It
+	// is the base name of the file when empty.
+	Name string `json:"name"`
+	// ResourceGroup is the name of the resource group, which may use ${run}.
+	ResourceGroup string `json:"resourceGroup"`
+	// Location is the location of the resource group and the default of
+	// the resources, the location of the configuration when empty.
+	Location string `json:"location"`
+	// Tags are added to the tags of the resource group and of every
+	// resource.
+	Tags map[string]string `json:"tags"`
+	// Resources are the resources in the group by key, the name by which
+	// the other resources refer to them.
+	Resources map[string]*Resource `json:"resources"`
+}
+
+// Resource is a resource of an environment. Its strings may refer to the
+// run ID and the location as ${run} and ${location}, and to the properties
+// of the resource group and of the other resources as ${key.path}, such as
+// ${vnet.id} or ${ip.properties.ipAddress}. A resource that refers to another
+// depends on it.
+type Resource struct {
+	// Type is the resource type, such as Microsoft.Network/virtualNetworks.
+	Type string `json:"type"`
+	// Name is the name of the resource, which may use ${run} and
+	// ${location} but no other resource. It is the key when empty.
+	Name string `json:"name"`
+	// APIVersion is the API version of the type, that of the Azure Stack
+	// Hub profile of the samples when empty.
+	APIVersion string                 `json:"apiVersion"`
+	Location   string                 `json:"location"`
+	Kind       string                 `json:"kind"`
+	SKU        map[string]interface{} `json:"sku"`
+	Tags       map[string]string      `json:"tags"`
+	Properties map[string]interface{} `json:"properties"`
+	// DependsOn are the keys of the resources the resource depends on
+	// besides those it refers to.
+	DependsOn []string `json:"dependsOn"`
+	// Immutable are the paths of the properties that cannot change in
+	// place, such as properties.storageProfile, besides the location. A
+	// resource that differs in them from an existing one is reported as
+	// drifted rather than updated.
+	Immutable []string `json:"immutable"`
+
+	key  string
+	deps []string
+}
+
+// Key returns the key of the resource in its spec.
+func (r *Resource) Key() string {
+	return r.key
+}
+
+// DependsOnKeys returns the keys of the resources r depends on, sorted.
+func (r *Resource) DependsOnKeys() []string {
+	return r.deps
+}
+
+// apiVersions are the API versions of the Azure Stack Hub 2020-09-01-hybrid
+// profile by provider namespace, and by type where a type differs from its
+// namespace.
+var apiVersions = map[string]string{
+	"microsoft.resources":                "2019-10-01",
+	"microsoft.network":                  "2018-11-01",
+	"microsoft.compute":                  "2020-06-01",
+	"microsoft.compute/disks":            "2019-07-01",
+	"microsoft.compute/snapshots":        "2019-07-01",
+	"microsoft.storage":                  "2019-06-01",
+	"microsoft.keyvault":                 "2019-09-01",
+	"microsoft.resources/resourcegroups": "2019-10-01",
+}
+
+// DefaultAPIVersion returns the API version of the profile of the samples for
+// the resource type, "" when the profile has no such provider.
+func DefaultAPIVersion(resourceType string) string {
+	t := strings.ToLower(resourceType)
+	if v, ok := apiVersions[t]; ok {
+		return v
+	}
+	for strings.Contains(t, "/") {
+		t = t[:strings.LastIndex(t, "/")]
+		if v, ok := apiVersions[t]; ok {
+			return v
+		}
+	}
+	return ""
+}
+
+var (
+	keyPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]*$`)
+	// reference matches ${run}, ${location} and ${key.path}.
+	reference = regexp.MustCompile(`\$\{([A-Za-z][A-Za-z0-9_-]*)((?:\.[A-Za-z0-9_-]+)*)\}`)
+)
+
+// Load reads the spec in the file at path, in YAML unless its extension is
+// .json, and checks it.
+func Load(path string) (*Spec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(filepath.Ext(path), ".json") {
+		if data, err = yamlToJSON(data); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	}
+	var s Spec
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&s); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if s.Name == "" {
+		s.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if err := s.check(); err != nil {
+		return nil, fmt.Errorf("invalid spec %s: %w", path, err)
+	}
+	return &s, nil
+}
+
+// yamlToJSON converts a YAML document to JSON, so that the specs in either
+// are decoded alike.
+func yamlToJSON(data []byte) ([]byte, error) {
+	var v interface{}
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	v, err := jsonValue(v)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// jsonValue returns v with the mappings that yaml decodes with keys other
+// than strings, such as numbers, turned into maps with string keys, which
+// JSON objects have.
+func jsonValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			e, err := jsonValue(e)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = e
+		}
+		return v, nil
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			e, err := jsonValue(e)
+			if err != nil {
+				return nil, err
+			}
+			m[fmt.Sprint(k)] = e
+		}
+		return m, nil
+	case []interface{}:
+		for i, e := range v {
+			e, err := jsonValue(e)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = e
+		}
+		return v, nil
+	}
+	return v, nil
+}
+
+// check checks the spec, fills in the defaults of its resources and finds
+// their dependencies.
+func (s *Spec) check() error {
+	if s.ResourceGroup == "" {
+		return fmt.Errorf("resourceGroup is missing")
+	}
+	if err := onlyBuiltins("resourceGroup", s.ResourceGroup); err != nil {
+		return err
+	}
+	if len(s.Resources) == 0 {
+		return fmt.Errorf("there are no resources")
+	}
+	for _, key := range s.Keys() {
+		r := s.Resources[key]
+		if r == nil {
+			return fmt.Errorf("resource %s is empty", key)
+		}
+		r.key = key
+		switch {
+		case key == Group || key == "run" || key == "location" || !keyPattern.MatchString(key):
+			return fmt.Errorf("invalid resource key %q", key)
+		case strings.Count(r.Type, "/") < 1:
+			return fmt.Errorf("resource %s: invalid type %q, want a type such as Microsoft.Network/virtualNetworks", key, r.Type)
+		}
+		if r.Name == "" {
+			r.Name = key
+		}
+		if err := onlyBuiltins("resource "+key+": name", r.Name); err != nil {
+			return err
+		}
+		if strings.Count(r.Name, "/") != strings.Count(r.Type, "/")-1 {
+			return fmt.Errorf("resource %s: name %q does not match the levels of type %s", key, r.Name, r.Type)
+		}
+		if r.APIVersion == "" {
+			if r.APIVersion = DefaultAPIVersion(r.Type); r.APIVersion == "" {
+				return fmt.Errorf("resource %s: no default API version for %s, set apiVersion", key, r.Type)
+			}
+		}
+		deps := map[string]bool{}
+		for _, dep := range r.DependsOn {
+			deps[dep] = true
+		}
+		for _, ref := range references(r.fields()) {
+			deps[ref] = true
+		}
+		delete(deps, Group)
+		delete(deps, "run")
+		delete(deps, "location")
+		for dep := range deps {
+			if dep == key {
+				return fmt.Errorf("resource %s refers to itself", key)
+			}
+			if s.Resources[dep] == nil {
+				return fmt.Errorf("resource %s depends on %s, which is not a resource of the spec", key, dep)
+			}
+			r.deps = append(r.deps, dep)
+		}
+		sort.Strings(r.deps)
+	}
+	_, err := s.Order()
+	return err
+}
+
+// onlyBuiltins checks that value refers to nothing but the run ID and the
+// location, which are known before any resource exists.
+func onlyBuiltins(what, value string) error {
+	for _, ref := range references(value) {
+		if ref != "run" && ref != "location" {
+			return fmt.Errorf("%s %q may only refer to ${run} and ${location}", what, value)
+		}
+	}
+	return nil
+}
+
+// fields returns the values of r that may refer to other resources.
+func (r *Resource) fields() map[string]interface{} {
+	return map[string]interface{}{"location": r.Location, "kind": r.Kind, "sku": r.SKU, "tags": r.Tags, "properties": r.Properties}
+}
+
+// references returns the keys v refers to, run and location included.
+func references(v interface{}) []string {
+	var refs []string
+	walkStrings(v, func(s string) {
+		for _, m := range reference.FindAllStringSubmatch(s, -1) {
+			refs = append(refs, m[1])
+		}
+	})
+	return refs
+}
+
+func walkStrings(v interface{}, fn func(string)) {
+	switch v := v.(type) {
+	case string:
+		fn(v)
+	case map[string]string:
+		for _, s := range v {
+			fn(s)
+		}
+	case map[string]interface{}:
+		for _, item := range v {
+			walkStrings(item, fn)
+		}
+	case []interface{}:
+		for _, item := range v {
+			walkStrings(item, fn)
+		}
+	}
+}
+
+// Keys returns the keys of the resources, sorted.
+func (s *Spec) Keys() []string {
+	keys := make([]string, 0, len(s.Resources))
+	for key := range s.Resources {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Order returns the keys of the resources in an order in which every
+// resource follows those it depends on, or an error naming a dependency
+// cycle.
+func (s *Spec) Order() ([]string, error) {
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := map[string]int{}
+	var order, path []string
+	var visit func(key string) error
+	visit = func(key string) error {
+		switch state[key] {
+		case done:
+			return nil
+		case visiting:
+			i := 0
+			for path[i] != key {
+				i++
+			}
+			return fmt.Errorf("dependency cycle: %s -> %s", strings.Join(path[i:], " -> "), key)
+		}
+		state[key] = visiting
+		path = append(path, key)
+		for _, dep := range s.Resources[key].deps {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[key] = done
+		order = append(order, key)
+		return nil
+	}
+	for _, key := range s.Keys() {
+		if err := visit(key); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// Dependents returns the keys of the resources that depend on key, sorted.
+func (s *Spec) Dependents(key string) []string {
+	var dependents []string
+	for _, k := range s.Keys() {
+		for _, dep := range s.Resources[k].deps {
+			if dep == key {
+				dependents = append(dependents, k)
+			}
+		}
+	}
+	return dependents
+}
+
+// Builtins are the values of ${run} and ${location}.
+type Builtins struct {
+	RunID    string
+	Location string
+}
+
+// expand replaces the references to the built-in values in value.
+func (b Builtins) expand(value string) string {
+	return reference.ReplaceAllStringFunc(value, func(ref string) string {
+		switch ref {
+		case "${run}":
+			return b.RunID
+		case "${location}":
+			return b.Location
+		}
+		return ref
+	})
+}
+
+// GroupName returns the name of the resource group of the environment.
+func (s *Spec) GroupName(b Builtins) string {
+	return b.expand(s.ResourceGroup)
+}
+
+// location returns the location of the resource group.
+func (s *Spec) location(b Builtins) string {
+	if s.Location == "" {
+		return b.Location
+	}
+	return b.expand(s.Location)
+}
+
+// ResourceID returns the ID of the resource key in the resource group with
+// the ID groupID.
+func (s *Spec) ResourceID(groupID, key string, b Builtins) string {
+	r := s.Resources[key]
+	types := strings.Split(r.Type, "/")
+	names := strings.Split(b.expand(r.Name), "/")
+	id := groupID + "/providers/" + types[0]
+	for i, name := range names {
+		id += "/" + types[i+1] + "/" + name
+	}
+	return id
+}
+
+// resolve returns v with the references replaced by the built-in values and
+// the outputs of the resources, by key. A string that is a single reference
+// to something other than a string is replaced by its value.
+func resolve(v interface{}, b Builtins, outputs map[string]interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		if m := reference.FindStringSubmatch(v); m != nil && m[0] == v && m[1] != "run" && m[1] != "location" {
+			return lookup(m[1], m[2], outputs)
+		}
+		var err error
+		s := reference.ReplaceAllStringFunc(v, func(ref string) string {
+			m := reference.FindStringSubmatch(ref)
+			switch m[1] {
+			case "run":
+				return b.RunID
+			case "location":
+				return b.Location
+			}
+			value, e := lookup(m[1], m[2], outputs)
+			if e != nil {
+				err = e
+				return ref
+			}
+			if s, ok := value.(string); ok {
+				return s
+			}
+			data, _ := json.Marshal(value)
+			return string(data)
+		})
+		return s, err
+	case map[string]string:
+		m := map[string]interface{}{}
+		for key, item := range v {
+			resolved, err := resolve(item, b, outputs)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = resolved
+		}
+		return m, nil
+	case map[string]interface{}:
+		m := map[string]interface{}{}
+		for key, item := range v {
+			resolved, err := resolve(item, b, outputs)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = resolved
+		}
+		return m, nil
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			resolved, err := resolve(item, b, outputs)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = resolved
+		}
+		return items, nil
+	}
+	return v, nil
+}
+
+// lookup returns the value at path, such as .properties.subnets.0.id, of the
+// output of the resource key.
+func lookup(key, path string, outputs map[string]interface{}) (interface{}, error) {
+	v, ok := outputs[key]
+	if !ok {
+		return nil, fmt.Errorf("${%s%s} refers to %s, which does not exist yet", key, path, key)
+	}
+	for _, segment := range strings.Split(strings.TrimPrefix(path, "."), ".") {
+		if segment == "" {
+			continue
+		}
+		switch node := v.(type) {
+		case map[string]interface{}:
+			v, ok = node[segment]
+			if !ok {
+				for k, item := range node {
+					if strings.EqualFold(k, segment) {
+						v, ok = item, true
+						break
+					}
+				}
+			}
+		case []interface{}:
+			var i int
+			if _, err := fmt.Sscan(segment, &i); err == nil && i >= 0 && i < len(node) {
+				v, ok = node[i], true
+			} else {
+				ok = false
+			}
+		default:
+			ok = false
+		}
+		if !ok {
+			return nil, fmt.Errorf("${%s%s}: %s has no %s", key, path, key, strings.TrimPrefix(path, "."))
+		}
+	}
+	return v, nil
+}
@@ -0,0 +1,154 @@
+package spec
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeSpec(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestYAMLToJSON(t *testing.T) {
+	data := `---
+# a comment
+name: "quoted # not a comment"
+list: [a, 'b c', 3]
+flow: {x: 1, y: true}
+plain: a, b # comment
+nested:
+  - &first
+    name: first
+    value: 10.0.0.0/8
+  - <<: *first
+    name: second
+ports:
+  22: ssh
+block: |
+  line one
+  line two
+empty:
+`
+	converted, err := yamlToJSON([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got interface{}
+	if err := json.Unmarshal(converted, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"name":  "quoted # not a comment",
+		"list":  []interface{}{"a", "b c", float64(3)},
+		"flow":  map[string]interface{}{"x": float64(1), "y": true},
+		"plain": "a, b",
+		"nested": []interface{}{
+			map[string]interface{}{"name": "first", "value": "10.0.0.0/8"},
+			map[string]interface{}{"name": "second", "value": "10.0.0.0/8"},
+		},
+		"ports": map[string]interface{}{"22": "ssh"},
+		"block": "line one\nline two\n",
+		"empty": nil,
+	}
+	if !reflect.DeepEqual(got, want) {
+		w, _ := json.Marshal(want)
+		t.Errorf("converted to\n%s\nwant\n%s", converted, w)
+	}
+	if _, err := yamlToJSON([]byte("a:\n\tb: c\n")); err == nil {
+		t.Error("a spec indented with tabs was converted")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	s, err := Load("../environments/vm.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	order, err := s.Order()
+	if err != nil {
+		t.Fatal(err)
+	}
+	position := map[string]int{}
+	for i, key := range order {
+		position[key] = i
+	}
+	for _, key := range s.Keys() {
+		for _, dep := range s.Resources[key].DependsOnKeys() {
+			if position[dep] > position[key] {
+				t.Errorf("%s comes before %s, which it depends on: %v", key, dep, order)
+			}
+		}
+	}
+	if got := strings.Join(s.Resources["nic"].DependsOnKeys(), ","); got != "ip,nsg,vnet" {
+		t.Errorf("nic depends on %s, want ip,nsg,vnet", got)
+	}
+	if got := strings.Join(s.Dependents("disk"), ","); got != "vm" {
+		t.Errorf("the dependents of disk are %s, want vm", got)
+	}
+	b := Builtins{RunID: "run01", Location: "local"}
+	if got, want := s.ResourceID("/subscriptions/sub/resourceGroups/g", "vnet", b), "/subscriptions/sub/resourceGroups/g/providers/Microsoft.Network/virtualNetworks/TestGoVnet-run01"; got != want {
+		t.Errorf("ResourceID = %s, want %s", got, want)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"resources:\n  a:\n    type: X/y\n", "resourceGroup is missing"},
+		{"resourceGroup: g-${a.id}\nresources:\n  a:\n    type: X/y\n", "may only refer to ${run} and ${location}"},
+		{"resourceGroup: g\nresources:\n  a:\n    type: X/y\n    apiVersion: v\n    dependsOn: [b]\n", "depends on b, which is not a resource"},
+		{"resourceGroup: g\nresources:\n  a:\n    type: X/y\n    apiVersion: v\n    properties:\n      p: ${b.id}\n  b:\n    type: X/y\n    apiVersion: v\n    dependsOn: [a]\n", "dependency cycle: a -> b -> a"},
+		{"resourceGroup: g\nresources:\n  a:\n    type: X/y\n    apiVersion: v\n    name: a/b\n", "does not match the levels of type"},
+		{"resourceGroup: g\nresources:\n  a:\n    type: X/y\n    apiVersion: v\n    colour: red\n", "unknown field"},
+	}
+	for _, tt := range tests {
+		_, err := Load(writeSpec(t, "env.yaml", tt.spec))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Load of\n%s\nfailed with %v, want %q", tt.spec, err, tt.want)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	outputs := map[string]interface{}{
+		"vnet": map[string]interface{}{
+			"id":         "/vnets/v",
+			"properties": map[string]interface{}{"subnets": []interface{}{map[string]interface{}{"id": "/vnets/v/subnets/s"}}},
+		},
+	}
+	b := Builtins{RunID: "run01", Location: "local"}
+	v := map[string]interface{}{
+		"subnet": "${vnet.properties.subnets.0.id}",
+		"all":    "${vnet.properties.subnets}",
+		"name":   "nic-${run}-${location} in ${vnet.id}",
+	}
+	got, err := resolve(v, b, outputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"subnet": "/vnets/v/subnets/s",
+		"all":    []interface{}{map[string]interface{}{"id": "/vnets/v/subnets/s"}},
+		"name":   "nic-run01-local in /vnets/v",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("resolved %v, want %v", got, want)
+	}
+	if _, err := resolve("${vnet.properties.missing}", b, outputs); err == nil || !strings.Contains(err.Error(), "vnet has no properties.missing") {
+		t.Errorf("resolving a missing property failed with %v", err)
+	}
+	if _, err := resolve("${ip.id}", b, outputs); err == nil || !strings.Contains(err.Error(), "does not exist yet") {
+		t.Errorf("resolving a resource without output failed with %v", err)
+	}
+}
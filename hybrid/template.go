@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/resources/armresources"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/converge"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/lro"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/session"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/hybrid/deployment"
+)
+
+// templateGroup is the resource group templates are deployed to unless
+// -group is given.
+const templateGroup = "TestGoTemplateResourceGroup"
+
+func runValidate(fs *flag.FlagSet, f *session.Flags, args []string) int {
+	return runTemplate("validate", fs, f, args)
+}
+
+func runDeploy(fs *flag.FlagSet, f *session.Flags, args []string) int {
+	return runTemplate("deploy", fs, f, args)
+}
+
+// runTemplate validates or deploys an ARM template to a resource group.
+func runTemplate(verb string, fs *flag.FlagSet, f *session.Flags, args []string) int {
+	parameters := fs.String("parameters", "", "JSON file with the values of the parameters of the template")
+	group := fs.String("group", "", "resource group to deploy to (default "+templateGroup+" of the run)")
+	name := fs.String("deployment", "", "name of the deployment (default the name of the template file with the run ID)")
+	modeName := fs.String("mode", "incremental", "deployment mode, incremental or complete")
+	args = parseArgs(fs, args)
+	mode, err := deployment.ParseMode(*modeName)
+	if len(args) != 1 || err != nil {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "hybrid %s: %s\n", verb, err)
+		}
+		fmt.Fprintf(os.Stderr, "Usage: hybrid %s <template> [-parameters file] [-group name] [-deployment name] [-mode incremental|complete] [flags]\n", verb)
+		return 2
+	}
+	t, err := deployment.Load(args[0], *parameters)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hybrid %s: %s\n", verb, err)
+		return 2
+	}
+	s, err := session.Open("template", f, transport)
+	if err != nil {
+		fmt.Fprintf(f.Log(), "%s\n", err)
+		return 1
+	}
+	if *group == "" {
+		if *group, err = s.ResourceGroup(templateGroup); err != nil {
+			fmt.Fprintf(s.Log, "%s\n", err)
+			s.Exit(2)
+		}
+	}
+	if *name == "" {
+		base := strings.TrimSuffix(filepath.Base(args[0]), filepath.Ext(args[0]))
+		if *name, err = s.Names.Name(lro.Deployment, base); err != nil {
+			fmt.Fprintf(s.Log, "%s\n", err)
+			s.Exit(2)
+		}
+	}
+	groups, err := armresources.NewResourceGroupsClient(s.Config.SubscriptionId, s.Credential, &s.Options)
+	if err != nil {
+		fmt.Fprintf(s.Log, "failed to create the resource group client: %s\n", err)
+		s.Exit(1)
+	}
+	deployments, err := armresources.NewDeploymentsClient(s.Config.SubscriptionId, s.Credential, &s.Options)
+	if err != nil {
+		fmt.Fprintf(s.Log, "failed to create the deployment client: %s\n", err)
+		s.Exit(1)
+	}
+	operations, err := armresources.NewDeploymentOperationsClient(s.Config.SubscriptionId, s.Credential, &s.Options)
+	if err != nil {
+		fmt.Fprintf(s.Log, "failed to create the deployment operation client: %s\n", err)
+		s.Exit(1)
+	}
+	d := &deployment.Deployer{
+		Deployments: deployments,
+		Operations:  operations,
+		Tags:        s.Tags,
+		Waiter:      s.Waiter,
+		Steps:       s.Steps,
+		Out:         s.Out,
+	}
+	ctx := s.Context()
+
+	if verb == "validate" {
+		if !s.DryRun() {
+			exists, err := groups.CheckExistence(ctx, *group, nil)
+			if err != nil {
+				fmt.Fprintf(s.Log, "failed to check whether resource group %s exists: %s\n", *group, err)
+				s.Exit(1)
+			}
+			if !exists.Success {
+				fmt.Fprintf(s.Log, "Resource group %s does not exist, deploy creates it or -group selects another\n", *group)
+				s.Exit(1)
+			}
+		}
+		fmt.Fprintf(s.Out, "Validating template %s as deployment %s to resource group %s in %s mode\n", t.Path, *name, *group, mode)
+		props, err := d.Validate(ctx, *group, *name, t, mode)
+		if err != nil {
+			s.Exit(templateFailed(s.Log, err, *group))
+		}
+		if props != nil {
+			fmt.Fprintf(s.Out, "Template %s is valid, it deploys:\n", t.Path)
+			for _, r := range deployment.ResourceNames(props.ValidatedResources) {
+				fmt.Fprintf(s.Out, "  %s\n", r)
+			}
+		}
+		s.Exit(0)
+	}
+
+	if mode == armresources.DeploymentModeComplete && !s.DryRun() && !s.Confirm(fmt.Sprintf("Deploy in complete mode, deleting the resources of resource group %s that template %s does not have?", *group, t.Path)) {
+		fmt.Fprintln(s.Log, "Nothing was deployed")
+		s.Exit(0)
+	}
+	err = s.Steps.Step("create resource group "+*group, func() error {
+		return ensureGroup(ctx, s, groups, *group)
+	})
+	if err != nil {
+		fmt.Fprintf(s.Log, "%s\n", err)
+		s.Exit(1)
+	}
+	fmt.Fprintf(s.Out, "Deploying template %s as deployment %s to resource group %s in %s mode\n", t.Path, *name, *group, mode)
+	props, err := d.Deploy(ctx, *group, *name, t, mode)
+	if err != nil {
+		// The rollback of the run would delete the deployment, whose
+		// operations explain the failure, with its resource group.
+		s.Keep(fmt.Sprintf("/subscriptions/%s/resourceGroups/%s", s.Config.SubscriptionId, *group))
+		s.Exit(templateFailed(s.Log, err, *group))
+	}
+	fmt.Fprintf(s.Out, "Deployment %s succeeded, its outputs are:\n", *name)
+	if err := deployment.WriteOutputs(s.Lists.W, props); err != nil {
+		fmt.Fprintf(s.Log, "%s\n", err)
+		s.Exit(1)
+	}
+	s.Exit(0)
+	return 0
+}
+
+// ensureGroup creates the resource group name unless it exists.
+func ensureGroup(ctx context.Context, s *session.Session, groups *armresources.ResourceGroupsClient, name string) error {
+	param := armresources.ResourceGroup{
+		Location: to.Ptr(s.Config.Location),
+		Tags:     s.Tags,
+	}
+	_, err := converge.Ensure(s.Converge, lro.ResourceGroup.Label()+" "+name, func() (armresources.ResourceGroup, error) {
+		resp, err := groups.Get(ctx, name, nil)
+		return resp.ResourceGroup, err
+	}, param, func() (armresources.ResourceGroup, error) {
+		fmt.Fprintf(s.Out, "Creating resource group %s\n", name)
+		resp, err := groups.CreateOrUpdate(ctx, name, param, nil)
+		if err != nil {
+			return resp.ResourceGroup, fmt.Errorf("failed to create resource group %s: %w", name, err)
+		}
+		return resp.ResourceGroup, nil
+	}, "location")
+	return err
+}
+
+// templateFailed writes to w why a validation or deployment to the resource
+// group failed, with the nested errors ARM reported, and returns the exit
+// code.
+func templateFailed(w io.Writer, err error, group string) int {
+	var e *deployment.Error
+	if !errors.As(err, &e) {
+		fmt.Fprintf(w, "%s\n", err)
+		return 1
+	}
+	if e.Action == "validate" {
+		fmt.Fprintf(w, "Validation of deployment %s failed:\n", e.Name)
+	} else {
+		fmt.Fprintf(w, "Deployment %s failed:\n", e.Name)
+	}
+	deployment.WriteError(w, e.Detail)
+	if e.Action == "deploy" {
+		fmt.Fprintf(w, "hybrid failures %s -group %s lists the operations that failed and their root causes\n", e.Name, group)
+	}
+	return 1
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/cassette"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/fakestack"
+)
+
+var record = flag.Bool("record", false, "record testdata/sample.json against the fake stamp before replaying it")
+
+// wantOutput is printed by every successful run of the sample with -clean.
+var wantOutput = []string{
+	"Completed: create key vault gotestkeyvault-fake01",
+	"Secret retrieved. Name: testgokey",
+	"Completed: delete resource group TestGoKVSampleResourceGroup-fake01",
+}
+
+func TestMain(m *testing.M) {
+	fakestack.Main(m, func(t policy.Transporter) {
+		transport = t
+		main()
+	})
+}
+
+func checkRun(t *testing.T, result fakestack.Result) {
+	t.Helper()
+	if result.ExitCode != 0 {
+		t.Fatalf("exit code %d, output:\n%s", result.ExitCode, result.Output)
+	}
+	for _, want := range wantOutput {
+		if !strings.Contains(result.Output, want) {
+			t.Errorf("output is missing %q:\n%s", want, result.Output)
+		}
+	}
+}
+
+func TestSample(t *testing.T) {
+	tests := []struct {
+		identity fakestack.Identity
+		args     []string
+	}{
+		{fakestack.AAD, []string{"-secret", "-disableID"}},
+		{fakestack.ADFS, nil},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.identity), func(t *testing.T) {
+			stack := fakestack.Start(t, tt.identity)
+			stack.SetPageSize(1)
+			checkRun(t, stack.Run(t, append(tt.args, "-clean")...))
+			if left := stack.Resources(); len(left) != 0 {
+				t.Errorf("resources left behind: %v", left)
+			}
+		})
+	}
+}
+
+// TestReplay runs the sample from the interactions recorded in testdata, which
+// are synthetic: they were recorded against the fake stamp, not a real one.
+// Run it with -record to record them again.
+func TestReplay(t *testing.T) {
+	path := filepath.Join("testdata", "sample.json")
+	args := []string{"-secret", "-disableID", "-clean", "-cassette", path}
+	if *record {
+		stack := fakestack.Start(t, fakestack.AAD)
+		checkRun(t, stack.Run(t, append(args, "-cassetteMode", "record")...))
+	}
+	endpoint, err := cassette.ResourceManagerEndpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRun(t, fakestack.RunOffline(t, endpoint, append(args, "-cassetteMode", "replay")...))
+}
@@ -0,0 +1,57 @@
+// Package demo is the key vault sample: it creates a key vault, stores a
+// secret in it and deletes the vault again.
+package demo
+
+import (
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/keyvault/armkeyvault"
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/resources/armresources"
+
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/session"
+)
+
+// ResourceGroup is the resource group the demo creates its resources in,
+// before the suffix of -groupSuffix and the run ID.
+const ResourceGroup = "TestGoKVSampleResourceGroup"
+
+// Run runs the demo on the stamp of sess.
+func Run(sess *session.Session) error {
+	rgClient, err := armresources.NewResourceGroupsClient(sess.Config.SubscriptionId, sess.Credential, &sess.Options)
+	if err != nil {
+		return fmt.Errorf("failed to create the resource group client: %w", err)
+	}
+
+	fmt.Fprintln(sess.Out, "Creating Key Vault client")
+	kvClient, err := armkeyvault.NewVaultsClient(sess.Config.SubscriptionId, sess.Credential, &sess.Options)
+	if err != nil {
+		return fmt.Errorf("failed to create the key vault client: %w", err)
+	}
+
+	fmt.Fprintln(sess.Out, "Creating Secret Client")
+	secClient, err := armkeyvault.NewSecretsClient(sess.Config.SubscriptionId, sess.Credential, &sess.Options)
+	if err != nil {
+		return fmt.Errorf("failed to create the secrets client: %w", err)
+	}
+
+	resourceGroupName, err := sess.ResourceGroup(ResourceGroup)
+	if err != nil {
+		return err
+	}
+	s := &sample{
+		groups:   rgClient,
+		vaults:   kvClient,
+		secrets:  secClient,
+		names:    sess.Names,
+		converge: sess.Converge,
+		waiter:   sess.Waiter,
+		out:      sess.Out,
+		lists:    sess.Lists,
+		steps:    sess.Steps,
+		location: sess.Config.Location,
+		tags:     sess.Tags,
+		tenantID: sess.AdminTenantID,
+		objectID: sess.Config.ObjectId,
+	}
+	return s.run(sess.Context(), resourceGroupName, "gotestkeyvault", sess.Clean)
+}
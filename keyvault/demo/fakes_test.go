@@ -0,0 +1,107 @@
+// Code generated by fakegen from workflow.go; DO NOT EDIT.
+
+package demo
+
+import (
+	"context"
+
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/keyvault/armkeyvault"
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/resources/armresources"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+)
+
+var _ resourceGroupsClient = (*fakeResourceGroupsClient)(nil)
+
+// fakeResourceGroupsClient is a fake resourceGroupsClient. Its methods call the function
+// fields of the same name with the Func suffix, which panic when they are not
+// set.
+type fakeResourceGroupsClient struct {
+	CreateOrUpdateFunc func(ctx context.Context, resourceGroupName string, parameters armresources.ResourceGroup, options *armresources.ResourceGroupsClientCreateOrUpdateOptions) (armresources.ResourceGroupsClientCreateOrUpdateResponse, error)
+	GetFunc            func(ctx context.Context, resourceGroupName string, options *armresources.ResourceGroupsClientGetOptions) (armresources.ResourceGroupsClientGetResponse, error)
+	BeginDeleteFunc    func(ctx context.Context, resourceGroupName string, options *armresources.ResourceGroupsClientBeginDeleteOptions) (*runtime.Poller[armresources.ResourceGroupsClientDeleteResponse], error)
+}
+
+func (f *fakeResourceGroupsClient) CreateOrUpdate(ctx context.Context, resourceGroupName string, parameters armresources.ResourceGroup, options *armresources.ResourceGroupsClientCreateOrUpdateOptions) (armresources.ResourceGroupsClientCreateOrUpdateResponse, error) {
+	if f.CreateOrUpdateFunc == nil {
+		panic("fakeResourceGroupsClient.CreateOrUpdate called without CreateOrUpdateFunc")
+	}
+	return f.CreateOrUpdateFunc(ctx, resourceGroupName, parameters, options)
+}
+
+func (f *fakeResourceGroupsClient) Get(ctx context.Context, resourceGroupName string, options *armresources.ResourceGroupsClientGetOptions) (armresources.ResourceGroupsClientGetResponse, error) {
+	if f.GetFunc == nil {
+		panic("fakeResourceGroupsClient.Get called without GetFunc")
+	}
+	return f.GetFunc(ctx, resourceGroupName, options)
+}
+
+func (f *fakeResourceGroupsClient) BeginDelete(ctx context.Context, resourceGroupName string, options *armresources.ResourceGroupsClientBeginDeleteOptions) (*runtime.Poller[armresources.ResourceGroupsClientDeleteResponse], error) {
+	if f.BeginDeleteFunc == nil {
+		panic("fakeResourceGroupsClient.BeginDelete called without BeginDeleteFunc")
+	}
+	return f.BeginDeleteFunc(ctx, resourceGroupName, options)
+}
+
+var _ vaultsClient = (*fakeVaultsClient)(nil)
+
+// fakeVaultsClient is a fake vaultsClient. Its methods call the function
+// fields of the same name with the Func suffix, which panic when they are not
+// set.
+type fakeVaultsClient struct {
+	NewListPagerFunc        func(filter armkeyvault.Enum10, apiVersion armkeyvault.Enum11, options *armkeyvault.VaultsClientListOptions) *runtime.Pager[armkeyvault.VaultsClientListResponse]
+	BeginCreateOrUpdateFunc func(ctx context.Context, resourceGroupName string, vaultName string, parameters armkeyvault.VaultCreateOrUpdateParameters, options *armkeyvault.VaultsClientBeginCreateOrUpdateOptions) (*runtime.Poller[armkeyvault.VaultsClientCreateOrUpdateResponse], error)
+	GetFunc                 func(ctx context.Context, resourceGroupName string, vaultName string, options *armkeyvault.VaultsClientGetOptions) (armkeyvault.VaultsClientGetResponse, error)
+	DeleteFunc              func(ctx context.Context, resourceGroupName string, vaultName string, options *armkeyvault.VaultsClientDeleteOptions) (armkeyvault.VaultsClientDeleteResponse, error)
+}
+
+func (f *fakeVaultsClient) NewListPager(filter armkeyvault.Enum10, apiVersion armkeyvault.Enum11, options *armkeyvault.VaultsClientListOptions) *runtime.Pager[armkeyvault.VaultsClientListResponse] {
+	if f.NewListPagerFunc == nil {
+		panic("fakeVaultsClient.NewListPager called without NewListPagerFunc")
+	}
+	return f.NewListPagerFunc(filter, apiVersion, options)
+}
+
+func (f *fakeVaultsClient) BeginCreateOrUpdate(ctx context.Context, resourceGroupName string, vaultName string, parameters armkeyvault.VaultCreateOrUpdateParameters, options *armkeyvault.VaultsClientBeginCreateOrUpdateOptions) (*runtime.Poller[armkeyvault.VaultsClientCreateOrUpdateResponse], error) {
+	if f.BeginCreateOrUpdateFunc == nil {
+		panic("fakeVaultsClient.BeginCreateOrUpdate called without BeginCreateOrUpdateFunc")
+	}
+	return f.BeginCreateOrUpdateFunc(ctx, resourceGroupName, vaultName, parameters, options)
+}
+
+func (f *fakeVaultsClient) Get(ctx context.Context, resourceGroupName string, vaultName string, options *armkeyvault.VaultsClientGetOptions) (armkeyvault.VaultsClientGetResponse, error) {
+	if f.GetFunc == nil {
+		panic("fakeVaultsClient.Get called without GetFunc")
+	}
+	return f.GetFunc(ctx, resourceGroupName, vaultName, options)
+}
+
+func (f *fakeVaultsClient) Delete(ctx context.Context, resourceGroupName string, vaultName string, options *armkeyvault.VaultsClientDeleteOptions) (armkeyvault.VaultsClientDeleteResponse, error) {
+	if f.DeleteFunc == nil {
+		panic("fakeVaultsClient.Delete called without DeleteFunc")
+	}
+	return f.DeleteFunc(ctx, resourceGroupName, vaultName, options)
+}
+
+var _ secretsClient = (*fakeSecretsClient)(nil)
+
+// fakeSecretsClient is a fake secretsClient. Its methods call the function
+// fields of the same name with the Func suffix, which panic when they are not
+// set.
+type fakeSecretsClient struct {
+	CreateOrUpdateFunc func(ctx context.Context, resourceGroupName string, vaultName string, secretName string, parameters armkeyvault.SecretCreateOrUpdateParameters, options *armkeyvault.SecretsClientCreateOrUpdateOptions) (armkeyvault.SecretsClientCreateOrUpdateResponse, error)
+	GetFunc            func(ctx context.Context, resourceGroupName string, vaultName string, secretName string, options *armkeyvault.SecretsClientGetOptions) (armkeyvault.SecretsClientGetResponse, error)
+}
+
+func (f *fakeSecretsClient) CreateOrUpdate(ctx context.Context, resourceGroupName string, vaultName string, secretName string, parameters armkeyvault.SecretCreateOrUpdateParameters, options *armkeyvault.SecretsClientCreateOrUpdateOptions) (armkeyvault.SecretsClientCreateOrUpdateResponse, error) {
+	if f.CreateOrUpdateFunc == nil {
+		panic("fakeSecretsClient.CreateOrUpdate called without CreateOrUpdateFunc")
+	}
+	return f.CreateOrUpdateFunc(ctx, resourceGroupName, vaultName, secretName, parameters, options)
+}
+
+func (f *fakeSecretsClient) Get(ctx context.Context, resourceGroupName string, vaultName string, secretName string, options *armkeyvault.SecretsClientGetOptions) (armkeyvault.SecretsClientGetResponse, error) {
+	if f.GetFunc == nil {
+		panic("fakeSecretsClient.Get called without GetFunc")
+	}
+	return f.GetFunc(ctx, resourceGroupName, vaultName, secretName, options)
+}
@@ -0,0 +1,254 @@
+package demo
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/keyvault/armkeyvault"
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/resources/armresources"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/converge"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/lro"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/naming"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/output"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/report"
+)
+
+//go:generate go run github.com/Azure-Samples/Hybrid-Golang-Samples/common/fakes/fakegen -type resourceGroupsClient,vaultsClient,secretsClient -out fakes_test.go
+
+// resourceGroupsClient is the part of *armresources.ResourceGroupsClient the
+// sample uses.
+type resourceGroupsClient interface {
+	CreateOrUpdate(ctx context.Context, resourceGroupName string, parameters armresources.ResourceGroup, options *armresources.ResourceGroupsClientCreateOrUpdateOptions) (armresources.ResourceGroupsClientCreateOrUpdateResponse, error)
+	Get(ctx context.Context, resourceGroupName string, options *armresources.ResourceGroupsClientGetOptions) (armresources.ResourceGroupsClientGetResponse, error)
+	BeginDelete(ctx context.Context, resourceGroupName string, options *armresources.ResourceGroupsClientBeginDeleteOptions) (*runtime.Poller[armresources.ResourceGroupsClientDeleteResponse], error)
+}
+
+// vaultsClient is the part of *armkeyvault.VaultsClient the sample uses.
+type vaultsClient interface {
+	NewListPager(filter armkeyvault.Enum10, apiVersion armkeyvault.Enum11, options *armkeyvault.VaultsClientListOptions) *runtime.Pager[armkeyvault.VaultsClientListResponse]
+	BeginCreateOrUpdate(ctx context.Context, resourceGroupName string, vaultName string, parameters armkeyvault.VaultCreateOrUpdateParameters, options *armkeyvault.VaultsClientBeginCreateOrUpdateOptions) (*runtime.Poller[armkeyvault.VaultsClientCreateOrUpdateResponse], error)
+	Get(ctx context.Context, resourceGroupName string, vaultName string, options *armkeyvault.VaultsClientGetOptions) (armkeyvault.VaultsClientGetResponse, error)
+	Delete(ctx context.Context, resourceGroupName string, vaultName string, options *armkeyvault.VaultsClientDeleteOptions) (armkeyvault.VaultsClientDeleteResponse, error)
+}
+
+// secretsClient is the part of *armkeyvault.SecretsClient the sample uses.
+type secretsClient interface {
+	CreateOrUpdate(ctx context.Context, resourceGroupName string, vaultName string, secretName string, parameters armkeyvault.SecretCreateOrUpdateParameters, options *armkeyvault.SecretsClientCreateOrUpdateOptions) (armkeyvault.SecretsClientCreateOrUpdateResponse, error)
+	Get(ctx context.Context, resourceGroupName string, vaultName string, secretName string, options *armkeyvault.SecretsClientGetOptions) (armkeyvault.SecretsClientGetResponse, error)
+}
+
+// sample runs the steps of the sample with the clients it is given and
+// reports its progress to out, its listings to lists and its steps to
+// steps, which may be nil. The vault is named by names, which may be nil as
+// well to keep the name the sample is given, and grants every permission to
+// the object objectID of the tenant tenantID. converge decides what to do
+// about the resources that exist already and may be nil too.
+type sample struct {
+	groups   resourceGroupsClient
+	vaults   vaultsClient
+	secrets  secretsClient
+	names    *naming.Namer
+	converge *converge.Checker
+	waiter   *lro.Waiter
+	out      io.Writer
+	lists    *output.Printer
+	steps    *report.Recorder
+	location string
+	// tags are the tags of every resource the sample creates.
+	tags     map[string]*string
+	tenantID string
+	objectID string
+}
+
+// run creates the resource group and a key vault in it, stores a secret in
+// the vault, reads it back and deletes the vault. With clean it deletes the
+// resource group as well. Every step is recorded in s.steps. The vault is
+// named after kvBase; when the name is taken, the vault gets a new name.
+func (s *sample) run(ctx context.Context, resourceGroupName, kvBase string, clean bool) error {
+	err := s.steps.Step("create resource group "+resourceGroupName, func() error {
+		return s.createResourceGroup(ctx, resourceGroupName)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = s.steps.Step("list key vaults", func() error {
+		fmt.Fprintln(s.out, "Printing Key Vaults")
+		return s.printVaults(ctx)
+	})
+	if err != nil {
+		return err
+	}
+
+	//Check name currently not supported
+	// fmt.Fprintln(s.out, "Checking name availability")
+
+	// availability, err := s.vaults.CheckNameAvailability(ctx, armkeyvault.VaultCheckNameAvailabilityParameters{Name: &kvName}, nil)
+	// if err != nil {
+	// 	return fmt.Errorf("failed to check key vault name availability: %w", err)
+	// }
+	// fmt.Fprintf(s.out, "The account %s is available: %t\n", kvName, *availability.NameAvailable)
+	// if !*availability.NameAvailable {
+	// 	return fmt.Errorf("the key vault name %s is not available: %s", kvName, *availability.Message)
+	// }
+
+	var kvName string
+	err = s.steps.StepWith("create key vault "+kvBase, &kvName, func() (err error) {
+		kvName, err = s.names.Retry(lro.Vault, kvBase, func(name string) error {
+			return s.createVault(ctx, resourceGroupName, name)
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	err = s.steps.Step("list key vaults with "+kvName, func() error {
+		fmt.Fprintln(s.out, "Printing Key Vaults")
+		return s.printVaults(ctx)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = s.steps.Step("create secret testgokey", func() error {
+		return s.createSecret(ctx, resourceGroupName, kvName, "testgokey", "testvalue")
+	})
+	if err != nil {
+		return err
+	}
+
+	err = s.steps.Step("delete key vault "+kvName, func() error {
+		fmt.Fprintln(s.out, "Deleting Key Vault")
+		cntxTimeout, cancel := s.waiter.WithTimeout(ctx, lro.Vault)
+		defer cancel()
+		if _, err := s.vaults.Delete(cntxTimeout, resourceGroupName, kvName, nil); err != nil {
+			return fmt.Errorf("failed to delete key vault %s: %w", kvName, err)
+		}
+		return nil
+	})
+	if err != nil || !clean {
+		return err
+	}
+
+	return s.steps.Step("delete resource group "+resourceGroupName, func() error {
+		fmt.Fprintln(s.out, "Deleting resource group")
+		poller, err := s.groups.BeginDelete(ctx, resourceGroupName, nil)
+		if err != nil {
+			return fmt.Errorf("failed to delete resource group %s: %w", resourceGroupName, err)
+		}
+		_, err = lro.Wait(ctx, s.waiter, lro.Delete(lro.ResourceGroup, resourceGroupName), poller)
+		return err
+	})
+}
+
+func (s *sample) printVaults(ctx context.Context) error {
+	var vaults []output.Resource
+	pager := s.vaults.NewListPager(armkeyvault.Enum10ResourceTypeEqMicrosoftKeyVaultVaults, armkeyvault.Enum11TwoThousandFifteen1101, nil)
+	for pager.More() {
+		resp, err := pager.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get the next page of the key vault list: %w", err)
+		}
+		// The list returns generic resources, without a provisioning state
+		// or SKU.
+		for _, kv := range resp.ResourceListResult.Value {
+			vaults = append(vaults, output.Resource{
+				Name:     output.String(kv.Name),
+				Location: output.String(kv.Location),
+				Tags:     output.Tags(kv.Tags),
+				ID:       output.String(kv.ID),
+			})
+		}
+	}
+	return s.lists.Print(vaults)
+}
+
+func (s *sample) createResourceGroup(ctx context.Context, name string) error {
+	fmt.Fprintln(s.out, "Creating resource group")
+	param := armresources.ResourceGroup{
+		Location: to.Ptr(s.location),
+		Tags:     s.tags,
+	}
+	_, err := converge.Ensure(s.converge, lro.ResourceGroup.Label()+" "+name, func() (armresources.ResourceGroup, error) {
+		resp, err := s.groups.Get(ctx, name, nil)
+		return resp.ResourceGroup, err
+	}, param, func() (armresources.ResourceGroup, error) {
+		resp, err := s.groups.CreateOrUpdate(ctx, name, param, nil)
+		if err != nil {
+			return resp.ResourceGroup, fmt.Errorf("failed to create resource group %s: %w", name, err)
+		}
+		return resp.ResourceGroup, nil
+	}, "location")
+	return err
+}
+
+// createVault creates the key vault kvName, or adopts or updates the one an
+// earlier run left behind. A vault cannot move to another tenant.
+func (s *sample) createVault(ctx context.Context, resourceGroupName, kvName string) error {
+	fmt.Fprintln(s.out, "Creating Key Vault")
+	param := armkeyvault.VaultCreateOrUpdateParameters{
+		Location: to.Ptr(s.location),
+		Tags:     s.tags,
+		Properties: &armkeyvault.VaultProperties{
+			TenantID: to.Ptr(s.tenantID),
+			SKU: &armkeyvault.SKU{
+				Family: to.Ptr(armkeyvault.SKUFamilyA),
+				Name:   to.Ptr(armkeyvault.SKUNameStandard),
+			},
+			AccessPolicies: []*armkeyvault.AccessPolicyEntry{{
+				ObjectID: to.Ptr(s.objectID),
+				TenantID: to.Ptr(s.tenantID),
+				Permissions: &armkeyvault.Permissions{
+					Secrets:      []*armkeyvault.SecretPermissions{to.Ptr(armkeyvault.SecretPermissionsAll)},
+					Keys:         []*armkeyvault.KeyPermissions{to.Ptr(armkeyvault.KeyPermissionsAll)},
+					Storage:      []*armkeyvault.StoragePermissions{to.Ptr(armkeyvault.StoragePermissionsAll)},
+					Certificates: []*armkeyvault.CertificatePermissions{to.Ptr(armkeyvault.CertificatePermissionsAll)},
+				},
+			}},
+		},
+	}
+	_, err := converge.Ensure(s.converge, lro.Vault.Label()+" "+kvName, func() (armkeyvault.Vault, error) {
+		resp, err := s.vaults.Get(ctx, resourceGroupName, kvName, nil)
+		return resp.Vault, err
+	}, param, func() (armkeyvault.Vault, error) {
+		poller, err := s.vaults.BeginCreateOrUpdate(ctx, resourceGroupName, kvName, param, nil)
+		if err != nil {
+			return armkeyvault.Vault{}, fmt.Errorf("failed to create key vault %s: %w", kvName, err)
+		}
+		result, err := lro.Wait(ctx, s.waiter, lro.Create(lro.Vault, kvName), poller)
+		return result.Vault, err
+	}, "location", "properties.tenantId")
+	return err
+}
+
+// createSecret stores the secret name in the vault and reads it back.
+func (s *sample) createSecret(ctx context.Context, resourceGroupName, kvName, name, value string) error {
+	fmt.Fprintln(s.out, "Creating secret in Key Vault")
+	_, err := s.secrets.CreateOrUpdate(
+		ctx,
+		resourceGroupName,
+		kvName,
+		name,
+		armkeyvault.SecretCreateOrUpdateParameters{
+			Properties: &armkeyvault.SecretProperties{
+				Value: to.Ptr(value),
+			},
+		},
+		nil,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create secret %s: %w", name, err)
+	}
+
+	fmt.Fprintln(s.out, "Getting secret from Key Vault")
+	secresp, err := s.secrets.Get(ctx, resourceGroupName, kvName, name, nil)
+	if err != nil {
+		return fmt.Errorf("failed to get secret %s: %w", name, err)
+	}
+	fmt.Fprintf(s.out, "Secret retrieved. Name: %s\n", *secresp.Name)
+	return nil
+}
@@ -0,0 +1,207 @@
+package demo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/keyvault/armkeyvault"
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/resources/armresources"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/converge"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/fakes"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/output"
+)
+
+const (
+	groupsURL = "https://management.local.azurestack.external/subscriptions/sub/resourceGroups"
+	vaultsURL = groupsURL + "/TestRG/providers/Microsoft.KeyVault/vaults"
+)
+
+func vaultPage(names ...string) armkeyvault.VaultsClientListResponse {
+	var page armkeyvault.VaultsClientListResponse
+	for _, name := range names {
+		page.Value = append(page.Value, &armkeyvault.Resource{Name: to.Ptr(name)})
+	}
+	return page
+}
+
+// existingVault makes the fake find the key vault testkv of the tenant
+// tenantID.
+func existingVault(tenantID string) func(fakeClients) {
+	return func(c fakeClients) {
+		c.vaults.GetFunc = func(ctx context.Context, resourceGroupName string, vaultName string, options *armkeyvault.VaultsClientGetOptions) (armkeyvault.VaultsClientGetResponse, error) {
+			var resp armkeyvault.VaultsClientGetResponse
+			resp.Vault = armkeyvault.Vault{
+				ID:       to.Ptr("/subscriptions/sub/resourceGroups/TestRG/providers/Microsoft.KeyVault/vaults/testkv"),
+				Name:     to.Ptr("testkv"),
+				Location: to.Ptr("local"),
+				Properties: &armkeyvault.VaultProperties{
+					TenantID: to.Ptr(tenantID),
+					SKU: &armkeyvault.SKU{
+						Family: to.Ptr(armkeyvault.SKUFamilyA),
+						Name:   to.Ptr(armkeyvault.SKUNameStandard),
+					},
+				},
+			}
+			return resp, nil
+		}
+		c.vaults.BeginCreateOrUpdateFunc = nil
+	}
+}
+
+// fakeClients are fakes on which every call succeeds unless a test replaces
+// one of their functions.
+type fakeClients struct {
+	groups  *fakeResourceGroupsClient
+	vaults  *fakeVaultsClient
+	secrets *fakeSecretsClient
+}
+
+func newClients() fakeClients {
+	var stored *string
+	return fakeClients{
+		groups: &fakeResourceGroupsClient{
+			CreateOrUpdateFunc: func(ctx context.Context, name string, parameters armresources.ResourceGroup, options *armresources.ResourceGroupsClientCreateOrUpdateOptions) (armresources.ResourceGroupsClientCreateOrUpdateResponse, error) {
+				return armresources.ResourceGroupsClientCreateOrUpdateResponse{}, nil
+			},
+			GetFunc: func(ctx context.Context, name string, options *armresources.ResourceGroupsClientGetOptions) (armresources.ResourceGroupsClientGetResponse, error) {
+				err := fakes.ResponseError(http.MethodGet, groupsURL+"/"+name, http.StatusNotFound, "ResourceGroupNotFound")
+				return armresources.ResourceGroupsClientGetResponse{}, err
+			},
+			BeginDeleteFunc: func(ctx context.Context, name string, options *armresources.ResourceGroupsClientBeginDeleteOptions) (*runtime.Poller[armresources.ResourceGroupsClientDeleteResponse], error) {
+				return fakes.Poller(1, armresources.ResourceGroupsClientDeleteResponse{}, nil), nil
+			},
+		},
+		vaults: &fakeVaultsClient{
+			NewListPagerFunc: func(filter armkeyvault.Enum10, apiVersion armkeyvault.Enum11, options *armkeyvault.VaultsClientListOptions) *runtime.Pager[armkeyvault.VaultsClientListResponse] {
+				return fakes.Pager([]armkeyvault.VaultsClientListResponse{vaultPage("other"), vaultPage("testkv")}, nil)
+			},
+			BeginCreateOrUpdateFunc: func(ctx context.Context, resourceGroupName string, vaultName string, parameters armkeyvault.VaultCreateOrUpdateParameters, options *armkeyvault.VaultsClientBeginCreateOrUpdateOptions) (*runtime.Poller[armkeyvault.VaultsClientCreateOrUpdateResponse], error) {
+				return fakes.Poller(2, armkeyvault.VaultsClientCreateOrUpdateResponse{}, nil), nil
+			},
+			GetFunc: func(ctx context.Context, resourceGroupName string, vaultName string, options *armkeyvault.VaultsClientGetOptions) (armkeyvault.VaultsClientGetResponse, error) {
+				err := fakes.ResponseError(http.MethodGet, vaultsURL+"/"+vaultName, http.StatusNotFound, "ResourceNotFound")
+				return armkeyvault.VaultsClientGetResponse{}, err
+			},
+			DeleteFunc: func(ctx context.Context, resourceGroupName string, vaultName string, options *armkeyvault.VaultsClientDeleteOptions) (armkeyvault.VaultsClientDeleteResponse, error) {
+				return armkeyvault.VaultsClientDeleteResponse{}, nil
+			},
+		},
+		secrets: &fakeSecretsClient{
+			CreateOrUpdateFunc: func(ctx context.Context, resourceGroupName string, vaultName string, secretName string, parameters armkeyvault.SecretCreateOrUpdateParameters, options *armkeyvault.SecretsClientCreateOrUpdateOptions) (armkeyvault.SecretsClientCreateOrUpdateResponse, error) {
+				stored = to.Ptr(secretName)
+				return armkeyvault.SecretsClientCreateOrUpdateResponse{}, nil
+			},
+			GetFunc: func(ctx context.Context, resourceGroupName string, vaultName string, secretName string, options *armkeyvault.SecretsClientGetOptions) (armkeyvault.SecretsClientGetResponse, error) {
+				if stored == nil || *stored != secretName {
+					return armkeyvault.SecretsClientGetResponse{}, fakes.ResponseError(http.MethodGet, vaultsURL+"/testkv/secrets/"+secretName, http.StatusNotFound, "ResourceNotFound")
+				}
+				var resp armkeyvault.SecretsClientGetResponse
+				resp.Name = stored
+				return resp, nil
+			},
+		},
+	}
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name       string
+		clean      bool
+		setup      func(fakeClients)
+		wantErr    string
+		wantOutput []string
+	}{
+		{
+			name:  "success",
+			clean: true,
+			wantOutput: []string{
+				"Printing Key Vaults\nNAME    LOCATION  STATE  TAGS  SKU  ID\nother   -         -      -     -    -\ntestkv  -",
+				"Completed: create key vault testkv",
+				"Secret retrieved. Name: testgokey",
+				"Completed: delete resource group TestRG",
+			},
+		},
+		{
+			name: "pager error",
+			setup: func(c fakeClients) {
+				c.vaults.NewListPagerFunc = func(filter armkeyvault.Enum10, apiVersion armkeyvault.Enum11, options *armkeyvault.VaultsClientListOptions) *runtime.Pager[armkeyvault.VaultsClientListResponse] {
+					return fakes.Pager([]armkeyvault.VaultsClientListResponse{vaultPage("other")}, errors.New("connection reset"))
+				}
+			},
+			wantErr: "failed to get the next page of the key vault list: connection reset",
+		},
+		{
+			name:  "rerun adopts the vault",
+			setup: existingVault("tenant"),
+			wantOutput: []string{
+				"Adopting the existing key vault testkv",
+				"Deleting Key Vault",
+				"Secret retrieved. Name: testgokey",
+			},
+		},
+		{
+			name:    "vault drifted",
+			setup:   existingVault("other"),
+			wantErr: `the existing key vault testkv has drifted: properties.tenantId is "other", want "tenant"; delete it or use another -runID`,
+		},
+		{
+			name: "create fails",
+			setup: func(c fakeClients) {
+				c.vaults.BeginCreateOrUpdateFunc = func(ctx context.Context, resourceGroupName string, vaultName string, parameters armkeyvault.VaultCreateOrUpdateParameters, options *armkeyvault.VaultsClientBeginCreateOrUpdateOptions) (*runtime.Poller[armkeyvault.VaultsClientCreateOrUpdateResponse], error) {
+					err := fakes.ResponseError(http.MethodPut, vaultsURL+"/testkv", http.StatusConflict, "VaultAlreadyExists")
+					return fakes.Poller(2, armkeyvault.VaultsClientCreateOrUpdateResponse{}, err), nil
+				}
+			},
+			wantErr: "failed to create key vault testkv: VaultAlreadyExists (status 409)",
+		},
+		{
+			name: "secret not stored",
+			setup: func(c fakeClients) {
+				c.secrets.CreateOrUpdateFunc = func(ctx context.Context, resourceGroupName string, vaultName string, secretName string, parameters armkeyvault.SecretCreateOrUpdateParameters, options *armkeyvault.SecretsClientCreateOrUpdateOptions) (armkeyvault.SecretsClientCreateOrUpdateResponse, error) {
+					return armkeyvault.SecretsClientCreateOrUpdateResponse{}, nil
+				}
+			},
+			wantErr: "failed to get secret testgokey",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clients := newClients()
+			if tt.setup != nil {
+				tt.setup(clients)
+			}
+			var out bytes.Buffer
+			s := &sample{
+				groups:   clients.groups,
+				vaults:   clients.vaults,
+				secrets:  clients.secrets,
+				converge: &converge.Checker{Out: &out},
+				waiter:   fakes.Waiter(&out),
+				out:      &out,
+				lists:    &output.Printer{W: &out, Format: output.Table},
+				location: "local",
+				tenantID: "tenant",
+				objectID: "object",
+			}
+			err := s.run(context.Background(), "TestRG", "testkv", tt.clean)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("run failed: %s", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("run returned %v, want an error containing %q", err, tt.wantErr)
+			}
+			for _, want := range tt.wantOutput {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("output is missing %q:\n%s", want, out.String())
+				}
+			}
+		})
+	}
+}
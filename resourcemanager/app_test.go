@@ -0,0 +1,104 @@
+package main
+
+import (
+	"flag"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/cassette"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/fakestack"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/tags"
+)
+
+var record = flag.Bool("record", false, "record testdata/sample.json against the fake stamp before replaying it")
+
+// wantOutput is printed by every successful run of the sample with -clean.
+var wantOutput = []string{
+	"\nTestGoSampleResourceGroup-fake01  local     Succeeded",
+	"Completed: delete resource group TestGoSampleResourceGroup-fake01",
+}
+
+func TestMain(m *testing.M) {
+	fakestack.Main(m, func(t policy.Transporter) {
+		transport = t
+		main()
+	})
+}
+
+func checkRun(t *testing.T, result fakestack.Result) {
+	t.Helper()
+	if result.ExitCode != 0 {
+		t.Fatalf("exit code %d, output:\n%s", result.ExitCode, result.Output)
+	}
+	for _, want := range wantOutput {
+		if !strings.Contains(result.Output, want) {
+			t.Errorf("output is missing %q:\n%s", want, result.Output)
+		}
+	}
+}
+
+func TestSample(t *testing.T) {
+	tests := []struct {
+		identity fakestack.Identity
+		args     []string
+	}{
+		{fakestack.AAD, []string{"-secret", "-disableID"}},
+		{fakestack.ADFS, nil},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.identity), func(t *testing.T) {
+			stack := fakestack.Start(t, tt.identity)
+			stack.SetPageSize(1)
+			checkRun(t, stack.Run(t, append(tt.args, "-clean")...))
+			if left := stack.Resources(); len(left) != 0 {
+				t.Errorf("resources left behind: %v", left)
+			}
+		})
+	}
+}
+
+// TestReplay runs the sample from the interactions recorded in testdata, which
+// are synthetic: they were recorded against the fake stamp, not a real one.
+// Run it with -record to record them again.
+func TestReplay(t *testing.T) {
+	path := filepath.Join("testdata", "sample.json")
+	args := []string{"-secret", "-disableID", "-clean", "-cassette", path}
+	if *record {
+		stack := fakestack.Start(t, fakestack.AAD)
+		checkRun(t, stack.Run(t, append(args, "-cassetteMode", "record")...))
+	}
+	endpoint, err := cassette.ResourceManagerEndpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRun(t, fakestack.RunOffline(t, endpoint, append(args, "-cassetteMode", "replay")...))
+}
+
+// TestExistingGroup checks that a rerun adopts the resource group an earlier
+// run with the same ID left behind, which -clean then deletes, while it
+// leaves the group of another run alone.
+func TestExistingGroup(t *testing.T) {
+	stack := fakestack.Start(t, fakestack.AAD)
+	stack.AddGroup("TestGoSampleResourceGroup-fake01", map[string]string{tags.RunID: "other1", tags.Creator: fakestack.ObjectID})
+	result := stack.Run(t, "-secret", "-disableID", "-clean")
+	want := `the existing resource group TestGoSampleResourceGroup-fake01 has drifted: tags.hybrid-samples-run-id is "other1", want "fake01"`
+	if result.ExitCode != 1 || !strings.Contains(result.Output, want) {
+		t.Fatalf("exit code %d, want 1 and an output containing %q:\n%s", result.ExitCode, want, result.Output)
+	}
+	if tags := stack.Tags()[stack.Resources()[0]]; len(stack.Resources()) != 1 || tags["hybrid-samples-run-id"] != "other1" {
+		t.Fatalf("the resource group of the other run was changed: %v %v", stack.Resources(), tags)
+	}
+
+	stack.AddGroup("TestGoSampleResourceGroup-fake01", map[string]string{tags.RunID: fakestack.RunID, tags.Creator: fakestack.ObjectID})
+	result = stack.Run(t, "-secret", "-disableID", "-clean")
+	checkRun(t, result)
+	if want := `Updating the existing resource group TestGoSampleResourceGroup-fake01 in place: tags.hybrid-samples-sample is missing, want "resourcemanager"`; !strings.Contains(result.Output, want) {
+		t.Errorf("output is missing %q:\n%s", want, result.Output)
+	}
+	if left := stack.Resources(); len(left) != 0 {
+		t.Errorf("resources left behind: %v", left)
+	}
+}
@@ -0,0 +1,30 @@
+// Package demo is the resource group sample: it creates a resource group,
+// lists the resource groups of the subscription and deletes the group again.
+package demo
+
+import (
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/resources/armresources"
+
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/session"
+)
+
+// ResourceGroup is the resource group the demo creates, before the suffix
+// of -groupSuffix and the run ID.
+const ResourceGroup = "TestGoSampleResourceGroup"
+
+// Run runs the demo on the stamp of sess.
+func Run(sess *session.Session) error {
+	rgClient, err := armresources.NewResourceGroupsClient(sess.Config.SubscriptionId, sess.Credential, &sess.Options)
+	if err != nil {
+		return fmt.Errorf("failed to create the resource group client: %w", err)
+	}
+
+	resourceGroupName, err := sess.ResourceGroup(ResourceGroup)
+	if err != nil {
+		return err
+	}
+	s := &sample{groups: rgClient, converge: sess.Converge, waiter: sess.Waiter, out: sess.Out, lists: sess.Lists, steps: sess.Steps, location: sess.Config.Location, tags: sess.Tags}
+	return s.run(sess.Context(), resourceGroupName, sess.Clean)
+}
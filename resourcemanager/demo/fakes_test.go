@@ -0,0 +1,50 @@
+// Code generated by fakegen from workflow.go; DO NOT EDIT.
+
+package demo
+
+import (
+	"context"
+
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/resources/armresources"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+)
+
+var _ resourceGroupsClient = (*fakeResourceGroupsClient)(nil)
+
+// fakeResourceGroupsClient is a fake resourceGroupsClient. Its methods call the function
+// fields of the same name with the Func suffix, which panic when they are not
+// set.
+type fakeResourceGroupsClient struct {
+	CreateOrUpdateFunc func(ctx context.Context, resourceGroupName string, parameters armresources.ResourceGroup, options *armresources.ResourceGroupsClientCreateOrUpdateOptions) (armresources.ResourceGroupsClientCreateOrUpdateResponse, error)
+	GetFunc            func(ctx context.Context, resourceGroupName string, options *armresources.ResourceGroupsClientGetOptions) (armresources.ResourceGroupsClientGetResponse, error)
+	NewListPagerFunc   func(options *armresources.ResourceGroupsClientListOptions) *runtime.Pager[armresources.ResourceGroupsClientListResponse]
+	BeginDeleteFunc    func(ctx context.Context, resourceGroupName string, options *armresources.ResourceGroupsClientBeginDeleteOptions) (*runtime.Poller[armresources.ResourceGroupsClientDeleteResponse], error)
+}
+
+func (f *fakeResourceGroupsClient) CreateOrUpdate(ctx context.Context, resourceGroupName string, parameters armresources.ResourceGroup, options *armresources.ResourceGroupsClientCreateOrUpdateOptions) (armresources.ResourceGroupsClientCreateOrUpdateResponse, error) {
+	if f.CreateOrUpdateFunc == nil {
+		panic("fakeResourceGroupsClient.CreateOrUpdate called without CreateOrUpdateFunc")
+	}
+	return f.CreateOrUpdateFunc(ctx, resourceGroupName, parameters, options)
+}
+
+func (f *fakeResourceGroupsClient) Get(ctx context.Context, resourceGroupName string, options *armresources.ResourceGroupsClientGetOptions) (armresources.ResourceGroupsClientGetResponse, error) {
+	if f.GetFunc == nil {
+		panic("fakeResourceGroupsClient.Get called without GetFunc")
+	}
+	return f.GetFunc(ctx, resourceGroupName, options)
+}
+
+func (f *fakeResourceGroupsClient) NewListPager(options *armresources.ResourceGroupsClientListOptions) *runtime.Pager[armresources.ResourceGroupsClientListResponse] {
+	if f.NewListPagerFunc == nil {
+		panic("fakeResourceGroupsClient.NewListPager called without NewListPagerFunc")
+	}
+	return f.NewListPagerFunc(options)
+}
+
+func (f *fakeResourceGroupsClient) BeginDelete(ctx context.Context, resourceGroupName string, options *armresources.ResourceGroupsClientBeginDeleteOptions) (*runtime.Poller[armresources.ResourceGroupsClientDeleteResponse], error) {
+	if f.BeginDeleteFunc == nil {
+		panic("fakeResourceGroupsClient.BeginDelete called without BeginDeleteFunc")
+	}
+	return f.BeginDeleteFunc(ctx, resourceGroupName, options)
+}
@@ -0,0 +1,132 @@
+package demo
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/resources/armresources"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/converge"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/lro"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/output"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/report"
+)
+
+//go:generate go run github.com/Azure-Samples/Hybrid-Golang-Samples/common/fakes/fakegen -type resourceGroupsClient -out fakes_test.go
+
+// resourceGroupsClient is the part of *armresources.ResourceGroupsClient the
+// sample uses.
+type resourceGroupsClient interface {
+	CreateOrUpdate(ctx context.Context, resourceGroupName string, parameters armresources.ResourceGroup, options *armresources.ResourceGroupsClientCreateOrUpdateOptions) (armresources.ResourceGroupsClientCreateOrUpdateResponse, error)
+	Get(ctx context.Context, resourceGroupName string, options *armresources.ResourceGroupsClientGetOptions) (armresources.ResourceGroupsClientGetResponse, error)
+	NewListPager(options *armresources.ResourceGroupsClientListOptions) *runtime.Pager[armresources.ResourceGroupsClientListResponse]
+	BeginDelete(ctx context.Context, resourceGroupName string, options *armresources.ResourceGroupsClientBeginDeleteOptions) (*runtime.Poller[armresources.ResourceGroupsClientDeleteResponse], error)
+}
+
+// sample runs the steps of the sample with the clients it is given and
+// reports its progress to out, its listings to lists and its steps to
+// steps, which may be nil. converge decides what to do about a resource group
+// that exists already and may be nil too.
+type sample struct {
+	groups   resourceGroupsClient
+	converge *converge.Checker
+	waiter   *lro.Waiter
+	out      io.Writer
+	lists    *output.Printer
+	steps    *report.Recorder
+	location string
+	// tags are the tags of every resource the sample creates.
+	tags map[string]*string
+}
+
+// run creates the resource group, lists the resource groups of the
+// subscription and, with clean, deletes the resource group again. Every
+// step is recorded in s.steps.
+func (s *sample) run(ctx context.Context, resourceGroupName string, clean bool) error {
+	err := s.steps.Step("create resource group "+resourceGroupName, func() error {
+		return s.createResourceGroup(ctx, resourceGroupName)
+	})
+	if err != nil {
+		return err
+	}
+	// List all the resource groups of an Azure subscription.
+	err = s.steps.Step("list resource groups", func() error {
+		fmt.Fprintln(s.out, "Listing Resource Groups")
+		return s.printResourceGroups(ctx)
+	})
+	if err != nil || !clean {
+		return err
+	}
+	err = s.steps.Step("delete resource group "+resourceGroupName, func() error {
+		return s.deleteResourceGroup(ctx, resourceGroupName)
+	})
+	if err != nil {
+		return err
+	}
+	return s.steps.Step("list resource groups after deleting "+resourceGroupName, func() error {
+		fmt.Fprintln(s.out, "Listing Resource Groups")
+		return s.printResourceGroups(ctx)
+	})
+}
+
+func (s *sample) createResourceGroup(ctx context.Context, name string) error {
+	fmt.Fprintln(s.out, "Creating resource group")
+	param := armresources.ResourceGroup{
+		Location: to.Ptr(s.location),
+		Tags:     s.tags,
+	}
+	_, err := converge.Ensure(s.converge, lro.ResourceGroup.Label()+" "+name, func() (armresources.ResourceGroup, error) {
+		resp, err := s.groups.Get(ctx, name, nil)
+		return resp.ResourceGroup, err
+	}, param, func() (armresources.ResourceGroup, error) {
+		resp, err := s.groups.CreateOrUpdate(ctx, name, param, nil)
+		if err != nil {
+			return resp.ResourceGroup, fmt.Errorf("failed to create resource group %s: %w", name, err)
+		}
+		return resp.ResourceGroup, nil
+	}, "location")
+	if err != nil {
+		return err
+	}
+	if _, err := s.groups.Get(ctx, name, nil); err != nil {
+		return fmt.Errorf("no resource group %s found: %w", name, err)
+	}
+	return nil
+}
+
+func (s *sample) printResourceGroups(ctx context.Context) error {
+	var groups []output.Resource
+	pager := s.groups.NewListPager(nil)
+	for pager.More() {
+		resp, err := pager.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get the next page of the resource group list: %w", err)
+		}
+		for _, rg := range resp.ResourceGroupListResult.Value {
+			group := output.Resource{
+				Name:     output.String(rg.Name),
+				Location: output.String(rg.Location),
+				Tags:     output.Tags(rg.Tags),
+				ID:       output.String(rg.ID),
+			}
+			if rg.Properties != nil {
+				group.ProvisioningState = output.String(rg.Properties.ProvisioningState)
+			}
+			groups = append(groups, group)
+		}
+	}
+	return s.lists.Print(groups)
+}
+
+func (s *sample) deleteResourceGroup(ctx context.Context, name string) error {
+	fmt.Fprintln(s.out, "Deleting resource group")
+	poller, err := s.groups.BeginDelete(ctx, name, nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete resource group %s: %w", name, err)
+	}
+	_, err = lro.Wait(ctx, s.waiter, lro.Delete(lro.ResourceGroup, name), poller)
+	return err
+}
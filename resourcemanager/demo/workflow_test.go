@@ -0,0 +1,149 @@
+package demo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/resources/armresources"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/converge"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/fakes"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/output"
+)
+
+const groupsURL = "https://management.local.azurestack.external/subscriptions/sub/resourcegroups"
+
+func groupPage(names ...string) armresources.ResourceGroupsClientListResponse {
+	var page armresources.ResourceGroupsClientListResponse
+	for _, name := range names {
+		page.Value = append(page.Value, &armresources.ResourceGroup{Name: to.Ptr(name)})
+	}
+	return page
+}
+
+// newGroupsClient returns a fake on which every call succeeds. The resource
+// group exists once it was created.
+func newGroupsClient() *fakeResourceGroupsClient {
+	created := false
+	return &fakeResourceGroupsClient{
+		CreateOrUpdateFunc: func(ctx context.Context, name string, parameters armresources.ResourceGroup, options *armresources.ResourceGroupsClientCreateOrUpdateOptions) (armresources.ResourceGroupsClientCreateOrUpdateResponse, error) {
+			created = true
+			return armresources.ResourceGroupsClientCreateOrUpdateResponse{}, nil
+		},
+		GetFunc: func(ctx context.Context, name string, options *armresources.ResourceGroupsClientGetOptions) (armresources.ResourceGroupsClientGetResponse, error) {
+			if !created {
+				return armresources.ResourceGroupsClientGetResponse{}, fakes.ResponseError(http.MethodGet, groupsURL+"/"+name, http.StatusNotFound, "ResourceGroupNotFound")
+			}
+			return armresources.ResourceGroupsClientGetResponse{}, nil
+		},
+		NewListPagerFunc: func(options *armresources.ResourceGroupsClientListOptions) *runtime.Pager[armresources.ResourceGroupsClientListResponse] {
+			return fakes.Pager([]armresources.ResourceGroupsClientListResponse{groupPage("rg1", "rg2"), groupPage("rg3")}, nil)
+		},
+		BeginDeleteFunc: func(ctx context.Context, name string, options *armresources.ResourceGroupsClientBeginDeleteOptions) (*runtime.Poller[armresources.ResourceGroupsClientDeleteResponse], error) {
+			return fakes.Poller(2, armresources.ResourceGroupsClientDeleteResponse{}, nil), nil
+		},
+	}
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name       string
+		clean      bool
+		format     output.Format
+		setup      func(*fakeResourceGroupsClient)
+		wantErr    string
+		wantOutput []string
+	}{
+		{
+			name:       "success",
+			wantOutput: []string{"Listing Resource Groups\nNAME ", "\nrg1 ", "\nrg2 ", "\nrg3 "},
+		},
+		{
+			name:       "success with clean",
+			clean:      true,
+			wantOutput: []string{"Completed: delete resource group TestRG", "\nrg3 "},
+		},
+		{
+			name:       "json",
+			format:     output.JSON,
+			wantOutput: []string{"Listing Resource Groups\n[\n  {\n    \"name\": \"rg1\",\n    \"location\": \"\",\n    \"provisioningState\": \"\",\n    \"tags\": {},"},
+		},
+		{
+			name:       "csv",
+			format:     output.CSV,
+			wantOutput: []string{"Listing Resource Groups\nname,location,provisioningState,tags,sku,id\nrg1,,,,,\nrg2,,,,,\nrg3,,,,,\n"},
+		},
+		{
+			name: "create fails",
+			setup: func(c *fakeResourceGroupsClient) {
+				c.CreateOrUpdateFunc = func(ctx context.Context, name string, parameters armresources.ResourceGroup, options *armresources.ResourceGroupsClientCreateOrUpdateOptions) (armresources.ResourceGroupsClientCreateOrUpdateResponse, error) {
+					return armresources.ResourceGroupsClientCreateOrUpdateResponse{}, fakes.ResponseError(http.MethodPut, groupsURL+"/TestRG", http.StatusForbidden, "AuthorizationFailed")
+				}
+			},
+			wantErr: "failed to create resource group TestRG",
+		},
+		{
+			name: "group drifted",
+			setup: func(c *fakeResourceGroupsClient) {
+				c.GetFunc = func(ctx context.Context, name string, options *armresources.ResourceGroupsClientGetOptions) (armresources.ResourceGroupsClientGetResponse, error) {
+					var resp armresources.ResourceGroupsClientGetResponse
+					resp.ResourceGroup = armresources.ResourceGroup{Name: to.Ptr(name), Location: to.Ptr("westus")}
+					return resp, nil
+				}
+			},
+			wantErr: `the existing resource group TestRG has drifted: location is "westus", want "local"`,
+		},
+		{
+			name: "pager error",
+			setup: func(c *fakeResourceGroupsClient) {
+				c.NewListPagerFunc = func(options *armresources.ResourceGroupsClientListOptions) *runtime.Pager[armresources.ResourceGroupsClientListResponse] {
+					return fakes.Pager([]armresources.ResourceGroupsClientListResponse{groupPage("rg1")}, errors.New("connection reset"))
+				}
+			},
+			wantErr: "failed to get the next page of the resource group list: connection reset",
+		},
+		{
+			name:  "delete fails",
+			clean: true,
+			setup: func(c *fakeResourceGroupsClient) {
+				c.BeginDeleteFunc = func(ctx context.Context, name string, options *armresources.ResourceGroupsClientBeginDeleteOptions) (*runtime.Poller[armresources.ResourceGroupsClientDeleteResponse], error) {
+					err := fakes.ResponseError(http.MethodDelete, groupsURL+"/TestRG", http.StatusConflict, "ResourceGroupDeletionBlocked")
+					return fakes.Poller(1, armresources.ResourceGroupsClientDeleteResponse{}, err), nil
+				}
+			},
+			wantErr: "failed to delete resource group TestRG: ResourceGroupDeletionBlocked (status 409)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newGroupsClient()
+			if tt.setup != nil {
+				tt.setup(client)
+			}
+			format := tt.format
+			if format == "" {
+				format = output.Table
+			}
+			var out bytes.Buffer
+			s := &sample{groups: client, converge: &converge.Checker{Out: &out}, waiter: fakes.Waiter(&out), out: &out, lists: &output.Printer{W: &out, Format: format}, location: "local"}
+			err := s.run(context.Background(), "TestRG", tt.clean)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("run failed: %s", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("run returned %v, want an error containing %q", err, tt.wantErr)
+			}
+			for _, want := range tt.wantOutput {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("output is missing %q:\n%s", want, out.String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/cassette"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/fakestack"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/output"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/tags"
+)
+
+var record = flag.Bool("record", false, "record testdata/sample.json against the fake stamp before replaying it")
+
+// wantOutput is printed by every successful run of the sample with -clean.
+var wantOutput = []string{
+	"Run ID: fake01",
+	"The account goteststorageaccfake01 is available: true",
+	"Completed: create storage account goteststorageaccfake01",
+	"Rotating key1",
+	"Completed: delete resource group TestGoStorageSampleResourceGroup-fake01",
+}
+
+func TestMain(m *testing.M) {
+	fakestack.Main(m, func(t policy.Transporter) {
+		transport = t
+		main()
+	})
+}
+
+func checkRun(t *testing.T, result fakestack.Result) {
+	t.Helper()
+	if result.ExitCode != 0 {
+		t.Fatalf("exit code %d, output:\n%s", result.ExitCode, result.Output)
+	}
+	for _, want := range wantOutput {
+		if !strings.Contains(result.Output, want) {
+			t.Errorf("output is missing %q:\n%s", want, result.Output)
+		}
+	}
+}
+
+func TestSample(t *testing.T) {
+	tests := []struct {
+		identity fakestack.Identity
+		args     []string
+	}{
+		{fakestack.AAD, []string{"-secret", "-disableID"}},
+		{fakestack.ADFS, nil},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.identity), func(t *testing.T) {
+			stack := fakestack.Start(t, tt.identity)
+			stack.SetPageSize(1)
+			checkRun(t, stack.Run(t, append(tt.args, "-clean")...))
+			if left := stack.Resources(); len(left) != 0 {
+				t.Errorf("resources left behind: %v", left)
+			}
+		})
+	}
+}
+
+// TestReplay runs the sample from the interactions recorded in testdata, which
+// are synthetic: they were recorded against the fake stamp, not a real one.
+// Run it with -record to record them again.
+func TestReplay(t *testing.T) {
+	path := filepath.Join("testdata", "sample.json")
+	args := []string{"-secret", "-disableID", "-clean", "-cassette", path}
+	if *record {
+		stack := fakestack.Start(t, fakestack.AAD)
+		checkRun(t, stack.Run(t, append(args, "-cassetteMode", "record")...))
+	}
+	endpoint, err := cassette.ResourceManagerEndpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRun(t, fakestack.RunOffline(t, endpoint, append(args, "-cassetteMode", "replay")...))
+}
+
+func TestThrottling(t *testing.T) {
+	stack := fakestack.Start(t, fakestack.AAD)
+	stack.Throttle(2)
+	result := stack.Run(t, "-secret", "-disableID", "-cleanup", "always")
+	if result.ExitCode != 0 {
+		t.Fatalf("exit code %d, output:\n%s", result.ExitCode, result.Output)
+	}
+	if !strings.Contains(result.Output, "2 throttled") {
+		t.Errorf("retry summary does not report the throttled requests:\n%s", result.Output)
+	}
+	if left := stack.Resources(); len(left) != 0 {
+		t.Errorf("resources left behind: %v", left)
+	}
+}
+
+// TestJSONOutput checks that with -output json stdout holds nothing but the
+// listings, one JSON array each.
+func TestJSONOutput(t *testing.T) {
+	stack := fakestack.Start(t, fakestack.AAD)
+	result := stack.Run(t, "-secret", "-disableID", "-clean", "-output", "json")
+	checkRun(t, result)
+
+	var listings [][]output.Resource
+	dec := json.NewDecoder(strings.NewReader(result.Stdout))
+	for dec.More() {
+		var listing []output.Resource
+		if err := dec.Decode(&listing); err != nil {
+			t.Fatalf("stdout is not a stream of JSON arrays: %s\n%s", err, result.Stdout)
+		}
+		listings = append(listings, listing)
+	}
+	if len(listings) != 2 {
+		t.Fatalf("got %d listings, want 2:\n%s", len(listings), result.Stdout)
+	}
+	want := output.Resource{
+		Name:              "goteststorageaccfake01",
+		Location:          "local",
+		ProvisioningState: "Succeeded",
+		SKU:               "Standard_LRS",
+		ID:                "/subscriptions/00000000-0000-0000-0000-000000000001/resourceGroups/TestGoStorageSampleResourceGroup-fake01/providers/Microsoft.Storage/storageAccounts/goteststorageaccfake01",
+	}
+	for i, listing := range listings {
+		if len(listing) == 1 {
+			// The times of the tags differ from run to run.
+			if got := listing[0].Tags; got[tags.RunID] != fakestack.RunID || got[tags.Sample] != "storage" || got[tags.CreatedAt] == "" {
+				t.Errorf("listing %d has the tags %v", i, got)
+			}
+			listing[0].Tags = nil
+		}
+		if len(listing) != 1 || !reflect.DeepEqual(listing[0], want) {
+			t.Errorf("listing %d is %+v, want [%+v]", i, listing, want)
+		}
+	}
+}
+
+// TestRerun checks that a rerun adopts the resource group and the storage
+// account an earlier run with the same ID left behind, rather than failing at
+// the check of the name of the account.
+func TestRerun(t *testing.T) {
+	stack := fakestack.Start(t, fakestack.AAD)
+	owned := map[string]string{tags.RunID: fakestack.RunID, tags.Creator: fakestack.ObjectID, tags.Sample: "storage"}
+	stack.AddGroup("TestGoStorageSampleResourceGroup-fake01", owned)
+	stack.AddResource("TestGoStorageSampleResourceGroup-fake01", "Microsoft.Storage/storageAccounts", "goteststorageaccfake01", map[string]string{tags.RunID: fakestack.RunID, tags.Creator: fakestack.ObjectID})
+	result := stack.Run(t, "-secret", "-disableID", "-clean")
+	if result.ExitCode != 0 {
+		t.Fatalf("exit code %d, output:\n%s", result.ExitCode, result.Output)
+	}
+	for _, want := range []string{
+		"Adopting the existing resource group TestGoStorageSampleResourceGroup-fake01",
+		"The account goteststorageaccfake01 exists in TestGoStorageSampleResourceGroup-fake01 already",
+		`Updating the existing storage account goteststorageaccfake01 in place: tags.hybrid-samples-sample is missing, want "storage"`,
+		"Completed: delete resource group TestGoStorageSampleResourceGroup-fake01",
+	} {
+		if !strings.Contains(result.Output, want) {
+			t.Errorf("output is missing %q:\n%s", want, result.Output)
+		}
+	}
+	if left := stack.Resources(); len(left) != 0 {
+		t.Errorf("resources left behind: %v", left)
+	}
+}
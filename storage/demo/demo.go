@@ -0,0 +1,36 @@
+// Package demo is the storage sample: it creates a storage account, lists the
+// storage accounts, rotates a key of the account and deletes it again.
+package demo
+
+import (
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/resources/armresources"
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/storage/armstorage"
+
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/session"
+)
+
+// ResourceGroup is the resource group the demo creates its resources in,
+// before the suffix of -groupSuffix and the run ID.
+const ResourceGroup = "TestGoStorageSampleResourceGroup"
+
+// Run runs the demo on the stamp of sess.
+func Run(sess *session.Session) error {
+	rgClient, err := armresources.NewResourceGroupsClient(sess.Config.SubscriptionId, sess.Credential, &sess.Options)
+	if err != nil {
+		return fmt.Errorf("failed to create the resource group client: %w", err)
+	}
+
+	saClient, err := armstorage.NewAccountsClient(sess.Config.SubscriptionId, sess.Credential, &sess.Options)
+	if err != nil {
+		return fmt.Errorf("failed to create the storage client: %w", err)
+	}
+
+	resourceGroupName, err := sess.ResourceGroup(ResourceGroup)
+	if err != nil {
+		return err
+	}
+	s := &sample{groups: rgClient, accounts: saClient, names: sess.Names, converge: sess.Converge, waiter: sess.Waiter, out: sess.Out, lists: sess.Lists, steps: sess.Steps, location: sess.Config.Location, tags: sess.Tags}
+	return s.run(sess.Context(), resourceGroupName, "goteststorageacc", sess.Clean)
+}
@@ -0,0 +1,115 @@
+// Code generated by fakegen from workflow.go; DO NOT EDIT.
+
+package demo
+
+import (
+	"context"
+
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/resources/armresources"
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/storage/armstorage"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+)
+
+var _ resourceGroupsClient = (*fakeResourceGroupsClient)(nil)
+
+// fakeResourceGroupsClient is a fake resourceGroupsClient. Its methods call the function
+// fields of the same name with the Func suffix, which panic when they are not
+// set.
+type fakeResourceGroupsClient struct {
+	CreateOrUpdateFunc func(ctx context.Context, resourceGroupName string, parameters armresources.ResourceGroup, options *armresources.ResourceGroupsClientCreateOrUpdateOptions) (armresources.ResourceGroupsClientCreateOrUpdateResponse, error)
+	GetFunc            func(ctx context.Context, resourceGroupName string, options *armresources.ResourceGroupsClientGetOptions) (armresources.ResourceGroupsClientGetResponse, error)
+	BeginDeleteFunc    func(ctx context.Context, resourceGroupName string, options *armresources.ResourceGroupsClientBeginDeleteOptions) (*runtime.Poller[armresources.ResourceGroupsClientDeleteResponse], error)
+}
+
+func (f *fakeResourceGroupsClient) CreateOrUpdate(ctx context.Context, resourceGroupName string, parameters armresources.ResourceGroup, options *armresources.ResourceGroupsClientCreateOrUpdateOptions) (armresources.ResourceGroupsClientCreateOrUpdateResponse, error) {
+	if f.CreateOrUpdateFunc == nil {
+		panic("fakeResourceGroupsClient.CreateOrUpdate called without CreateOrUpdateFunc")
+	}
+	return f.CreateOrUpdateFunc(ctx, resourceGroupName, parameters, options)
+}
+
+func (f *fakeResourceGroupsClient) Get(ctx context.Context, resourceGroupName string, options *armresources.ResourceGroupsClientGetOptions) (armresources.ResourceGroupsClientGetResponse, error) {
+	if f.GetFunc == nil {
+		panic("fakeResourceGroupsClient.Get called without GetFunc")
+	}
+	return f.GetFunc(ctx, resourceGroupName, options)
+}
+
+func (f *fakeResourceGroupsClient) BeginDelete(ctx context.Context, resourceGroupName string, options *armresources.ResourceGroupsClientBeginDeleteOptions) (*runtime.Poller[armresources.ResourceGroupsClientDeleteResponse], error) {
+	if f.BeginDeleteFunc == nil {
+		panic("fakeResourceGroupsClient.BeginDelete called without BeginDeleteFunc")
+	}
+	return f.BeginDeleteFunc(ctx, resourceGroupName, options)
+}
+
+var _ accountsClient = (*fakeAccountsClient)(nil)
+
+// fakeAccountsClient is a fake accountsClient. Its methods call the function
+// fields of the same name with the Func suffix, which panic when they are not
+// set.
+type fakeAccountsClient struct {
+	CheckNameAvailabilityFunc       func(ctx context.Context, accountName armstorage.AccountCheckNameAvailabilityParameters, options *armstorage.AccountsClientCheckNameAvailabilityOptions) (armstorage.AccountsClientCheckNameAvailabilityResponse, error)
+	BeginCreateFunc                 func(ctx context.Context, resourceGroupName string, accountName string, parameters armstorage.AccountCreateParameters, options *armstorage.AccountsClientBeginCreateOptions) (*runtime.Poller[armstorage.AccountsClientCreateResponse], error)
+	GetPropertiesFunc               func(ctx context.Context, resourceGroupName string, accountName string, options *armstorage.AccountsClientGetPropertiesOptions) (armstorage.AccountsClientGetPropertiesResponse, error)
+	NewListPagerFunc                func(options *armstorage.AccountsClientListOptions) *runtime.Pager[armstorage.AccountsClientListResponse]
+	NewListByResourceGroupPagerFunc func(resourceGroupName string, options *armstorage.AccountsClientListByResourceGroupOptions) *runtime.Pager[armstorage.AccountsClientListByResourceGroupResponse]
+	ListKeysFunc                    func(ctx context.Context, resourceGroupName string, accountName string, options *armstorage.AccountsClientListKeysOptions) (armstorage.AccountsClientListKeysResponse, error)
+	RegenerateKeyFunc               func(ctx context.Context, resourceGroupName string, accountName string, regenerateKey armstorage.AccountRegenerateKeyParameters, options *armstorage.AccountsClientRegenerateKeyOptions) (armstorage.AccountsClientRegenerateKeyResponse, error)
+	DeleteFunc                      func(ctx context.Context, resourceGroupName string, accountName string, options *armstorage.AccountsClientDeleteOptions) (armstorage.AccountsClientDeleteResponse, error)
+}
+
+func (f *fakeAccountsClient) CheckNameAvailability(ctx context.Context, accountName armstorage.AccountCheckNameAvailabilityParameters, options *armstorage.AccountsClientCheckNameAvailabilityOptions) (armstorage.AccountsClientCheckNameAvailabilityResponse, error) {
+	if f.CheckNameAvailabilityFunc == nil {
+		panic("fakeAccountsClient.CheckNameAvailability called without CheckNameAvailabilityFunc")
+	}
+	return f.CheckNameAvailabilityFunc(ctx, accountName, options)
+}
+
+func (f *fakeAccountsClient) BeginCreate(ctx context.Context, resourceGroupName string, accountName string, parameters armstorage.AccountCreateParameters, options *armstorage.AccountsClientBeginCreateOptions) (*runtime.Poller[armstorage.AccountsClientCreateResponse], error) {
+	if f.BeginCreateFunc == nil {
+		panic("fakeAccountsClient.BeginCreate called without BeginCreateFunc")
+	}
+	return f.BeginCreateFunc(ctx, resourceGroupName, accountName, parameters, options)
+}
+
+func (f *fakeAccountsClient) GetProperties(ctx context.Context, resourceGroupName string, accountName string, options *armstorage.AccountsClientGetPropertiesOptions) (armstorage.AccountsClientGetPropertiesResponse, error) {
+	if f.GetPropertiesFunc == nil {
+		panic("fakeAccountsClient.GetProperties called without GetPropertiesFunc")
+	}
+	return f.GetPropertiesFunc(ctx, resourceGroupName, accountName, options)
+}
+
+func (f *fakeAccountsClient) NewListPager(options *armstorage.AccountsClientListOptions) *runtime.Pager[armstorage.AccountsClientListResponse] {
+	if f.NewListPagerFunc == nil {
+		panic("fakeAccountsClient.NewListPager called without NewListPagerFunc")
+	}
+	return f.NewListPagerFunc(options)
+}
+
+func (f *fakeAccountsClient) NewListByResourceGroupPager(resourceGroupName string, options *armstorage.AccountsClientListByResourceGroupOptions) *runtime.Pager[armstorage.AccountsClientListByResourceGroupResponse] {
+	if f.NewListByResourceGroupPagerFunc == nil {
+		panic("fakeAccountsClient.NewListByResourceGroupPager called without NewListByResourceGroupPagerFunc")
+	}
+	return f.NewListByResourceGroupPagerFunc(resourceGroupName, options)
+}
+
+func (f *fakeAccountsClient) ListKeys(ctx context.Context, resourceGroupName string, accountName string, options *armstorage.AccountsClientListKeysOptions) (armstorage.AccountsClientListKeysResponse, error) {
+	if f.ListKeysFunc == nil {
+		panic("fakeAccountsClient.ListKeys called without ListKeysFunc")
+	}
+	return f.ListKeysFunc(ctx, resourceGroupName, accountName, options)
+}
+
+func (f *fakeAccountsClient) RegenerateKey(ctx context.Context, resourceGroupName string, accountName string, regenerateKey armstorage.AccountRegenerateKeyParameters, options *armstorage.AccountsClientRegenerateKeyOptions) (armstorage.AccountsClientRegenerateKeyResponse, error) {
+	if f.RegenerateKeyFunc == nil {
+		panic("fakeAccountsClient.RegenerateKey called without RegenerateKeyFunc")
+	}
+	return f.RegenerateKeyFunc(ctx, resourceGroupName, accountName, regenerateKey, options)
+}
+
+func (f *fakeAccountsClient) Delete(ctx context.Context, resourceGroupName string, accountName string, options *armstorage.AccountsClientDeleteOptions) (armstorage.AccountsClientDeleteResponse, error) {
+	if f.DeleteFunc == nil {
+		panic("fakeAccountsClient.Delete called without DeleteFunc")
+	}
+	return f.DeleteFunc(ctx, resourceGroupName, accountName, options)
+}
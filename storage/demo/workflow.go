@@ -0,0 +1,273 @@
+package demo
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/resources/armresources"
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/storage/armstorage"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/converge"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/lro"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/naming"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/output"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/report"
+)
+
+//go:generate go run github.com/Azure-Samples/Hybrid-Golang-Samples/common/fakes/fakegen -type resourceGroupsClient,accountsClient -out fakes_test.go
+
+// resourceGroupsClient is the part of *armresources.ResourceGroupsClient the
+// sample uses.
+type resourceGroupsClient interface {
+	CreateOrUpdate(ctx context.Context, resourceGroupName string, parameters armresources.ResourceGroup, options *armresources.ResourceGroupsClientCreateOrUpdateOptions) (armresources.ResourceGroupsClientCreateOrUpdateResponse, error)
+	Get(ctx context.Context, resourceGroupName string, options *armresources.ResourceGroupsClientGetOptions) (armresources.ResourceGroupsClientGetResponse, error)
+	BeginDelete(ctx context.Context, resourceGroupName string, options *armresources.ResourceGroupsClientBeginDeleteOptions) (*runtime.Poller[armresources.ResourceGroupsClientDeleteResponse], error)
+}
+
+// accountsClient is the part of *armstorage.AccountsClient the sample uses.
+type accountsClient interface {
+	CheckNameAvailability(ctx context.Context, accountName armstorage.AccountCheckNameAvailabilityParameters, options *armstorage.AccountsClientCheckNameAvailabilityOptions) (armstorage.AccountsClientCheckNameAvailabilityResponse, error)
+	BeginCreate(ctx context.Context, resourceGroupName string, accountName string, parameters armstorage.AccountCreateParameters, options *armstorage.AccountsClientBeginCreateOptions) (*runtime.Poller[armstorage.AccountsClientCreateResponse], error)
+	GetProperties(ctx context.Context, resourceGroupName string, accountName string, options *armstorage.AccountsClientGetPropertiesOptions) (armstorage.AccountsClientGetPropertiesResponse, error)
+	NewListPager(options *armstorage.AccountsClientListOptions) *runtime.Pager[armstorage.AccountsClientListResponse]
+	NewListByResourceGroupPager(resourceGroupName string, options *armstorage.AccountsClientListByResourceGroupOptions) *runtime.Pager[armstorage.AccountsClientListByResourceGroupResponse]
+	ListKeys(ctx context.Context, resourceGroupName string, accountName string, options *armstorage.AccountsClientListKeysOptions) (armstorage.AccountsClientListKeysResponse, error)
+	RegenerateKey(ctx context.Context, resourceGroupName string, accountName string, regenerateKey armstorage.AccountRegenerateKeyParameters, options *armstorage.AccountsClientRegenerateKeyOptions) (armstorage.AccountsClientRegenerateKeyResponse, error)
+	Delete(ctx context.Context, resourceGroupName string, accountName string, options *armstorage.AccountsClientDeleteOptions) (armstorage.AccountsClientDeleteResponse, error)
+}
+
+// sample runs the steps of the sample with the clients it is given and
+// reports its progress to out, its listings to lists and its steps to
+// steps, which may be nil. The storage account is named by names, which may
+// be nil as well to keep the name the sample is given. converge decides what
+// to do about the resources that exist already and may be nil too.
+type sample struct {
+	groups   resourceGroupsClient
+	accounts accountsClient
+	names    *naming.Namer
+	converge *converge.Checker
+	waiter   *lro.Waiter
+	out      io.Writer
+	lists    *output.Printer
+	steps    *report.Recorder
+	location string
+	// tags are the tags of every resource the sample creates.
+	tags map[string]*string
+}
+
+// run creates the resource group and a storage account in it, lists the
+// storage accounts, rotates key1 of the account and deletes the account. With
+// clean it deletes the resource group as well. Every step is recorded in
+// s.steps. The account is named after accountBase; when the name is taken,
+// the account gets a new name.
+func (s *sample) run(ctx context.Context, resourceGroupName, accountBase string, clean bool) error {
+	err := s.steps.Step("create resource group "+resourceGroupName, func() error {
+		return s.createResourceGroup(ctx, resourceGroupName)
+	})
+	if err != nil {
+		return err
+	}
+	var storageAccountName string
+	err = s.steps.StepWith("check name availability of "+accountBase, &storageAccountName, func() (err error) {
+		storageAccountName, err = s.names.Retry(lro.StorageAccount, accountBase, func(name string) error {
+			// An account of an earlier run with the same ID takes the name
+			// in the resource group of the run, where it is adopted.
+			_, err := s.accounts.GetProperties(ctx, resourceGroupName, name, nil)
+			switch {
+			case err == nil:
+				fmt.Fprintf(s.out, "The account %s exists in %s already\n", name, resourceGroupName)
+				return nil
+			case !converge.NotFound(err):
+				return fmt.Errorf("failed to get storage account %s: %w", name, err)
+			}
+			return s.checkName(ctx, name)
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	err = s.steps.Step("create storage account "+storageAccountName, func() error {
+		return s.createAccount(ctx, resourceGroupName, storageAccountName)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = s.steps.Step("list storage accounts", func() error {
+		fmt.Fprintln(s.out, "Printing all storage accounts")
+		return s.printAccounts(ctx)
+	})
+	if err != nil {
+		return err
+	}
+	err = s.steps.Step("list storage accounts in "+resourceGroupName, func() error {
+		fmt.Fprintf(s.out, "Printing all storage accounts in %s\n", resourceGroupName)
+		return s.printAccountsIn(ctx, resourceGroupName)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = s.steps.Step("list keys of "+storageAccountName, func() error {
+		return s.printKeys(ctx, resourceGroupName, storageAccountName)
+	})
+	if err != nil {
+		return err
+	}
+	err = s.steps.Step("rotate key1 of "+storageAccountName, func() error {
+		fmt.Fprintln(s.out, "Rotating key1")
+		if _, err := s.accounts.RegenerateKey(ctx, resourceGroupName, storageAccountName, armstorage.AccountRegenerateKeyParameters{KeyName: to.Ptr("key1")}, nil); err != nil {
+			return fmt.Errorf("failed to regenerate key: %w", err)
+		}
+		return s.printKeys(ctx, resourceGroupName, storageAccountName)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = s.steps.Step("delete storage account "+storageAccountName, func() error {
+		fmt.Fprintln(s.out, "Deleting storage account")
+		cntxTimeout, cancel := s.waiter.WithTimeout(ctx, lro.StorageAccount)
+		defer cancel()
+		if _, err := s.accounts.Delete(cntxTimeout, resourceGroupName, storageAccountName, nil); err != nil {
+			return fmt.Errorf("failed to delete storage account %s: %w", storageAccountName, err)
+		}
+		return nil
+	})
+	if err != nil || !clean {
+		return err
+	}
+
+	return s.steps.Step("delete resource group "+resourceGroupName, func() error {
+		fmt.Fprintln(s.out, "Deleting resource group")
+		poller, err := s.groups.BeginDelete(ctx, resourceGroupName, nil)
+		if err != nil {
+			return fmt.Errorf("failed to delete resource group %s: %w", resourceGroupName, err)
+		}
+		_, err = lro.Wait(ctx, s.waiter, lro.Delete(lro.ResourceGroup, resourceGroupName), poller)
+		return err
+	})
+}
+
+// checkName checks that the name of the storage account is available. The
+// error wraps naming.ErrUnavailable when the name is taken.
+func (s *sample) checkName(ctx context.Context, name string) error {
+	availability, err := s.accounts.CheckNameAvailability(ctx, armstorage.AccountCheckNameAvailabilityParameters{Name: to.Ptr(name)}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to check storage account name availability: %w", err)
+	}
+	fmt.Fprintf(s.out, "The account %s is available: %t\n", name, *availability.NameAvailable)
+	if !*availability.NameAvailable {
+		if availability.Reason != nil && *availability.Reason == armstorage.ReasonAlreadyExists {
+			return fmt.Errorf("the storage account name %s is not available: %s: %w", name, *availability.Message, naming.ErrUnavailable)
+		}
+		return fmt.Errorf("the storage account name %s is not available: %s", name, *availability.Message)
+	}
+	return nil
+}
+
+func (s *sample) createResourceGroup(ctx context.Context, name string) error {
+	fmt.Fprintln(s.out, "Creating resource group")
+	param := armresources.ResourceGroup{
+		Location: to.Ptr(s.location),
+		Tags:     s.tags,
+	}
+	_, err := converge.Ensure(s.converge, lro.ResourceGroup.Label()+" "+name, func() (armresources.ResourceGroup, error) {
+		resp, err := s.groups.Get(ctx, name, nil)
+		return resp.ResourceGroup, err
+	}, param, func() (armresources.ResourceGroup, error) {
+		resp, err := s.groups.CreateOrUpdate(ctx, name, param, nil)
+		if err != nil {
+			return resp.ResourceGroup, fmt.Errorf("failed to create resource group %s: %w", name, err)
+		}
+		return resp.ResourceGroup, nil
+	}, "location")
+	return err
+}
+
+// createAccount creates the storage account name, or adopts or updates the
+// one an earlier run left behind.
+func (s *sample) createAccount(ctx context.Context, resourceGroupName, name string) error {
+	param := armstorage.AccountCreateParameters{
+		Kind:       to.Ptr(armstorage.KindStorage),
+		SKU:        &armstorage.SKU{Name: to.Ptr(armstorage.SKUNameStandardLRS)},
+		Location:   to.Ptr(s.location),
+		Tags:       s.tags,
+		Properties: &armstorage.AccountPropertiesCreateParameters{},
+	}
+	_, err := converge.Ensure(s.converge, lro.StorageAccount.Label()+" "+name, func() (armstorage.Account, error) {
+		resp, err := s.accounts.GetProperties(ctx, resourceGroupName, name, nil)
+		return resp.Account, err
+	}, param, func() (armstorage.Account, error) {
+		poller, err := s.accounts.BeginCreate(ctx, resourceGroupName, name, param, nil)
+		if err != nil {
+			return armstorage.Account{}, fmt.Errorf("failed to create storage account %s: %w", name, err)
+		}
+		result, err := lro.Wait(ctx, s.waiter, lro.Create(lro.StorageAccount, name), poller)
+		return result.Account, err
+	}, "location", "kind")
+	return err
+}
+
+func (s *sample) printAccounts(ctx context.Context) error {
+	var accounts []output.Resource
+	pager := s.accounts.NewListPager(nil)
+	for pager.More() {
+		resp, err := pager.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get the next page of the storage account list: %w", err)
+		}
+		for _, sa := range resp.AccountListResult.Value {
+			accounts = append(accounts, accountResource(sa))
+		}
+	}
+	return s.lists.Print(accounts)
+}
+
+func (s *sample) printAccountsIn(ctx context.Context, resourceGroupName string) error {
+	var accounts []output.Resource
+	pager := s.accounts.NewListByResourceGroupPager(resourceGroupName, nil)
+	for pager.More() {
+		resp, err := pager.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get the next page of the storage account list: %w", err)
+		}
+		for _, sa := range resp.AccountListResult.Value {
+			accounts = append(accounts, accountResource(sa))
+		}
+	}
+	return s.lists.Print(accounts)
+}
+
+func accountResource(sa *armstorage.Account) output.Resource {
+	account := output.Resource{
+		Name:     output.String(sa.Name),
+		Location: output.String(sa.Location),
+		Tags:     output.Tags(sa.Tags),
+		ID:       output.String(sa.ID),
+	}
+	if sa.SKU != nil {
+		account.SKU = output.String(sa.SKU.Name)
+	}
+	if sa.Properties != nil {
+		account.ProvisioningState = output.String(sa.Properties.ProvisioningState)
+	}
+	return account
+}
+
+func (s *sample) printKeys(ctx context.Context, resourceGroupName, name string) error {
+	fmt.Fprintf(s.out, "Printing all keys for storage account: %s\n", name)
+	keysResponse, err := s.accounts.ListKeys(ctx, resourceGroupName, name, nil)
+	if err != nil {
+		return fmt.Errorf("failed to list keys: %w", err)
+	}
+	for _, key := range keysResponse.AccountListKeysResult.Keys {
+		fmt.Fprint(s.out, "Name: "+*key.KeyName+" Value: "+*key.Value+", ")
+	}
+	fmt.Fprintln(s.out)
+	return nil
+}
@@ -0,0 +1,230 @@
+package demo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/resources/armresources"
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/storage/armstorage"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/converge"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/fakes"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/naming"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/output"
+)
+
+const (
+	groupsURL   = "https://management.local.azurestack.external/subscriptions/sub/resourceGroups"
+	accountsURL = groupsURL + "/TestRG/providers/Microsoft.Storage/storageAccounts"
+)
+
+// existingAccount makes the fake find the storage account testsa in
+// location.
+func existingAccount(location string) func(*fakeResourceGroupsClient, *fakeAccountsClient) {
+	return func(groups *fakeResourceGroupsClient, accounts *fakeAccountsClient) {
+		accounts.GetPropertiesFunc = func(ctx context.Context, resourceGroupName string, accountName string, options *armstorage.AccountsClientGetPropertiesOptions) (armstorage.AccountsClientGetPropertiesResponse, error) {
+			var resp armstorage.AccountsClientGetPropertiesResponse
+			resp.Account = armstorage.Account{
+				ID:       to.Ptr("/subscriptions/sub/resourceGroups/TestRG/providers/Microsoft.Storage/storageAccounts/testsa"),
+				Name:     to.Ptr("testsa"),
+				Kind:     to.Ptr(armstorage.KindStorage),
+				Location: to.Ptr(location),
+				SKU:      &armstorage.SKU{Name: to.Ptr(armstorage.SKUNameStandardLRS)},
+			}
+			return resp, nil
+		}
+		accounts.BeginCreateFunc = nil
+	}
+}
+
+func accountList(names ...string) armstorage.AccountListResult {
+	var list armstorage.AccountListResult
+	for _, name := range names {
+		list.Value = append(list.Value, &armstorage.Account{Name: to.Ptr(name)})
+	}
+	return list
+}
+
+// newClients returns fakes on which every call succeeds.
+func newClients() (*fakeResourceGroupsClient, *fakeAccountsClient) {
+	groups := &fakeResourceGroupsClient{
+		CreateOrUpdateFunc: func(ctx context.Context, name string, parameters armresources.ResourceGroup, options *armresources.ResourceGroupsClientCreateOrUpdateOptions) (armresources.ResourceGroupsClientCreateOrUpdateResponse, error) {
+			return armresources.ResourceGroupsClientCreateOrUpdateResponse{}, nil
+		},
+		GetFunc: func(ctx context.Context, name string, options *armresources.ResourceGroupsClientGetOptions) (armresources.ResourceGroupsClientGetResponse, error) {
+			err := fakes.ResponseError(http.MethodGet, groupsURL+"/"+name, http.StatusNotFound, "ResourceGroupNotFound")
+			return armresources.ResourceGroupsClientGetResponse{}, err
+		},
+		BeginDeleteFunc: func(ctx context.Context, name string, options *armresources.ResourceGroupsClientBeginDeleteOptions) (*runtime.Poller[armresources.ResourceGroupsClientDeleteResponse], error) {
+			return fakes.Poller(1, armresources.ResourceGroupsClientDeleteResponse{}, nil), nil
+		},
+	}
+	accounts := &fakeAccountsClient{
+		CheckNameAvailabilityFunc: func(ctx context.Context, accountName armstorage.AccountCheckNameAvailabilityParameters, options *armstorage.AccountsClientCheckNameAvailabilityOptions) (armstorage.AccountsClientCheckNameAvailabilityResponse, error) {
+			var resp armstorage.AccountsClientCheckNameAvailabilityResponse
+			resp.NameAvailable = to.Ptr(true)
+			return resp, nil
+		},
+		BeginCreateFunc: func(ctx context.Context, resourceGroupName string, accountName string, parameters armstorage.AccountCreateParameters, options *armstorage.AccountsClientBeginCreateOptions) (*runtime.Poller[armstorage.AccountsClientCreateResponse], error) {
+			return fakes.Poller(2, armstorage.AccountsClientCreateResponse{}, nil), nil
+		},
+		GetPropertiesFunc: func(ctx context.Context, resourceGroupName string, accountName string, options *armstorage.AccountsClientGetPropertiesOptions) (armstorage.AccountsClientGetPropertiesResponse, error) {
+			err := fakes.ResponseError(http.MethodGet, accountsURL+"/"+accountName, http.StatusNotFound, "ResourceNotFound")
+			return armstorage.AccountsClientGetPropertiesResponse{}, err
+		},
+		NewListPagerFunc: func(options *armstorage.AccountsClientListOptions) *runtime.Pager[armstorage.AccountsClientListResponse] {
+			return fakes.Pager([]armstorage.AccountsClientListResponse{{AccountListResult: accountList("other", "testsa")}}, nil)
+		},
+		NewListByResourceGroupPagerFunc: func(resourceGroupName string, options *armstorage.AccountsClientListByResourceGroupOptions) *runtime.Pager[armstorage.AccountsClientListByResourceGroupResponse] {
+			return fakes.Pager([]armstorage.AccountsClientListByResourceGroupResponse{{AccountListResult: accountList("testsa")}}, nil)
+		},
+		ListKeysFunc: func(ctx context.Context, resourceGroupName string, accountName string, options *armstorage.AccountsClientListKeysOptions) (armstorage.AccountsClientListKeysResponse, error) {
+			var resp armstorage.AccountsClientListKeysResponse
+			resp.Keys = []*armstorage.AccountKey{{KeyName: to.Ptr("key1"), Value: to.Ptr("value1")}}
+			return resp, nil
+		},
+		RegenerateKeyFunc: func(ctx context.Context, resourceGroupName string, accountName string, regenerateKey armstorage.AccountRegenerateKeyParameters, options *armstorage.AccountsClientRegenerateKeyOptions) (armstorage.AccountsClientRegenerateKeyResponse, error) {
+			return armstorage.AccountsClientRegenerateKeyResponse{}, nil
+		},
+		DeleteFunc: func(ctx context.Context, resourceGroupName string, accountName string, options *armstorage.AccountsClientDeleteOptions) (armstorage.AccountsClientDeleteResponse, error) {
+			return armstorage.AccountsClientDeleteResponse{}, nil
+		},
+	}
+	return groups, accounts
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name  string
+		clean bool
+		// runID, when set, names the storage account after the run.
+		runID      string
+		setup      func(*fakeResourceGroupsClient, *fakeAccountsClient)
+		wantErr    string
+		wantOutput []string
+	}{
+		{
+			name:  "success",
+			clean: true,
+			wantOutput: []string{
+				"The account testsa is available: true",
+				"Completed: create storage account testsa",
+				"Printing all storage accounts\nNAME    LOCATION  STATE  TAGS  SKU  ID\nother   -",
+				"Printing all storage accounts in TestRG\nNAME    LOCATION  STATE  TAGS  SKU  ID\ntestsa  -",
+				"Name: key1 Value: value1, ",
+				"Completed: delete resource group TestRG",
+			},
+		},
+		{
+			name: "name not available",
+			setup: func(groups *fakeResourceGroupsClient, accounts *fakeAccountsClient) {
+				accounts.CheckNameAvailabilityFunc = func(ctx context.Context, accountName armstorage.AccountCheckNameAvailabilityParameters, options *armstorage.AccountsClientCheckNameAvailabilityOptions) (armstorage.AccountsClientCheckNameAvailabilityResponse, error) {
+					var resp armstorage.AccountsClientCheckNameAvailabilityResponse
+					resp.NameAvailable = to.Ptr(false)
+					resp.Message = to.Ptr("The storage account named testsa is already taken.")
+					return resp, nil
+				}
+			},
+			wantErr: "the storage account name testsa is not available: The storage account named testsa is already taken.",
+		},
+		{
+			name:  "name taken by another run",
+			runID: "run1",
+			setup: func(groups *fakeResourceGroupsClient, accounts *fakeAccountsClient) {
+				accounts.CheckNameAvailabilityFunc = func(ctx context.Context, accountName armstorage.AccountCheckNameAvailabilityParameters, options *armstorage.AccountsClientCheckNameAvailabilityOptions) (armstorage.AccountsClientCheckNameAvailabilityResponse, error) {
+					var resp armstorage.AccountsClientCheckNameAvailabilityResponse
+					resp.NameAvailable = to.Ptr(*accountName.Name != "testsarun1")
+					if !*resp.NameAvailable {
+						resp.Reason = to.Ptr(armstorage.ReasonAlreadyExists)
+						resp.Message = to.Ptr("The storage account named testsarun1 is already taken.")
+					}
+					return resp, nil
+				}
+			},
+			wantOutput: []string{
+				"The account testsarun1 is available: false",
+				"The name testsarun1 is taken, trying testsa",
+				"Completed: create storage account testsa",
+			},
+		},
+		{
+			name:  "rerun adopts the account",
+			setup: existingAccount("local"),
+			wantOutput: []string{
+				"The account testsa exists in TestRG already",
+				"Adopting the existing storage account testsa",
+				"Rotating key1",
+			},
+		},
+		{
+			name:    "account drifted",
+			setup:   existingAccount("westus"),
+			wantErr: `the existing storage account testsa has drifted: location is "westus", want "local"; delete it or use another -runID`,
+		},
+		{
+			name: "create fails",
+			setup: func(groups *fakeResourceGroupsClient, accounts *fakeAccountsClient) {
+				accounts.BeginCreateFunc = func(ctx context.Context, resourceGroupName string, accountName string, parameters armstorage.AccountCreateParameters, options *armstorage.AccountsClientBeginCreateOptions) (*runtime.Poller[armstorage.AccountsClientCreateResponse], error) {
+					err := fakes.ResponseError(http.MethodPut, accountsURL+"/testsa", http.StatusConflict, "StorageAccountAlreadyTaken")
+					return fakes.Poller(1, armstorage.AccountsClientCreateResponse{}, err), nil
+				}
+			},
+			wantErr: "failed to create storage account testsa: StorageAccountAlreadyTaken (status 409)",
+		},
+		{
+			name: "pager error",
+			setup: func(groups *fakeResourceGroupsClient, accounts *fakeAccountsClient) {
+				accounts.NewListByResourceGroupPagerFunc = func(resourceGroupName string, options *armstorage.AccountsClientListByResourceGroupOptions) *runtime.Pager[armstorage.AccountsClientListByResourceGroupResponse] {
+					return fakes.Pager[armstorage.AccountsClientListByResourceGroupResponse](nil, errors.New("connection reset"))
+				}
+			},
+			wantErr: "failed to get the next page of the storage account list: connection reset",
+		},
+		{
+			name: "list keys fails",
+			setup: func(groups *fakeResourceGroupsClient, accounts *fakeAccountsClient) {
+				accounts.ListKeysFunc = func(ctx context.Context, resourceGroupName string, accountName string, options *armstorage.AccountsClientListKeysOptions) (armstorage.AccountsClientListKeysResponse, error) {
+					err := fakes.ResponseError(http.MethodPost, accountsURL+"/testsa/listKeys", http.StatusForbidden, "AuthorizationFailed")
+					return armstorage.AccountsClientListKeysResponse{}, err
+				}
+			},
+			wantErr: "failed to list keys",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			groups, accounts := newClients()
+			if tt.setup != nil {
+				tt.setup(groups, accounts)
+			}
+			var out bytes.Buffer
+			s := &sample{groups: groups, accounts: accounts, converge: &converge.Checker{Out: &out}, waiter: fakes.Waiter(&out), out: &out, lists: &output.Printer{W: &out, Format: output.Table}, location: "local"}
+			if tt.runID != "" {
+				names, err := naming.New(tt.runID, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				names.Out = &out
+				s.names = names
+			}
+			err := s.run(context.Background(), "TestRG", "testsa", tt.clean)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("run failed: %s", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("run returned %v, want an error containing %q", err, tt.wantErr)
+			}
+			for _, want := range tt.wantOutput {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("output is missing %q:\n%s", want, out.String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/cassette"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/fakestack"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/plan"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/report"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/tags"
+)
+
+var record = flag.Bool("record", false, "record testdata/sample.json against the fake stamp before replaying it")
+
+// wantOutput is printed by every successful run of the sample with -clean.
+var wantOutput = []string{
+	"Completed: create virtual network TestGoVnetName",
+	"Completed: create network interface testGoNetworkInterface",
+	"Completed: delete virtual machine TestGoVm1",
+	"Completed: create virtual machine TestGoManagedDiskVm",
+	"Completed: delete resource group TestGoVMSampleResourceGroup-fake01",
+}
+
+func TestMain(m *testing.M) {
+	fakestack.Main(m, func(t policy.Transporter) {
+		transport = t
+		main()
+	})
+}
+
+func checkRun(t *testing.T, result fakestack.Result) {
+	t.Helper()
+	if result.ExitCode != 0 {
+		t.Fatalf("exit code %d, output:\n%s", result.ExitCode, result.Output)
+	}
+	for _, want := range wantOutput {
+		if !strings.Contains(result.Output, want) {
+			t.Errorf("output is missing %q:\n%s", want, result.Output)
+		}
+	}
+}
+
+func TestSample(t *testing.T) {
+	tests := []struct {
+		identity fakestack.Identity
+		args     []string
+	}{
+		{fakestack.AAD, []string{"-secret", "-disableID"}},
+		{fakestack.ADFS, nil},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.identity), func(t *testing.T) {
+			stack := fakestack.Start(t, tt.identity)
+			stack.SetPageSize(1)
+			checkRun(t, stack.Run(t, append(tt.args, "-clean")...))
+			if left := stack.Resources(); len(left) != 0 {
+				t.Errorf("resources left behind: %v", left)
+			}
+		})
+	}
+}
+
+// TestReplay runs the sample from the interactions recorded in testdata, which
+// are synthetic: they were recorded against the fake stamp, not a real one.
+// Run it with -record to record them again.
+func TestReplay(t *testing.T) {
+	path := filepath.Join("testdata", "sample.json")
+	args := []string{"-secret", "-disableID", "-clean", "-cassette", path}
+	if *record {
+		stack := fakestack.Start(t, fakestack.AAD)
+		checkRun(t, stack.Run(t, append(args, "-cassetteMode", "record")...))
+	}
+	endpoint, err := cassette.ResourceManagerEndpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRun(t, fakestack.RunOffline(t, endpoint, append(args, "-cassetteMode", "replay")...))
+}
+
+func TestRollbackAfterFailedCreate(t *testing.T) {
+	stack := fakestack.Start(t, fakestack.AAD)
+	stack.FailCreate("TestGoVm1", "OSProvisioningTimedOut", "OS Provisioning for VM 'TestGoVm1' did not finish in the allotted time.")
+	dir := t.TempDir()
+	result := stack.Run(t, "-secret", "-disableID", "-report", filepath.Join(dir, "report.json"), "-junit", filepath.Join(dir, "report.xml"))
+	if result.ExitCode != 1 {
+		t.Fatalf("exit code %d, output:\n%s", result.ExitCode, result.Output)
+	}
+	for _, want := range []string{
+		"failed to create virtual machine TestGoVm1: OSProvisioningTimedOut",
+		"All resources created by this run were deleted",
+	} {
+		if !strings.Contains(result.Output, want) {
+			t.Errorf("output is missing %q:\n%s", want, result.Output)
+		}
+	}
+	if left := stack.Resources(); len(left) != 0 {
+		t.Errorf("resources left behind: %v", left)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "report.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r report.Report
+	if err := json.Unmarshal(data, &r); err != nil {
+		t.Fatalf("report is not JSON: %s", err)
+	}
+	if r.Status != report.Failed || r.IdentityProvider != report.AAD || r.ExitCode != 1 {
+		t.Errorf("report has status %s, identity provider %s and exit code %d, want failed, AAD and 1", r.Status, r.IdentityProvider, r.ExitCode)
+	}
+	steps := map[string]report.Step{}
+	for _, step := range r.Steps {
+		steps[step.Name] = step
+	}
+	failed := steps["create virtual machine TestGoVm1"]
+	if failed.Status != report.Failed || failed.Error == nil || failed.Error.Code != "OSProvisioningTimedOut" {
+		t.Errorf("create virtual machine TestGoVm1 reported as %+v, want it failed with OSProvisioningTimedOut", failed)
+	}
+	if len(failed.ResourceIDs) != 1 || !strings.HasSuffix(failed.ResourceIDs[0], "/virtualMachines/TestGoVm1") {
+		t.Errorf("create virtual machine TestGoVm1 reports the resources %v", failed.ResourceIDs)
+	}
+	if rollback := steps["roll back"]; rollback.Status != report.Passed {
+		t.Errorf("roll back reported as %+v, want it passed", rollback)
+	}
+
+	junit, err := os.ReadFile(filepath.Join(dir, "report.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(junit), `<testcase name="create virtual machine TestGoVm1" classname="vm.AAD"`) || !strings.Contains(string(junit), `failures="1"`) {
+		t.Errorf("JUnit report does not show the failed step:\n%s", junit)
+	}
+}
+
+// TestTags checks that every resource the sample creates has the standard
+// tags and those of -tag.
+func TestTags(t *testing.T) {
+	stack := fakestack.Start(t, fakestack.AAD)
+	result := stack.Run(t, "-secret", "-disableID", "-cleanup", "never", "-tag", "owner=alice", "-ttl", "2h")
+	if result.ExitCode != 0 {
+		t.Fatalf("exit code %d, output:\n%s", result.ExitCode, result.Output)
+	}
+	all := stack.Tags()
+	var tagged int
+	for _, id := range stack.Resources() {
+		// Skip child resources, such as subnets, which have no tags.
+		if n := strings.Count(id, "/"); n != 4 && n != 8 {
+			continue
+		}
+		tagged++
+		got := all[id]
+		for key, want := range map[string]string{tags.RunID: fakestack.RunID, tags.Creator: fakestack.ObjectID, tags.Sample: "vm", "owner": "alice"} {
+			if got[key] != want {
+				t.Errorf("tag %s of %s is %q, want %q", key, id, got[key], want)
+			}
+		}
+		created, err1 := time.Parse(time.RFC3339, got[tags.CreatedAt])
+		expires, err2 := time.Parse(time.RFC3339, got[tags.ExpiresAt])
+		if err1 != nil || err2 != nil || expires.Sub(created) != 2*time.Hour {
+			t.Errorf("%s was created at %q and expires at %q, want 2h later", id, got[tags.CreatedAt], got[tags.ExpiresAt])
+		}
+	}
+	// The resource group, virtual network, security group, public IP
+	// address, network interface, storage account, disk and the virtual
+	// machine with the managed disk.
+	if tagged != 8 {
+		t.Errorf("found %d resources, want 8: %v", tagged, stack.Resources())
+	}
+}
+
+// TestDryRun checks that -dry-run leaves the stamp untouched and plans the
+// same requests as a real run, with the admin password masked.
+func TestDryRun(t *testing.T) {
+	stack := fakestack.Start(t, fakestack.AAD)
+	path := filepath.Join(t.TempDir(), "plan.json")
+	result := stack.Run(t, "-secret", "-disableID", "-clean", "-dry-run", "-planFile", path)
+	if result.ExitCode != 0 {
+		t.Fatalf("exit code %d, output:\n%s", result.ExitCode, result.Output)
+	}
+	if left := stack.Resources(); len(left) != 0 {
+		t.Errorf("dry run created resources: %v", left)
+	}
+	if strings.Contains(result.Output, "Password!23") {
+		t.Errorf("output shows the admin password:\n%s", result.Output)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p struct{ Operations []plan.Operation }
+	if err := json.Unmarshal(data, &p); err != nil {
+		t.Fatalf("plan file is not JSON: %s", err)
+	}
+	var puts []string
+	for _, op := range p.Operations {
+		if op.Method == "PUT" {
+			puts = append(puts, op.ResourceID[strings.LastIndex(op.ResourceID, "/")+1:])
+		}
+		if strings.Contains(string(op.Body), "Password!23") {
+			t.Errorf("plan shows the admin password in %s", op)
+		}
+	}
+	want := "TestGoVMSampleResourceGroup-fake01 TestGoVnetName TestGoNsgName TestGoIpAddr testGoNetworkInterface govmteststorageaccfake01 TestGoVm1 osDisk2 TestGoManagedDiskVm"
+	if got := strings.Join(puts, " "); got != want {
+		t.Errorf("planned PUTs %q, want %q", got, want)
+	}
+}
+
+// TestRerun checks that a rerun with the ID of an earlier run that was not
+// cleaned up adopts the resources it left behind and only creates the virtual
+// machine that run deleted.
+func TestRerun(t *testing.T) {
+	stack := fakestack.Start(t, fakestack.AAD)
+	if result := stack.Run(t, "-secret", "-disableID", "-cleanup", "never"); result.ExitCode != 0 {
+		t.Fatalf("exit code %d, output:\n%s", result.ExitCode, result.Output)
+	}
+	result := stack.Run(t, "-secret", "-disableID", "-clean")
+	if result.ExitCode != 0 {
+		t.Fatalf("exit code %d, output:\n%s", result.ExitCode, result.Output)
+	}
+	for _, want := range []string{
+		"Adopting the existing resource group TestGoVMSampleResourceGroup-fake01",
+		"Adopting the existing virtual network TestGoVnetName",
+		"Adopting the existing network interface testGoNetworkInterface",
+		"Adopting the existing disk osDisk2",
+		"Adopting the existing virtual machine TestGoManagedDiskVm",
+		"Completed: create virtual machine TestGoVm1",
+		"Completed: delete resource group TestGoVMSampleResourceGroup-fake01",
+	} {
+		if !strings.Contains(result.Output, want) {
+			t.Errorf("output is missing %q:\n%s", want, result.Output)
+		}
+	}
+	for _, unwanted := range []string{"Completed: create virtual network", "Completed: create virtual machine TestGoManagedDiskVm"} {
+		if strings.Contains(result.Output, unwanted) {
+			t.Errorf("output contains %q:\n%s", unwanted, result.Output)
+		}
+	}
+}
+
+// TestResume checks that a run resumed after a failure skips the steps the
+// failed run completed and carries on from the step that failed.
+func TestResume(t *testing.T) {
+	stack := fakestack.Start(t, fakestack.AAD)
+	state := t.TempDir()
+	stack.FailCreate("TestGoManagedDiskVm", "OSProvisioningTimedOut", "OS Provisioning for VM 'TestGoManagedDiskVm' did not finish in the allotted time.")
+	result := stack.Run(t, "-secret", "-disableID", "-cleanup", "never", "-stateDir", state)
+	want := "The state of the run is in " + filepath.Join(state, "vm-fake01.json") + ", resume it with -resume fake01"
+	if result.ExitCode != 1 || !strings.Contains(result.Output, want) {
+		t.Fatalf("exit code %d, want 1 and an output containing %q:\n%s", result.ExitCode, want, result.Output)
+	}
+
+	path := filepath.Join(t.TempDir(), "report.json")
+	result = stack.Run(t, "-secret", "-disableID", "-clean", "-stateDir", state, "-resume", "fake01", "-report", path)
+	if result.ExitCode != 0 {
+		t.Fatalf("exit code %d, output:\n%s", result.ExitCode, result.Output)
+	}
+	for _, want := range []string{
+		"Resuming run fake01 after its 7 completed steps",
+		`Skipping step "create network"`,
+		`Skipping step "create disk osDisk2"`,
+		"Updating the existing virtual machine TestGoManagedDiskVm, whose provisioning failed",
+	} {
+		if !strings.Contains(result.Output, want) {
+			t.Errorf("output is missing %q:\n%s", want, result.Output)
+		}
+	}
+	if strings.Contains(result.Output, "Creating Vnet and subnets") {
+		t.Errorf("the resumed run created the network again:\n%s", result.Output)
+	}
+	if left := stack.Resources(); len(left) != 0 {
+		t.Errorf("resources left behind: %v", left)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r report.Report
+	if err := json.Unmarshal(data, &r); err != nil {
+		t.Fatalf("report is not JSON: %s", err)
+	}
+	var skipped []string
+	for _, step := range r.Steps {
+		if step.Status == report.Skipped {
+			skipped = append(skipped, step.Name)
+		}
+	}
+	if len(skipped) != 7 || skipped[0] != "create resource group TestGoVMSampleResourceGroup-fake01" {
+		t.Errorf("skipped steps %v, want the 7 steps of the failed run", skipped)
+	}
+}
+
+func TestBlobState(t *testing.T) {
+	stack := fakestack.Start(t, fakestack.AAD)
+	config := t.TempDir()
+	if err := fakestack.WriteConfig(config, stack.URL); err != nil {
+		t.Fatal(err)
+	}
+	if err := fakestack.WriteConfigSection(config, "State", stack.StateConfig()); err != nil {
+		t.Fatal(err)
+	}
+	args := []string{"-secret", "-disableID", "-configDir", config, "-stateBackend", "blob"}
+
+	// A run with the same names on another agent holds the lease.
+	stack.LeaseBlob("hybrid-state", "vm-fake01.json")
+	result := stack.Run(t, append(args, "-cleanup", "never")...)
+	want := "another run with the same names is in progress: it holds the lease of " + stack.BlobEndpoint() + "/hybrid-state/vm-fake01.json"
+	if result.ExitCode != 1 || !strings.Contains(result.Output, want) {
+		t.Fatalf("exit code %d, want 1 and an output containing %q:\n%s", result.ExitCode, want, result.Output)
+	}
+	if left := stack.Resources(); len(left) != 0 {
+		t.Fatalf("the refused run created %v", left)
+	}
+	stack.ReleaseBlob("hybrid-state", "vm-fake01.json")
+
+	stack.FailCreate("TestGoManagedDiskVm", "OSProvisioningTimedOut", "OS Provisioning for VM 'TestGoManagedDiskVm' did not finish in the allotted time.")
+	result = stack.Run(t, append(args, "-cleanup", "never")...)
+	if result.ExitCode != 1 {
+		t.Fatalf("exit code %d, want 1:\n%s", result.ExitCode, result.Output)
+	}
+	data, exists, leased := stack.Blob("hybrid-state", "vm-fake01.json")
+	if !exists || leased || !strings.Contains(string(data), `"name": "create disk osDisk2"`) {
+		t.Fatalf("state blob exists %v, leased %v, want the released state of the failed run:\n%s", exists, leased, data)
+	}
+
+	result = stack.Run(t, append(args, "-clean", "-resume", "fake01")...)
+	if result.ExitCode != 0 || !strings.Contains(result.Output, "Resuming run fake01 after its 7 completed steps") {
+		t.Fatalf("exit code %d, want 0 and a resumed run:\n%s", result.ExitCode, result.Output)
+	}
+	if left := stack.Resources(); len(left) != 0 {
+		t.Errorf("resources left behind: %v", left)
+	}
+	if _, _, leased := stack.Blob("hybrid-state", "vm-fake01.json"); leased {
+		t.Errorf("the resumed run kept the lease of its state blob")
+	}
+
+	// Another run takes over the state blob while the run creates its
+	// resources, which the run notices at the first renewal of its lease,
+	// after 5 seconds.
+	state := map[string]interface{}{"LeaseDuration": 15}
+	for key, value := range stack.StateConfig() {
+		state[key] = value
+	}
+	if err := fakestack.WriteConfigSection(config, "State", state); err != nil {
+		t.Fatal(err)
+	}
+	stack.SetPolls(1000)
+	defer stack.SetPolls(0)
+	taken := make(chan []byte)
+	go func() {
+		for {
+			if data, _, leased := stack.Blob("hybrid-state", "vm-fake01.json"); leased {
+				stack.ReleaseBlob("hybrid-state", "vm-fake01.json")
+				stack.LeaseBlob("hybrid-state", "vm-fake01.json")
+				taken <- data
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	result = stack.Run(t, append(args, "-cleanup", "never")...)
+	data = <-taken
+	want = "Cancelling the run: lost the lease of " + stack.BlobEndpoint() + "/hybrid-state/vm-fake01.json"
+	if result.ExitCode != 1 || !strings.Contains(result.Output, want) {
+		t.Fatalf("exit code %d, want 1 and an output containing %q:\n%s", result.ExitCode, want, result.Output)
+	}
+	if !strings.Contains(result.Output, "Warning: failed to write the state of the run to "+stack.BlobEndpoint()+"/hybrid-state/vm-fake01.json: lost the lease") {
+		t.Errorf("the run did not refuse to write its state:\n%s", result.Output)
+	}
+	if after, _, leased := stack.Blob("hybrid-state", "vm-fake01.json"); !leased || string(after) != string(data) {
+		t.Errorf("leased %v, the run that lost the lease changed the state blob:\n%s", leased, after)
+	}
+}
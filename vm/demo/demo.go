@@ -0,0 +1,107 @@
+// Package demo is the virtual machine sample: it creates a network, a
+// storage account and a virtual machine with an unmanaged disk, then a
+// virtual machine with a managed disk, and deletes them again.
+package demo
+
+import (
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/compute/armcompute"
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/network/armnetwork"
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/resources/armresources"
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/storage/armstorage"
+
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/session"
+)
+
+// ResourceGroup is the resource group the demo creates its resources in,
+// before the suffix of -groupSuffix and the run ID.
+const ResourceGroup = "TestGoVMSampleResourceGroup"
+
+// The image of the virtual machines.
+const (
+	publisher = "Canonical"
+	offer     = "UbuntuServer"
+	sku       = "16.04-LTS"
+)
+
+// Run runs the demo on the stamp of sess.
+func Run(sess *session.Session) error {
+	subscriptionID, cred, options := sess.Config.SubscriptionId, sess.Credential, &sess.Options
+	rgClient, err := armresources.NewResourceGroupsClient(subscriptionID, cred, options)
+	if err != nil {
+		return fmt.Errorf("failed to create the resource group client: %w", err)
+	}
+
+	fmt.Fprintln(sess.Out, "Creating a virtual network client")
+	vnetClient, err := armnetwork.NewVirtualNetworksClient(subscriptionID, cred, options)
+	if err != nil {
+		return fmt.Errorf("failed to create the vnet client: %w", err)
+	}
+
+	nsgClient, err := armnetwork.NewSecurityGroupsClient(subscriptionID, cred, options)
+	if err != nil {
+		return fmt.Errorf("failed to create the NSG client: %w", err)
+	}
+
+	fmt.Fprintln(sess.Out, "Creating public ip client")
+	ipClient, err := armnetwork.NewPublicIPAddressesClient(subscriptionID, cred, options)
+	if err != nil {
+		return fmt.Errorf("failed to create the public ip client: %w", err)
+	}
+
+	fmt.Fprintln(sess.Out, "Create Subnet client")
+	subnetClient, err := armnetwork.NewSubnetsClient(subscriptionID, cred, options)
+	if err != nil {
+		return fmt.Errorf("failed to create the subnets client: %w", err)
+	}
+
+	fmt.Fprintln(sess.Out, "Creating a Network Interface client")
+	niClient, err := armnetwork.NewInterfacesClient(subscriptionID, cred, options)
+	if err != nil {
+		return fmt.Errorf("failed to create the network interface client: %w", err)
+	}
+
+	saClient, err := armstorage.NewAccountsClient(subscriptionID, cred, options)
+	if err != nil {
+		return fmt.Errorf("failed to create the storage client: %w", err)
+	}
+
+	fmt.Fprintln(sess.Out, "Creating Virtual Machine client")
+	vmClient, err := armcompute.NewVirtualMachinesClient(subscriptionID, cred, options)
+	if err != nil {
+		return fmt.Errorf("failed to create the vm client: %w", err)
+	}
+
+	fmt.Fprintln(sess.Out, "Creating Disk client")
+	diskClient, err := armcompute.NewDisksClient(subscriptionID, cred, options)
+	if err != nil {
+		return fmt.Errorf("failed to create the disk client: %w", err)
+	}
+
+	resourceGroupName, err := sess.ResourceGroup(ResourceGroup)
+	if err != nil {
+		return err
+	}
+	s := &sample{
+		groups:        rgClient,
+		vnets:         vnetClient,
+		nsgs:          nsgClient,
+		ips:           ipClient,
+		subnets:       subnetClient,
+		nics:          niClient,
+		accounts:      saClient,
+		vms:           vmClient,
+		disks:         diskClient,
+		names:         sess.Names,
+		converge:      sess.Converge,
+		waiter:        sess.Waiter,
+		out:           sess.Out,
+		lists:         sess.Lists,
+		steps:         sess.Steps,
+		location:      sess.Config.Location,
+		tags:          sess.Tags,
+		storageSuffix: sess.Environment.StorageEndpointSuffix,
+	}
+	return s.run(sess.Context(), resourceGroupName, sess.Clean)
+}
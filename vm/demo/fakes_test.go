@@ -0,0 +1,245 @@
+// Code generated by fakegen from workflow.go; DO NOT EDIT.
+
+package demo
+
+import (
+	"context"
+
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/compute/armcompute"
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/network/armnetwork"
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/resources/armresources"
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/storage/armstorage"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+)
+
+var _ resourceGroupsClient = (*fakeResourceGroupsClient)(nil)
+
+// fakeResourceGroupsClient is a fake resourceGroupsClient. Its methods call the function
+// fields of the same name with the Func suffix, which panic when they are not
+// set.
+type fakeResourceGroupsClient struct {
+	CreateOrUpdateFunc func(ctx context.Context, resourceGroupName string, parameters armresources.ResourceGroup, options *armresources.ResourceGroupsClientCreateOrUpdateOptions) (armresources.ResourceGroupsClientCreateOrUpdateResponse, error)
+	GetFunc            func(ctx context.Context, resourceGroupName string, options *armresources.ResourceGroupsClientGetOptions) (armresources.ResourceGroupsClientGetResponse, error)
+	BeginDeleteFunc    func(ctx context.Context, resourceGroupName string, options *armresources.ResourceGroupsClientBeginDeleteOptions) (*runtime.Poller[armresources.ResourceGroupsClientDeleteResponse], error)
+}
+
+func (f *fakeResourceGroupsClient) CreateOrUpdate(ctx context.Context, resourceGroupName string, parameters armresources.ResourceGroup, options *armresources.ResourceGroupsClientCreateOrUpdateOptions) (armresources.ResourceGroupsClientCreateOrUpdateResponse, error) {
+	if f.CreateOrUpdateFunc == nil {
+		panic("fakeResourceGroupsClient.CreateOrUpdate called without CreateOrUpdateFunc")
+	}
+	return f.CreateOrUpdateFunc(ctx, resourceGroupName, parameters, options)
+}
+
+func (f *fakeResourceGroupsClient) Get(ctx context.Context, resourceGroupName string, options *armresources.ResourceGroupsClientGetOptions) (armresources.ResourceGroupsClientGetResponse, error) {
+	if f.GetFunc == nil {
+		panic("fakeResourceGroupsClient.Get called without GetFunc")
+	}
+	return f.GetFunc(ctx, resourceGroupName, options)
+}
+
+func (f *fakeResourceGroupsClient) BeginDelete(ctx context.Context, resourceGroupName string, options *armresources.ResourceGroupsClientBeginDeleteOptions) (*runtime.Poller[armresources.ResourceGroupsClientDeleteResponse], error) {
+	if f.BeginDeleteFunc == nil {
+		panic("fakeResourceGroupsClient.BeginDelete called without BeginDeleteFunc")
+	}
+	return f.BeginDeleteFunc(ctx, resourceGroupName, options)
+}
+
+var _ virtualNetworksClient = (*fakeVirtualNetworksClient)(nil)
+
+// fakeVirtualNetworksClient is a fake virtualNetworksClient. Its methods call the function
+// fields of the same name with the Func suffix, which panic when they are not
+// set.
+type fakeVirtualNetworksClient struct {
+	BeginCreateOrUpdateFunc func(ctx context.Context, resourceGroupName string, virtualNetworkName string, parameters armnetwork.VirtualNetwork, options *armnetwork.VirtualNetworksClientBeginCreateOrUpdateOptions) (*runtime.Poller[armnetwork.VirtualNetworksClientCreateOrUpdateResponse], error)
+	GetFunc                 func(ctx context.Context, resourceGroupName string, virtualNetworkName string, options *armnetwork.VirtualNetworksClientGetOptions) (armnetwork.VirtualNetworksClientGetResponse, error)
+}
+
+func (f *fakeVirtualNetworksClient) BeginCreateOrUpdate(ctx context.Context, resourceGroupName string, virtualNetworkName string, parameters armnetwork.VirtualNetwork, options *armnetwork.VirtualNetworksClientBeginCreateOrUpdateOptions) (*runtime.Poller[armnetwork.VirtualNetworksClientCreateOrUpdateResponse], error) {
+	if f.BeginCreateOrUpdateFunc == nil {
+		panic("fakeVirtualNetworksClient.BeginCreateOrUpdate called without BeginCreateOrUpdateFunc")
+	}
+	return f.BeginCreateOrUpdateFunc(ctx, resourceGroupName, virtualNetworkName, parameters, options)
+}
+
+func (f *fakeVirtualNetworksClient) Get(ctx context.Context, resourceGroupName string, virtualNetworkName string, options *armnetwork.VirtualNetworksClientGetOptions) (armnetwork.VirtualNetworksClientGetResponse, error) {
+	if f.GetFunc == nil {
+		panic("fakeVirtualNetworksClient.Get called without GetFunc")
+	}
+	return f.GetFunc(ctx, resourceGroupName, virtualNetworkName, options)
+}
+
+var _ securityGroupsClient = (*fakeSecurityGroupsClient)(nil)
+
+// fakeSecurityGroupsClient is a fake securityGroupsClient. Its methods call the function
+// fields of the same name with the Func suffix, which panic when they are not
+// set.
+type fakeSecurityGroupsClient struct {
+	BeginCreateOrUpdateFunc func(ctx context.Context, resourceGroupName string, networkSecurityGroupName string, parameters armnetwork.SecurityGroup, options *armnetwork.SecurityGroupsClientBeginCreateOrUpdateOptions) (*runtime.Poller[armnetwork.SecurityGroupsClientCreateOrUpdateResponse], error)
+	GetFunc                 func(ctx context.Context, resourceGroupName string, networkSecurityGroupName string, options *armnetwork.SecurityGroupsClientGetOptions) (armnetwork.SecurityGroupsClientGetResponse, error)
+}
+
+func (f *fakeSecurityGroupsClient) BeginCreateOrUpdate(ctx context.Context, resourceGroupName string, networkSecurityGroupName string, parameters armnetwork.SecurityGroup, options *armnetwork.SecurityGroupsClientBeginCreateOrUpdateOptions) (*runtime.Poller[armnetwork.SecurityGroupsClientCreateOrUpdateResponse], error) {
+	if f.BeginCreateOrUpdateFunc == nil {
+		panic("fakeSecurityGroupsClient.BeginCreateOrUpdate called without BeginCreateOrUpdateFunc")
+	}
+	return f.BeginCreateOrUpdateFunc(ctx, resourceGroupName, networkSecurityGroupName, parameters, options)
+}
+
+func (f *fakeSecurityGroupsClient) Get(ctx context.Context, resourceGroupName string, networkSecurityGroupName string, options *armnetwork.SecurityGroupsClientGetOptions) (armnetwork.SecurityGroupsClientGetResponse, error) {
+	if f.GetFunc == nil {
+		panic("fakeSecurityGroupsClient.Get called without GetFunc")
+	}
+	return f.GetFunc(ctx, resourceGroupName, networkSecurityGroupName, options)
+}
+
+var _ publicIPAddressesClient = (*fakePublicIPAddressesClient)(nil)
+
+// fakePublicIPAddressesClient is a fake publicIPAddressesClient. Its methods call the function
+// fields of the same name with the Func suffix, which panic when they are not
+// set.
+type fakePublicIPAddressesClient struct {
+	BeginCreateOrUpdateFunc func(ctx context.Context, resourceGroupName string, publicIPAddressName string, parameters armnetwork.PublicIPAddress, options *armnetwork.PublicIPAddressesClientBeginCreateOrUpdateOptions) (*runtime.Poller[armnetwork.PublicIPAddressesClientCreateOrUpdateResponse], error)
+	GetFunc                 func(ctx context.Context, resourceGroupName string, publicIPAddressName string, options *armnetwork.PublicIPAddressesClientGetOptions) (armnetwork.PublicIPAddressesClientGetResponse, error)
+}
+
+func (f *fakePublicIPAddressesClient) BeginCreateOrUpdate(ctx context.Context, resourceGroupName string, publicIPAddressName string, parameters armnetwork.PublicIPAddress, options *armnetwork.PublicIPAddressesClientBeginCreateOrUpdateOptions) (*runtime.Poller[armnetwork.PublicIPAddressesClientCreateOrUpdateResponse], error) {
+	if f.BeginCreateOrUpdateFunc == nil {
+		panic("fakePublicIPAddressesClient.BeginCreateOrUpdate called without BeginCreateOrUpdateFunc")
+	}
+	return f.BeginCreateOrUpdateFunc(ctx, resourceGroupName, publicIPAddressName, parameters, options)
+}
+
+func (f *fakePublicIPAddressesClient) Get(ctx context.Context, resourceGroupName string, publicIPAddressName string, options *armnetwork.PublicIPAddressesClientGetOptions) (armnetwork.PublicIPAddressesClientGetResponse, error) {
+	if f.GetFunc == nil {
+		panic("fakePublicIPAddressesClient.Get called without GetFunc")
+	}
+	return f.GetFunc(ctx, resourceGroupName, publicIPAddressName, options)
+}
+
+var _ subnetsClient = (*fakeSubnetsClient)(nil)
+
+// fakeSubnetsClient is a fake subnetsClient. Its methods call the function
+// fields of the same name with the Func suffix, which panic when they are not
+// set.
+type fakeSubnetsClient struct {
+	GetFunc func(ctx context.Context, resourceGroupName string, virtualNetworkName string, subnetName string, options *armnetwork.SubnetsClientGetOptions) (armnetwork.SubnetsClientGetResponse, error)
+}
+
+func (f *fakeSubnetsClient) Get(ctx context.Context, resourceGroupName string, virtualNetworkName string, subnetName string, options *armnetwork.SubnetsClientGetOptions) (armnetwork.SubnetsClientGetResponse, error) {
+	if f.GetFunc == nil {
+		panic("fakeSubnetsClient.Get called without GetFunc")
+	}
+	return f.GetFunc(ctx, resourceGroupName, virtualNetworkName, subnetName, options)
+}
+
+var _ interfacesClient = (*fakeInterfacesClient)(nil)
+
+// fakeInterfacesClient is a fake interfacesClient. Its methods call the function
+// fields of the same name with the Func suffix, which panic when they are not
+// set.
+type fakeInterfacesClient struct {
+	BeginCreateOrUpdateFunc func(ctx context.Context, resourceGroupName string, networkInterfaceName string, parameters armnetwork.Interface, options *armnetwork.InterfacesClientBeginCreateOrUpdateOptions) (*runtime.Poller[armnetwork.InterfacesClientCreateOrUpdateResponse], error)
+	GetFunc                 func(ctx context.Context, resourceGroupName string, networkInterfaceName string, options *armnetwork.InterfacesClientGetOptions) (armnetwork.InterfacesClientGetResponse, error)
+}
+
+func (f *fakeInterfacesClient) BeginCreateOrUpdate(ctx context.Context, resourceGroupName string, networkInterfaceName string, parameters armnetwork.Interface, options *armnetwork.InterfacesClientBeginCreateOrUpdateOptions) (*runtime.Poller[armnetwork.InterfacesClientCreateOrUpdateResponse], error) {
+	if f.BeginCreateOrUpdateFunc == nil {
+		panic("fakeInterfacesClient.BeginCreateOrUpdate called without BeginCreateOrUpdateFunc")
+	}
+	return f.BeginCreateOrUpdateFunc(ctx, resourceGroupName, networkInterfaceName, parameters, options)
+}
+
+func (f *fakeInterfacesClient) Get(ctx context.Context, resourceGroupName string, networkInterfaceName string, options *armnetwork.InterfacesClientGetOptions) (armnetwork.InterfacesClientGetResponse, error) {
+	if f.GetFunc == nil {
+		panic("fakeInterfacesClient.Get called without GetFunc")
+	}
+	return f.GetFunc(ctx, resourceGroupName, networkInterfaceName, options)
+}
+
+var _ accountsClient = (*fakeAccountsClient)(nil)
+
+// fakeAccountsClient is a fake accountsClient. Its methods call the function
+// fields of the same name with the Func suffix, which panic when they are not
+// set.
+type fakeAccountsClient struct {
+	BeginCreateFunc   func(ctx context.Context, resourceGroupName string, accountName string, parameters armstorage.AccountCreateParameters, options *armstorage.AccountsClientBeginCreateOptions) (*runtime.Poller[armstorage.AccountsClientCreateResponse], error)
+	GetPropertiesFunc func(ctx context.Context, resourceGroupName string, accountName string, options *armstorage.AccountsClientGetPropertiesOptions) (armstorage.AccountsClientGetPropertiesResponse, error)
+}
+
+func (f *fakeAccountsClient) BeginCreate(ctx context.Context, resourceGroupName string, accountName string, parameters armstorage.AccountCreateParameters, options *armstorage.AccountsClientBeginCreateOptions) (*runtime.Poller[armstorage.AccountsClientCreateResponse], error) {
+	if f.BeginCreateFunc == nil {
+		panic("fakeAccountsClient.BeginCreate called without BeginCreateFunc")
+	}
+	return f.BeginCreateFunc(ctx, resourceGroupName, accountName, parameters, options)
+}
+
+func (f *fakeAccountsClient) GetProperties(ctx context.Context, resourceGroupName string, accountName string, options *armstorage.AccountsClientGetPropertiesOptions) (armstorage.AccountsClientGetPropertiesResponse, error) {
+	if f.GetPropertiesFunc == nil {
+		panic("fakeAccountsClient.GetProperties called without GetPropertiesFunc")
+	}
+	return f.GetPropertiesFunc(ctx, resourceGroupName, accountName, options)
+}
+
+var _ virtualMachinesClient = (*fakeVirtualMachinesClient)(nil)
+
+// fakeVirtualMachinesClient is a fake virtualMachinesClient. Its methods call the function
+// fields of the same name with the Func suffix, which panic when they are not
+// set.
+type fakeVirtualMachinesClient struct {
+	BeginCreateOrUpdateFunc func(ctx context.Context, resourceGroupName string, vmName string, parameters armcompute.VirtualMachine, options *armcompute.VirtualMachinesClientBeginCreateOrUpdateOptions) (*runtime.Poller[armcompute.VirtualMachinesClientCreateOrUpdateResponse], error)
+	GetFunc                 func(ctx context.Context, resourceGroupName string, vmName string, options *armcompute.VirtualMachinesClientGetOptions) (armcompute.VirtualMachinesClientGetResponse, error)
+	NewListPagerFunc        func(resourceGroupName string, options *armcompute.VirtualMachinesClientListOptions) *runtime.Pager[armcompute.VirtualMachinesClientListResponse]
+	BeginDeleteFunc         func(ctx context.Context, resourceGroupName string, vmName string, options *armcompute.VirtualMachinesClientBeginDeleteOptions) (*runtime.Poller[armcompute.VirtualMachinesClientDeleteResponse], error)
+}
+
+func (f *fakeVirtualMachinesClient) BeginCreateOrUpdate(ctx context.Context, resourceGroupName string, vmName string, parameters armcompute.VirtualMachine, options *armcompute.VirtualMachinesClientBeginCreateOrUpdateOptions) (*runtime.Poller[armcompute.VirtualMachinesClientCreateOrUpdateResponse], error) {
+	if f.BeginCreateOrUpdateFunc == nil {
+		panic("fakeVirtualMachinesClient.BeginCreateOrUpdate called without BeginCreateOrUpdateFunc")
+	}
+	return f.BeginCreateOrUpdateFunc(ctx, resourceGroupName, vmName, parameters, options)
+}
+
+func (f *fakeVirtualMachinesClient) Get(ctx context.Context, resourceGroupName string, vmName string, options *armcompute.VirtualMachinesClientGetOptions) (armcompute.VirtualMachinesClientGetResponse, error) {
+	if f.GetFunc == nil {
+		panic("fakeVirtualMachinesClient.Get called without GetFunc")
+	}
+	return f.GetFunc(ctx, resourceGroupName, vmName, options)
+}
+
+func (f *fakeVirtualMachinesClient) NewListPager(resourceGroupName string, options *armcompute.VirtualMachinesClientListOptions) *runtime.Pager[armcompute.VirtualMachinesClientListResponse] {
+	if f.NewListPagerFunc == nil {
+		panic("fakeVirtualMachinesClient.NewListPager called without NewListPagerFunc")
+	}
+	return f.NewListPagerFunc(resourceGroupName, options)
+}
+
+func (f *fakeVirtualMachinesClient) BeginDelete(ctx context.Context, resourceGroupName string, vmName string, options *armcompute.VirtualMachinesClientBeginDeleteOptions) (*runtime.Poller[armcompute.VirtualMachinesClientDeleteResponse], error) {
+	if f.BeginDeleteFunc == nil {
+		panic("fakeVirtualMachinesClient.BeginDelete called without BeginDeleteFunc")
+	}
+	return f.BeginDeleteFunc(ctx, resourceGroupName, vmName, options)
+}
+
+var _ disksClient = (*fakeDisksClient)(nil)
+
+// fakeDisksClient is a fake disksClient. Its methods call the function
+// fields of the same name with the Func suffix, which panic when they are not
+// set.
+type fakeDisksClient struct {
+	BeginCreateOrUpdateFunc func(ctx context.Context, resourceGroupName string, diskName string, disk armcompute.Disk, options *armcompute.DisksClientBeginCreateOrUpdateOptions) (*runtime.Poller[armcompute.DisksClientCreateOrUpdateResponse], error)
+	GetFunc                 func(ctx context.Context, resourceGroupName string, diskName string, options *armcompute.DisksClientGetOptions) (armcompute.DisksClientGetResponse, error)
+}
+
+func (f *fakeDisksClient) BeginCreateOrUpdate(ctx context.Context, resourceGroupName string, diskName string, disk armcompute.Disk, options *armcompute.DisksClientBeginCreateOrUpdateOptions) (*runtime.Poller[armcompute.DisksClientCreateOrUpdateResponse], error) {
+	if f.BeginCreateOrUpdateFunc == nil {
+		panic("fakeDisksClient.BeginCreateOrUpdate called without BeginCreateOrUpdateFunc")
+	}
+	return f.BeginCreateOrUpdateFunc(ctx, resourceGroupName, diskName, disk, options)
+}
+
+func (f *fakeDisksClient) Get(ctx context.Context, resourceGroupName string, diskName string, options *armcompute.DisksClientGetOptions) (armcompute.DisksClientGetResponse, error) {
+	if f.GetFunc == nil {
+		panic("fakeDisksClient.Get called without GetFunc")
+	}
+	return f.GetFunc(ctx, resourceGroupName, diskName, options)
+}
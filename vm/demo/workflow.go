@@ -0,0 +1,596 @@
+package demo
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/compute/armcompute"
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/network/armnetwork"
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/resources/armresources"
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/storage/armstorage"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/converge"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/lro"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/naming"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/output"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/report"
+)
+
+//go:generate go run github.com/Azure-Samples/Hybrid-Golang-Samples/common/fakes/fakegen -type resourceGroupsClient,virtualNetworksClient,securityGroupsClient,publicIPAddressesClient,subnetsClient,interfacesClient,accountsClient,virtualMachinesClient,disksClient -out fakes_test.go
+
+// resourceGroupsClient is the part of *armresources.ResourceGroupsClient the
+// sample uses.
+type resourceGroupsClient interface {
+	CreateOrUpdate(ctx context.Context, resourceGroupName string, parameters armresources.ResourceGroup, options *armresources.ResourceGroupsClientCreateOrUpdateOptions) (armresources.ResourceGroupsClientCreateOrUpdateResponse, error)
+	Get(ctx context.Context, resourceGroupName string, options *armresources.ResourceGroupsClientGetOptions) (armresources.ResourceGroupsClientGetResponse, error)
+	BeginDelete(ctx context.Context, resourceGroupName string, options *armresources.ResourceGroupsClientBeginDeleteOptions) (*runtime.Poller[armresources.ResourceGroupsClientDeleteResponse], error)
+}
+
+// virtualNetworksClient is the part of *armnetwork.VirtualNetworksClient the
+// sample uses.
+type virtualNetworksClient interface {
+	BeginCreateOrUpdate(ctx context.Context, resourceGroupName string, virtualNetworkName string, parameters armnetwork.VirtualNetwork, options *armnetwork.VirtualNetworksClientBeginCreateOrUpdateOptions) (*runtime.Poller[armnetwork.VirtualNetworksClientCreateOrUpdateResponse], error)
+	Get(ctx context.Context, resourceGroupName string, virtualNetworkName string, options *armnetwork.VirtualNetworksClientGetOptions) (armnetwork.VirtualNetworksClientGetResponse, error)
+}
+
+// securityGroupsClient is the part of *armnetwork.SecurityGroupsClient the
+// sample uses.
+type securityGroupsClient interface {
+	BeginCreateOrUpdate(ctx context.Context, resourceGroupName string, networkSecurityGroupName string, parameters armnetwork.SecurityGroup, options *armnetwork.SecurityGroupsClientBeginCreateOrUpdateOptions) (*runtime.Poller[armnetwork.SecurityGroupsClientCreateOrUpdateResponse], error)
+	Get(ctx context.Context, resourceGroupName string, networkSecurityGroupName string, options *armnetwork.SecurityGroupsClientGetOptions) (armnetwork.SecurityGroupsClientGetResponse, error)
+}
+
+// publicIPAddressesClient is the part of *armnetwork.PublicIPAddressesClient
+// the sample uses.
+type publicIPAddressesClient interface {
+	BeginCreateOrUpdate(ctx context.Context, resourceGroupName string, publicIPAddressName string, parameters armnetwork.PublicIPAddress, options *armnetwork.PublicIPAddressesClientBeginCreateOrUpdateOptions) (*runtime.Poller[armnetwork.PublicIPAddressesClientCreateOrUpdateResponse], error)
+	Get(ctx context.Context, resourceGroupName string, publicIPAddressName string, options *armnetwork.PublicIPAddressesClientGetOptions) (armnetwork.PublicIPAddressesClientGetResponse, error)
+}
+
+// subnetsClient is the part of *armnetwork.SubnetsClient the sample uses.
+type subnetsClient interface {
+	Get(ctx context.Context, resourceGroupName string, virtualNetworkName string, subnetName string, options *armnetwork.SubnetsClientGetOptions) (armnetwork.SubnetsClientGetResponse, error)
+}
+
+// interfacesClient is the part of *armnetwork.InterfacesClient the sample
+// uses.
+type interfacesClient interface {
+	BeginCreateOrUpdate(ctx context.Context, resourceGroupName string, networkInterfaceName string, parameters armnetwork.Interface, options *armnetwork.InterfacesClientBeginCreateOrUpdateOptions) (*runtime.Poller[armnetwork.InterfacesClientCreateOrUpdateResponse], error)
+	Get(ctx context.Context, resourceGroupName string, networkInterfaceName string, options *armnetwork.InterfacesClientGetOptions) (armnetwork.InterfacesClientGetResponse, error)
+}
+
+// accountsClient is the part of *armstorage.AccountsClient the sample uses.
+type accountsClient interface {
+	BeginCreate(ctx context.Context, resourceGroupName string, accountName string, parameters armstorage.AccountCreateParameters, options *armstorage.AccountsClientBeginCreateOptions) (*runtime.Poller[armstorage.AccountsClientCreateResponse], error)
+	GetProperties(ctx context.Context, resourceGroupName string, accountName string, options *armstorage.AccountsClientGetPropertiesOptions) (armstorage.AccountsClientGetPropertiesResponse, error)
+}
+
+// virtualMachinesClient is the part of *armcompute.VirtualMachinesClient the
+// sample uses.
+type virtualMachinesClient interface {
+	BeginCreateOrUpdate(ctx context.Context, resourceGroupName string, vmName string, parameters armcompute.VirtualMachine, options *armcompute.VirtualMachinesClientBeginCreateOrUpdateOptions) (*runtime.Poller[armcompute.VirtualMachinesClientCreateOrUpdateResponse], error)
+	Get(ctx context.Context, resourceGroupName string, vmName string, options *armcompute.VirtualMachinesClientGetOptions) (armcompute.VirtualMachinesClientGetResponse, error)
+	NewListPager(resourceGroupName string, options *armcompute.VirtualMachinesClientListOptions) *runtime.Pager[armcompute.VirtualMachinesClientListResponse]
+	BeginDelete(ctx context.Context, resourceGroupName string, vmName string, options *armcompute.VirtualMachinesClientBeginDeleteOptions) (*runtime.Poller[armcompute.VirtualMachinesClientDeleteResponse], error)
+}
+
+// disksClient is the part of *armcompute.DisksClient the sample uses.
+type disksClient interface {
+	BeginCreateOrUpdate(ctx context.Context, resourceGroupName string, diskName string, disk armcompute.Disk, options *armcompute.DisksClientBeginCreateOrUpdateOptions) (*runtime.Poller[armcompute.DisksClientCreateOrUpdateResponse], error)
+	Get(ctx context.Context, resourceGroupName string, diskName string, options *armcompute.DisksClientGetOptions) (armcompute.DisksClientGetResponse, error)
+}
+
+// sample runs the steps of the sample with the clients it is given and
+// reports its progress to out, its listings to lists and its steps to
+// steps, which may be nil. The resources are named by names, which may be nil
+// as well to keep the names of the sample. storageSuffix is the storage
+// endpoint suffix of the stamp, used for the URI of the unmanaged OS disk.
+// converge decides what to do about the resources that exist already and may
+// be nil too.
+type sample struct {
+	groups   resourceGroupsClient
+	vnets    virtualNetworksClient
+	nsgs     securityGroupsClient
+	ips      publicIPAddressesClient
+	subnets  subnetsClient
+	nics     interfacesClient
+	accounts accountsClient
+	vms      virtualMachinesClient
+	disks    disksClient
+	names    *naming.Namer
+	converge *converge.Checker
+	waiter   *lro.Waiter
+	out      io.Writer
+	lists    *output.Printer
+	steps    *report.Recorder
+	location string
+	// tags are the tags of every resource the sample creates.
+	tags          map[string]*string
+	storageSuffix string
+}
+
+// run creates the network of the virtual machines and a storage account,
+// then creates and deletes a virtual machine with an unmanaged disk and
+// creates one with a managed data disk. With clean it deletes the resource
+// group as well. Every step is recorded in s.steps.
+func (s *sample) run(ctx context.Context, resourceGroupName string, clean bool) error {
+	names, err := s.resourceNames()
+	if err != nil {
+		return err
+	}
+	err = s.steps.Step("create resource group "+resourceGroupName, func() error {
+		return s.createResourceGroup(ctx, resourceGroupName)
+	})
+	if err != nil {
+		return err
+	}
+
+	var nic armnetwork.Interface
+	err = s.steps.StepWith("create network", &nic, func() (err error) {
+		nic, err = s.createNetwork(ctx, resourceGroupName, names)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// Create storage acc
+	var storageAccountBase = "govmteststorageacc"
+	var storageAccountName string
+	err = s.steps.StepWith("create storage account "+storageAccountBase, &storageAccountName, func() (err error) {
+		storageAccountName, err = s.names.Retry(lro.StorageAccount, storageAccountBase, func(name string) error {
+			return s.createStorageAccount(ctx, resourceGroupName, name)
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// Create Virtual Machine
+	var vmName = names.vm
+
+	// Create Profiles
+	hardwareProfile := &armcompute.HardwareProfile{
+		VMSize: to.Ptr(armcompute.VirtualMachineSizeTypesStandardA1),
+	}
+
+	vhdURItemplate := "https://%s.blob." + s.storageSuffix + "/vhds/%s.vhd"
+	storageProfile := &armcompute.StorageProfile{
+		ImageReference: imageReference(),
+		OSDisk: &armcompute.OSDisk{
+			Name: to.Ptr("osDisk"),
+			Vhd: &armcompute.VirtualHardDisk{
+				URI: to.Ptr(fmt.Sprintf(vhdURItemplate, storageAccountName, vmName)),
+			},
+			CreateOption: to.Ptr(armcompute.DiskCreateOptionTypesFromImage),
+		},
+	}
+	osProfile := &armcompute.OSProfile{
+		ComputerName:  to.Ptr(vmName),
+		AdminUsername: to.Ptr("username"),
+		AdminPassword: to.Ptr("Password!23"),
+	}
+
+	networkProfile := &armcompute.NetworkProfile{
+		NetworkInterfaces: []*armcompute.NetworkInterfaceReference{
+			{
+				ID: nic.ID,
+				Properties: &armcompute.NetworkInterfaceReferenceProperties{
+					Primary: to.Ptr(true),
+				},
+			},
+		},
+	}
+
+	err = s.steps.Step("create virtual machine "+vmName, func() error {
+		fmt.Fprintln(s.out, "Creating Virtual Machine")
+		return s.createVM(ctx, resourceGroupName, vmName, &armcompute.VirtualMachineProperties{
+			HardwareProfile: hardwareProfile,
+			OSProfile:       osProfile,
+			NetworkProfile:  networkProfile,
+			StorageProfile:  storageProfile,
+		})
+	})
+	if err != nil {
+		return err
+	}
+	err = s.steps.Step("list virtual machines with "+vmName, func() error {
+		return s.printVMs(ctx, resourceGroupName)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = s.steps.Step("delete virtual machine "+vmName, func() error {
+		return s.deleteVM(ctx, resourceGroupName, vmName)
+	})
+	if err != nil {
+		return err
+	}
+
+	//Managed disk vm
+	var diskName = names.disk
+	var vmNameMD = names.vmMD
+	var disk armcompute.Disk
+	err = s.steps.StepWith("create disk "+diskName, &disk, func() (err error) {
+		disk, err = s.createDisk(ctx, resourceGroupName, diskName)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	storageProfileManagedDisk := &armcompute.StorageProfile{
+		ImageReference: imageReference(),
+		DataDisks: []*armcompute.DataDisk{
+			{
+				CreateOption: to.Ptr(armcompute.DiskCreateOptionTypesAttach),
+				ManagedDisk: &armcompute.ManagedDiskParameters{
+					StorageAccountType: to.Ptr(armcompute.StorageAccountTypesStandardLRS),
+					ID:                 disk.ID,
+				},
+				Caching:    to.Ptr(armcompute.CachingTypesReadOnly),
+				DiskSizeGB: to.Ptr(int32(1)),
+				Lun:        to.Ptr(int32(1)),
+				Name:       to.Ptr(diskName),
+			},
+		},
+		OSDisk: &armcompute.OSDisk{
+			Name:         to.Ptr("osDiskMD"),
+			CreateOption: to.Ptr(armcompute.DiskCreateOptionTypesFromImage),
+		},
+	}
+	err = s.steps.Step("create virtual machine "+vmNameMD, func() error {
+		fmt.Fprintln(s.out, "Creating Managed Disk VM")
+		return s.createVM(ctx, resourceGroupName, vmNameMD, &armcompute.VirtualMachineProperties{
+			HardwareProfile: hardwareProfile,
+			OSProfile:       osProfile,
+			NetworkProfile:  networkProfile,
+			StorageProfile:  storageProfileManagedDisk,
+		})
+	})
+	if err != nil {
+		return err
+	}
+	err = s.steps.Step("list virtual machines with "+vmNameMD, func() error {
+		return s.printVMs(ctx, resourceGroupName)
+	})
+	if err != nil || !clean {
+		return err
+	}
+
+	return s.steps.Step("delete resource group "+resourceGroupName, func() error {
+		fmt.Fprintln(s.out, "Deleting resource group")
+		poller, err := s.groups.BeginDelete(ctx, resourceGroupName, nil)
+		if err != nil {
+			return fmt.Errorf("failed to delete resource group %s: %w", resourceGroupName, err)
+		}
+		_, err = lro.Wait(ctx, s.waiter, lro.Delete(lro.ResourceGroup, resourceGroupName), poller)
+		return err
+	})
+}
+
+func (s *sample) createResourceGroup(ctx context.Context, name string) error {
+	fmt.Fprintln(s.out, "Creating resource group")
+	param := armresources.ResourceGroup{
+		Location: to.Ptr(s.location),
+		Tags:     s.tags,
+	}
+	_, err := converge.Ensure(s.converge, lro.ResourceGroup.Label()+" "+name, func() (armresources.ResourceGroup, error) {
+		resp, err := s.groups.Get(ctx, name, nil)
+		return resp.ResourceGroup, err
+	}, param, func() (armresources.ResourceGroup, error) {
+		resp, err := s.groups.CreateOrUpdate(ctx, name, param, nil)
+		if err != nil {
+			return resp.ResourceGroup, fmt.Errorf("failed to create resource group %s: %w", name, err)
+		}
+		return resp.ResourceGroup, nil
+	}, "location")
+	return err
+}
+
+// createStorageAccount creates the storage account holding the unmanaged
+// disk of the first virtual machine, or adopts the one of an earlier run.
+func (s *sample) createStorageAccount(ctx context.Context, resourceGroupName, name string) error {
+	param := armstorage.AccountCreateParameters{
+		SKU:        &armstorage.SKU{Name: to.Ptr(armstorage.SKUNameStandardLRS)},
+		Location:   to.Ptr(s.location),
+		Tags:       s.tags,
+		Properties: &armstorage.AccountPropertiesCreateParameters{},
+	}
+	_, err := converge.Ensure(s.converge, lro.StorageAccount.Label()+" "+name, func() (armstorage.Account, error) {
+		resp, err := s.accounts.GetProperties(ctx, resourceGroupName, name, nil)
+		return resp.Account, err
+	}, param, func() (armstorage.Account, error) {
+		poller, err := s.accounts.BeginCreate(ctx, resourceGroupName, name, param, nil)
+		if err != nil {
+			return armstorage.Account{}, fmt.Errorf("failed to create storage account %s: %w", name, err)
+		}
+		result, err := lro.Wait(ctx, s.waiter, lro.Create(lro.StorageAccount, name), poller)
+		return result.Account, err
+	}, "location")
+	return err
+}
+
+func (s *sample) deleteVM(ctx context.Context, resourceGroupName, vmName string) error {
+	fmt.Fprintln(s.out, "Deleting VM")
+	poller, err := s.vms.BeginDelete(ctx, resourceGroupName, vmName, nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete virtual machine %s: %w", vmName, err)
+	}
+	_, err = lro.Wait(ctx, s.waiter, lro.Delete(lro.VirtualMachine, vmName), poller)
+	return err
+}
+
+// createDisk creates an empty managed disk of 1 GB, or adopts or updates the
+// one an earlier run left behind, and returns it.
+func (s *sample) createDisk(ctx context.Context, resourceGroupName, diskName string) (armcompute.Disk, error) {
+	fmt.Fprintln(s.out, "Creating Disk")
+	param := armcompute.Disk{
+		Location: to.Ptr(s.location),
+		Tags:     s.tags,
+		Properties: &armcompute.DiskProperties{
+			CreationData: &armcompute.CreationData{
+				CreateOption: to.Ptr(armcompute.DiskCreateOptionEmpty),
+			},
+			DiskSizeGB: to.Ptr(int32(1)),
+		},
+	}
+	return converge.Ensure(s.converge, lro.Disk.Label()+" "+diskName, func() (armcompute.Disk, error) {
+		resp, err := s.disks.Get(ctx, resourceGroupName, diskName, nil)
+		return resp.Disk, err
+	}, param, func() (armcompute.Disk, error) {
+		poller, err := s.disks.BeginCreateOrUpdate(ctx, resourceGroupName, diskName, param, nil)
+		if err != nil {
+			return armcompute.Disk{}, fmt.Errorf("failed to create disk %s: %w", diskName, err)
+		}
+		result, err := lro.Wait(ctx, s.waiter, lro.Create(lro.Disk, diskName), poller)
+		return result.Disk, err
+	}, "location", "properties.creationData")
+}
+
+// resourceNames are the names of the resources of a run.
+type resourceNames struct {
+	vnet, nsg, ip, nic, vm, vmMD, disk string
+}
+
+// resourceNames names the resources of the run with s.names.
+func (s *sample) resourceNames() (resourceNames, error) {
+	var n resourceNames
+	for _, r := range []struct {
+		kind lro.Kind
+		base string
+		name *string
+	}{
+		{lro.VirtualNetwork, "TestGoVnetName", &n.vnet},
+		{lro.SecurityGroup, "TestGoNsgName", &n.nsg},
+		{lro.PublicIPAddress, "TestGoIpAddr", &n.ip},
+		{lro.NetworkInterface, "testGoNetworkInterface", &n.nic},
+		{lro.VirtualMachine, "TestGoVm1", &n.vm},
+		{lro.VirtualMachine, "TestGoManagedDiskVm", &n.vmMD},
+		{lro.Disk, "osDisk2", &n.disk},
+	} {
+		name, err := s.names.Name(r.kind, r.base)
+		if err != nil {
+			return n, err
+		}
+		*r.name = name
+	}
+	return n, nil
+}
+
+// createNetwork creates a virtual network with a subnet, a network security
+// group allowing SSH and HTTPS, a public IP address and the network interface
+// of the virtual machines, and returns the network interface. The resources an
+// earlier run left behind are adopted or updated.
+func (s *sample) createNetwork(ctx context.Context, resourceGroupName string, names resourceNames) (armnetwork.Interface, error) {
+	//Create Vnet
+	fmt.Fprintln(s.out, "Creating Vnet and subnets")
+	var vnetName = names.vnet
+	var subnetName = "TestGoSubnetName"
+	vnet := armnetwork.VirtualNetwork{
+		Location: to.Ptr(s.location),
+		Tags:     s.tags,
+		Properties: &armnetwork.VirtualNetworkPropertiesFormat{
+			AddressSpace: &armnetwork.AddressSpace{
+				AddressPrefixes: []*string{to.Ptr("10.0.0.0/8")},
+			},
+			Subnets: []*armnetwork.Subnet{
+				to.Ptr(armnetwork.Subnet{
+					Name: to.Ptr(subnetName),
+					Properties: &armnetwork.SubnetPropertiesFormat{
+						AddressPrefix: to.Ptr("10.0.0.0/16"),
+					},
+				}),
+			},
+		},
+	}
+	_, err := converge.Ensure(s.converge, lro.VirtualNetwork.Label()+" "+vnetName, func() (armnetwork.VirtualNetwork, error) {
+		resp, err := s.vnets.Get(ctx, resourceGroupName, vnetName, nil)
+		return resp.VirtualNetwork, err
+	}, vnet, func() (armnetwork.VirtualNetwork, error) {
+		poller, err := s.vnets.BeginCreateOrUpdate(ctx, resourceGroupName, vnetName, vnet, nil)
+		if err != nil {
+			return armnetwork.VirtualNetwork{}, fmt.Errorf("failed to create virtual network %s: %w", vnetName, err)
+		}
+		result, err := lro.Wait(ctx, s.waiter, lro.Create(lro.VirtualNetwork, vnetName), poller)
+		return result.VirtualNetwork, err
+	}, "location")
+	if err != nil {
+		return armnetwork.Interface{}, err
+	}
+
+	//Create NSG
+	nsgName := names.nsg
+	nsgParam := armnetwork.SecurityGroup{
+		Location: to.Ptr(s.location),
+		Tags:     s.tags,
+		Properties: &armnetwork.SecurityGroupPropertiesFormat{
+			SecurityRules: []*armnetwork.SecurityRule{
+				allowInbound("allow_ssh", "22", 100),
+				allowInbound("allow_https", "443", 200),
+			},
+		},
+	}
+	nsg, err := converge.Ensure(s.converge, lro.SecurityGroup.Label()+" "+nsgName, func() (armnetwork.SecurityGroup, error) {
+		resp, err := s.nsgs.Get(ctx, resourceGroupName, nsgName, nil)
+		return resp.SecurityGroup, err
+	}, nsgParam, func() (armnetwork.SecurityGroup, error) {
+		poller, err := s.nsgs.BeginCreateOrUpdate(ctx, resourceGroupName, nsgName, nsgParam, nil)
+		if err != nil {
+			return armnetwork.SecurityGroup{}, fmt.Errorf("failed to create network security group %s: %w", nsgName, err)
+		}
+		result, err := lro.Wait(ctx, s.waiter, lro.Create(lro.SecurityGroup, nsgName), poller)
+		return result.SecurityGroup, err
+	}, "location")
+	if err != nil {
+		return armnetwork.Interface{}, err
+	}
+
+	// Create public ip
+	fmt.Fprintln(s.out, "Creating public ip")
+	var publicIpName = names.ip
+	ipParam := armnetwork.PublicIPAddress{
+		Name:     to.Ptr(publicIpName),
+		Location: to.Ptr(s.location),
+		Tags:     s.tags,
+		Properties: &armnetwork.PublicIPAddressPropertiesFormat{
+			PublicIPAllocationMethod: to.Ptr(armnetwork.IPAllocationMethodStatic),
+		},
+	}
+	pubIp, err := converge.Ensure(s.converge, lro.PublicIPAddress.Label()+" "+publicIpName, func() (armnetwork.PublicIPAddress, error) {
+		resp, err := s.ips.Get(ctx, resourceGroupName, publicIpName, nil)
+		return resp.PublicIPAddress, err
+	}, ipParam, func() (armnetwork.PublicIPAddress, error) {
+		poller, err := s.ips.BeginCreateOrUpdate(ctx, resourceGroupName, publicIpName, ipParam, nil)
+		if err != nil {
+			return armnetwork.PublicIPAddress{}, fmt.Errorf("failed to create public IP address %s: %w", publicIpName, err)
+		}
+		result, err := lro.Wait(ctx, s.waiter, lro.Create(lro.PublicIPAddress, publicIpName), poller)
+		return result.PublicIPAddress, err
+	}, "location")
+	if err != nil {
+		return armnetwork.Interface{}, err
+	}
+
+	//Get subnet
+	subresp, err := s.subnets.Get(ctx, resourceGroupName, vnetName, subnetName, nil)
+	if err != nil {
+		return armnetwork.Interface{}, fmt.Errorf("failed to get subnet %s: %w", subnetName, err)
+	}
+
+	//Create a network interface
+	fmt.Fprintln(s.out, "Creating Network Interface")
+	var nicname = names.nic
+	nicParam := armnetwork.Interface{
+		Name:     to.Ptr(nicname),
+		Location: to.Ptr(s.location),
+		Tags:     s.tags,
+		Properties: &armnetwork.InterfacePropertiesFormat{
+			NetworkSecurityGroup: &armnetwork.SecurityGroup{ID: nsg.ID},
+			IPConfigurations: []*armnetwork.InterfaceIPConfiguration{
+				{
+					Name: to.Ptr("ipConfig1"),
+					Properties: &armnetwork.InterfaceIPConfigurationPropertiesFormat{
+						Subnet:                    &armnetwork.Subnet{ID: subresp.ID},
+						PrivateIPAllocationMethod: to.Ptr(armnetwork.IPAllocationMethodDynamic),
+						PublicIPAddress:           &armnetwork.PublicIPAddress{ID: pubIp.ID},
+					},
+				},
+			},
+		},
+	}
+	return converge.Ensure(s.converge, lro.NetworkInterface.Label()+" "+nicname, func() (armnetwork.Interface, error) {
+		resp, err := s.nics.Get(ctx, resourceGroupName, nicname, nil)
+		return resp.Interface, err
+	}, nicParam, func() (armnetwork.Interface, error) {
+		poller, err := s.nics.BeginCreateOrUpdate(ctx, resourceGroupName, nicname, nicParam, nil)
+		if err != nil {
+			return armnetwork.Interface{}, fmt.Errorf("failed to create network interface %s: %w", nicname, err)
+		}
+		result, err := lro.Wait(ctx, s.waiter, lro.Create(lro.NetworkInterface, nicname), poller)
+		return result.Interface, err
+	}, "location")
+}
+
+// allowInbound returns a security rule allowing inbound TCP traffic to port.
+func allowInbound(name, port string, priority int32) *armnetwork.SecurityRule {
+	return &armnetwork.SecurityRule{
+		Name: to.Ptr(name),
+		Properties: &armnetwork.SecurityRulePropertiesFormat{
+			Protocol:                 to.Ptr(armnetwork.SecurityRuleProtocolTCP),
+			SourceAddressPrefix:      to.Ptr("0.0.0.0/0"),
+			SourcePortRange:          to.Ptr("1-65535"),
+			DestinationAddressPrefix: to.Ptr("0.0.0.0/0"),
+			DestinationPortRange:     to.Ptr(port),
+			Access:                   to.Ptr(armnetwork.SecurityRuleAccessAllow),
+			Direction:                to.Ptr(armnetwork.SecurityRuleDirectionInbound),
+			Priority:                 to.Ptr(priority),
+		},
+	}
+}
+
+func imageReference() *armcompute.ImageReference {
+	return &armcompute.ImageReference{
+		Publisher: to.Ptr(publisher),
+		Offer:     to.Ptr(offer),
+		SKU:       to.Ptr(sku),
+		Version:   to.Ptr("latest"),
+	}
+}
+
+// createVM creates the virtual machine vmName with properties, or adopts or
+// updates the one an earlier run left behind. The disks and the operating
+// system of a virtual machine cannot change in place.
+func (s *sample) createVM(ctx context.Context, resourceGroupName, vmName string, properties *armcompute.VirtualMachineProperties) error {
+	param := armcompute.VirtualMachine{
+		Location:   to.Ptr(s.location),
+		Tags:       s.tags,
+		Properties: properties,
+	}
+	_, err := converge.Ensure(s.converge, lro.VirtualMachine.Label()+" "+vmName, func() (armcompute.VirtualMachine, error) {
+		resp, err := s.vms.Get(ctx, resourceGroupName, vmName, nil)
+		return resp.VirtualMachine, err
+	}, param, func() (armcompute.VirtualMachine, error) {
+		poller, err := s.vms.BeginCreateOrUpdate(ctx, resourceGroupName, vmName, param, nil)
+		if err != nil {
+			return armcompute.VirtualMachine{}, fmt.Errorf("failed to create virtual machine %s: %w", vmName, err)
+		}
+		result, err := lro.Wait(ctx, s.waiter, lro.Create(lro.VirtualMachine, vmName), poller)
+		return result.VirtualMachine, err
+	}, "location", "properties.storageProfile", "properties.osProfile")
+	return err
+}
+
+func (s *sample) printVMs(ctx context.Context, resourceGroupName string) error {
+	fmt.Fprintf(s.out, "Listing virtual machines in %s\n", resourceGroupName)
+	var vms []output.Resource
+	pager := s.vms.NewListPager(resourceGroupName, nil)
+	for pager.More() {
+		resp, err := pager.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get the next page of the virtual machine list: %w", err)
+		}
+		for _, vm := range resp.VirtualMachineListResult.Value {
+			machine := output.Resource{
+				Name:     output.String(vm.Name),
+				Location: output.String(vm.Location),
+				Tags:     output.Tags(vm.Tags),
+				ID:       output.String(vm.ID),
+			}
+			// A virtual machine has no SKU; its size plays that part.
+			if vm.Properties != nil {
+				machine.ProvisioningState = output.String(vm.Properties.ProvisioningState)
+				if vm.Properties.HardwareProfile != nil {
+					machine.SKU = output.String(vm.Properties.HardwareProfile.VMSize)
+				}
+			}
+			vms = append(vms, machine)
+		}
+	}
+	return s.lists.Print(vms)
+}
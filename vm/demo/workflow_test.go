@@ -0,0 +1,282 @@
+package demo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/compute/armcompute"
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/network/armnetwork"
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/resources/armresources"
+	"github.com/Azure/azure-sdk-for-go/profile/p20200901/resourcemanager/storage/armstorage"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/converge"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/fakes"
+	"github.com/Azure-Samples/Hybrid-Golang-Samples/common/output"
+)
+
+const groupURL = "https://management.local.azurestack.external/subscriptions/sub/resourceGroups/TestRG"
+
+// notFound is the error of a GET of a resource that does not exist, at path
+// below the resource group of the tests.
+func notFound(path string) error {
+	return fakes.ResponseError(http.MethodGet, groupURL+path, http.StatusNotFound, "ResourceNotFound")
+}
+
+// fakeClients are fakes on which every call succeeds unless a test replaces
+// one of their functions. The virtual machines client keeps the names of the
+// machines it created, so that listing them reflects the run.
+type fakeClients struct {
+	groups   *fakeResourceGroupsClient
+	vnets    *fakeVirtualNetworksClient
+	nsgs     *fakeSecurityGroupsClient
+	ips      *fakePublicIPAddressesClient
+	subnets  *fakeSubnetsClient
+	nics     *fakeInterfacesClient
+	accounts *fakeAccountsClient
+	vms      *fakeVirtualMachinesClient
+	disks    *fakeDisksClient
+}
+
+func newClients() fakeClients {
+	var vms []string
+	return fakeClients{
+		groups: &fakeResourceGroupsClient{
+			CreateOrUpdateFunc: func(ctx context.Context, name string, parameters armresources.ResourceGroup, options *armresources.ResourceGroupsClientCreateOrUpdateOptions) (armresources.ResourceGroupsClientCreateOrUpdateResponse, error) {
+				return armresources.ResourceGroupsClientCreateOrUpdateResponse{}, nil
+			},
+			GetFunc: func(ctx context.Context, name string, options *armresources.ResourceGroupsClientGetOptions) (armresources.ResourceGroupsClientGetResponse, error) {
+				return armresources.ResourceGroupsClientGetResponse{}, notFound("")
+			},
+			BeginDeleteFunc: func(ctx context.Context, name string, options *armresources.ResourceGroupsClientBeginDeleteOptions) (*runtime.Poller[armresources.ResourceGroupsClientDeleteResponse], error) {
+				return fakes.Poller(1, armresources.ResourceGroupsClientDeleteResponse{}, nil), nil
+			},
+		},
+		vnets: &fakeVirtualNetworksClient{
+			BeginCreateOrUpdateFunc: func(ctx context.Context, resourceGroupName string, virtualNetworkName string, parameters armnetwork.VirtualNetwork, options *armnetwork.VirtualNetworksClientBeginCreateOrUpdateOptions) (*runtime.Poller[armnetwork.VirtualNetworksClientCreateOrUpdateResponse], error) {
+				return fakes.Poller(1, armnetwork.VirtualNetworksClientCreateOrUpdateResponse{}, nil), nil
+			},
+			GetFunc: func(ctx context.Context, resourceGroupName string, virtualNetworkName string, options *armnetwork.VirtualNetworksClientGetOptions) (armnetwork.VirtualNetworksClientGetResponse, error) {
+				return armnetwork.VirtualNetworksClientGetResponse{}, notFound("/providers/Microsoft.Network/virtualNetworks/" + virtualNetworkName)
+			},
+		},
+		nsgs: &fakeSecurityGroupsClient{
+			BeginCreateOrUpdateFunc: func(ctx context.Context, resourceGroupName string, networkSecurityGroupName string, parameters armnetwork.SecurityGroup, options *armnetwork.SecurityGroupsClientBeginCreateOrUpdateOptions) (*runtime.Poller[armnetwork.SecurityGroupsClientCreateOrUpdateResponse], error) {
+				return fakes.Poller(1, armnetwork.SecurityGroupsClientCreateOrUpdateResponse{}, nil), nil
+			},
+			GetFunc: func(ctx context.Context, resourceGroupName string, networkSecurityGroupName string, options *armnetwork.SecurityGroupsClientGetOptions) (armnetwork.SecurityGroupsClientGetResponse, error) {
+				return armnetwork.SecurityGroupsClientGetResponse{}, notFound("/providers/Microsoft.Network/networkSecurityGroups/" + networkSecurityGroupName)
+			},
+		},
+		ips: &fakePublicIPAddressesClient{
+			BeginCreateOrUpdateFunc: func(ctx context.Context, resourceGroupName string, publicIPAddressName string, parameters armnetwork.PublicIPAddress, options *armnetwork.PublicIPAddressesClientBeginCreateOrUpdateOptions) (*runtime.Poller[armnetwork.PublicIPAddressesClientCreateOrUpdateResponse], error) {
+				return fakes.Poller(1, armnetwork.PublicIPAddressesClientCreateOrUpdateResponse{}, nil), nil
+			},
+			GetFunc: func(ctx context.Context, resourceGroupName string, publicIPAddressName string, options *armnetwork.PublicIPAddressesClientGetOptions) (armnetwork.PublicIPAddressesClientGetResponse, error) {
+				return armnetwork.PublicIPAddressesClientGetResponse{}, notFound("/providers/Microsoft.Network/publicIPAddresses/" + publicIPAddressName)
+			},
+		},
+		subnets: &fakeSubnetsClient{
+			GetFunc: func(ctx context.Context, resourceGroupName string, virtualNetworkName string, subnetName string, options *armnetwork.SubnetsClientGetOptions) (armnetwork.SubnetsClientGetResponse, error) {
+				return armnetwork.SubnetsClientGetResponse{}, nil
+			},
+		},
+		nics: &fakeInterfacesClient{
+			BeginCreateOrUpdateFunc: func(ctx context.Context, resourceGroupName string, networkInterfaceName string, parameters armnetwork.Interface, options *armnetwork.InterfacesClientBeginCreateOrUpdateOptions) (*runtime.Poller[armnetwork.InterfacesClientCreateOrUpdateResponse], error) {
+				var resp armnetwork.InterfacesClientCreateOrUpdateResponse
+				resp.ID = to.Ptr(groupURL + "/providers/Microsoft.Network/networkInterfaces/" + networkInterfaceName)
+				return fakes.Poller(1, resp, nil), nil
+			},
+			GetFunc: func(ctx context.Context, resourceGroupName string, networkInterfaceName string, options *armnetwork.InterfacesClientGetOptions) (armnetwork.InterfacesClientGetResponse, error) {
+				return armnetwork.InterfacesClientGetResponse{}, notFound("/providers/Microsoft.Network/networkInterfaces/" + networkInterfaceName)
+			},
+		},
+		accounts: &fakeAccountsClient{
+			BeginCreateFunc: func(ctx context.Context, resourceGroupName string, accountName string, parameters armstorage.AccountCreateParameters, options *armstorage.AccountsClientBeginCreateOptions) (*runtime.Poller[armstorage.AccountsClientCreateResponse], error) {
+				return fakes.Poller(1, armstorage.AccountsClientCreateResponse{}, nil), nil
+			},
+			GetPropertiesFunc: func(ctx context.Context, resourceGroupName string, accountName string, options *armstorage.AccountsClientGetPropertiesOptions) (armstorage.AccountsClientGetPropertiesResponse, error) {
+				return armstorage.AccountsClientGetPropertiesResponse{}, notFound("/providers/Microsoft.Storage/storageAccounts/" + accountName)
+			},
+		},
+		vms: &fakeVirtualMachinesClient{
+			BeginCreateOrUpdateFunc: func(ctx context.Context, resourceGroupName string, vmName string, parameters armcompute.VirtualMachine, options *armcompute.VirtualMachinesClientBeginCreateOrUpdateOptions) (*runtime.Poller[armcompute.VirtualMachinesClientCreateOrUpdateResponse], error) {
+				vms = append(vms, vmName)
+				return fakes.Poller(2, armcompute.VirtualMachinesClientCreateOrUpdateResponse{}, nil), nil
+			},
+			GetFunc: func(ctx context.Context, resourceGroupName string, vmName string, options *armcompute.VirtualMachinesClientGetOptions) (armcompute.VirtualMachinesClientGetResponse, error) {
+				return armcompute.VirtualMachinesClientGetResponse{}, notFound("/providers/Microsoft.Compute/virtualMachines/" + vmName)
+			},
+			NewListPagerFunc: func(resourceGroupName string, options *armcompute.VirtualMachinesClientListOptions) *runtime.Pager[armcompute.VirtualMachinesClientListResponse] {
+				var page armcompute.VirtualMachinesClientListResponse
+				for _, name := range vms {
+					page.Value = append(page.Value, &armcompute.VirtualMachine{Name: to.Ptr(name)})
+				}
+				return fakes.Pager([]armcompute.VirtualMachinesClientListResponse{page}, nil)
+			},
+			BeginDeleteFunc: func(ctx context.Context, resourceGroupName string, vmName string, options *armcompute.VirtualMachinesClientBeginDeleteOptions) (*runtime.Poller[armcompute.VirtualMachinesClientDeleteResponse], error) {
+				for i, name := range vms {
+					if name == vmName {
+						vms = append(vms[:i], vms[i+1:]...)
+						break
+					}
+				}
+				return fakes.Poller(1, armcompute.VirtualMachinesClientDeleteResponse{}, nil), nil
+			},
+		},
+		disks: &fakeDisksClient{
+			BeginCreateOrUpdateFunc: func(ctx context.Context, resourceGroupName string, diskName string, disk armcompute.Disk, options *armcompute.DisksClientBeginCreateOrUpdateOptions) (*runtime.Poller[armcompute.DisksClientCreateOrUpdateResponse], error) {
+				var resp armcompute.DisksClientCreateOrUpdateResponse
+				resp.ID = to.Ptr(groupURL + "/providers/Microsoft.Compute/disks/" + diskName)
+				return fakes.Poller(1, resp, nil), nil
+			},
+			GetFunc: func(ctx context.Context, resourceGroupName string, diskName string, options *armcompute.DisksClientGetOptions) (armcompute.DisksClientGetResponse, error) {
+				return armcompute.DisksClientGetResponse{}, notFound("/providers/Microsoft.Compute/disks/" + diskName)
+			},
+		},
+	}
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name       string
+		clean      bool
+		setup      func(fakeClients)
+		wantErr    string
+		wantOutput []string
+	}{
+		{
+			name:  "success",
+			clean: true,
+			wantOutput: []string{
+				"Completed: create network interface testGoNetworkInterface",
+				"Listing virtual machines in TestRG\nNAME       LOCATION  STATE  TAGS  SKU  ID\nTestGoVm1  -",
+				"Completed: delete virtual machine TestGoVm1",
+				"Listing virtual machines in TestRG\nNAME                 LOCATION  STATE  TAGS  SKU  ID\nTestGoManagedDiskVm  -",
+				"Completed: delete resource group TestRG",
+			},
+		},
+		{
+			name: "rerun adopts the network interface",
+			setup: func(c fakeClients) {
+				c.nics.GetFunc = func(ctx context.Context, resourceGroupName string, networkInterfaceName string, options *armnetwork.InterfacesClientGetOptions) (armnetwork.InterfacesClientGetResponse, error) {
+					var resp armnetwork.InterfacesClientGetResponse
+					resp.ID = to.Ptr(groupURL + "/providers/Microsoft.Network/networkInterfaces/" + networkInterfaceName)
+					resp.Location = to.Ptr("local")
+					return resp, nil
+				}
+				c.nics.BeginCreateOrUpdateFunc = nil
+				c.vms.BeginCreateOrUpdateFunc = func(ctx context.Context, resourceGroupName string, vmName string, parameters armcompute.VirtualMachine, options *armcompute.VirtualMachinesClientBeginCreateOrUpdateOptions) (*runtime.Poller[armcompute.VirtualMachinesClientCreateOrUpdateResponse], error) {
+					if id := *parameters.Properties.NetworkProfile.NetworkInterfaces[0].ID; !strings.HasSuffix(id, "/testGoNetworkInterface") {
+						return nil, errors.New("wrong network interface " + id)
+					}
+					return fakes.Poller(1, armcompute.VirtualMachinesClientCreateOrUpdateResponse{}, nil), nil
+				}
+			},
+			wantOutput: []string{
+				"Adopting the existing network interface testGoNetworkInterface",
+				"Completed: create virtual machine TestGoManagedDiskVm",
+			},
+		},
+		{
+			name: "virtual machine drifted",
+			setup: func(c fakeClients) {
+				c.vms.GetFunc = func(ctx context.Context, resourceGroupName string, vmName string, options *armcompute.VirtualMachinesClientGetOptions) (armcompute.VirtualMachinesClientGetResponse, error) {
+					if vmName != "TestGoManagedDiskVm" {
+						return armcompute.VirtualMachinesClientGetResponse{}, notFound("/providers/Microsoft.Compute/virtualMachines/" + vmName)
+					}
+					var resp armcompute.VirtualMachinesClientGetResponse
+					resp.Location = to.Ptr("local")
+					resp.Properties = &armcompute.VirtualMachineProperties{
+						OSProfile: &armcompute.OSProfile{ComputerName: to.Ptr("other")},
+					}
+					return resp, nil
+				}
+			},
+			wantErr: `the existing virtual machine TestGoManagedDiskVm has drifted: properties.osProfile.computerName is "other", want "TestGoVm1"`,
+		},
+		{
+			name: "network interface fails",
+			setup: func(c fakeClients) {
+				c.nics.BeginCreateOrUpdateFunc = func(ctx context.Context, resourceGroupName string, networkInterfaceName string, parameters armnetwork.Interface, options *armnetwork.InterfacesClientBeginCreateOrUpdateOptions) (*runtime.Poller[armnetwork.InterfacesClientCreateOrUpdateResponse], error) {
+					err := fakes.ResponseError(http.MethodPut, groupURL+"/providers/Microsoft.Network/networkInterfaces/"+networkInterfaceName, http.StatusBadRequest, "InvalidResourceReference")
+					return nil, err
+				}
+			},
+			wantErr: "failed to create network interface testGoNetworkInterface",
+		},
+		{
+			name: "virtual machine fails",
+			setup: func(c fakeClients) {
+				c.vms.BeginCreateOrUpdateFunc = func(ctx context.Context, resourceGroupName string, vmName string, parameters armcompute.VirtualMachine, options *armcompute.VirtualMachinesClientBeginCreateOrUpdateOptions) (*runtime.Poller[armcompute.VirtualMachinesClientCreateOrUpdateResponse], error) {
+					err := fakes.ResponseError(http.MethodPut, groupURL+"/providers/Microsoft.Compute/virtualMachines/"+vmName, http.StatusOK, "OSProvisioningTimedOut")
+					return fakes.Poller(3, armcompute.VirtualMachinesClientCreateOrUpdateResponse{}, err), nil
+				}
+			},
+			wantErr: "failed to create virtual machine TestGoVm1: OSProvisioningTimedOut",
+		},
+		{
+			name: "pager error",
+			setup: func(c fakeClients) {
+				c.vms.NewListPagerFunc = func(resourceGroupName string, options *armcompute.VirtualMachinesClientListOptions) *runtime.Pager[armcompute.VirtualMachinesClientListResponse] {
+					return fakes.Pager[armcompute.VirtualMachinesClientListResponse](nil, errors.New("connection reset"))
+				}
+			},
+			wantErr: "failed to get the next page of the virtual machine list: connection reset",
+		},
+		{
+			name: "disk fails",
+			setup: func(c fakeClients) {
+				c.disks.BeginCreateOrUpdateFunc = func(ctx context.Context, resourceGroupName string, diskName string, disk armcompute.Disk, options *armcompute.DisksClientBeginCreateOrUpdateOptions) (*runtime.Poller[armcompute.DisksClientCreateOrUpdateResponse], error) {
+					err := fakes.ResponseError(http.MethodPut, groupURL+"/providers/Microsoft.Compute/disks/"+diskName, http.StatusOK, "QuotaExceeded")
+					return fakes.Poller(1, armcompute.DisksClientCreateOrUpdateResponse{}, err), nil
+				}
+			},
+			wantErr:    "failed to create disk osDisk2: QuotaExceeded",
+			wantOutput: []string{"Completed: delete virtual machine TestGoVm1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newClients()
+			if tt.setup != nil {
+				tt.setup(c)
+			}
+			var out bytes.Buffer
+			s := &sample{
+				groups:        c.groups,
+				vnets:         c.vnets,
+				nsgs:          c.nsgs,
+				ips:           c.ips,
+				subnets:       c.subnets,
+				nics:          c.nics,
+				accounts:      c.accounts,
+				vms:           c.vms,
+				disks:         c.disks,
+				converge:      &converge.Checker{Out: &out},
+				waiter:        fakes.Waiter(&out),
+				out:           &out,
+				lists:         &output.Printer{W: &out, Format: output.Table},
+				location:      "local",
+				storageSuffix: "local.azurestack.external",
+			}
+			err := s.run(context.Background(), "TestRG", tt.clean)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("run failed: %s", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("run returned %v, want an error containing %q", err, tt.wantErr)
+			}
+			for _, want := range tt.wantOutput {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("output is missing %q:\n%s", want, out.String())
+				}
+			}
+		})
+	}
+}